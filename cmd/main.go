@@ -3,17 +3,25 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"aidanwoods.dev/go-paseto"
 	hspb "github.com/10664kls/estatement/genproto/go/http/v1"
 	"github.com/10664kls/estatement/internal/auth"
+	"github.com/10664kls/estatement/internal/errs"
+	"github.com/10664kls/estatement/internal/feature"
+	"github.com/10664kls/estatement/internal/i18n"
 	"github.com/10664kls/estatement/internal/middleware"
 	"github.com/10664kls/estatement/internal/server"
 	"github.com/10664kls/estatement/internal/statement"
@@ -24,9 +32,11 @@ import (
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/genproto/googleapis/rpc/code"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	_ "github.com/denisenkom/go-mssqldb"
 )
@@ -63,6 +73,25 @@ func run() error {
 	}
 	defer db.Close()
 
+	// The replica shares the credentials and the database name of the primary.
+	var replica *sql.DB
+	if host := os.Getenv("DB_REPLICA_HOST"); host != "" {
+		replica, err = sql.Open(
+			"sqlserver",
+			fmt.Sprintf("sqlserver://%s:%s@%s:%s?database=%s&TrustServerCertificate=true&ApplicationIntent=ReadOnly",
+				os.Getenv("DB_USER"),
+				os.Getenv("DB_PASSWORD"),
+				host,
+				getEnv("DB_REPLICA_PORT", os.Getenv("DB_PORT")),
+				os.Getenv("DB_NAME"),
+			),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create replica db connection: %w", err)
+		}
+		defer replica.Close()
+	}
+
 	// if err := db.PingContext(ctx); err != nil {
 	// 	return fmt.Errorf("failed to ping DB: %w", err)
 	// }
@@ -72,27 +101,103 @@ func run() error {
 	e.Use(stdmws()...)
 	e.HTTPErrorHandler = httpErr
 
-	statementSvc, err := statement.NewService(ctx, db, zlog)
+	statementSvc, err := statement.NewService(ctx, db, zlog, statement.Config{
+		RedactListDetails:       getEnv("REDACT_LIST_DETAILS", "false") == "true",
+		LogPII:                  getEnv("LOG_PII", "false") == "true",
+		ExportSigningKey:        must(hex.DecodeString(os.Getenv("EXPORT_SIGNING_KEY"))),
+		PageTokenSigningKey:     must(hex.DecodeString(os.Getenv("PAGE_TOKEN_SIGNING_KEY"))),
+		SlowQueryThreshold:      must(time.ParseDuration(getEnv("SLOW_QUERY_THRESHOLD", "1s"))),
+		RestrictedExportColumns: getEnvList("EXPORT_RESTRICTED_COLUMNS", "EmailMsg,BankMoreInfo"),
+		RequireBoundedExport:    getEnv("REQUIRE_BOUNDED_EXPORT", "false") == "true",
+		DefaultSort:             must(statement.ParseSortOrder(getEnv("DEFAULT_SORT", "id"))),
+		EmailSentStatus:         os.Getenv("EMAIL_SENT_STATUS"),
+		EmailFailedStatus:       os.Getenv("EMAIL_FAILED_STATUS"),
+		MaskPolicies:            maskPolicies(),
+		LookupCacheTTL:          must(time.ParseDuration(getEnv("LOOKUP_CACHE_TTL", "0s"))),
+		LookupRefreshInterval:   must(time.ParseDuration(getEnv("LOOKUP_REFRESH_INTERVAL", "0s"))),
+		EmailFallbackLocale:     os.Getenv("EMAIL_FALLBACK_LOCALE"),
+		ModifiedAtColumn:        os.Getenv("MODIFIED_AT_COLUMN"),
+		EmailSentAtColumn:       os.Getenv("EMAIL_SENT_AT_COLUMN"),
+		StatementTable:          os.Getenv("STATEMENT_TABLE"),
+		ReadReplica:             replica,
+		MaxResultWindow:         must(strconv.ParseUint(getEnv("MAX_RESULT_WINDOW", "100000"), 10, 64)),
+		QueryConcurrency:        must(strconv.Atoi(getEnv("QUERY_CONCURRENCY", "1"))),
+		ExportCacheSize:         must(strconv.Atoi(getEnv("EXPORT_CACHE_SIZE", "0"))),
+		ExportJobTTL:            must(time.ParseDuration(getEnv("EXPORT_JOB_TTL", "24h"))),
+		ExportJobMaxBytes:       must(strconv.ParseInt(getEnv("EXPORT_JOB_MAX_BYTES", "536870912"), 10, 64)),
+		MaxQueuedExportJobs:     must(strconv.Atoi(getEnv("EXPORT_JOB_MAX_QUEUED", "10"))),
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create statement service: %w", err)
 	}
 
-	akey := must(paseto.V4SymmetricKeyFromHex(os.Getenv("PASETO_ACCESS_KEY")))
-	rkey := must(paseto.V4SymmetricKeyFromHex(os.Getenv("PASETO_REFRESH_KEY")))
+	akeys := auth.NewRotatingKey(must(loadKey("PASETO_ACCESS_KEY")))
+	rkeys := auth.NewRotatingKey(must(loadKey("PASETO_REFRESH_KEY")))
+	go reloadKeysOnHangup(ctx, zlog, akeys, rkeys)
+
+	var hasher auth.Hasher = auth.BcryptHasher{}
+	if getEnv("PASSWORD_HASHER", "bcrypt") == "argon2id" {
+		hasher = auth.Argon2idHasher{}
+	}
+
+	// The products of the users are checked against the catalog of the
+	// products, not the products of the statements, so a new product can be
+	// given to the users before it has any statement.
+	var products []string
+	if getEnv("VALIDATE_USER_PRODUCT", "false") == "true" {
+		products = getEnvList("PRODUCTS", "")
+		if len(products) == 0 {
+			return errors.New("PRODUCTS must list the products when VALIDATE_USER_PRODUCT is set")
+		}
+	}
 
-	authService, err := auth.NewAuthService(ctx, db, akey, rkey, zlog)
+	authService, err := auth.NewAuthService(ctx, db, akeys.Current(), rkeys.Current(), zlog, auth.Config{
+		Hasher:      hasher,
+		Audience:    os.Getenv("TOKEN_AUDIENCE"),
+		Issuer:      os.Getenv("TOKEN_ISSUER"),
+		AccessKeys:  akeys,
+		RefreshKeys: rkeys,
+		Products:    products,
+		Roles:       getEnvList("USER_ROLES", auth.RoleUser),
+
+		MinPasswordScore: must(strconv.Atoi(getEnv("MIN_PASSWORD_SCORE", "2"))),
+		MaxLoginFailures: must(strconv.Atoi(getEnv("LOGIN_MAX_FAILURES", "5"))),
+		LoginLockout:     must(time.ParseDuration(getEnv("LOGIN_LOCKOUT", "15m"))),
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create auth service: %w", err)
 	}
 
+	// The initial admin is created before serving, so a fresh deployment
+	// can be logged into.
+	if username := os.Getenv("INITIAL_ADMIN_USERNAME"); username != "" {
+		if err := authService.EnsureAdmin(ctx, username, os.Getenv("INITIAL_ADMIN_PASSWORD")); err != nil {
+			return fmt.Errorf("failed to ensure initial admin: %w", err)
+		}
+	}
+
 	mws := []echo.MiddlewareFunc{
 		middleware.PASETO(middleware.PASETOConfig{
-			SymmetricKey: akey,
+			Keys:           akeys,
+			Rules:          authService.TokenRules(),
+			APIKeyResolver: authService.ClaimsFromAPIToken,
 		}),
 		middleware.SetContextClaimsFromToken,
+		middleware.PasswordChanged(passwordChangeAllowed),
+		middleware.FeatureFlags(feature.HeaderResolver(
+			"X-Feature-Flags",
+			featureFlags(),
+			getEnvList("FEATURE_FLAGS_OVERRIDABLE", ""),
+		)),
 	}
 
-	server := must(server.NewServer(statementSvc, authService))
+	server := must(server.NewServer(statementSvc, authService, server.Config{
+		RefreshTokenCookie: getEnv("REFRESH_TOKEN_COOKIE", "false") == "true",
+		SnakeCaseJSON:      getEnv("SNAKE_CASE_JSON", "false") == "true",
+		HealthChecks: []server.HealthCheck{
+			{Name: "database", Critical: true, Check: statementSvc.Ping},
+		},
+	}))
 	if err := server.Install(e, mws...); err != nil {
 		return fmt.Errorf("failed to install server: %w", err)
 	}
@@ -134,6 +239,90 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getEnvList returns the comma separated values of the environment variable.
+func getEnvList(key, fallback string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(getEnv(key, fallback), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// maskPolicies returns the masking policies of the roles listed in
+// MASK_ACCOUNT_ROLES and MASK_NAME_ROLES.
+func maskPolicies() map[string]statement.MaskPolicy {
+	policies := make(map[string]statement.MaskPolicy)
+	for _, role := range getEnvList("MASK_ACCOUNT_ROLES", "") {
+		p := policies[role]
+		p.AccountNumber = true
+		policies[role] = p
+	}
+	for _, role := range getEnvList("MASK_NAME_ROLES", "") {
+		p := policies[role]
+		p.CustomerName = true
+		policies[role] = p
+	}
+	return policies
+}
+
+// featureFlags returns the flags enabled by default, listed in FEATURE_FLAGS.
+func featureFlags() feature.Flags {
+	flags := make(feature.Flags)
+	for _, name := range getEnvList("FEATURE_FLAGS", "") {
+		flags[name] = true
+	}
+	return flags
+}
+
+// loadKey loads the PASETO key from the file named by the <name>_FILE
+// environment variable, or from the <name> environment variable.
+func loadKey(name string) (paseto.V4SymmetricKey, error) {
+	file := os.Getenv(name + "_FILE")
+	if file == "" {
+		return paseto.V4SymmetricKeyFromHex(os.Getenv(name))
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return paseto.V4SymmetricKey{}, err
+	}
+	return paseto.V4SymmetricKeyFromHex(strings.TrimSpace(string(b)))
+}
+
+// reloadKeysOnHangup reloads the PASETO keys on SIGHUP. The replaced keys are
+// accepted until the tokens they issued expire.
+func reloadKeysOnHangup(ctx context.Context, zlog *zap.Logger, akeys, rkeys *auth.RotatingKey) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+
+	reload := func(name string, keys *auth.RotatingKey, grace time.Duration) {
+		key, err := loadKey(name)
+		if err != nil {
+			zlog.Error("failed to reload key", zap.String("key", name), zap.Error(err))
+			return
+		}
+		if key.ExportHex() == keys.Current().ExportHex() {
+			return
+		}
+
+		keys.Rotate(key, grace)
+		zlog.Info("key rotated", zap.String("key", name))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			reload("PASETO_ACCESS_KEY", akeys, auth.AccessTokenTTL)
+			reload("PASETO_REFRESH_KEY", rkeys, auth.RefreshTokenTTL)
+		}
+	}
+}
+
 func newLogger() (*zap.Logger, error) {
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "timestamp",
@@ -167,8 +356,18 @@ func newLogger() (*zap.Logger, error) {
 }
 
 func httpErr(err error, c echo.Context) {
+	lang := c.Request().Header.Get("Accept-Language")
+
+	// The not found errors may be wrapped, their status is used as is so
+	// the wrapping context is not leaked in the message.
+	var nf *errs.NotFoundError
+	if errors.As(err, &nf) {
+		err = nf.GRPCStatus().Err()
+	}
+
 	if s, ok := status.FromError(err); ok {
-		he := httpStatusPbFromRPC(s)
+		setRetryAfter(c, s)
+		he := httpStatusPbFromRPC(s, lang)
 		jsonb, _ := protojson.Marshal(he)
 		c.JSONBlob(int(he.Error.Code), jsonb)
 		return
@@ -187,7 +386,7 @@ func httpErr(err error, c echo.Context) {
 			s = status.New(codes.Unknown, "Unknown error!")
 		}
 
-		hbp := httpStatusPbFromRPC(s)
+		hbp := httpStatusPbFromRPC(s, lang)
 		jsonb, _ := protojson.Marshal(hbp)
 		c.JSONBlob(int(hbp.Error.Code), jsonb)
 		return
@@ -200,11 +399,34 @@ func httpErr(err error, c echo.Context) {
 	})
 }
 
+// rateLimit is the number of requests per second allowed per client.
+const rateLimit = 10
+
+// setRetryAfter sets the Retry-After header, in seconds, when the status
+// tells when the request may be retried.
+func setRetryAfter(c echo.Context, s *status.Status) {
+	for _, d := range s.Details() {
+		ri, ok := d.(*edpb.RetryInfo)
+		if !ok || ri.GetRetryDelay() == nil {
+			continue
+		}
+
+		secs := int64(math.Ceil(ri.GetRetryDelay().AsDuration().Seconds()))
+		if secs < 1 {
+			secs = 1
+		}
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+		return
+	}
+}
+
 func stdmws() []echo.MiddlewareFunc {
 	return []echo.MiddlewareFunc{
 		stdmw.RemoveTrailingSlash(),
+		middleware.Trace(middleware.TraceConfig{}),
 		// stdmw.Logger(),
 		stdmw.Recover(),
+		middleware.Compress(),
 		stdmw.CORSWithConfig(stdmw.CORSConfig{
 			AllowOriginFunc: func(origin string) (bool, error) {
 				return true, nil
@@ -221,16 +443,61 @@ func stdmws() []echo.MiddlewareFunc {
 			AllowCredentials: true,
 			MaxAge:           86400,
 		}),
-		stdmw.RateLimiter(stdmw.NewRateLimiterMemoryStore(10)),
+		stdmw.RateLimiterWithConfig(stdmw.RateLimiterConfig{
+			Store: stdmw.NewRateLimiterMemoryStore(rateLimit),
+			DenyHandler: func(c echo.Context, _ string, _ error) error {
+				// The bucket refills at rateLimit tokens per second.
+				s, _ := status.New(codes.ResourceExhausted, "Too many requests.").
+					WithDetails(&edpb.RetryInfo{
+						RetryDelay: durationpb.New(time.Second / rateLimit),
+					})
+				return s.Err()
+			},
+		}),
+		middleware.TimeoutWithConfig(middleware.TimeoutConfig{
+			UnboundedSkipper: unboundedRequest,
+			Timeout:          must(time.ParseDuration(getEnv("REQUEST_TIMEOUT", "30s"))),
+			Header:           "X-Request-Timeout",
+			MaxTimeout:       must(time.ParseDuration(getEnv("REQUEST_MAX_TIMEOUT", "10m"))),
+		}),
 		stdmw.Secure(),
 	}
 }
 
-func httpStatusPbFromRPC(s *status.Status) *hspb.Error {
+// passwordChangeAllowed reports whether the request is allowed to the users
+// who must change their password: the change of the password itself, and
+// seeing who they are.
+func passwordChangeAllowed(c echo.Context) bool {
+	method := c.Request().Method
+	return (method == http.MethodPost && c.Path() == "/v1/auth/me/password") ||
+		(method == http.MethodGet && c.Path() == "/v1/auth/me")
+}
+
+// unboundedRoutes are the routes of the export jobs that stream or wait for
+// the job, they are bounded by the export concurrency guard instead. The
+// synchronous exports stay bounded by the request timeout.
+var unboundedRoutes = map[string]bool{
+	"/v1/statements/export-jobs/:id/events":  true,
+	"/v1/statements/export-jobs/:id/content": true,
+}
+
+// unboundedRequest reports whether the request is not bounded by the request
+// timeout: the streams of the export jobs, and the NDJSON stream of the
+// statements which pulls the whole filtered set. Both are still bounded by
+// the timeout of the client.
+func unboundedRequest(c echo.Context) bool {
+	if unboundedRoutes[c.Path()] {
+		return true
+	}
+	return c.Path() == "/v1/statements" &&
+		strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "application/x-ndjson")
+}
+
+func httpStatusPbFromRPC(s *status.Status, lang string) *hspb.Error {
 	return &hspb.Error{
 		Error: &hspb.Status{
 			Code:    int32(runtime.HTTPStatusFromCode(s.Code())),
-			Message: s.Message(),
+			Message: i18n.Default.Localize(lang, s),
 			Status:  code.Code(s.Code()),
 			Details: s.Proto().GetDetails(),
 		},
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/10664kls/estatement/internal/errs"
+	"github.com/10664kls/estatement/internal/middleware"
+	"github.com/labstack/echo/v4"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestHTTPErrRetryAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "locked out",
+			err: func() error {
+				s, _ := status.New(codes.ResourceExhausted, "Too many failed logins.").
+					WithDetails(&edpb.RetryInfo{RetryDelay: durationpb.New(90500 * time.Millisecond)})
+				return s.Err()
+			}(),
+			want: "91",
+		},
+		{
+			name: "without retry info",
+			err:  status.Error(codes.ResourceExhausted, "Too many requests."),
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/v1/auth/login", nil), rec)
+
+			httpErr(tt.err, c)
+			if rec.Code != http.StatusTooManyRequests {
+				t.Errorf("httpErr() = %d, want %d", rec.Code, http.StatusTooManyRequests)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.want {
+				t.Errorf("Retry-After = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = httpErr
+	e.Use(stdmws()...)
+	e.GET("/v1/statements", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	var throttled *httptest.ResponseRecorder
+	for range 5 * rateLimit {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/statements", nil))
+		if rec.Code == http.StatusTooManyRequests {
+			throttled = rec
+			break
+		}
+	}
+	if throttled == nil {
+		t.Fatal("no request was throttled")
+	}
+
+	secs, err := strconv.Atoi(throttled.Header().Get("Retry-After"))
+	if err != nil || secs < 1 {
+		t.Errorf("Retry-After = %q, want a number of seconds", throttled.Header().Get("Retry-After"))
+	}
+}
+
+func TestHTTPErrNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "statement",
+			err:  errs.NotFound("statement", "Q1"),
+			want: "Statement not found.",
+		},
+		{
+			name: "user",
+			err:  fmt.Errorf("failed to get user: %w", errs.NotFound("user", "42")),
+			want: "User not found.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+
+			httpErr(tt.err, c)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("httpErr() = %d, want %d", rec.Code, http.StatusNotFound)
+			}
+
+			var body struct {
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if body.Error.Message != tt.want {
+				t.Errorf("httpErr() message = %q, want %q", body.Error.Message, tt.want)
+			}
+		})
+	}
+}
+
+func newContext(method, path, accept string) echo.Context {
+	req := httptest.NewRequest(method, path, nil)
+	if accept != "" {
+		req.Header.Set(echo.HeaderAccept, accept)
+	}
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	c.SetPath(path)
+	return c
+}
+
+func TestPasswordChangeAllowed(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{http.MethodPost, "/v1/auth/me/password", true},
+		{http.MethodGet, "/v1/auth/me", true},
+		{http.MethodPatch, "/v1/auth/me", false},
+		{http.MethodGet, "/v1/auth/me/password", false},
+		{http.MethodGet, "/v1/statements", false},
+	}
+	for _, tt := range tests {
+		if got := passwordChangeAllowed(newContext(tt.method, tt.path, "")); got != tt.want {
+			t.Errorf("passwordChangeAllowed(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestUnboundedRequest(t *testing.T) {
+	tests := []struct {
+		path, accept string
+		want         bool
+	}{
+		{"/v1/statements/export-jobs/:id/events", "", true},
+		{"/v1/statements/export-jobs/:id/content", "", true},
+		{"/v1/statements/export-to-excel", "", false},
+		{"/v1/statements/export-to-csv", "", false},
+		{"/v1/statements/export-signed", "", false},
+		{"/v1/statements/export-estimate", "", false},
+		{"/v1/statements/summary/export", "", false},
+		{"/v1/statements", "application/x-ndjson", true},
+		{"/v1/statements", "application/json", false},
+		{"/v1/statements:count", "application/x-ndjson", false},
+	}
+	for _, tt := range tests {
+		if got := unboundedRequest(newContext(http.MethodGet, tt.path, tt.accept)); got != tt.want {
+			t.Errorf("unboundedRequest(%s, %q) = %v, want %v", tt.path, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestSlowExportTimesOut(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = httpErr
+	e.Use(middleware.TimeoutWithConfig(middleware.TimeoutConfig{
+		UnboundedSkipper: unboundedRequest,
+		Timeout:          10 * time.Millisecond,
+	}))
+	e.GET("/v1/statements/export-to-excel", func(c echo.Context) error {
+		select {
+		case <-c.Request().Context().Done():
+			return c.Request().Context().Err()
+		case <-time.After(time.Second):
+			return c.NoContent(http.StatusOK)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/statements/export-to-excel", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("GET = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+}
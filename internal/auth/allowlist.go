@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/netip"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// errIPNotAllowed is a helper function to create an error when the user
+// logs in from an address out of its allowlist.
+func errIPNotAllowed() error {
+	s, _ := rpcstatus.New(codes.PermissionDenied, "You are not allowed to login from this network.").
+		WithDetails(&edpb.ErrorInfo{
+			Reason: "IP_NOT_ALLOWED",
+			Domain: "auth",
+		})
+	return s.Err()
+}
+
+// ipAllowed reports whether the ip matches one of the entries of the
+// allowlist, an entry being an address or a CIDR prefix.
+// An empty allowlist allows every address.
+func ipAllowed(allowlist []string, ip string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, entry := range allowlist {
+		if !strings.Contains(entry, "/") {
+			if a, err := netip.ParseAddr(entry); err == nil && a.Unmap() == addr {
+				return true
+			}
+			continue
+		}
+		if p, err := netip.ParsePrefix(entry); err == nil && p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// listAllowedIPs returns the allowlist of the user, empty when the user is
+// not restricted.
+func listAllowedIPs(ctx context.Context, db *sql.DB, userID string) ([]string, error) {
+	q, args := sq.Select("cidr").
+		From("dbo.tb_user_ip_allowlist").
+		PlaceholderFormat(sq.AtP).
+		Where(sq.Eq{"USID": userID}).
+		MustSql()
+
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allowlist := make([]string, 0)
+	for rows.Next() {
+		var cidr string
+		if err := rows.Scan(&cidr); err != nil {
+			return nil, err
+		}
+		allowlist = append(allowlist, strings.TrimSpace(cidr))
+	}
+
+	return allowlist, rows.Err()
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestIPAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		ip        string
+		want      bool
+	}{
+		{name: "unrestricted", allowlist: nil, ip: "203.0.113.7", want: true},
+		{name: "address", allowlist: []string{"10.0.0.5"}, ip: "10.0.0.5", want: true},
+		{name: "prefix", allowlist: []string{"10.1.0.0/16"}, ip: "10.1.2.3", want: true},
+		{name: "mapped", allowlist: []string{"10.1.0.0/16"}, ip: "::ffff:10.1.2.3", want: true},
+		{name: "outside", allowlist: []string{"10.0.0.5", "10.1.0.0/16"}, ip: "10.2.0.1", want: false},
+		{name: "invalid ip", allowlist: []string{"10.0.0.0/8"}, ip: "unknown", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ipAllowed(tt.allowlist, tt.ip); got != tt.want {
+				t.Errorf("ipAllowed(%v, %q) = %t, want %t", tt.allowlist, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginAllowlist(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		ip        string
+		wantErr   codes.Code
+	}{
+		{name: "allowed", allowlist: []string{"10.1.0.0/16"}, ip: "10.1.2.3"},
+		{name: "not allowed", allowlist: []string{"10.1.0.0/16"}, ip: "192.0.2.1", wantErr: codes.PermissionDenied},
+		{name: "unrestricted", ip: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestAuth(t, Config{Hasher: BcryptHasher{Cost: 4}})
+			u := testUser{id: "U1", username: "alice", hash: mustHash(t, BcryptHasher{Cost: 4}, "Violet-Harbor-Lantern-93"), role: RoleUser}
+
+			allowlist := sqlmock.NewRows([]string{"cidr"})
+			for _, cidr := range tt.allowlist {
+				allowlist.AddRow(cidr)
+			}
+			mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(u))
+			mock.ExpectQuery("FROM dbo.tb_user_ip_allowlist").WithArgs("U1").WillReturnRows(allowlist)
+			if tt.wantErr == codes.OK {
+				mock.ExpectExec("INSERT INTO dbo.tb_login_history").WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			_, err := s.Login(context.Background(), &LoginReq{Username: "alice", Password: "Violet-Harbor-Lantern-93", IP: tt.ip})
+			if code := rpcstatus.Code(err); code != tt.wantErr {
+				t.Errorf("Login() error = %v, want %s", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
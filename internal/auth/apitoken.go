@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// ErrAPITokenNotFound is returned when the API token is not found.
+var ErrAPITokenNotFound = errors.New("api token not found")
+
+// apiTokenPrefix is prepended to every API token so they are easy to spot
+// (e.g. by secret scanners).
+const apiTokenPrefix = "est_"
+
+// APIToken is a long-lived token for service integrations.
+// The plain token is only returned when it is created, the hash is stored.
+type APIToken struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"userId"`
+	Token     string     `json:"token,omitempty"`
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+// CreateAPIToken mints a new API token bound to the user.
+// Only admins are allowed to create API tokens.
+func (s *Auth) CreateAPIToken(ctx context.Context, userID string) (*APIToken, error) {
+	claims := ClaimsFromContext(ctx)
+	zlog := s.zlog.With(
+		zap.String("method", "CreateAPIToken"),
+		zap.String("userId", userID),
+		zap.String("by", claims.Username),
+	)
+
+	zlog.Info("starting to create api token")
+
+	if !claims.IsAdmin() {
+		zlog.Info("not an admin")
+		return nil, rpcstatus.Error(codes.PermissionDenied, "You are not allowed to create api tokens.")
+	}
+
+	if _, err := getUserByID(ctx, s.db, userID); errors.Is(err, ErrUserNotFound) {
+		zlog.Info("user not found")
+		return nil, err
+	} else if err != nil {
+		zlog.Error("failed to get user by id", zap.Error(err))
+		return nil, err
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		zlog.Error("failed to gen api token id", zap.Error(err))
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		zlog.Error("failed to gen api token", zap.Error(err))
+		return nil, err
+	}
+
+	t := &APIToken{
+		ID:        id,
+		UserID:    userID,
+		Token:     apiTokenPrefix + secret,
+		CreatedBy: claims.Username,
+		CreatedAt: time.Now(),
+	}
+	if err := createAPIToken(ctx, s.db, t); err != nil {
+		zlog.Error("failed to create api token", zap.Error(err))
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// RevokeAPIToken revokes the API token of the user.
+// Only admins are allowed to revoke API tokens.
+func (s *Auth) RevokeAPIToken(ctx context.Context, userID, tokenID string) error {
+	claims := ClaimsFromContext(ctx)
+	zlog := s.zlog.With(
+		zap.String("method", "RevokeAPIToken"),
+		zap.String("userId", userID),
+		zap.String("tokenId", tokenID),
+		zap.String("by", claims.Username),
+	)
+
+	zlog.Info("starting to revoke api token")
+
+	if !claims.IsAdmin() {
+		zlog.Info("not an admin")
+		return rpcstatus.Error(codes.PermissionDenied, "You are not allowed to revoke api tokens.")
+	}
+
+	err := revokeAPIToken(ctx, s.db, userID, tokenID, time.Now())
+	if errors.Is(err, ErrAPITokenNotFound) {
+		zlog.Info("api token not found")
+		return rpcstatus.Error(codes.NotFound, "API token not found (or it may be already revoked).")
+	}
+	if err != nil {
+		zlog.Error("failed to revoke api token", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ClaimsFromAPIToken returns the claims of the user the API token is bound to,
+// used from the ip. The user must be allowed from the ip and have a valid
+// product, like on login. It returns an unauthenticated error if the token is
+// unknown or revoked.
+func (s *Auth) ClaimsFromAPIToken(ctx context.Context, token, ip string) (*Claims, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "ClaimsFromAPIToken"),
+		tokenField(token),
+	)
+
+	user, err := getUserByAPIToken(ctx, s.db, hashAPIToken(token))
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, errInvalidCredentials()
+	}
+	if err != nil {
+		zlog.Error("failed to get user by api token", zap.Error(err))
+		return nil, err
+	}
+
+	allowlist, err := listAllowedIPs(ctx, s.db, user.ID)
+	if err != nil {
+		zlog.Error("failed to list allowed ips", zap.Error(err))
+		return nil, err
+	}
+	if !ipAllowed(allowlist, ip) {
+		zlog.Info("ip not allowed", zap.String("ip", ip))
+		return nil, errIPNotAllowed()
+	}
+
+	if err := s.checkProduct(user); err != nil {
+		zlog.Info("product check failed", zap.String("product", user.ProductName), zap.Error(err))
+		return nil, err
+	}
+
+	return claimsFromUser(user), nil
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func createAPIToken(ctx context.Context, db *sql.DB, t *APIToken) error {
+	q, args := sq.Insert("dbo.tb_api_token").
+		Columns(
+			"id",
+			"USID",
+			"token_hash",
+			"createby",
+			"createdate",
+		).
+		Values(
+			t.ID,
+			t.UserID,
+			hashAPIToken(t.Token),
+			t.CreatedBy,
+			t.CreatedAt,
+		).
+		PlaceholderFormat(sq.AtP).
+		MustSql()
+
+	_, err := db.ExecContext(ctx, q, args...)
+	return err
+}
+
+func revokeAPIToken(ctx context.Context, db *sql.DB, userID, tokenID string, at time.Time) error {
+	q, args := sq.Update("dbo.tb_api_token").
+		Set("revokedate", at).
+		Where(sq.Eq{
+			"id":         tokenID,
+			"USID":       userID,
+			"revokedate": nil,
+		}).
+		PlaceholderFormat(sq.AtP).
+		MustSql()
+
+	res, err := db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+func getUserByAPIToken(ctx context.Context, db *sql.DB, hash string) (*User, error) {
+	q, args := sq.Select(
+		"TOP 1 u.USID",
+		"u.Username",
+		"u.productnames",
+		"u.role",
+		"u.createdate",
+		"ISNULL(u.must_change_pwd, 0)",
+	).
+		From("dbo.tb_api_token t").
+		Join("dbo.tb_user u ON u.USID = t.USID").
+		PlaceholderFormat(sq.AtP).
+		Where(sq.Eq{
+			"t.token_hash": hash,
+			"t.revokedate": nil,
+			"u.rectype":    "ADD",
+		}).
+		MustSql()
+
+	row := db.QueryRowContext(ctx, q, args...)
+	var u User
+
+	err := row.Scan(
+		&u.ID,
+		&u.Username,
+		&u.ProductName,
+		&u.Role,
+		&u.CreatedAt,
+		&u.MustChangePassword,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// apiTokenUserRows returns the rows of getUserByAPIToken for the user.
+func apiTokenUserRows(u testUser) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"USID", "Username", "productnames", "role", "createdate", "must_change_pwd"}).
+		AddRow(u.id, u.username, u.product, u.role, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), u.mustChange)
+}
+
+func allowlistRows(cidrs ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"cidr"})
+	for _, c := range cidrs {
+		rows.AddRow(c)
+	}
+	return rows
+}
+
+func TestClaimsFromAPIToken(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	u := testUser{id: "U1", username: "svc", product: "LOAN", role: "user", mustChange: true}
+	mock.ExpectQuery("FROM dbo.tb_api_token").WillReturnRows(apiTokenUserRows(u))
+	mock.ExpectQuery("FROM dbo.tb_user_ip_allowlist").WillReturnRows(allowlistRows("10.0.0.0/8"))
+
+	claims, err := s.ClaimsFromAPIToken(context.Background(), "est_secret", "10.1.2.3")
+	if err != nil {
+		t.Fatalf("ClaimsFromAPIToken() error = %v", err)
+	}
+	if claims.ID != "U1" || !claims.MustChangePassword {
+		t.Errorf("ClaimsFromAPIToken() = %+v, want U1 who must change the password", claims)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClaimsFromAPITokenRejected(t *testing.T) {
+	u := testUser{id: "U1", username: "svc", product: "LOAN", role: "user"}
+	tests := []struct {
+		name     string
+		products []string
+		allowed  []string
+		ip       string
+		want     codes.Code
+	}{
+		{"ip not allowed", nil, []string{"10.0.0.0/8"}, "192.168.1.1", codes.PermissionDenied},
+		{"product removed", []string{"CARD"}, nil, "10.1.2.3", codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestAuth(t, Config{Products: tt.products})
+			mock.ExpectQuery("FROM dbo.tb_api_token").WillReturnRows(apiTokenUserRows(u))
+			mock.ExpectQuery("FROM dbo.tb_user_ip_allowlist").WillReturnRows(allowlistRows(tt.allowed...))
+
+			_, err := s.ClaimsFromAPIToken(context.Background(), "est_secret", tt.ip)
+			if code := rpcstatus.Code(err); code != tt.want {
+				t.Errorf("ClaimsFromAPIToken() code = %v, want %v", code, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestClaimsFromAPITokenUnknown(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	mock.ExpectQuery("FROM dbo.tb_api_token").
+		WillReturnRows(sqlmock.NewRows([]string{"USID", "Username", "productnames", "role", "createdate", "must_change_pwd"}))
+
+	_, err := s.ClaimsFromAPIToken(context.Background(), "est_revoked", "10.1.2.3")
+	if code := rpcstatus.Code(err); code != codes.Unauthenticated {
+		t.Errorf("ClaimsFromAPIToken() code = %v, want %v", code, codes.Unauthenticated)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -5,47 +5,190 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"aidanwoods.dev/go-paseto"
+	"github.com/10664kls/estatement/internal/errs"
 	sq "github.com/Masterminds/squirrel"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	rpcstatus "google.golang.org/grpc/status"
 )
 
-// ErrUserNotFound is returned when the user is not found.
-var ErrUserNotFound = errors.New("user not found")
+// errProductNotFound is a helper function to create an error when the
+// product of the user does not exist anymore.
+func errProductNotFound() error {
+	s, _ := rpcstatus.New(codes.PermissionDenied, "Your product does not exist anymore. Please contact an administrator.").
+		WithDetails(&edpb.ErrorInfo{
+			Reason: "PRODUCT_NOT_FOUND",
+			Domain: "auth",
+		})
+	return s.Err()
+}
+
+// ErrUserNotFound is returned when the user is not found, it converts to a
+// codes.NotFound status.
+var ErrUserNotFound error = &errs.NotFoundError{Resource: "user"}
+
+// errInvalidCredentials is a helper function to create an error when
+// the provided credentials are not valid.
+func errInvalidCredentials() error {
+	s, _ := rpcstatus.New(codes.Unauthenticated, "Your credentials not valid. Please check and try again.").
+		WithDetails(&edpb.ErrorInfo{
+			Reason: "INVALID_CREDENTIALS",
+			Domain: "auth",
+		})
+	return s.Err()
+}
 
 type Auth struct {
-	db   *sql.DB
-	aKey paseto.V4SymmetricKey
-	rKey paseto.V4SymmetricKey
-	zlog *zap.Logger
+	db     *sql.DB
+	aKey   KeyProvider
+	rKey   KeyProvider
+	zlog   *zap.Logger
+	tracer trace.Tracer
+	hasher multiHasher
+
+	audience string
+	issuer   string
+
+	products []string
+
+	minPasswordScore int
+	roles            []string
+
+	lockout *loginLockout
+}
+
+// Config defines the config for auth service.
+type Config struct {
+	// Tracer is used to trace the service operations.
+	// Optional. Default value is the tracer of the global provider.
+	Tracer trace.Tracer
+
+	// Hasher hashes the new passwords, the stored ones are verified with
+	// the algorithm matching their prefix whatever the hasher is, and are
+	// rehashed with it on the next login.
+	// Optional. Default value BcryptHasher.
+	Hasher Hasher
+
+	// Audience is stamped on the issued tokens, the tokens of another
+	// audience are rejected.
+	// Optional. Default value "", the audience is not checked.
+	Audience string
+
+	// Issuer is stamped on the issued tokens, the tokens of another issuer
+	// are rejected.
+	// Optional. Default value "", the issuer is not checked.
+	Issuer string
+
+	// AccessKeys provides the keys of the access tokens, so they can be
+	// rotated without restart.
+	// Optional. Default value the access key given to NewAuthService.
+	AccessKeys KeyProvider
+
+	// RefreshKeys provides the keys of the refresh tokens.
+	// Optional. Default value the refresh key given to NewAuthService.
+	RefreshKeys KeyProvider
+
+	// Products is the catalog of the existing products, whether they have
+	// statements yet or not. The users whose product is not one of them are
+	// refused on login, token refresh and API token use, and can't be given
+	// one by UpdateUser.
+	// Optional. Default value empty, the product of the users is not checked.
+	Products []string
+
+	// Roles are the roles the users may be given by UpdateUser, RoleAdmin is
+	// always one of them.
+	// Optional. Default value is RoleAdmin and RoleUser.
+	Roles []string
+
+	// MinPasswordScore is the minimum strength score of the new passwords,
+	// from 0 to 4. The common passwords and the ones containing the username
+	// are refused whatever their score is.
+	// Optional. Default value 2.
+	MinPasswordScore int
+
+	// MaxLoginFailures is the number of consecutive failed logins after
+	// which the username is locked out, Login then fails with
+	// codes.ResourceExhausted telling when to retry.
+	// Optional. Default value 0, the usernames are never locked out.
+	MaxLoginFailures int
+
+	// LoginLockout is how long a username is locked out.
+	// Optional. Default value 15 minutes.
+	LoginLockout time.Duration
 }
 
 func NewAuthService(_ context.Context,
 	db *sql.DB,
 	aKey paseto.V4SymmetricKey,
 	rKey paseto.V4SymmetricKey,
-	zlog *zap.Logger) (*Auth, error) {
+	zlog *zap.Logger,
+	cfg Config) (*Auth, error) {
 	if db == nil {
 		return nil, errors.New("db is nil")
 	}
+	if cfg.Tracer == nil {
+		cfg.Tracer = otel.Tracer("github.com/10664kls/estatement/internal/auth")
+	}
+	if cfg.Hasher == nil {
+		cfg.Hasher = BcryptHasher{}
+	}
+	if cfg.AccessKeys == nil {
+		cfg.AccessKeys = NewRotatingKey(aKey)
+	}
+	if cfg.RefreshKeys == nil {
+		cfg.RefreshKeys = NewRotatingKey(rKey)
+	}
+	if len(cfg.Roles) == 0 {
+		cfg.Roles = []string{RoleUser}
+	}
+	if cfg.MinPasswordScore == 0 {
+		cfg.MinPasswordScore = 2
+	}
+	if cfg.MinPasswordScore < 0 || cfg.MinPasswordScore > 4 {
+		return nil, fmt.Errorf("min password score %d is not between 0 and 4", cfg.MinPasswordScore)
+	}
+	if cfg.LoginLockout <= 0 {
+		cfg.LoginLockout = 15 * time.Minute
+	}
 
 	s := &Auth{
-		db:   db,
-		aKey: aKey,
-		rKey: rKey,
-		zlog: zlog,
+		db:     db,
+		aKey:   cfg.AccessKeys,
+		rKey:   cfg.RefreshKeys,
+		zlog:   zlog,
+		tracer: cfg.Tracer,
+		hasher: multiHasher{hasher: cfg.Hasher},
+
+		audience: cfg.Audience,
+		issuer:   cfg.Issuer,
+
+		products: slices.Clone(cfg.Products),
+
+		minPasswordScore: cfg.MinPasswordScore,
+		roles:            append(slices.Clone(cfg.Roles), RoleAdmin),
+
+		lockout: newLoginLockout(cfg.MaxLoginFailures, cfg.LoginLockout),
 	}
 
 	return s, nil
 }
 
 func (s *Auth) Profile(ctx context.Context) (*User, error) {
-	claims := ClaimsFromContext(ctx)
+	claims, err := RequireClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	user, err := getUserByUsername(ctx, s.db, claims.Username)
 	if errors.Is(err, ErrUserNotFound) {
 		return nil, rpcstatus.Error(
@@ -55,49 +198,214 @@ func (s *Auth) Profile(ctx context.Context) (*User, error) {
 	return user, err
 }
 
+type UpdateProfileReq struct {
+	// Timezone is the IANA timezone the exports of the user are written in
+	// when they don't set one, e.g. "Asia/Vientiane". The empty string
+	// clears the preference.
+	// Optional. The preference is left unchanged when it's nil.
+	Timezone *string `json:"timezone"`
+}
+
+// UpdateProfile updates the preferences of the current user.
+func (s *Auth) UpdateProfile(ctx context.Context, req *UpdateProfileReq) (*User, error) {
+	claims, err := RequireClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	zlog := s.zlog.With(
+		zap.String("method", "UpdateProfile"),
+		zap.String("username", claims.Username),
+	)
+
+	zlog.Info("starting to update profile")
+
+	if req.Timezone != nil {
+		tz := strings.TrimSpace(*req.Timezone)
+		if _, err := time.LoadLocation(tz); err != nil {
+			zlog.Info("timezone is not valid", zap.Error(err))
+			st, _ := rpcstatus.New(codes.InvalidArgument, "Your profile is not valid.").
+				WithDetails(&edpb.BadRequest{
+					FieldViolations: []*edpb.BadRequest_FieldViolation{
+						{
+							Field:       "timezone",
+							Description: "timezone must be a valid IANA timezone name",
+						},
+					},
+				})
+			return nil, st.Err()
+		}
+
+		if err := updateTimezone(ctx, s.db, claims.ID, tz); err != nil {
+			zlog.Error("failed to update timezone", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	return s.Profile(ctx)
+}
+
+func updateTimezone(ctx context.Context, db *sql.DB, userID, tz string) error {
+	var v any
+	if tz != "" {
+		v = tz
+	}
+
+	q, args := sq.Update("dbo.tb_user").
+		Set("timezone", v).
+		Where(sq.Eq{"USID": userID}).
+		PlaceholderFormat(sq.AtP).
+		MustSql()
+
+	_, err := db.ExecContext(ctx, q, args...)
+	return err
+}
+
+// Permissions are the actions the user is allowed to do, so the clients can
+// hide the disallowed ones.
+type Permissions struct {
+	Role string `json:"role"`
+
+	// AllProducts is true when the user can access the statements of every
+	// product, Products lists the accessible products otherwise.
+	AllProducts bool     `json:"allProducts"`
+	Products    []string `json:"products"`
+
+	CanExport       bool `json:"canExport"`
+	CanViewInactive bool `json:"canViewInactive"`
+	CanManageUsers  bool `json:"canManageUsers"`
+}
+
+// PermissionsFromClaims returns the permissions granted by the claims.
+func PermissionsFromClaims(claims *Claims) *Permissions {
+	p := &Permissions{
+		Role:      claims.Role,
+		Products:  make([]string, 0),
+		CanExport: true,
+	}
+	if claims.ProductName != "" {
+		p.Products = append(p.Products, claims.ProductName)
+	}
+
+	if claims.IsAdmin() {
+		p.AllProducts = true
+		p.CanViewInactive = true
+		p.CanManageUsers = true
+	}
+	return p
+}
+
+// Permissions returns the permissions of the current user.
+func (s *Auth) Permissions(ctx context.Context) *Permissions {
+	return PermissionsFromClaims(ClaimsFromContext(ctx))
+}
+
 type LoginReq struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	// IP and UserAgent describe the client, they are set by the server
+	// and recorded in the login history. The IP is also checked against the
+	// allowlist of the user.
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
 }
 
 type Token struct {
 	AccessToken  string `json:"accessToken"`
 	RefreshToken string `json:"refreshToken"`
+
+	// MustChangePassword is set when the password of the user was reset.
+	// The access token is then only good to change the password, and no
+	// refresh token is issued. The user logs in again once it's changed.
+	MustChangePassword bool `json:"mustChangePassword"`
 }
 
 func (s *Auth) Login(ctx context.Context, req *LoginReq) (*Token, error) {
 	zlog := s.zlog.With(
 		zap.String("method", "Login"),
-		zap.Any("username", req.Username),
+		zap.String("username", req.Username),
 	)
 
+	ctx, span := s.tracer.Start(ctx, "auth.Login")
+	defer span.End()
+
 	zlog.Info("starting to login")
 
+	if retry := s.lockout.lockedFor(req.Username); retry > 0 {
+		zlog.Info("login locked out", zap.Duration("retry", retry))
+		span.SetAttributes(attribute.Bool("auth.authenticated", false))
+		return nil, errLoginLockedOut(retry)
+	}
+
 	user, err := getUserByUsername(ctx, s.db, req.Username)
 	if errors.Is(err, ErrUserNotFound) {
 		zlog.Info("user not found")
-		return nil, rpcstatus.Error(codes.Unauthenticated, "Your credentials not valid. Please check and try again.")
+		span.SetAttributes(attribute.Bool("auth.authenticated", false))
+		s.lockout.fail(req.Username)
+		return nil, errInvalidCredentials()
 	}
 	if err != nil {
 		zlog.Error("failed to get user by username", zap.Error(err))
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
 		return nil, err
 	}
 
-	pass, err := user.Compare(req.Password)
+	pass, err := s.hasher.Compare(user.password, req.Password)
 	if err != nil || !pass {
 		zlog.Info("password not match", zap.Error(err))
-		return nil, rpcstatus.Error(codes.Unauthenticated, "Your credentials not valid. Please check and try again.")
+		span.SetAttributes(attribute.Bool("auth.authenticated", false))
+		s.lockout.fail(req.Username)
+		return nil, errInvalidCredentials()
+	}
+	s.lockout.reset(req.Username)
+
+	allowlist, err := listAllowedIPs(ctx, s.db, user.ID)
+	if err != nil {
+		zlog.Error("failed to list allowed ips", zap.Error(err))
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+		return nil, err
+	}
+	if !ipAllowed(allowlist, req.IP) {
+		zlog.Info("ip not allowed", zap.String("ip", req.IP))
+		span.SetAttributes(attribute.Bool("auth.authenticated", false))
+		return nil, errIPNotAllowed()
+	}
+
+	if err := s.checkProduct(user); err != nil {
+		zlog.Info("product check failed", zap.String("product", user.ProductName), zap.Error(err))
+		span.SetAttributes(attribute.Bool("auth.authenticated", false))
+		return nil, err
 	}
 
 	token, err := s.genToken(user)
 	if err != nil {
 		zlog.Error("failed to gen token", zap.Error(err))
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
 		return nil, err
 	}
 
+	s.recordLogin(ctx, user, req)
+	if s.hasher.needsRehash(user.password) {
+		s.rehashPassword(ctx, user, req.Password)
+	}
+
+	span.SetAttributes(
+		attribute.Bool("auth.authenticated", true),
+		attribute.String("auth.user_id", user.ID),
+	)
 	return token, nil
 }
 
+// tokenField returns the log field identifying the token by a prefix of its
+// SHA-256, the token itself is never logged.
+func tokenField(token string) zap.Field {
+	return zap.String("tokenSha256", hashAPIToken(token)[:16])
+}
+
 type NewTokenReq struct {
 	Token string `json:"token"`
 }
@@ -105,39 +413,44 @@ type NewTokenReq struct {
 func (s *Auth) RefreshToken(ctx context.Context, req *NewTokenReq) (*Token, error) {
 	zlog := s.zlog.With(
 		zap.String("method", "RefreshToken"),
-		zap.Any("token", req.Token),
+		tokenField(req.Token),
 	)
 
 	zlog.Info("starting to refresh token")
 
-	roles := []paseto.Rule{
-		paseto.NotExpired(),
-		paseto.ValidAt(time.Now()),
-	}
-
-	parser := paseto.MakeParser(roles)
-	token, err := parser.ParseV4Local(s.rKey, req.Token, nil)
+	parser := paseto.MakeParser(s.parseRules())
+	token, err := ParseV4Local(parser, s.rKey, req.Token, nil)
 	if err != nil {
 		zlog.Info("failed to parse token", zap.Error(err))
-		return nil, rpcstatus.Error(codes.Unauthenticated, "Your credentials not valid. Please check and try again.")
+		return nil, errInvalidCredentials()
 	}
 
 	claims := new(Claims)
 	if err := token.Get("profile", claims); err != nil {
 		zlog.Info("failed to get claims", zap.Error(err))
-		return nil, rpcstatus.Error(codes.Unauthenticated, "Your credentials not valid. Please check and try again.")
+		return nil, errInvalidCredentials()
 	}
 
 	user, err := getUserByUsername(ctx, s.db, claims.Username)
 	if errors.Is(err, ErrUserNotFound) {
 		zlog.Info("user not found")
-		return nil, rpcstatus.Error(codes.Unauthenticated, "Your credentials not valid. Please check and try again.")
+		return nil, errInvalidCredentials()
 	}
 	if err != nil {
 		zlog.Error("failed to get user by username", zap.Error(err))
 		return nil, err
 	}
 
+	if err := s.checkProduct(user); err != nil {
+		zlog.Info("product check failed", zap.String("product", user.ProductName), zap.Error(err))
+		return nil, err
+	}
+
+	if user.MustChangePassword {
+		zlog.Info("password change required")
+		return nil, ErrPasswordChangeRequired
+	}
+
 	tk, err := s.genToken(user)
 	if err != nil {
 		zlog.Error("failed to gen token", zap.Error(err))
@@ -147,10 +460,121 @@ func (s *Auth) RefreshToken(ctx context.Context, req *NewTokenReq) (*Token, erro
 	return tk, nil
 }
 
+// checkProduct returns an error if the product of the user does not exist.
+// The users without product are not checked.
+func (s *Auth) checkProduct(user *User) error {
+	if len(s.products) == 0 || user.ProductName == "" {
+		return nil
+	}
+
+	if !slices.Contains(s.products, user.ProductName) {
+		return errProductNotFound()
+	}
+	return nil
+}
+
+// RoleAdmin is the role of the users allowed to manage other users.
+const RoleAdmin = "admin"
+
+// RoleUser is the role of the users without any privilege.
+const RoleUser = "user"
+
+type IntrospectReq struct {
+	Token string `json:"token"`
+}
+
+type Introspection struct {
+	Active    bool       `json:"active"`
+	Claims    *Claims    `json:"claims,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Introspect validates the access token and returns its claims.
+// An invalid or expired token is reported as not active rather than an error.
+func (s *Auth) Introspect(ctx context.Context, req *IntrospectReq) (*Introspection, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "Introspect"),
+	)
+
+	zlog.Info("starting to introspect token")
+
+	parser := paseto.MakeParser(s.parseRules())
+	token, err := ParseV4Local(parser, s.aKey, req.Token, nil)
+	if err != nil {
+		zlog.Info("token is not active", zap.Error(err))
+		return &Introspection{Active: false}, nil
+	}
+
+	claims := new(Claims)
+	if err := token.Get("profile", claims); err != nil {
+		zlog.Info("failed to get claims", zap.Error(err))
+		return &Introspection{Active: false}, nil
+	}
+
+	exp, err := token.GetExpiration()
+	if err != nil {
+		zlog.Info("failed to get expiration", zap.Error(err))
+		return &Introspection{Active: false}, nil
+	}
+
+	return &Introspection{
+		Active:    true,
+		Claims:    claims,
+		ExpiresAt: &exp,
+	}, nil
+}
+
 type Claims struct {
 	ID          string `json:"id"`
 	Username    string `json:"username"`
 	ProductName string `json:"productName"`
+	Role        string `json:"role"`
+
+	// MustChangePassword restricts the token to the change of the password,
+	// see middleware.PasswordChanged.
+	MustChangePassword bool `json:"mustChangePassword,omitempty"`
+}
+
+// IsAdmin reports whether the claims belong to an admin.
+func (c *Claims) IsAdmin() bool {
+	return c.Role == RoleAdmin
+}
+
+// claimsFromUser returns the claims issued to the user.
+func claimsFromUser(user *User) *Claims {
+	return &Claims{
+		ID:          user.ID,
+		Username:    user.Username,
+		ProductName: user.ProductName,
+		Role:        user.Role,
+
+		MustChangePassword: user.MustChangePassword,
+	}
+}
+
+const (
+	// AccessTokenTTL is the lifetime of the access tokens.
+	AccessTokenTTL = time.Hour
+
+	// RefreshTokenTTL is the lifetime of the refresh tokens.
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// TokenRules returns the rules the issued tokens are validated with on top of
+// the expiration ones, to be used by the middleware.
+func (s *Auth) TokenRules() []paseto.Rule {
+	rules := make([]paseto.Rule, 0)
+	if s.audience != "" {
+		rules = append(rules, paseto.ForAudience(s.audience))
+	}
+	if s.issuer != "" {
+		rules = append(rules, paseto.IssuedBy(s.issuer))
+	}
+	return rules
+}
+
+func (s *Auth) parseRules() []paseto.Rule {
+	return append(s.TokenRules(), paseto.NotExpired(), paseto.ValidAt(time.Now()))
 }
 
 func (s *Auth) genToken(user *User) (*Token, error) {
@@ -160,21 +584,29 @@ func (s *Auth) genToken(user *User) (*Token, error) {
 	t.SetSubject(user.Username)
 	t.SetIssuedAt(now)
 	t.SetNotBefore(now)
-	t.SetExpiration(now.Add(time.Hour))
+	t.SetExpiration(now.Add(AccessTokenTTL))
 	t.SetFooter([]byte(now.Format(time.RFC3339)))
+	if s.audience != "" {
+		t.SetAudience(s.audience)
+	}
+	if s.issuer != "" {
+		t.SetIssuer(s.issuer)
+	}
 
-	if err := t.Set("profile", &Claims{
-		ID:          user.ID,
-		Username:    user.Username,
-		ProductName: user.ProductName,
-	}); err != nil {
+	if err := t.Set("profile", claimsFromUser(user)); err != nil {
 		return nil, fmt.Errorf("failed to set claims: %w", err)
 	}
 
-	aToken := t.V4Encrypt(s.aKey, nil)
+	aToken := t.V4Encrypt(s.aKey.Current(), nil)
+	if user.MustChangePassword {
+		return &Token{
+			AccessToken:        aToken,
+			MustChangePassword: true,
+		}, nil
+	}
 
-	t.SetExpiration(now.Add(time.Hour * 7 * 24))
-	rToken := t.V4Encrypt(s.rKey, nil)
+	t.SetExpiration(now.Add(RefreshTokenTTL))
+	rToken := t.V4Encrypt(s.rKey.Current(), nil)
 
 	return &Token{
 		AccessToken:  aToken,
@@ -189,13 +621,30 @@ const (
 )
 
 func ClaimsFromContext(ctx context.Context) *Claims {
-	claims, ok := ctx.Value(claimsKey).(*Claims)
+	claims, ok := ClaimsFromContextOK(ctx)
 	if !ok {
 		return &Claims{}
 	}
 	return claims
 }
 
+// ClaimsFromContextOK returns the claims of the context and whether the
+// context has any, telling the anonymous requests apart.
+func ClaimsFromContextOK(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// RequireClaims returns the claims of the context, or a codes.Unauthenticated
+// error if the request is anonymous.
+func RequireClaims(ctx context.Context) (*Claims, error) {
+	claims, ok := ClaimsFromContextOK(ctx)
+	if !ok {
+		return nil, rpcstatus.Error(codes.Unauthenticated, "You are not authenticated.")
+	}
+	return claims, nil
+}
+
 func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
 	return context.WithValue(ctx, claimsKey, claims)
 }
@@ -204,33 +653,42 @@ type User struct {
 	ID          string `json:"id"`
 	Username    string `json:"username"`
 	ProductName string `json:"productName"`
+	Role        string `json:"role"`
 	password    string
 	CreatedAt   time.Time `json:"createdAt"`
-}
 
-func (u *User) Compare(password string) (bool, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(u.password), bcrypt.DefaultCost)
-	if err != nil {
-		return false, err
-	}
+	// MustChangePassword is set when the password was reset by an admin,
+	// the user must change it before logging in.
+	MustChangePassword bool `json:"mustChangePassword"`
 
-	return bcrypt.CompareHashAndPassword(hashed, []byte(password)) == nil, nil
+	// Timezone is the timezone the exports of the user are written in when
+	// they don't set one, empty when the user has no preference.
+	Timezone string `json:"timezone"`
 }
 
 func getUserByUsername(ctx context.Context, db *sql.DB, username string) (*User, error) {
+	return getUser(ctx, db, sq.Eq{"Username": username})
+}
+
+func getUserByID(ctx context.Context, db *sql.DB, id string) (*User, error) {
+	return getUser(ctx, db, sq.Eq{"USID": id})
+}
+
+func getUser(ctx context.Context, db *sql.DB, pred sq.Eq) (*User, error) {
 	q, args := sq.Select(
 		"TOP 1 USID",
 		"Username",
 		"pwd",
 		"productnames",
+		"role",
 		"createdate",
+		"ISNULL(must_change_pwd, 0)",
+		"ISNULL(timezone, '')",
 	).
 		From("dbo.tb_user").
 		PlaceholderFormat(sq.AtP).
-		Where(sq.Eq{
-			"rectype":  "ADD",
-			"Username": username,
-		}).
+		Where(sq.Eq{"rectype": "ADD"}).
+		Where(pred).
 		MustSql()
 
 	row := db.QueryRowContext(ctx, q, args...)
@@ -241,7 +699,10 @@ func getUserByUsername(ctx context.Context, db *sql.DB, username string) (*User,
 		&u.Username,
 		&u.password,
 		&u.ProductName,
+		&u.Role,
 		&u.CreatedAt,
+		&u.MustChangePassword,
+		&u.Timezone,
 	)
 	if err == sql.ErrNoRows {
 		return nil, ErrUserNotFound
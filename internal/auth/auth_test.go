@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// newTestAuth returns an auth service backed by a mock database, the
+// expected queries are matched when they contain the expected SQL.
+func newTestAuth(t *testing.T, cfg Config) (*Auth, sqlmock.Sqlmock) {
+	t.Helper()
+
+	matcher := sqlmock.QueryMatcherFunc(func(expected, actual string) error {
+		if !strings.Contains(actual, expected) {
+			return &mismatchError{expected: expected, actual: actual}
+		}
+		return nil
+	})
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s, err := NewAuthService(context.Background(), db, paseto.NewV4SymmetricKey(), paseto.NewV4SymmetricKey(), zap.NewNop(), cfg)
+	if err != nil {
+		t.Fatalf("NewAuthService() error = %v", err)
+	}
+	return s, mock
+}
+
+type mismatchError struct {
+	expected, actual string
+}
+
+func (e *mismatchError) Error() string {
+	return "query " + e.actual + " does not contain " + e.expected
+}
+
+// userColumns are the columns scanned by getUser.
+var userColumns = []string{"USID", "Username", "pwd", "productnames", "role", "createdate", "must_change_pwd", "timezone"}
+
+// testUser is a user of the mock database.
+type testUser struct {
+	id, username, hash, product, role string
+	mustChange                        bool
+}
+
+func (u testUser) row() []driver.Value {
+	return []driver.Value{u.id, u.username, u.hash, u.product, u.role, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), u.mustChange, ""}
+}
+
+// userRows returns the rows of getUser for the users.
+func userRows(users ...testUser) *sqlmock.Rows {
+	rows := sqlmock.NewRows(userColumns)
+	for _, u := range users {
+		rows.AddRow(u.row()...)
+	}
+	return rows
+}
+
+// mustHash returns the hash of the password with the hasher.
+func mustHash(t *testing.T, h Hasher, password string) string {
+	t.Helper()
+
+	hash, err := h.Hash(password)
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	return hash
+}
+
+func userContext(u testUser) context.Context {
+	return ContextWithClaims(context.Background(), claimsFromUser(&User{
+		ID:                 u.id,
+		Username:           u.username,
+		ProductName:        u.product,
+		Role:               u.role,
+		MustChangePassword: u.mustChange,
+	}))
+}
+
+func TestPermissionsFromClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *Claims
+		want   Permissions
+	}{
+		{
+			name:   "admin",
+			claims: &Claims{ID: "A1", Username: "admin", Role: RoleAdmin},
+			want: Permissions{
+				Role:            RoleAdmin,
+				AllProducts:     true,
+				Products:        []string{},
+				CanExport:       true,
+				CanViewInactive: true,
+				CanManageUsers:  true,
+			},
+		},
+		{
+			name:   "user",
+			claims: &Claims{ID: "U1", Username: "alice", ProductName: "LOAN", Role: RoleUser},
+			want: Permissions{
+				Role:      RoleUser,
+				Products:  []string{"LOAN"},
+				CanExport: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestAuth(t, Config{})
+			got := s.Permissions(ContextWithClaims(context.Background(), tt.claims))
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Permissions() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimsFromContextOK(t *testing.T) {
+	claims := &Claims{ID: "1", Username: "alice", Role: "user"}
+
+	got, ok := ClaimsFromContextOK(ContextWithClaims(context.Background(), claims))
+	if !ok || got != claims {
+		t.Errorf("ClaimsFromContextOK() = %v, %v, want %v, true", got, ok, claims)
+	}
+
+	for name, ctx := range map[string]context.Context{
+		"absent": context.Background(),
+		"nil":    ContextWithClaims(context.Background(), nil),
+	} {
+		if got, ok := ClaimsFromContextOK(ctx); ok {
+			t.Errorf("ClaimsFromContextOK(%s) = %v, true, want false", name, got)
+		}
+		if got := ClaimsFromContext(ctx); got == nil || got.Username != "" {
+			t.Errorf("ClaimsFromContext(%s) = %v, want empty claims", name, got)
+		}
+	}
+}
+
+func TestProfileRequiresClaims(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+
+	_, err := s.Profile(context.Background())
+	if got := rpcstatus.Code(err); got != codes.Unauthenticated {
+		t.Errorf("Profile() code = %v, want %v", got, codes.Unauthenticated)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
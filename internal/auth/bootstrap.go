@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+)
+
+// EnsureAdmin creates the initial admin with the username and password if
+// there's no admin yet, so a fresh deployment can be logged into. The admin
+// must change the password on the first login. It does nothing when an
+// admin exists, so it's safe to run on every startup, even from several
+// instances at once.
+func (s *Auth) EnsureAdmin(ctx context.Context, username, password string) error {
+	username = strings.TrimSpace(username)
+	zlog := s.zlog.With(
+		zap.String("method", "EnsureAdmin"),
+		zap.String("username", username),
+	)
+
+	zlog.Info("starting to ensure admin")
+
+	if username == "" {
+		return errors.New("admin username is empty")
+	}
+	if err := s.checkPassword(username, password); err != nil {
+		return fmt.Errorf("admin password is refused: %w", err)
+	}
+
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return fmt.Errorf("failed to gen user id: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The admins are read with range locks held to the commit, so the
+	// instances starting together don't both create one.
+	exists, err := adminExists(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("failed to check admin: %w", err)
+	}
+	if exists {
+		zlog.Info("admin already exists")
+		return nil
+	}
+
+	taken, err := usernameExists(ctx, tx, username)
+	if err != nil {
+		return fmt.Errorf("failed to check username: %w", err)
+	}
+	if taken {
+		return fmt.Errorf("username %q is taken by a user who is not an admin", username)
+	}
+
+	if err := createAdmin(ctx, tx, id, username, hash); err != nil {
+		return fmt.Errorf("failed to create admin: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	zlog.Info("admin created")
+	return nil
+}
+
+func adminExists(ctx context.Context, tx *sql.Tx) (bool, error) {
+	q, args := sq.Select("COUNT(*)").
+		From("dbo.tb_user WITH (UPDLOCK, HOLDLOCK)").
+		Where(sq.Eq{"rectype": "ADD", "role": RoleAdmin}).
+		PlaceholderFormat(sq.AtP).
+		MustSql()
+
+	var n int
+	if err := tx.QueryRowContext(ctx, q, args...).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func usernameExists(ctx context.Context, tx *sql.Tx, username string) (bool, error) {
+	q, args := sq.Select("COUNT(*)").
+		From("dbo.tb_user").
+		Where(sq.Eq{"rectype": "ADD", "Username": username}).
+		PlaceholderFormat(sq.AtP).
+		MustSql()
+
+	var n int
+	if err := tx.QueryRowContext(ctx, q, args...).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func createAdmin(ctx context.Context, tx *sql.Tx, id, username, hash string) error {
+	q, args := sq.Insert("dbo.tb_user").
+		Columns(
+			"USID",
+			"Username",
+			"pwd",
+			"productnames",
+			"role",
+			"rectype",
+			"must_change_pwd",
+			"createdate",
+		).
+		Values(
+			id,
+			username,
+			hash,
+			"",
+			RoleAdmin,
+			"ADD",
+			true,
+			time.Now(),
+		).
+		PlaceholderFormat(sq.AtP).
+		MustSql()
+
+	_, err := tx.ExecContext(ctx, q, args...)
+	return err
+}
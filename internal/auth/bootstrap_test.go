@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// passwordHash matches the hash of the password.
+type passwordHash struct {
+	hasher   Hasher
+	password string
+}
+
+func (a passwordHash) Match(v driver.Value) bool {
+	hash, ok := v.(string)
+	if !ok {
+		return false
+	}
+	match, err := a.hasher.Compare(hash, a.password)
+	return err == nil && match
+}
+
+func countRows(n int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"count"}).AddRow(n)
+}
+
+func TestEnsureAdmin(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	const password = "correct horse battery staple"
+
+	// The first run creates the admin.
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM dbo.tb_user WITH (UPDLOCK, HOLDLOCK)").WithArgs("ADD", RoleAdmin).WillReturnRows(countRows(0))
+	mock.ExpectQuery("FROM dbo.tb_user").WithArgs("root", "ADD").WillReturnRows(countRows(0))
+	mock.ExpectExec("INSERT INTO dbo.tb_user").
+		WithArgs(sqlmock.AnyArg(), "root", passwordHash{s.hasher, password}, "", RoleAdmin, "ADD", true, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// The next runs find it.
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM dbo.tb_user WITH (UPDLOCK, HOLDLOCK)").WillReturnRows(countRows(1))
+	mock.ExpectRollback()
+
+	for range 2 {
+		if err := s.EnsureAdmin(context.Background(), " root ", password); err != nil {
+			t.Fatalf("EnsureAdmin() error = %v", err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnsureAdminUsernameTaken(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM dbo.tb_user WITH (UPDLOCK, HOLDLOCK)").WillReturnRows(countRows(0))
+	mock.ExpectQuery("FROM dbo.tb_user").WithArgs("alice", "ADD").WillReturnRows(countRows(1))
+	mock.ExpectRollback()
+
+	if err := s.EnsureAdmin(context.Background(), "alice", "correct horse battery staple"); err == nil {
+		t.Error("EnsureAdmin() error = nil, want the username refused")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnsureAdminWeakPassword(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+
+	if err := s.EnsureAdmin(context.Background(), "root", "root"); err == nil {
+		t.Error("EnsureAdmin() error = nil, want the password refused")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
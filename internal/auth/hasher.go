@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher hashes and verifies passwords.
+type Hasher interface {
+	// Hash returns the encoded hash of the password.
+	Hash(password string) (string, error)
+
+	// Compare reports whether the password matches the encoded hash.
+	Compare(hash, password string) (bool, error)
+}
+
+// BcryptHasher hashes passwords with bcrypt.
+type BcryptHasher struct {
+	// Cost is the bcrypt cost.
+	// Optional. Default value bcrypt.DefaultCost.
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	cost := h.Cost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (h BcryptHasher) Compare(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Argon2idHasher hashes passwords with argon2id, the hash is encoded in the
+// PHC string format: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>.
+type Argon2idHasher struct {
+	// Time is the number of passes over the memory.
+	// Optional. Default value 1.
+	Time uint32
+
+	// Memory is the size of the memory in KiB.
+	// Optional. Default value 64 MiB.
+	Memory uint32
+
+	// Threads is the number of threads.
+	// Optional. Default value 4.
+	Threads uint8
+}
+
+const (
+	argon2idSaltLen = 16
+	argon2idKeyLen  = 32
+
+	// argon2idMaxMemory is the biggest memory in KiB accepted from a stored
+	// hash, so a tampered one can't exhaust the memory of the service.
+	argon2idMaxMemory = 1024 * 1024
+)
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	if h.Time == 0 {
+		h.Time = 1
+	}
+	if h.Memory == 0 {
+		h.Memory = 64 * 1024
+	}
+	if h.Threads == 0 {
+		h.Threads = 4
+	}
+
+	salt := make([]byte, argon2idSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, argon2idKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Memory,
+		h.Time,
+		h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h Argon2idHasher) Compare(hash, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, errors.New("argon2id: malformed hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, fmt.Errorf("argon2id: malformed version: %w", err)
+	}
+	if version != argon2.Version {
+		return false, fmt.Errorf("argon2id: unsupported version %d", version)
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, fmt.Errorf("argon2id: malformed params: %w", err)
+	}
+	if time < 1 || threads < 1 || memory > argon2idMaxMemory {
+		return false, fmt.Errorf("argon2id: malformed params: m=%d,t=%d,p=%d", memory, time, threads)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("argon2id: malformed salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("argon2id: malformed key: %w", err)
+	}
+
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// multiHasher hashes new passwords with the configured hasher and verifies
+// stored hashes with the hasher matching their prefix, so the users can be
+// migrated from one algorithm to another gradually.
+// Stored values without a known prefix are legacy plain text passwords.
+type multiHasher struct {
+	hasher Hasher
+}
+
+func (m multiHasher) Hash(password string) (string, error) {
+	return m.hasher.Hash(password)
+}
+
+func (m multiHasher) Compare(hash, password string) (bool, error) {
+	switch hashScheme(hash) {
+	case schemeArgon2id:
+		return Argon2idHasher{}.Compare(hash, password)
+
+	case schemeBcrypt:
+		return BcryptHasher{}.Compare(hash, password)
+
+	default:
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1, nil
+	}
+}
+
+// needsRehash reports whether the stored hash was not made by the configured
+// hasher, e.g. a legacy plain text password or a bcrypt hash once argon2id is
+// configured. The hashers other than BcryptHasher and Argon2idHasher are
+// never rehashed, their prefix is unknown.
+func (m multiHasher) needsRehash(hash string) bool {
+	var want string
+	switch m.hasher.(type) {
+	case Argon2idHasher, *Argon2idHasher:
+		want = schemeArgon2id
+	case BcryptHasher, *BcryptHasher:
+		want = schemeBcrypt
+	default:
+		return false
+	}
+	return hashScheme(hash) != want
+}
+
+const (
+	schemeArgon2id = "argon2id"
+	schemeBcrypt   = "bcrypt"
+)
+
+// hashScheme returns the scheme of the stored hash by its prefix, empty for
+// the legacy plain text passwords.
+func hashScheme(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return schemeArgon2id
+
+	case strings.HasPrefix(hash, "$2a$"),
+		strings.HasPrefix(hash, "$2b$"),
+		strings.HasPrefix(hash, "$2y$"):
+		return schemeBcrypt
+
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestHashers(t *testing.T) {
+	hashers := map[string]Hasher{
+		"bcrypt":   BcryptHasher{Cost: 4},
+		"argon2id": Argon2idHasher{Memory: 1024},
+	}
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			hash := mustHash(t, h, "Violet-Harbor-Lantern-93")
+
+			for _, c := range []Hasher{h, multiHasher{hasher: BcryptHasher{}}, multiHasher{hasher: Argon2idHasher{}}} {
+				ok, err := c.Compare(hash, "Violet-Harbor-Lantern-93")
+				if err != nil || !ok {
+					t.Errorf("%T.Compare(right password) = %v, %v, want true", c, ok, err)
+				}
+				ok, err = c.Compare(hash, "violet-harbor-lantern-93")
+				if err != nil || ok {
+					t.Errorf("%T.Compare(wrong password) = %v, %v, want false", c, ok, err)
+				}
+			}
+		})
+	}
+}
+
+func TestArgon2idCompareMalformedParams(t *testing.T) {
+	hash := mustHash(t, Argon2idHasher{Memory: 1024}, "Violet-Harbor-Lantern-93")
+	tests := []struct {
+		name, params string
+	}{
+		{name: "no pass", params: "m=1024,t=0,p=4"},
+		{name: "no thread", params: "m=1024,t=1,p=0"},
+		{name: "memory over the cap", params: "m=4194304,t=1,p=4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := strings.Replace(hash, "m=1024,t=1,p=4", tt.params, 1)
+			ok, err := Argon2idHasher{}.Compare(tampered, "Violet-Harbor-Lantern-93")
+			if ok || err == nil || !strings.Contains(err.Error(), "malformed") {
+				t.Errorf("Compare(%s) = %v, %v, want a malformed hash error", tt.params, ok, err)
+			}
+		})
+	}
+}
+
+func TestMultiHasherLegacyPlainText(t *testing.T) {
+	m := multiHasher{hasher: BcryptHasher{}}
+	if ok, _ := m.Compare("secret", "secret"); !ok {
+		t.Error("Compare(plain text) = false, want true")
+	}
+	if ok, _ := m.Compare("secret", "Secret"); ok {
+		t.Error("Compare(wrong plain text) = true, want false")
+	}
+}
+
+func TestMultiHasherNeedsRehash(t *testing.T) {
+	bcryptHash := mustHash(t, BcryptHasher{Cost: 4}, "pw")
+	argonHash := mustHash(t, Argon2idHasher{Memory: 1024}, "pw")
+
+	tests := []struct {
+		hasher Hasher
+		hash   string
+		want   bool
+	}{
+		{BcryptHasher{}, bcryptHash, false},
+		{BcryptHasher{}, argonHash, true},
+		{BcryptHasher{}, "pw", true},
+		{Argon2idHasher{}, argonHash, false},
+		{Argon2idHasher{}, bcryptHash, true},
+	}
+	for _, tt := range tests {
+		if got := (multiHasher{hasher: tt.hasher}).needsRehash(tt.hash); got != tt.want {
+			t.Errorf("needsRehash(%T, %.10q) = %v, want %v", tt.hasher, tt.hash, got, tt.want)
+		}
+	}
+}
+
+// argon2idHash matches the argon2id hashes.
+type argon2idHash struct{}
+
+func (argon2idHash) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, "$argon2id$")
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	s, mock := newTestAuth(t, Config{Hasher: Argon2idHasher{Memory: 1024}})
+	u := testUser{id: "U1", username: "alice", hash: mustHash(t, BcryptHasher{Cost: 4}, "Violet-Harbor-Lantern-93"), role: "user"}
+
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(u))
+	mock.ExpectQuery("FROM dbo.tb_user_ip_allowlist").WillReturnRows(sqlmock.NewRows([]string{"cidr"}))
+	mock.ExpectExec("INSERT INTO dbo.tb_login_history").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE dbo.tb_user").
+		WithArgs(argon2idHash{}, false, "U1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := s.Login(context.Background(), &LoginReq{Username: "alice", Password: "Violet-Harbor-Lantern-93", IP: "10.1.2.3"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoginKeepsCurrentHash(t *testing.T) {
+	s, mock := newTestAuth(t, Config{Hasher: BcryptHasher{Cost: 4}})
+	u := testUser{id: "U1", username: "alice", hash: mustHash(t, BcryptHasher{Cost: 4}, "Violet-Harbor-Lantern-93"), role: "user"}
+
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(u))
+	mock.ExpectQuery("FROM dbo.tb_user_ip_allowlist").WillReturnRows(sqlmock.NewRows([]string{"cidr"}))
+	mock.ExpectExec("INSERT INTO dbo.tb_login_history").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := s.Login(context.Background(), &LoginReq{Username: "alice", Password: "Violet-Harbor-Lantern-93", IP: "10.1.2.3"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+)
+
+// KeyProvider provides the keys used to encrypt and decrypt the tokens.
+// It is consulted on every request so the keys can be rotated without restart.
+type KeyProvider interface {
+	// Current returns the key the new tokens are encrypted with.
+	Current() paseto.V4SymmetricKey
+
+	// Keys returns the keys accepted to decrypt the tokens, the current one first.
+	Keys() []paseto.V4SymmetricKey
+}
+
+// previousKey is a replaced key, accepted for decryption until the end of
+// its grace window.
+type previousKey struct {
+	key   paseto.V4SymmetricKey
+	until time.Time
+}
+
+type keySet struct {
+	current  paseto.V4SymmetricKey
+	previous []previousKey
+}
+
+// RotatingKey is a KeyProvider whose key can be replaced atomically.
+// Every replaced key is still accepted for decryption during its own grace
+// window, so the tokens issued before a rotation keep working until they
+// expire, even when the key is rotated again in the meantime.
+type RotatingKey struct {
+	set atomic.Pointer[keySet]
+	now func() time.Time
+
+	// mu serializes the rotations, the readers only load the set.
+	mu sync.Mutex
+}
+
+// NewRotatingKey returns a RotatingKey starting with the given key.
+func NewRotatingKey(key paseto.V4SymmetricKey) *RotatingKey {
+	k := &RotatingKey{now: time.Now}
+	k.set.Store(&keySet{current: key})
+	return k
+}
+
+// Rotate replaces the current key, the replaced one is accepted for
+// decryption for the grace duration. The keys replaced earlier are kept
+// until their own grace window ends.
+func (k *RotatingKey) Rotate(key paseto.V4SymmetricKey, grace time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	old := k.set.Load()
+	previous := []previousKey{{key: old.current, until: now.Add(grace)}}
+	for _, p := range old.previous {
+		if now.Before(p.until) {
+			previous = append(previous, p)
+		}
+	}
+	k.set.Store(&keySet{current: key, previous: previous})
+}
+
+func (k *RotatingKey) Current() paseto.V4SymmetricKey {
+	return k.set.Load().current
+}
+
+func (k *RotatingKey) Keys() []paseto.V4SymmetricKey {
+	set := k.set.Load()
+	now := k.now()
+	keys := []paseto.V4SymmetricKey{set.current}
+	for _, p := range set.previous {
+		if now.Before(p.until) {
+			keys = append(keys, p.key)
+		}
+	}
+	return keys
+}
+
+// ParseV4Local parses the token with the first key of the provider
+// able to decrypt it.
+func ParseV4Local(parser paseto.Parser, keys KeyProvider, token string, implicit []byte) (*paseto.Token, error) {
+	var errs error
+	for _, key := range keys.Keys() {
+		t, err := parser.ParseV4Local(key, token, implicit)
+		if err == nil {
+			return t, nil
+		}
+		errs = errors.Join(errs, err)
+	}
+	return nil, errs
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+)
+
+// hasKey reports whether the key is among the keys.
+func hasKey(keys []paseto.V4SymmetricKey, key paseto.V4SymmetricKey) bool {
+	for _, k := range keys {
+		if k.ExportHex() == key.ExportHex() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRotatingKeyGraceAcrossRotations(t *testing.T) {
+	first, second, third := paseto.NewV4SymmetricKey(), paseto.NewV4SymmetricKey(), paseto.NewV4SymmetricKey()
+	now := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	k := NewRotatingKey(first)
+	k.now = func() time.Time { return now }
+
+	k.Rotate(second, time.Hour)
+	now = now.Add(10 * time.Minute)
+	k.Rotate(third, 15*time.Minute)
+
+	keys := k.Keys()
+	if len(keys) != 3 || keys[0].ExportHex() != third.ExportHex() {
+		t.Fatalf("Keys() = %d keys, want the current key first and both previous ones", len(keys))
+	}
+	if !hasKey(keys, first) {
+		t.Error("Keys() dropped the first key before the end of its grace window")
+	}
+
+	now = now.Add(20 * time.Minute)
+	keys = k.Keys()
+	if hasKey(keys, second) {
+		t.Error("Keys() kept the second key after the end of its grace window")
+	}
+	if !hasKey(keys, first) {
+		t.Error("Keys() dropped the first key before the end of its grace window")
+	}
+
+	now = now.Add(time.Hour)
+	if got := len(k.Keys()); got != 1 {
+		t.Errorf("Keys() = %d keys, want only the current one once every grace window ended", got)
+	}
+}
+
+func TestRotatingKeyConcurrentRotations(t *testing.T) {
+	k := NewRotatingKey(paseto.NewV4SymmetricKey())
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k.Rotate(paseto.NewV4SymmetricKey(), time.Hour)
+		}()
+	}
+	wg.Wait()
+
+	// Every rotation replaced a key, none of them got lost.
+	if got := len(k.Keys()); got != 21 {
+		t.Errorf("Keys() = %d keys, want 21 after 20 concurrent rotations", got)
+	}
+}
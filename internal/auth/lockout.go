@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// errLoginLockedOut is a helper function to create an error when the user
+// is locked out after too many failed logins, retry tells when to log in
+// again.
+func errLoginLockedOut(retry time.Duration) error {
+	s, _ := rpcstatus.New(codes.ResourceExhausted, "Too many failed logins. Please try again later.").
+		WithDetails(
+			&edpb.ErrorInfo{
+				Reason: "LOGIN_LOCKED_OUT",
+				Domain: "auth",
+			},
+			&edpb.RetryInfo{RetryDelay: durationpb.New(retry)},
+		)
+	return s.Err()
+}
+
+// maxTrackedLogins is the number of usernames with failed logins above which
+// the stale ones are swept, so unknown usernames can't grow it for ever.
+const maxTrackedLogins = 10000
+
+// loginFailures are the failed logins of a username.
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// loginLockout locks the usernames out after maxFailures consecutive failed
+// logins, for the duration. A zero maxFailures never locks them out.
+type loginLockout struct {
+	maxFailures int
+	duration    time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+func newLoginLockout(maxFailures int, duration time.Duration) *loginLockout {
+	return &loginLockout{
+		maxFailures: maxFailures,
+		duration:    duration,
+		now:         time.Now,
+		failures:    make(map[string]*loginFailures),
+	}
+}
+
+// lockedFor returns how long the username is still locked out, 0 when it
+// isn't.
+func (l *loginLockout) lockedFor(username string) time.Duration {
+	if l.maxFailures <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[strings.ToLower(username)]
+	if !ok {
+		return 0
+	}
+	return max(f.lockedUntil.Sub(l.now()), 0)
+}
+
+// fail records a failed login of the username, it's locked out once the
+// failures reach maxFailures.
+func (l *loginLockout) fail(username string) {
+	if l.maxFailures <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.failures) >= maxTrackedLogins {
+		l.sweep(now)
+	}
+
+	key := strings.ToLower(username)
+	f, ok := l.failures[key]
+	if !ok || now.Sub(f.last) > l.duration {
+		f = &loginFailures{}
+		l.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= l.maxFailures {
+		f.count = 0
+		f.lockedUntil = now.Add(l.duration)
+	}
+}
+
+// reset forgets the failed logins of the username, e.g. once it logged in.
+func (l *loginLockout) reset(username string) {
+	if l.maxFailures <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, strings.ToLower(username))
+}
+
+// sweep drops the failures which neither lock out nor count anymore.
+func (l *loginLockout) sweep(now time.Time) {
+	for key, f := range l.failures {
+		if now.Sub(f.last) > l.duration && !now.Before(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// retryDelay returns the retry delay of the error, 0 when it has none.
+func retryDelay(err error) time.Duration {
+	for _, d := range rpcstatus.Convert(err).Details() {
+		if ri, ok := d.(*edpb.RetryInfo); ok {
+			return ri.GetRetryDelay().AsDuration()
+		}
+	}
+	return 0
+}
+
+func TestLoginLockedOut(t *testing.T) {
+	s, mock := newTestAuth(t, Config{Hasher: BcryptHasher{Cost: 4}, MaxLoginFailures: 2, LoginLockout: time.Minute})
+	now := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.lockout.now = func() time.Time { return now }
+	u := testUser{id: "U1", username: "alice", hash: mustHash(t, BcryptHasher{Cost: 4}, "Violet-Harbor-Lantern-93"), role: RoleUser}
+
+	for range 2 {
+		mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(u))
+		_, err := s.Login(context.Background(), &LoginReq{Username: "alice", Password: "wrong"})
+		if code := rpcstatus.Code(err); code != codes.Unauthenticated {
+			t.Fatalf("Login(wrong password) code = %v, want %v", code, codes.Unauthenticated)
+		}
+	}
+
+	// The right password is refused as well while locked out.
+	now = now.Add(20 * time.Second)
+	_, err := s.Login(context.Background(), &LoginReq{Username: "Alice", Password: "Violet-Harbor-Lantern-93"})
+	if code := rpcstatus.Code(err); code != codes.ResourceExhausted {
+		t.Fatalf("Login() locked out code = %v, want %v", code, codes.ResourceExhausted)
+	}
+	if got := retryDelay(err); got != 40*time.Second {
+		t.Errorf("Login() locked out retry delay = %v, want %v", got, 40*time.Second)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("locked out login queried the database: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(u))
+	mock.ExpectQuery("FROM dbo.tb_user_ip_allowlist").WillReturnRows(sqlmock.NewRows([]string{"cidr"}))
+	mock.ExpectExec("INSERT INTO dbo.tb_login_history").WillReturnResult(sqlmock.NewResult(0, 1))
+	if _, err := s.Login(context.Background(), &LoginReq{Username: "alice", Password: "Violet-Harbor-Lantern-93"}); err != nil {
+		t.Fatalf("Login() once the lockout expired error = %v", err)
+	}
+}
+
+func TestLoginLockoutResetOnSuccess(t *testing.T) {
+	l := newLoginLockout(2, time.Minute)
+	now := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	l.fail("alice")
+	l.reset("alice")
+	l.fail("alice")
+	if got := l.lockedFor("alice"); got != 0 {
+		t.Errorf("lockedFor() = %v, want 0 once the failures were reset", got)
+	}
+
+	l.fail("alice")
+	if got := l.lockedFor("alice"); got != time.Minute {
+		t.Errorf("lockedFor() = %v, want %v after consecutive failures", got, time.Minute)
+	}
+}
+
+func TestLoginLockoutDisabled(t *testing.T) {
+	l := newLoginLockout(0, time.Minute)
+	for range 10 {
+		l.fail("alice")
+	}
+	if got := l.lockedFor("alice"); got != 0 {
+		t.Errorf("lockedFor() = %v, want 0 when disabled", got)
+	}
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/10664kls/estatement/internal/pager"
+	sq "github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// LoginRecord is an entry of the login history of a user.
+type LoginRecord struct {
+	ID        string    `json:"id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ListLoginsReq struct {
+	PageToken string `json:"pageToken" query:"pageToken"`
+	PageSize  uint64 `json:"pageSize" query:"pageSize"`
+}
+
+type ListLoginsResult struct {
+	Logins        []*LoginRecord `json:"logins"`
+	NextPageToken string         `json:"nextPageToken"`
+}
+
+// ListLogins returns the recent logins of the current user, newest first.
+func (s *Auth) ListLogins(ctx context.Context, req *ListLoginsReq) (*ListLoginsResult, error) {
+	claims, err := RequireClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	zlog := s.zlog.With(
+		zap.String("method", "ListLogins"),
+		zap.String("username", claims.Username),
+		zap.Any("req", req),
+	)
+
+	zlog.Info("starting to list logins")
+
+	var cursor *pager.Cursor
+	if req.PageToken != "" {
+		c, err := pager.DecodeCursor(req.PageToken)
+		if err != nil {
+			st, _ := rpcstatus.New(codes.InvalidArgument, "Your request is not valid.").
+				WithDetails(&edpb.BadRequest{
+					FieldViolations: []*edpb.BadRequest_FieldViolation{
+						{
+							Field:       "pageToken",
+							Description: "pageToken must be a valid token",
+						},
+					},
+				})
+			return nil, st.Err()
+		}
+		cursor = c
+	}
+
+	logins, err := listLogins(ctx, s.db, claims.ID, pager.Size(req.PageSize), cursor)
+	if err != nil {
+		zlog.Error("failed to list logins", zap.Error(err))
+		return nil, err
+	}
+
+	pageToken := pager.NextPageToken(len(logins), req.PageSize, func() *pager.Cursor {
+		last := logins[len(logins)-1]
+		return &pager.Cursor{
+			ID:   last.ID,
+			Time: last.CreatedAt,
+		}
+	})
+
+	return &ListLoginsResult{
+		Logins:        logins,
+		NextPageToken: pageToken,
+	}, nil
+}
+
+// recordLogin adds the login to the history of the user.
+// It is best effort, a failure does not fail the login.
+func (s *Auth) recordLogin(ctx context.Context, user *User, req *LoginReq) {
+	id, err := randomHex(16)
+	if err == nil {
+		err = createLogin(ctx, s.db, user.ID, &LoginRecord{
+			ID:        id,
+			IP:        req.IP,
+			UserAgent: req.UserAgent,
+			CreatedAt: time.Now(),
+		})
+	}
+	if err != nil {
+		s.zlog.Error("failed to record login",
+			zap.String("username", user.Username),
+			zap.Error(err),
+		)
+	}
+}
+
+// rehashPassword stores the password of the user hashed with the configured
+// hasher, once it's verified against a hash of another algorithm. The login
+// doesn't fail when it can't, the user is rehashed on the next one.
+func (s *Auth) rehashPassword(ctx context.Context, user *User, password string) {
+	hash, err := s.hasher.Hash(password)
+	if err == nil {
+		err = updatePassword(ctx, s.db, user.ID, hash, user.MustChangePassword)
+	}
+	if err != nil {
+		s.zlog.Error("failed to rehash password",
+			zap.String("username", user.Username),
+			zap.Error(err),
+		)
+	}
+}
+
+func createLogin(ctx context.Context, db *sql.DB, userID string, l *LoginRecord) error {
+	q, args := sq.Insert("dbo.tb_login_history").
+		Columns(
+			"id",
+			"USID",
+			"ip",
+			"user_agent",
+			"createdate",
+		).
+		Values(
+			l.ID,
+			userID,
+			l.IP,
+			l.UserAgent,
+			l.CreatedAt,
+		).
+		PlaceholderFormat(sq.AtP).
+		MustSql()
+
+	_, err := db.ExecContext(ctx, q, args...)
+	return err
+}
+
+func listLogins(ctx context.Context, db *sql.DB, userID string, limit uint64, cursor *pager.Cursor) ([]*LoginRecord, error) {
+	and := sq.And{sq.Eq{"USID": userID}}
+	if cursor != nil {
+		and = append(and, sq.Or{
+			sq.Lt{"createdate": cursor.Time},
+			sq.And{
+				sq.Eq{"createdate": cursor.Time},
+				sq.Lt{"id": cursor.ID},
+			},
+		})
+	}
+
+	q, args := sq.Select(
+		"id",
+		"ip",
+		"user_agent",
+		"createdate",
+	).
+		From("dbo.tb_login_history").
+		Options("TOP "+strconv.FormatUint(limit, 10)).
+		PlaceholderFormat(sq.AtP).
+		Where(and).
+		OrderBy("createdate DESC", "id DESC").
+		MustSql()
+
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logins := make([]*LoginRecord, 0)
+	for rows.Next() {
+		var l LoginRecord
+		if err := rows.Scan(
+			&l.ID,
+			&l.IP,
+			&l.UserAgent,
+			&l.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		logins = append(logins, &l)
+	}
+
+	return logins, rows.Err()
+}
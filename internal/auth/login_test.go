@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// loginRows returns the rows of listLogins for the logins of the IDs, the
+// first one being the newest.
+func loginRows(at time.Time, ids ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "ip", "user_agent", "createdate"})
+	for i, id := range ids {
+		rows.AddRow(id, "10.0.0.1", "test", at.Add(-time.Duration(i)*time.Minute))
+	}
+	return rows
+}
+
+func TestListLoginsPages(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	alice := testUser{id: "U1", username: "alice", role: RoleUser}
+	at := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("FROM dbo.tb_login_history").
+		WithArgs("U1").
+		WillReturnRows(loginRows(at, "L5", "L4"))
+	mock.ExpectQuery("FROM dbo.tb_login_history").
+		WithArgs("U1", at.Add(-time.Minute), at.Add(-time.Minute), "L4").
+		WillReturnRows(loginRows(at.Add(-2*time.Minute), "L3", "L2"))
+	mock.ExpectQuery("FROM dbo.tb_login_history").
+		WithArgs("U1", at.Add(-3*time.Minute), at.Add(-3*time.Minute), "L2").
+		WillReturnRows(loginRows(at.Add(-4*time.Minute), "L1"))
+
+	seen := make([]string, 0)
+	req := &ListLoginsReq{PageSize: 2}
+	for page := 1; ; page++ {
+		result, err := s.ListLogins(userContext(alice), req)
+		if err != nil {
+			t.Fatalf("ListLogins() page %d error = %v", page, err)
+		}
+		for _, l := range result.Logins {
+			seen = append(seen, l.ID)
+		}
+		if result.NextPageToken == "" {
+			break
+		}
+		if page == 3 {
+			t.Fatal("ListLogins() returned a next page token on the last page")
+		}
+		req.PageToken = result.NextPageToken
+	}
+
+	if got, want := fmt.Sprint(seen), "[L5 L4 L3 L2 L1]"; got != want {
+		t.Errorf("ListLogins() walked %s, want %s", got, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoginRecordsHistory(t *testing.T) {
+	s, mock := newTestAuth(t, Config{Hasher: BcryptHasher{Cost: 4}})
+	u := testUser{id: "U1", username: "alice", hash: mustHash(t, BcryptHasher{Cost: 4}, "Violet-Harbor-Lantern-93"), role: RoleUser}
+
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(u))
+	mock.ExpectQuery("FROM dbo.tb_user_ip_allowlist").WillReturnRows(sqlmock.NewRows([]string{"cidr"}))
+	mock.ExpectExec("INSERT INTO dbo.tb_login_history").
+		WithArgs(sqlmock.AnyArg(), "U1", "10.1.2.3", "test-agent", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := s.Login(context.Background(), &LoginReq{
+		Username:  "alice",
+		Password:  "Violet-Harbor-Lantern-93",
+		IP:        "10.1.2.3",
+		UserAgent: "test-agent",
+	})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListLoginsOfCaller(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	bob := testUser{id: "U2", username: "bob", role: RoleUser}
+
+	mock.ExpectQuery("FROM dbo.tb_login_history WHERE (USID = @p1)").
+		WithArgs("U2").
+		WillReturnRows(loginRows(time.Now(), "L1"))
+
+	result, err := s.ListLogins(userContext(bob), &ListLoginsReq{})
+	if err != nil {
+		t.Fatalf("ListLogins() error = %v", err)
+	}
+	if len(result.Logins) != 1 || result.Logins[0].ID != "L1" {
+		t.Errorf("ListLogins() = %v, want the login of bob", result.Logins)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := s.ListLogins(context.Background(), &ListLoginsReq{}); rpcstatus.Code(err) != codes.Unauthenticated {
+		t.Errorf("ListLogins() without claims error = %v, want %s", err, codes.Unauthenticated)
+	}
+}
@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	sq "github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// minPasswordLen is the minimum length of the new passwords, whatever their
+// score is.
+const minPasswordLen = 8
+
+// commonPasswords are the most used passwords, they are refused along with
+// their variants ending with digits or symbols, e.g. "password123".
+var commonPasswords = map[string]bool{
+	"password": true, "passw0rd": true, "p@ssword": true, "p@ssw0rd": true,
+	"123456": true, "12345678": true, "123456789": true, "1234567890": true,
+	"qwerty": true, "qwertyuiop": true, "asdfghjkl": true, "zxcvbnm": true,
+	"abc123": true, "iloveyou": true, "welcome": true, "admin": true,
+	"administrator": true, "letmein": true, "monkey": true, "dragon": true,
+	"football": true, "baseball": true, "sunshine": true, "princess": true,
+	"master": true, "shadow": true, "superman": true, "trustno1": true,
+	"changeme": true, "secret": true, "default": true, "login": true,
+	"statement": true, "estatement": true, "bank": true, "banking": true,
+}
+
+// errWeakPassword is a helper function to create an error when the new
+// password is refused.
+func errWeakPassword(reason, description string) error {
+	s, _ := rpcstatus.New(codes.InvalidArgument, "Your new password is too weak. "+description).
+		WithDetails(
+			&edpb.ErrorInfo{
+				Reason: reason,
+				Domain: "auth",
+			},
+			&edpb.BadRequest{
+				FieldViolations: []*edpb.BadRequest_FieldViolation{
+					{
+						Field:       "newPassword",
+						Description: description,
+					},
+				},
+			},
+		)
+	return s.Err()
+}
+
+// ErrPasswordChangeRequired is returned to the users whose password was
+// reset, until they change it.
+var ErrPasswordChangeRequired = func() error {
+	s, _ := rpcstatus.New(codes.FailedPrecondition, "Your password was reset. Please change it first.").
+		WithDetails(&edpb.ErrorInfo{
+			Reason: "PASSWORD_CHANGE_REQUIRED",
+			Domain: "auth",
+		})
+	return s.Err()
+}()
+
+// passwordScore estimates the strength of the password from 0, trivially
+// guessable, to 4, very hard to guess, like zxcvbn does. The estimate is
+// based on the entropy of the characters, the repeated characters and the
+// sequences like "abcd" or "4321" only count once.
+func passwordScore(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	charset := 0
+	if lower {
+		charset += 26
+	}
+	if upper {
+		charset += 26
+	}
+	if digit {
+		charset += 10
+	}
+	if other {
+		charset += 33
+	}
+	if charset == 0 {
+		return 0
+	}
+
+	// The characters repeating or following the previous one add nothing.
+	runes := []rune(password)
+	length := 0
+	for i, r := range runes {
+		if i > 0 {
+			d := r - runes[i-1]
+			if d == 0 || d == 1 || d == -1 {
+				continue
+			}
+		}
+		length++
+	}
+
+	bits := float64(length) * math.Log2(float64(charset))
+	switch {
+	case bits < 25:
+		return 0
+	case bits < 40:
+		return 1
+	case bits < 55:
+		return 2
+	case bits < 70:
+		return 3
+	}
+	return 4
+}
+
+// checkPassword returns an error if the new password of the user is too
+// common, contains the username or scores below the minimum score.
+func (s *Auth) checkPassword(username, password string) error {
+	if len([]rune(password)) < minPasswordLen {
+		return errWeakPassword("PASSWORD_TOO_SHORT", fmt.Sprintf("It must have at least %d characters.", minPasswordLen))
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[strings.TrimRightFunc(lower, func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})] {
+		return errWeakPassword("PASSWORD_TOO_COMMON", "It is too common.")
+	}
+
+	if u := strings.ToLower(strings.TrimSpace(username)); len(u) >= 3 && strings.Contains(lower, u) {
+		return errWeakPassword("PASSWORD_CONTAINS_USERNAME", "It must not contain your username.")
+	}
+
+	if passwordScore(password) < s.minPasswordScore {
+		return errWeakPassword("PASSWORD_TOO_WEAK", "Use a longer password mixing letters, digits and symbols, or a passphrase.")
+	}
+	return nil
+}
+
+type ChangePasswordReq struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// ChangePassword replaces the password of the current user, the current
+// password must be given. The new password must pass the strength check.
+// The users who must change their password do it with the restricted token
+// issued by Login, so the checks of the login apply to them as well.
+func (s *Auth) ChangePassword(ctx context.Context, req *ChangePasswordReq) error {
+	claims, err := RequireClaims(ctx)
+	if err != nil {
+		return err
+	}
+
+	zlog := s.zlog.With(
+		zap.String("method", "ChangePassword"),
+		zap.String("username", claims.Username),
+	)
+
+	zlog.Info("starting to change password")
+
+	user, err := getUserByUsername(ctx, s.db, claims.Username)
+	if errors.Is(err, ErrUserNotFound) {
+		zlog.Info("user not found")
+		return errInvalidCredentials()
+	}
+	if err != nil {
+		zlog.Error("failed to get user by username", zap.Error(err))
+		return err
+	}
+
+	pass, err := s.hasher.Compare(user.password, req.CurrentPassword)
+	if err != nil || !pass {
+		zlog.Info("password not match", zap.Error(err))
+		return errInvalidCredentials()
+	}
+
+	if err := s.checkPassword(user.Username, req.NewPassword); err != nil {
+		zlog.Info("new password refused", zap.Error(err))
+		return err
+	}
+
+	hash, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		zlog.Error("failed to hash password", zap.Error(err))
+		return err
+	}
+
+	if err := updatePassword(ctx, s.db, user.ID, hash, false); err != nil {
+		zlog.Error("failed to update password", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+type ResetPasswordReq struct {
+	// NewPassword is the new password of the user, it must pass the strength
+	// check. A random password is generated when it's empty.
+	NewPassword string `json:"newPassword"`
+
+	// Temporary makes the user change the password before logging in again.
+	// The generated passwords are always temporary.
+	Temporary bool `json:"temporary"`
+}
+
+type ResetPasswordResult struct {
+	// GeneratedPassword is only set when the password was generated, it's
+	// to be handed to the user.
+	GeneratedPassword  string `json:"generatedPassword,omitempty"`
+	MustChangePassword bool   `json:"mustChangePassword"`
+}
+
+// ResetPassword replaces the password of the user without the current one.
+// Only admins are allowed to reset the passwords.
+func (s *Auth) ResetPassword(ctx context.Context, userID string, req *ResetPasswordReq) (*ResetPasswordResult, error) {
+	claims := ClaimsFromContext(ctx)
+	zlog := s.zlog.With(
+		zap.String("method", "ResetPassword"),
+		zap.String("userId", userID),
+		zap.String("by", claims.Username),
+	)
+
+	zlog.Info("starting to reset password")
+
+	if !claims.IsAdmin() {
+		zlog.Info("not an admin")
+		return nil, rpcstatus.Error(codes.PermissionDenied, "You are not allowed to reset the passwords.")
+	}
+
+	user, err := getUserByID(ctx, s.db, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		zlog.Info("user not found")
+		return nil, err
+	}
+	if err != nil {
+		zlog.Error("failed to get user by id", zap.Error(err))
+		return nil, err
+	}
+
+	result := &ResetPasswordResult{MustChangePassword: req.Temporary}
+	password := req.NewPassword
+	if password == "" {
+		password, err = randomHex(12)
+		if err != nil {
+			zlog.Error("failed to gen password", zap.Error(err))
+			return nil, err
+		}
+		result.GeneratedPassword = password
+		result.MustChangePassword = true
+	} else if err := s.checkPassword(user.Username, password); err != nil {
+		zlog.Info("new password refused", zap.Error(err))
+		return nil, err
+	}
+
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		zlog.Error("failed to hash password", zap.Error(err))
+		return nil, err
+	}
+
+	if err := updatePassword(ctx, s.db, user.ID, hash, result.MustChangePassword); err != nil {
+		zlog.Error("failed to update password", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func updatePassword(ctx context.Context, db *sql.DB, userID, hash string, mustChange bool) error {
+	q, args := sq.Update("dbo.tb_user").
+		Set("pwd", hash).
+		Set("must_change_pwd", mustChange).
+		Where(sq.Eq{"USID": userID}).
+		PlaceholderFormat(sq.AtP).
+		MustSql()
+
+	_, err := db.ExecContext(ctx, q, args...)
+	return err
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// weakPasswordReason returns the reason of the error refusing a password.
+func weakPasswordReason(err error) string {
+	for _, d := range rpcstatus.Convert(err).Details() {
+		if info, ok := d.(*edpb.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
+
+func TestCheckPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		score    int
+		password string
+		want     string
+	}{
+		{name: "common", password: "password123", want: "PASSWORD_TOO_COMMON"},
+		{name: "common with symbols", password: "Qwerty!!", want: "PASSWORD_TOO_COMMON"},
+		{name: "username", password: "xAlice-2025!", want: "PASSWORD_CONTAINS_USERNAME"},
+		{name: "too short", password: "a1!", want: "PASSWORD_TOO_SHORT"},
+		{name: "sequence", password: "abcdefgh", want: "PASSWORD_TOO_WEAK"},
+		{name: "passphrase", password: "correct horse battery staple", want: ""},
+		{name: "default score", password: "gardenpath", want: ""},
+		{name: "strict score", score: 4, password: "gardenpath", want: "PASSWORD_TOO_WEAK"},
+		{name: "strict score passphrase", score: 4, password: "Violet-Harbor-Lantern-93", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestAuth(t, Config{MinPasswordScore: tt.score})
+
+			err := s.checkPassword("alice", tt.password)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("checkPassword(%q) error = %v, want nil", tt.password, err)
+				}
+				return
+			}
+			if code := rpcstatus.Code(err); code != codes.InvalidArgument {
+				t.Errorf("checkPassword(%q) code = %v, want %v", tt.password, code, codes.InvalidArgument)
+			}
+			if got := weakPasswordReason(err); got != tt.want {
+				t.Errorf("checkPassword(%q) reason = %q, want %q", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangePasswordRejectsWeakPassword(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	u := testUser{id: "U1", username: "alice", hash: mustHash(t, BcryptHasher{}, "Temp-Pass-42"), role: "user"}
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(u))
+
+	err := s.ChangePassword(userContext(u), &ChangePasswordReq{
+		CurrentPassword: "Temp-Pass-42",
+		NewPassword:     "alice2025",
+	})
+	if got := weakPasswordReason(err); got != "PASSWORD_CONTAINS_USERNAME" {
+		t.Errorf("ChangePassword() reason = %q, want %q", got, "PASSWORD_CONTAINS_USERNAME")
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	admin := userContext(testUser{id: "A1", username: "admin", role: RoleAdmin})
+	alice := testUser{id: "U1", username: "alice", role: RoleUser}
+
+	tests := []struct {
+		name       string
+		req        *ResetPasswordReq
+		mustChange bool
+		generated  bool
+	}{
+		{name: "given", req: &ResetPasswordReq{NewPassword: "Violet-Harbor-Lantern-93"}},
+		{name: "temporary", req: &ResetPasswordReq{NewPassword: "Violet-Harbor-Lantern-93", Temporary: true}, mustChange: true},
+		{name: "generated", req: &ResetPasswordReq{}, mustChange: true, generated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestAuth(t, Config{Hasher: BcryptHasher{Cost: 4}})
+			mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(alice))
+			mock.ExpectExec("UPDATE dbo.tb_user").
+				WithArgs(sqlmock.AnyArg(), tt.mustChange, "U1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			result, err := s.ResetPassword(admin, "U1", tt.req)
+			if err != nil {
+				t.Fatalf("ResetPassword() error = %v", err)
+			}
+			if result.MustChangePassword != tt.mustChange {
+				t.Errorf("ResetPassword() mustChangePassword = %v, want %v", result.MustChangePassword, tt.mustChange)
+			}
+			if got := result.GeneratedPassword != ""; got != tt.generated {
+				t.Errorf("ResetPassword() generatedPassword = %q, want generated %v", result.GeneratedPassword, tt.generated)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestResetPasswordRequiresAdmin(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+
+	_, err := s.ResetPassword(userContext(testUser{id: "U2", username: "bob", role: RoleUser}), "U1", &ResetPasswordReq{})
+	if code := rpcstatus.Code(err); code != codes.PermissionDenied {
+		t.Errorf("ResetPassword() code = %v, want %v", code, codes.PermissionDenied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoginMustChangePassword(t *testing.T) {
+	s, mock := newTestAuth(t, Config{Hasher: BcryptHasher{Cost: 4}})
+	u := testUser{id: "U1", username: "alice", hash: mustHash(t, BcryptHasher{Cost: 4}, "Temp-Pass-42"), role: RoleUser, mustChange: true}
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(u))
+	mock.ExpectQuery("FROM dbo.tb_user_ip_allowlist").WillReturnRows(sqlmock.NewRows([]string{"cidr"}))
+	mock.ExpectExec("INSERT INTO dbo.tb_login_history").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	token, err := s.Login(context.Background(), &LoginReq{Username: "alice", Password: "Temp-Pass-42"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if !token.MustChangePassword || token.RefreshToken != "" {
+		t.Errorf("Login() = %+v, want a restricted token without refresh token", token)
+	}
+}
+
+func TestChangePasswordRequiresClaims(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+
+	err := s.ChangePassword(context.Background(), &ChangePasswordReq{
+		CurrentPassword: "guess",
+		NewPassword:     "correct horse battery staple",
+	})
+	if code := rpcstatus.Code(err); code != codes.Unauthenticated {
+		t.Errorf("ChangePassword() code = %v, want %v", code, codes.Unauthenticated)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChangePasswordOfMustChangeUser(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	u := testUser{id: "U1", username: "alice", hash: mustHash(t, BcryptHasher{}, "Temp-Pass-42"), role: "user", mustChange: true}
+
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(u))
+	mock.ExpectExec("UPDATE dbo.tb_user").
+		WithArgs(sqlmock.AnyArg(), false, "U1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := s.ChangePassword(userContext(u), &ChangePasswordReq{
+		CurrentPassword: "Temp-Pass-42",
+		NewPassword:     "Violet-Harbor-Lantern-93",
+	})
+	if err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestLoginChecksProduct(t *testing.T) {
+	tests := []struct {
+		name    string
+		product string
+		want    codes.Code
+	}{
+		{"valid product", "LOAN", codes.OK},
+		{"orphaned product", "LEASING", codes.PermissionDenied},
+		{"no product", "", codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestAuth(t, Config{Hasher: BcryptHasher{Cost: 4}, Products: []string{"LOAN", "CARD"}})
+			u := testUser{id: "U1", username: "alice", hash: mustHash(t, BcryptHasher{Cost: 4}, "Violet-Harbor-Lantern-93"), product: tt.product, role: RoleUser}
+
+			mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(u))
+			mock.ExpectQuery("FROM dbo.tb_user_ip_allowlist").WillReturnRows(sqlmock.NewRows([]string{"cidr"}))
+			if tt.want == codes.OK {
+				mock.ExpectExec("INSERT INTO dbo.tb_login_history").WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			_, err := s.Login(context.Background(), &LoginReq{Username: "alice", Password: "Violet-Harbor-Lantern-93"})
+			if code := rpcstatus.Code(err); code != tt.want {
+				t.Errorf("Login() code = %v, want %v", code, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestUpdateUserProductWithoutStatements(t *testing.T) {
+	// CARD is in the catalog even though no statement has it yet.
+	s, mock := newTestAuth(t, Config{Products: []string{"LOAN", "CARD"}})
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(testAlice))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE dbo.tb_user").WithArgs("CARD", RoleUser, "U1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	product := "CARD"
+	if _, err := s.UpdateUser(userContext(testAdmin), "U1", &UpdateUserReq{ProductName: &product}); err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+
+	product = "LEASING"
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(testAlice))
+	_, err := s.UpdateUser(userContext(testAdmin), "U1", &UpdateUserReq{ProductName: &product})
+	if code := rpcstatus.Code(err); code != codes.InvalidArgument {
+		t.Errorf("UpdateUser(unknown product) code = %v, want %v", code, codes.InvalidArgument)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// signToken returns an access token of the claims signed by s, valid from
+// issued to expires.
+func signToken(t *testing.T, s *Auth, claims *Claims, issued, expires time.Time) string {
+	t.Helper()
+
+	token := paseto.NewToken()
+	token.SetIssuedAt(issued)
+	token.SetNotBefore(issued)
+	token.SetExpiration(expires)
+	if err := token.Set("profile", claims); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	return token.V4Encrypt(s.aKey.Current(), nil)
+}
+
+func TestIntrospectActive(t *testing.T) {
+	s, _ := newTestAuth(t, Config{})
+	token, err := s.genToken(&User{ID: "U1", Username: "alice", Role: RoleUser})
+	if err != nil {
+		t.Fatalf("genToken() error = %v", err)
+	}
+
+	got, err := s.Introspect(context.Background(), &IntrospectReq{Token: token.AccessToken})
+	if err != nil {
+		t.Fatalf("Introspect() error = %v", err)
+	}
+	if !got.Active || got.Claims == nil || got.Claims.Username != "alice" {
+		t.Fatalf("Introspect() = %+v, want the active claims of alice", got)
+	}
+	if got.ExpiresAt == nil || time.Until(*got.ExpiresAt) > AccessTokenTTL {
+		t.Errorf("Introspect() expiresAt = %v, want within %v", got.ExpiresAt, AccessTokenTTL)
+	}
+}
+
+func TestIntrospectExpired(t *testing.T) {
+	s, _ := newTestAuth(t, Config{})
+	now := time.Now()
+	token := signToken(t, s, &Claims{ID: "U1", Username: "alice"}, now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	got, err := s.Introspect(context.Background(), &IntrospectReq{Token: token})
+	if err != nil {
+		t.Fatalf("Introspect() error = %v", err)
+	}
+	if got.Active || got.Claims != nil || got.ExpiresAt != nil {
+		t.Errorf("Introspect() = %+v, want inactive", got)
+	}
+}
+
+func TestIntrospectMalformed(t *testing.T) {
+	s, _ := newTestAuth(t, Config{})
+
+	for _, token := range []string{"", "not-a-token", "v4.local.garbage"} {
+		got, err := s.Introspect(context.Background(), &IntrospectReq{Token: token})
+		if err != nil {
+			t.Fatalf("Introspect(%q) error = %v", token, err)
+		}
+		if got.Active || got.Claims != nil {
+			t.Errorf("Introspect(%q) = %+v, want inactive", token, got)
+		}
+	}
+}
+
+// introspectActive reports whether the access token of the user minted by
+// from is active for to.
+func introspectActive(t *testing.T, from, to *Auth) bool {
+	t.Helper()
+
+	token, err := from.genToken(&User{ID: "U1", Username: "alice", Role: RoleUser})
+	if err != nil {
+		t.Fatalf("genToken() error = %v", err)
+	}
+	got, err := to.Introspect(context.Background(), &IntrospectReq{Token: token.AccessToken})
+	if err != nil {
+		t.Fatalf("Introspect() error = %v", err)
+	}
+	return got.Active
+}
+
+func TestAudience(t *testing.T) {
+	keys := NewRotatingKey(paseto.NewV4SymmetricKey())
+	statements, _ := newTestAuth(t, Config{Audience: "statements", AccessKeys: keys})
+	reports, _ := newTestAuth(t, Config{Audience: "reports", AccessKeys: keys})
+	unset, _ := newTestAuth(t, Config{AccessKeys: keys})
+
+	if !introspectActive(t, statements, statements) {
+		t.Error("token of the matching audience is not active")
+	}
+	if introspectActive(t, reports, statements) {
+		t.Error("token of another audience is active")
+	}
+	if introspectActive(t, unset, statements) {
+		t.Error("token without audience is active")
+	}
+	if !introspectActive(t, reports, unset) {
+		t.Error("token is not active without the audience check")
+	}
+}
+
+func TestIssuer(t *testing.T) {
+	keys := NewRotatingKey(paseto.NewV4SymmetricKey())
+	ours, _ := newTestAuth(t, Config{Issuer: "estatement", AccessKeys: keys})
+	foreign, _ := newTestAuth(t, Config{Issuer: "gateway", AccessKeys: keys})
+
+	if !introspectActive(t, ours, ours) {
+		t.Error("token of the issuer is not active")
+	}
+	if introspectActive(t, foreign, ours) {
+		t.Error("token of a foreign issuer is active")
+	}
+}
+
+func TestRefreshTokenNotLogged(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	core, logs := observer.New(zap.DebugLevel)
+	s.zlog = zap.New(core)
+
+	now := time.Now()
+	token := paseto.NewToken()
+	token.SetIssuedAt(now)
+	token.SetNotBefore(now)
+	token.SetExpiration(now.Add(time.Hour))
+	if err := token.Set("profile", &Claims{ID: "U1", Username: "alice"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	refresh := token.V4Encrypt(s.rKey.Current(), nil)
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnError(errors.New("connection reset"))
+
+	for _, tk := range []string{refresh, "v4.local.not-a-token"} {
+		if _, err := s.RefreshToken(context.Background(), &NewTokenReq{Token: tk}); err == nil {
+			t.Fatalf("RefreshToken() error = nil, want an error")
+		}
+	}
+
+	if logs.Len() == 0 {
+		t.Fatal("RefreshToken() logged nothing")
+	}
+	for _, entry := range logs.All() {
+		logged := entry.Message + fmt.Sprint(entry.ContextMap())
+		for _, tk := range []string{refresh, "not-a-token"} {
+			if strings.Contains(logged, tk) {
+				t.Errorf("RefreshToken() logged the token: %s", logged)
+			}
+		}
+		if _, ok := entry.ContextMap()["tokenSha256"]; !ok {
+			t.Errorf("RefreshToken() log %q has no token hash", entry.Message)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// GetUser returns the user, only admins are allowed to get the other users.
+// The password hash is never returned.
+func (s *Auth) GetUser(ctx context.Context, userID string) (*User, error) {
+	claims := ClaimsFromContext(ctx)
+	zlog := s.zlog.With(
+		zap.String("method", "GetUser"),
+		zap.String("userId", userID),
+		zap.String("by", claims.Username),
+	)
+
+	zlog.Info("starting to get user")
+
+	if !claims.IsAdmin() {
+		zlog.Info("not an admin")
+		return nil, rpcstatus.Error(codes.PermissionDenied, "You are not allowed to manage the users.")
+	}
+
+	user, err := getUserByID(ctx, s.db, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		zlog.Info("user not found")
+		return nil, err
+	}
+	if err != nil {
+		zlog.Error("failed to get user by id", zap.Error(err))
+		return nil, err
+	}
+
+	return user, nil
+}
+
+type UpdateUserReq struct {
+	// ProductName is the product the statements of the user are restricted
+	// to, it must exist. The empty string gives access to every product.
+	// Optional. The product is left unchanged when it's nil.
+	ProductName *string `json:"productName"`
+
+	// Role is the role of the user, e.g. RoleAdmin.
+	// Optional. The role is left unchanged when it's nil.
+	Role *string `json:"role"`
+}
+
+// UpdateUser updates the product and the role of the user, only admins are
+// allowed to. The role must be one of Config.Roles, and the admin role of the
+// last admin can't be dropped, so there's always one left.
+// The changes apply to the tokens issued from the next login or refresh.
+func (s *Auth) UpdateUser(ctx context.Context, userID string, req *UpdateUserReq) (*User, error) {
+	claims := ClaimsFromContext(ctx)
+	zlog := s.zlog.With(
+		zap.String("method", "UpdateUser"),
+		zap.String("userId", userID),
+		zap.String("by", claims.Username),
+	)
+
+	zlog.Info("starting to update user")
+
+	if !claims.IsAdmin() {
+		zlog.Info("not an admin")
+		return nil, rpcstatus.Error(codes.PermissionDenied, "You are not allowed to manage the users.")
+	}
+
+	user, err := getUserByID(ctx, s.db, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		zlog.Info("user not found")
+		return nil, err
+	}
+	if err != nil {
+		zlog.Error("failed to get user by id", zap.Error(err))
+		return nil, err
+	}
+
+	violations := make([]*edpb.BadRequest_FieldViolation, 0)
+	if req.ProductName != nil {
+		user.ProductName = strings.TrimSpace(*req.ProductName)
+		if err := s.checkProduct(user); err != nil {
+			violations = append(violations, &edpb.BadRequest_FieldViolation{
+				Field:       "productName",
+				Description: "productName must be an existing product",
+			})
+		}
+	}
+	wasAdmin := user.Role == RoleAdmin
+	if req.Role != nil {
+		user.Role = strings.TrimSpace(*req.Role)
+		if !slices.Contains(s.roles, user.Role) {
+			violations = append(violations, &edpb.BadRequest_FieldViolation{
+				Field:       "role",
+				Description: "role must be one of " + strings.Join(s.roles, ", "),
+			})
+		}
+	}
+	if len(violations) > 0 {
+		zlog.Info("user is not valid")
+		st, _ := rpcstatus.New(codes.InvalidArgument, "User is not valid.").
+			WithDetails(&edpb.BadRequest{FieldViolations: violations})
+		return nil, st.Err()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		zlog.Error("failed to begin transaction", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if wasAdmin && user.Role != RoleAdmin {
+		// The other admins are read with range locks held to the commit, so
+		// two admins dropping each other's role don't leave none.
+		exists, err := otherAdminExists(ctx, tx, user.ID)
+		if err != nil {
+			zlog.Error("failed to check admins", zap.Error(err))
+			return nil, err
+		}
+		if !exists {
+			zlog.Info("admin role of the last admin dropped")
+			return nil, rpcstatus.Error(codes.FailedPrecondition, "The admin role of the last admin can't be dropped.")
+		}
+	}
+
+	if err := updateUser(ctx, tx, user); err != nil {
+		zlog.Error("failed to update user", zap.Error(err))
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		zlog.Error("failed to commit transaction", zap.Error(err))
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// otherAdminExists reports whether there's an admin other than the user.
+func otherAdminExists(ctx context.Context, tx *sql.Tx, userID string) (bool, error) {
+	q, args := sq.Select("COUNT(*)").
+		From("dbo.tb_user WITH (UPDLOCK, HOLDLOCK)").
+		Where(sq.Eq{"rectype": "ADD", "role": RoleAdmin}).
+		Where(sq.NotEq{"USID": userID}).
+		PlaceholderFormat(sq.AtP).
+		MustSql()
+
+	var n int
+	if err := tx.QueryRowContext(ctx, q, args...).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func updateUser(ctx context.Context, tx *sql.Tx, user *User) error {
+	q, args := sq.Update("dbo.tb_user").
+		Set("productnames", user.ProductName).
+		Set("role", user.Role).
+		Where(sq.Eq{"USID": user.ID}).
+		PlaceholderFormat(sq.AtP).
+		MustSql()
+
+	_, err := tx.ExecContext(ctx, q, args...)
+	return err
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+var (
+	testAdmin = testUser{id: "A1", username: "root", role: RoleAdmin}
+	testAlice = testUser{id: "U1", username: "alice", hash: "$2a$10$hash", product: "LOAN", role: RoleUser}
+)
+
+func TestGetUser(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	mock.ExpectQuery("FROM dbo.tb_user").WithArgs("ADD", "U1").WillReturnRows(userRows(testAlice))
+
+	user, err := s.GetUser(userContext(testAdmin), "U1")
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	if user.ID != "U1" || user.ProductName != "LOAN" {
+		t.Errorf("GetUser() = %+v, want alice", user)
+	}
+	if user.password == "" {
+		t.Error("GetUser() has no password hash, want it kept unexported")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetUserNotFound(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows())
+
+	_, err := s.GetUser(userContext(testAdmin), "U9")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUser() error = %v, want %v", err, ErrUserNotFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetUserRequiresAdmin(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+
+	_, err := s.GetUser(userContext(testAlice), "U1")
+	if code := rpcstatus.Code(err); code != codes.PermissionDenied {
+		t.Errorf("GetUser() code = %v, want %v", code, codes.PermissionDenied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	s, mock := newTestAuth(t, Config{Roles: []string{RoleUser, "auditor"}})
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(testAlice))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE dbo.tb_user").WithArgs("CARD", "auditor", "U1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	product, role := " CARD ", "auditor"
+	user, err := s.UpdateUser(userContext(testAdmin), "U1", &UpdateUserReq{ProductName: &product, Role: &role})
+	if err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+	if user.ProductName != "CARD" || user.Role != "auditor" {
+		t.Errorf("UpdateUser() = %+v, want CARD auditor", user)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateUserUnknownRole(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(testAlice))
+
+	role := "superuser"
+	_, err := s.UpdateUser(userContext(testAdmin), "U1", &UpdateUserReq{Role: &role})
+	if code := rpcstatus.Code(err); code != codes.InvalidArgument {
+		t.Errorf("UpdateUser() code = %v, want %v", code, codes.InvalidArgument)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateUserNotFound(t *testing.T) {
+	s, mock := newTestAuth(t, Config{})
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows())
+
+	role := RoleUser
+	_, err := s.UpdateUser(userContext(testAdmin), "U9", &UpdateUserReq{Role: &role})
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("UpdateUser() error = %v, want %v", err, ErrUserNotFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateUserDropsAdminRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		others int
+		want   codes.Code
+	}{
+		{"last admin", 0, codes.FailedPrecondition},
+		{"another admin left", 1, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestAuth(t, Config{})
+			mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRows(testAdmin))
+			mock.ExpectBegin()
+			mock.ExpectQuery("WITH (UPDLOCK, HOLDLOCK)").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.others))
+			if tt.want == codes.OK {
+				mock.ExpectExec("UPDATE dbo.tb_user").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			role := RoleUser
+			_, err := s.UpdateUser(userContext(testAdmin), "A1", &UpdateUserReq{Role: &role})
+			if code := rpcstatus.Code(err); code != tt.want {
+				t.Errorf("UpdateUser() code = %v, want %v", code, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Package errs defines the errors shared by the services.
+package errs
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// NotFoundError is returned when a resource is not found.
+// It converts to a codes.NotFound status, so the services can return it as is.
+type NotFoundError struct {
+	// Resource is the kind of the resource, e.g. "statement".
+	Resource string
+
+	// ID identifies the missing resource.
+	// Optional. Default value "", a NotFoundError without ID matches every
+	// NotFoundError of the same resource with errors.Is.
+	ID string
+}
+
+// NotFound returns a NotFoundError of the resource.
+func NotFound(resource, id string) error {
+	return &NotFoundError{Resource: resource, ID: id}
+}
+
+func (e *NotFoundError) Error() string {
+	if e.ID == "" {
+		return e.Resource + " not found"
+	}
+	return e.Resource + " " + e.ID + " not found"
+}
+
+// Is reports whether target is a NotFoundError of the same resource, and of
+// the same ID unless target has none.
+func (e *NotFoundError) Is(target error) bool {
+	t, ok := target.(*NotFoundError)
+	if !ok {
+		return false
+	}
+	return t.Resource == e.Resource && (t.ID == "" || t.ID == e.ID)
+}
+
+// GRPCStatus returns the codes.NotFound status of the error, with the
+// <RESOURCE>_NOT_FOUND reason. The ID is kept out of the message.
+func (e *NotFoundError) GRPCStatus() *rpcstatus.Status {
+	s, _ := rpcstatus.New(codes.NotFound, capitalize(e.Resource)+" not found.").
+		WithDetails(&edpb.ErrorInfo{
+			Reason: strings.ToUpper(strings.ReplaceAll(e.Resource, " ", "_")) + "_NOT_FOUND",
+			Domain: e.Resource,
+		})
+	return s
+}
+
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
@@ -0,0 +1,63 @@
+// Package feature resolves the feature flags of the requests, so the new
+// behaviors can be rolled out gradually.
+package feature
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// ExportDictionary adds the data dictionary sheet to every export, as if
+// the request set includeDictionary.
+const ExportDictionary = "export_dictionary"
+
+// Flags are the feature flags of a request keyed by name, the missing flags
+// are disabled.
+type Flags map[string]bool
+
+// Resolver resolves the flags of the request. The claims of the user are
+// in the context of the request when it's authenticated.
+type Resolver func(r *http.Request) Flags
+
+// HeaderResolver returns a Resolver starting from the defaults, which the
+// clients can override for the overridable flags with a comma separated
+// list of names in the header, a name prefixed with "-" disables the flag.
+// The other names of the header are ignored.
+func HeaderResolver(header string, defaults Flags, overridable []string) Resolver {
+	allowed := make(map[string]bool, len(overridable))
+	for _, name := range overridable {
+		allowed[name] = true
+	}
+
+	return func(r *http.Request) Flags {
+		flags := make(Flags, len(defaults))
+		for name, on := range defaults {
+			flags[name] = on
+		}
+
+		for _, name := range strings.Split(r.Header.Get(header), ",") {
+			name = strings.TrimSpace(name)
+			on := !strings.HasPrefix(name, "-")
+			name = strings.TrimPrefix(name, "-")
+			if allowed[name] {
+				flags[name] = on
+			}
+		}
+		return flags
+	}
+}
+
+type flagsKey struct{}
+
+// ContextWithFlags returns a copy of ctx carrying the flags.
+func ContextWithFlags(ctx context.Context, flags Flags) context.Context {
+	return context.WithValue(ctx, flagsKey{}, flags)
+}
+
+// FlagFromContext reports whether the flag is enabled for the request of ctx.
+// Every flag is disabled when ctx carries none.
+func FlagFromContext(ctx context.Context, name string) bool {
+	flags, _ := ctx.Value(flagsKey{}).(Flags)
+	return flags[name]
+}
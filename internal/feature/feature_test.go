@@ -0,0 +1,45 @@
+package feature
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderResolver(t *testing.T) {
+	resolve := HeaderResolver("X-Features", Flags{"a": true, "b": false}, []string{"a", "b"})
+
+	tests := []struct {
+		name   string
+		header string
+		want   Flags
+	}{
+		{name: "defaults", header: "", want: Flags{"a": true, "b": false}},
+		{name: "enable", header: "b", want: Flags{"a": true, "b": true}},
+		{name: "disable", header: " -a , b", want: Flags{"a": false, "b": true}},
+		{name: "not overridable", header: "c", want: Flags{"a": true, "b": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Features", tt.header)
+			if got := resolve(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolve(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagFromContext(t *testing.T) {
+	ctx := ContextWithFlags(context.Background(), Flags{ExportDictionary: true})
+	if !FlagFromContext(ctx, ExportDictionary) {
+		t.Errorf("FlagFromContext(%q) = false, want true", ExportDictionary)
+	}
+	if FlagFromContext(ctx, "other") {
+		t.Error("FlagFromContext(\"other\") = true, want false")
+	}
+	if FlagFromContext(context.Background(), ExportDictionary) {
+		t.Errorf("FlagFromContext(%q) without flags = true, want false", ExportDictionary)
+	}
+}
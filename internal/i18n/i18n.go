@@ -0,0 +1,105 @@
+package i18n
+
+import (
+	"golang.org/x/text/language"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// Catalog holds the localized user-facing messages.
+// Messages are keyed by language, then by either an ErrorInfo reason or
+// a gRPC code name (e.g. "NotFound"), the reason takes precedence.
+// English is the source language, so it has no entries: the original message
+// is returned as is.
+type Catalog struct {
+	tags     []language.Tag
+	matcher  language.Matcher
+	messages map[language.Tag]map[string]string
+}
+
+// NewCatalog returns a catalog serving the given messages.
+func NewCatalog(messages map[language.Tag]map[string]string) *Catalog {
+	tags := []language.Tag{language.English}
+	for tag := range messages {
+		tags = append(tags, tag)
+	}
+
+	return &Catalog{
+		tags:     tags,
+		matcher:  language.NewMatcher(tags),
+		messages: messages,
+	}
+}
+
+// Localize returns the message of s translated to the best language matching
+// the given Accept-Language header value.
+// It falls back to the original message if no translation is available.
+func (c *Catalog) Localize(acceptLanguage string, s *status.Status) string {
+	msgs := c.messages[c.match(acceptLanguage)]
+	if msgs == nil {
+		return s.Message()
+	}
+
+	for _, d := range s.Details() {
+		if ei, ok := d.(*edpb.ErrorInfo); ok {
+			if msg, ok := msgs[ei.GetReason()]; ok {
+				return msg
+			}
+		}
+	}
+
+	if msg, ok := msgs[s.Code().String()]; ok {
+		return msg
+	}
+
+	return s.Message()
+}
+
+func (c *Catalog) match(acceptLanguage string) language.Tag {
+	prefs, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(prefs) == 0 {
+		return language.English
+	}
+
+	_, i, confidence := c.matcher.Match(prefs...)
+	if confidence == language.No {
+		return language.English
+	}
+	return c.tags[i]
+}
+
+// Default is the catalog of the messages returned by this service.
+var Default = NewCatalog(map[language.Tag]map[string]string{
+	language.Lao: {
+		"INVALID_CREDENTIALS": "ຂໍ້ມູນເຂົ້າລະບົບຂອງທ່ານບໍ່ຖືກຕ້ອງ. ກະລຸນາກວດສອບແລ້ວລອງໃໝ່ອີກຄັ້ງ.",
+		"INVALID_TOKEN":       "ໂທເຄັນຂອງທ່ານບໍ່ຖືກຕ້ອງ, ກະລຸນາໃຊ້ໂທເຄັນທີ່ຖືກຕ້ອງ.",
+		"STATEMENT_NOT_FOUND": "ບໍ່ພົບຂໍ້ມູນ Statement.",
+		"USER_NOT_FOUND":      "ບໍ່ພົບຜູ້ໃຊ້.",
+		"BINDING_ERROR":       "ຂໍ້ມູນທີ່ສົ່ງມາຕ້ອງເປັນ JSON ທີ່ຖືກຕ້ອງ.",
+		"LOGIN_LOCKED_OUT":    "ທ່ານເຂົ້າລະບົບບໍ່ສຳເລັດຫຼາຍຄັ້ງເກີນໄປ. ກະລຸນາລອງໃໝ່ພາຍຫຼັງ.",
+
+		"InvalidArgument":   "ຂໍ້ມູນທີ່ສົ່ງມາບໍ່ຖືກຕ້ອງ.",
+		"NotFound":          "ບໍ່ພົບຂໍ້ມູນ!",
+		"PermissionDenied":  "ທ່ານບໍ່ມີສິດເຂົ້າເຖິງຂໍ້ມູນນີ້.",
+		"Unauthenticated":   "ທ່ານຍັງບໍ່ໄດ້ເຂົ້າສູ່ລະບົບ.",
+		"ResourceExhausted": "ມີການຮ້ອງຂໍຫຼາຍເກີນໄປ.",
+		"Internal":          "ເກີດຂໍ້ຜິດພາດພາຍໃນລະບົບ.",
+		"Unknown":           "ເກີດຂໍ້ຜິດພາດທີ່ບໍ່ຮູ້ສາເຫດ!",
+	},
+	language.Thai: {
+		"INVALID_CREDENTIALS": "ข้อมูลการเข้าสู่ระบบไม่ถูกต้อง กรุณาตรวจสอบและลองใหม่อีกครั้ง",
+		"INVALID_TOKEN":       "โทเค็นของคุณไม่ถูกต้อง กรุณาใช้โทเค็นที่ถูกต้อง",
+		"STATEMENT_NOT_FOUND": "ไม่พบข้อมูล Statement",
+		"USER_NOT_FOUND":      "ไม่พบผู้ใช้",
+		"BINDING_ERROR":       "ข้อมูลที่ส่งมาต้องเป็น JSON ที่ถูกต้อง",
+		"LOGIN_LOCKED_OUT":    "เข้าสู่ระบบไม่สำเร็จหลายครั้งเกินไป กรุณาลองใหม่ภายหลัง",
+
+		"InvalidArgument":   "ข้อมูลที่ส่งมาไม่ถูกต้อง",
+		"NotFound":          "ไม่พบข้อมูล",
+		"PermissionDenied":  "คุณไม่มีสิทธิ์เข้าถึงข้อมูลนี้",
+		"Unauthenticated":   "คุณยังไม่ได้เข้าสู่ระบบ",
+		"ResourceExhausted": "มีคำขอมากเกินไป",
+		"Internal":          "เกิดข้อผิดพลาดภายในระบบ",
+		"Unknown":           "เกิดข้อผิดพลาดที่ไม่ทราบสาเหตุ",
+	},
+})
@@ -0,0 +1,46 @@
+package i18n
+
+import (
+	"testing"
+
+	"golang.org/x/text/language"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLocalize(t *testing.T) {
+	c := NewCatalog(map[language.Tag]map[string]string{
+		language.Lao: {
+			"INVALID_TOKEN": "lo token",
+			"NotFound":      "lo not found",
+		},
+	})
+
+	withReason, _ := status.New(codes.Unauthenticated, "token not valid").
+		WithDetails(&edpb.ErrorInfo{Reason: "INVALID_TOKEN"})
+	notFound := status.New(codes.NotFound, "statement not found")
+	internal := status.New(codes.Internal, "internal error")
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		s              *status.Status
+		want           string
+	}{
+		{name: "reason", acceptLanguage: "lo", s: withReason, want: "lo token"},
+		{name: "code", acceptLanguage: "lo-LA,en;q=0.5", s: notFound, want: "lo not found"},
+		{name: "untranslated", acceptLanguage: "lo", s: internal, want: "internal error"},
+		{name: "unsupported", acceptLanguage: "fr", s: notFound, want: "statement not found"},
+		{name: "english", acceptLanguage: "en-US", s: withReason, want: "token not valid"},
+		{name: "missing", acceptLanguage: "", s: notFound, want: "statement not found"},
+		{name: "malformed", acceptLanguage: ";;q=x", s: notFound, want: "statement not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Localize(tt.acceptLanguage, tt.s); got != tt.want {
+				t.Errorf("Localize(%q) = %q, want %q", tt.acceptLanguage, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// CompressConfig defines the config for Compress middleware.
+type CompressConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper middleware.Skipper
+
+	// Level is the gzip compression level.
+	// Optional. Default value gzip.DefaultCompression.
+	Level int
+
+	// ContentTypes are the media types of the responses which are compressed.
+	// The other responses, e.g. the XLSX and PDF exports which are already
+	// compressed, are sent untouched.
+	// Optional. Default value DefaultCompressContentTypes.
+	ContentTypes []string
+}
+
+// DefaultCompressContentTypes are the media types compressed by default.
+var DefaultCompressContentTypes = []string{
+	"application/json",
+	"text/csv",
+	"text/plain",
+}
+
+// Compress returns a middleware which gzips the JSON, CSV and text responses
+// when the client accepts it.
+func Compress() echo.MiddlewareFunc {
+	return CompressWithConfig(CompressConfig{})
+}
+
+// CompressWithConfig returns a Compress middleware with config.
+//
+// Whether the response is compressed is decided on its Content-Type once the
+// handler writes the header, so the binary exports are never compressed
+// twice. The Content-Length of the compressed responses is dropped, they are
+// sent chunked instead.
+func CompressWithConfig(cfg CompressConfig) echo.MiddlewareFunc {
+	if cfg.Skipper == nil {
+		cfg.Skipper = middleware.DefaultSkipper
+	}
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = DefaultCompressContentTypes
+	}
+
+	types := make(map[string]bool, len(cfg.ContentTypes))
+	for _, t := range cfg.ContentTypes {
+		types[strings.ToLower(t)] = true
+	}
+
+	pool := &sync.Pool{
+		New: func() any {
+			w, err := gzip.NewWriterLevel(io.Discard, cfg.Level)
+			if err != nil {
+				return err
+			}
+			return w
+		},
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if cfg.Skipper(c) {
+				return next(c)
+			}
+
+			res := c.Response()
+			res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+
+			req := c.Request()
+			if req.Method == http.MethodHead || !acceptsGzip(req.Header.Get(echo.HeaderAcceptEncoding)) {
+				return next(c)
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: res.Writer,
+				types:          types,
+				pool:           pool,
+			}
+			res.Writer = cw
+			defer func() {
+				cw.close()
+				res.Writer = cw.ResponseWriter
+			}()
+
+			return next(c)
+		}
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip, either
+// by name or by "*", with a non-zero quality.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter gzips the body of the response when its Content-Type is one
+// of types, the other responses are written through.
+type compressWriter struct {
+	http.ResponseWriter
+
+	types map[string]bool
+	pool  *sync.Pool
+
+	wroteHeader bool
+	gz          *gzip.Writer
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if w.shouldCompress(code) {
+		if gz, ok := w.pool.Get().(*gzip.Writer); ok {
+			gz.Reset(w.ResponseWriter)
+			w.gz = gz
+
+			h := w.Header()
+			h.Set(echo.HeaderContentEncoding, "gzip")
+			h.Del(echo.HeaderContentLength)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) shouldCompress(code int) bool {
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+
+	h := w.Header()
+	if h.Get(echo.HeaderContentEncoding) != "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(h.Get(echo.HeaderContentType))
+	if err != nil {
+		return false
+	}
+	return w.types[mediaType]
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get(echo.HeaderContentType) == "" {
+			w.Header().Set(echo.HeaderContentType, http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close flushes the compressed body and returns the gzip writer to the pool.
+func (w *compressWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	w.gz.Reset(io.Discard)
+	w.pool.Put(w.gz)
+	w.gz = nil
+}
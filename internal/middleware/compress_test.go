@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCompress(t *testing.T) {
+	const xlsx = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	body := strings.Repeat(`{"queueNumber":"Q1"},`, 100)
+
+	tests := []struct {
+		name        string
+		contentType string
+		accept      string
+		want        bool
+	}{
+		{name: "json", contentType: echo.MIMEApplicationJSON, accept: "gzip, br", want: true},
+		{name: "csv", contentType: "text/csv; charset=utf-8", accept: "gzip", want: true},
+		{name: "xlsx", contentType: xlsx, accept: "gzip", want: false},
+		{name: "not accepted", contentType: echo.MIMEApplicationJSON, accept: "br", want: false},
+		{name: "refused", contentType: echo.MIMEApplicationJSON, accept: "gzip;q=0", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(Compress())
+			e.GET("/", func(c echo.Context) error {
+				return c.Blob(http.StatusOK, tt.contentType, []byte(body))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(echo.HeaderAcceptEncoding, tt.accept)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			gzipped := rec.Header().Get(echo.HeaderContentEncoding) == "gzip"
+			if gzipped != tt.want {
+				t.Fatalf("Content-Encoding = %q, want gzip %v", rec.Header().Get(echo.HeaderContentEncoding), tt.want)
+			}
+			if vary := rec.Header().Get(echo.HeaderVary); vary != echo.HeaderAcceptEncoding {
+				t.Errorf("Vary = %q, want %q", vary, echo.HeaderAcceptEncoding)
+			}
+
+			got := rec.Body.String()
+			if gzipped {
+				if cl := rec.Header().Get(echo.HeaderContentLength); cl != "" {
+					t.Errorf("Content-Length = %q, want none once compressed", cl)
+				}
+				r, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader() error = %v", err)
+				}
+				b, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("io.ReadAll() error = %v", err)
+				}
+				got = string(b)
+			}
+			if got != body {
+				t.Errorf("body = %q, want %q", got, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/10664kls/estatement/internal/feature"
+	"github.com/labstack/echo/v4"
+)
+
+// FeatureFlags returns a middleware storing the flags resolved for the request
+// into its context, they are read with feature.FlagFromContext.
+// It must run after the claims are set when the resolver reads them.
+func FeatureFlags(resolver feature.Resolver) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := feature.ContextWithFlags(req.Context(), resolver(req))
+			c.SetRequest(req.WithContext(ctx))
+			return next(c)
+		}
+	}
+}
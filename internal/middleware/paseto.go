@@ -1,16 +1,30 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"time"
 
 	"aidanwoods.dev/go-paseto"
+	"github.com/10664kls/estatement/internal/auth"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// errInvalidToken is a helper function to create an error when
+// the token is missing or not valid.
+func errInvalidToken() error {
+	s, _ := status.New(codes.Unauthenticated, "Your provided token not valid, Please provide a valid token.").
+		WithDetails(&edpb.ErrorInfo{
+			Reason: "INVALID_TOKEN",
+			Domain: "auth",
+		})
+	return s.Err()
+}
+
 type pasetoExtractor func(echo.Context) (string, error)
 
 // pasetoFromHeader returns a `pasetoExtractor` that extracts token from the request header.
@@ -37,6 +51,11 @@ type PASETOConfig struct {
 	// SymmetricKey is the key used to sign and decrypted PASETO token.
 	SymmetricKey paseto.V4SymmetricKey
 
+	// Keys provides the keys used to decrypt the token, it is consulted on
+	// every request so the keys can be rotated without restart.
+	// Optional. Default value SymmetricKey.
+	Keys auth.KeyProvider
+
 	// Implicit are bytes used to calculate the encrypted token, but which are not
 	// present in the final token (or its decrypted value).
 	Implicit []byte
@@ -47,6 +66,16 @@ type PASETOConfig struct {
 	// ContextKey key to store token information *paseto.Token into echo context.
 	// Optional. Default value "token".
 	ContextKey string
+
+	// APIKeyResolver resolves the claims of a long-lived API key sent in the
+	// APIKeyHeader header instead of a PASETO token, from the real IP of the
+	// client.
+	// Optional. Default value nil, API keys are not accepted.
+	APIKeyResolver func(ctx context.Context, key, ip string) (*auth.Claims, error)
+
+	// APIKeyHeader is the header holding the API key.
+	// Optional. Default value "X-API-Key".
+	APIKeyHeader string
 }
 
 // PASETO returns a PASETO auth middleware.
@@ -57,6 +86,12 @@ func PASETO(cfg PASETOConfig) echo.MiddlewareFunc {
 	if cfg.ContextKey == "" {
 		cfg.ContextKey = "token"
 	}
+	if cfg.APIKeyHeader == "" {
+		cfg.APIKeyHeader = "X-API-Key"
+	}
+	if cfg.Keys == nil {
+		cfg.Keys = auth.NewRotatingKey(cfg.SymmetricKey)
+	}
 
 	extractor := pasetoFromHeader(echo.HeaderAuthorization, "Bearer")
 
@@ -66,30 +101,46 @@ func PASETO(cfg PASETOConfig) echo.MiddlewareFunc {
 				return next(c)
 			}
 
+			if key := c.Request().Header.Get(cfg.APIKeyHeader); key != "" && cfg.APIKeyResolver != nil {
+				req := c.Request()
+				claims, err := cfg.APIKeyResolver(req.Context(), key, c.RealIP())
+				if err != nil {
+					if cfg.ErrorHandler != nil {
+						return cfg.ErrorHandler(c, err)
+					}
+					// The key may be valid but not allowed, e.g. from this
+					// network, the client is told why.
+					if status.Code(err) == codes.PermissionDenied {
+						return err
+					}
+
+					return errInvalidToken()
+				}
+
+				c.SetRequest(req.WithContext(auth.ContextWithClaims(req.Context(), claims)))
+				return next(c)
+			}
+
 			tainted, err := extractor(c)
 			if err != nil {
 				if cfg.ErrorHandler != nil {
 					return cfg.ErrorHandler(c, err)
 				}
 
-				return status.Error(
-					codes.Unauthenticated,
-					"Your provided token not valid, Please provide a valid token.",
-				)
+				return errInvalidToken()
 			}
 
-			rules := append(cfg.Rules, paseto.NotExpired(), paseto.ValidAt(time.Now()))
+			rules := make([]paseto.Rule, 0, len(cfg.Rules)+2)
+			rules = append(rules, cfg.Rules...)
+			rules = append(rules, paseto.NotExpired(), paseto.ValidAt(time.Now()))
 			parser := paseto.MakeParser(rules)
-			token, err := parser.ParseV4Local(cfg.SymmetricKey, tainted, cfg.Implicit)
+			token, err := auth.ParseV4Local(parser, cfg.Keys, tainted, cfg.Implicit)
 			if err != nil {
 				if cfg.ErrorHandler != nil {
 					return cfg.ErrorHandler(c, err)
 				}
 
-				return status.Error(
-					codes.Unauthenticated,
-					"Your provided token not valid, Please provide a valid token.",
-				)
+				return errInvalidToken()
 			}
 
 			c.Set(cfg.ContextKey, token)
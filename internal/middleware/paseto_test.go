@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+	"github.com/10664kls/estatement/internal/auth"
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newToken returns a token issued by issuer, valid for an hour, encrypted
+// with key.
+func newToken(key paseto.V4SymmetricKey, issuer string) string {
+	now := time.Now()
+	t := paseto.NewToken()
+	t.SetIssuedAt(now)
+	t.SetNotBefore(now)
+	t.SetExpiration(now.Add(time.Hour))
+	if issuer != "" {
+		t.SetIssuer(issuer)
+	}
+	return t.V4Encrypt(key, nil)
+}
+
+// authenticate runs the request with the bearer token through the middleware.
+func authenticate(mw echo.MiddlewareFunc, token string) error {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	return mw(func(echo.Context) error { return nil })(c)
+}
+
+func TestPASETOIssuer(t *testing.T) {
+	key := paseto.NewV4SymmetricKey()
+	mw := PASETO(PASETOConfig{
+		SymmetricKey: key,
+		Rules:        []paseto.Rule{paseto.IssuedBy("estatement")},
+	})
+
+	if err := authenticate(mw, newToken(key, "estatement")); err != nil {
+		t.Errorf("PASETO() error = %v, want the token of the issuer accepted", err)
+	}
+	for _, issuer := range []string{"gateway", ""} {
+		err := authenticate(mw, newToken(key, issuer))
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("PASETO(issuer %q) error = %v, want %s", issuer, err, codes.Unauthenticated)
+		}
+	}
+}
+
+func TestPASETOKeyRotation(t *testing.T) {
+	old := paseto.NewV4SymmetricKey()
+	keys := auth.NewRotatingKey(old)
+	mw := PASETO(PASETOConfig{Keys: keys})
+	token := newToken(old, "")
+
+	keys.Rotate(paseto.NewV4SymmetricKey(), 50*time.Millisecond)
+	if err := authenticate(mw, token); err != nil {
+		t.Errorf("PASETO() error = %v, want the old key accepted during the grace window", err)
+	}
+	if err := authenticate(mw, newToken(keys.Current(), "")); err != nil {
+		t.Errorf("PASETO() error = %v, want the new key accepted", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := authenticate(mw, token); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("PASETO() error = %v, want the old key rejected after the grace window", err)
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"github.com/10664kls/estatement/internal/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// PasswordChanged returns a middleware refusing the requests of the users
+// who must change their password with auth.ErrPasswordChangeRequired, except
+// the skipped ones, e.g. the change of the password itself.
+// It must run after the claims are set.
+func PasswordChanged(skipper middleware.Skipper) echo.MiddlewareFunc {
+	if skipper == nil {
+		skipper = middleware.DefaultSkipper
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper(c) {
+				return next(c)
+			}
+			if auth.ClaimsFromContext(c.Request().Context()).MustChangePassword {
+				return auth.ErrPasswordChangeRequired
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TimeoutConfig defines the config for Timeout middleware.
+type TimeoutConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper middleware.Skipper
+
+	// Timeout is the maximum duration of a request.
+	Timeout time.Duration
+
+	// UnboundedSkipper selects the requests which are not bounded by Timeout,
+	// e.g. the long-running exports. They are still bounded by the timeout of
+	// the client.
+	// Optional. Default value nil, every request is bounded by Timeout.
+	UnboundedSkipper middleware.Skipper
+
+	// Header is the request header the clients set their own timeout in, in
+	// milliseconds, e.g. "X-Request-Timeout: 5000". It applies when it's
+	// shorter than Timeout.
+	// Optional. Default value "", the clients can't set a timeout.
+	Header string
+
+	// MaxTimeout caps the timeouts of the clients.
+	// Optional. Default value Timeout.
+	MaxTimeout time.Duration
+}
+
+// Timeout returns a middleware which bounds the duration of a request to d.
+func Timeout(d time.Duration) echo.MiddlewareFunc {
+	return TimeoutWithConfig(TimeoutConfig{Timeout: d})
+}
+
+// TimeoutWithConfig returns a Timeout middleware with config.
+//
+// The clients may shorten the timeout with the Header, up to MaxTimeout for
+// the requests not bounded by Timeout.
+//
+// The request context is cancelled once the timeout expires, so the handler
+// (and the DB queries it runs) must honor it. If the handler has not written
+// the response by then, a codes.DeadlineExceeded error is returned, which is
+// rendered as 504 Gateway Timeout.
+func TimeoutWithConfig(cfg TimeoutConfig) echo.MiddlewareFunc {
+	if cfg.Skipper == nil {
+		cfg.Skipper = middleware.DefaultSkipper
+	}
+	if cfg.UnboundedSkipper == nil {
+		cfg.UnboundedSkipper = middleware.DefaultSkipper
+	}
+	if cfg.MaxTimeout <= 0 {
+		cfg.MaxTimeout = cfg.Timeout
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if cfg.Skipper(c) {
+				return next(c)
+			}
+
+			timeout := cfg.Timeout
+			if cfg.UnboundedSkipper(c) {
+				timeout = 0
+			}
+
+			if cfg.Header != "" {
+				client, err := clientTimeout(c.Request().Header.Get(cfg.Header), cfg.Header)
+				if err != nil {
+					return err
+				}
+				if client > 0 && cfg.MaxTimeout > 0 {
+					client = min(client, cfg.MaxTimeout)
+				}
+				if client > 0 && (timeout <= 0 || client < timeout) {
+					timeout = client
+				}
+			}
+
+			if timeout <= 0 {
+				return next(c)
+			}
+
+			req := c.Request()
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer cancel()
+
+			c.SetRequest(req.WithContext(ctx))
+			err := next(c)
+
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Response().Committed {
+				return status.Error(
+					codes.DeadlineExceeded,
+					"The request took too long to complete, please narrow it down and try again.",
+				)
+			}
+			return err
+		}
+	}
+}
+
+// clientTimeout parses the timeout of the client in milliseconds, it's 0
+// when the header is not set.
+func clientTimeout(v, header string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+
+	ms, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || ms <= 0 {
+		s, _ := status.New(codes.InvalidArgument, header+" must be a positive number of milliseconds.").
+			WithDetails(&edpb.BadRequest{
+				FieldViolations: []*edpb.BadRequest_FieldViolation{
+					{
+						Field:       header,
+						Description: "must be a positive number of milliseconds",
+					},
+				},
+			})
+		return 0, s.Err()
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTimeoutClientHeaderClamped(t *testing.T) {
+	tests := []struct {
+		name      string
+		unbounded bool
+		header    string
+		want      time.Duration
+	}{
+		{name: "shorter than the timeout", header: "2000", want: 2 * time.Second},
+		{name: "longer than the timeout", header: "3600000", want: time.Minute},
+		{name: "unbounded clamped to the max", unbounded: true, header: "3600000", want: 10 * time.Minute},
+		{name: "unbounded under the max", unbounded: true, header: "5000", want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Request-Timeout", tt.header)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			mw := TimeoutWithConfig(TimeoutConfig{
+				Timeout:          time.Minute,
+				UnboundedSkipper: func(echo.Context) bool { return tt.unbounded },
+				Header:           "X-Request-Timeout",
+				MaxTimeout:       10 * time.Minute,
+			})
+
+			var got time.Duration
+			start := time.Now()
+			err := mw(func(c echo.Context) error {
+				deadline, ok := c.Request().Context().Deadline()
+				if !ok {
+					t.Fatal("request has no deadline")
+				}
+				got = deadline.Sub(start)
+				return nil
+			})(c)
+			if err != nil {
+				t.Fatalf("TimeoutWithConfig() error = %v", err)
+			}
+			if got < tt.want-time.Second || got > tt.want+time.Second {
+				t.Errorf("TimeoutWithConfig() deadline in %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// slowHandler waits for the request to be cancelled, or d.
+func slowHandler(d time.Duration) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		select {
+		case <-c.Request().Context().Done():
+		case <-time.After(d):
+		}
+		return nil
+	}
+}
+
+func TestTimeoutExceeded(t *testing.T) {
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+
+	err := Timeout(10 * time.Millisecond)(slowHandler(time.Second))(c)
+	if code := status.Code(err); code != codes.DeadlineExceeded {
+		t.Errorf("Timeout() code = %v, want %v", code, codes.DeadlineExceeded)
+	}
+}
+
+func TestTimeoutUnbounded(t *testing.T) {
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+
+	mw := TimeoutWithConfig(TimeoutConfig{
+		Timeout:          10 * time.Millisecond,
+		UnboundedSkipper: func(echo.Context) bool { return true },
+	})
+	if err := mw(slowHandler(50 * time.Millisecond))(c); err != nil {
+		t.Errorf("TimeoutWithConfig() error = %v, want the request unbounded", err)
+	}
+}
+
+func TestTimeoutClientHeader(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Timeout", "10")
+	c := e.NewContext(req, httptest.NewRecorder())
+
+	mw := TimeoutWithConfig(TimeoutConfig{Timeout: time.Minute, Header: "X-Request-Timeout"})
+	err := mw(slowHandler(time.Second))(c)
+	if code := status.Code(err); code != codes.DeadlineExceeded {
+		t.Errorf("TimeoutWithConfig() code = %v, want %v", code, codes.DeadlineExceeded)
+	}
+
+	req.Header.Set("X-Request-Timeout", "soon")
+	err = mw(slowHandler(time.Second))(e.NewContext(req, httptest.NewRecorder()))
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("TimeoutWithConfig(invalid header) code = %v, want %v", code, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceConfig defines the config for Trace middleware.
+type TraceConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper middleware.Skipper
+
+	// Tracer is used to start the server span of the request.
+	// Optional. Default value is the tracer of the global provider.
+	Tracer trace.Tracer
+
+	// Propagator extracts the trace context from the request headers.
+	// Optional. Default value is the global propagator.
+	Propagator propagation.TextMapPropagator
+}
+
+// Trace returns a middleware which continues the trace of the incoming
+// request and wraps the handler in a server span.
+func Trace(cfg TraceConfig) echo.MiddlewareFunc {
+	if cfg.Skipper == nil {
+		cfg.Skipper = middleware.DefaultSkipper
+	}
+	if cfg.Tracer == nil {
+		cfg.Tracer = otel.Tracer("github.com/10664kls/estatement/internal/middleware")
+	}
+	if cfg.Propagator == nil {
+		cfg.Propagator = otel.GetTextMapPropagator()
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if cfg.Skipper(c) {
+				return next(c)
+			}
+
+			req := c.Request()
+			ctx := cfg.Propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			ctx, span := cfg.Tracer.Start(ctx, req.Method+" "+c.Path(),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", req.Method),
+					attribute.String("http.route", c.Path()),
+				),
+			)
+			defer span.End()
+
+			c.SetRequest(req.WithContext(ctx))
+			err := next(c)
+			if err != nil {
+				span.RecordError(err)
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", c.Response().Status))
+			return err
+		}
+	}
+}
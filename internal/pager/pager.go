@@ -1,20 +1,33 @@
 package pager
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"strings"
 	"time"
 )
 
-// Size returns the size of the page.
-// If the size is less than or equal to 0, it returns 20.
-// If the size is greater than 200, it returns 200.
+const (
+	// DefaultSize is the size of the page when none is provided.
+	DefaultSize uint64 = 20
+
+	// MaxSize is the biggest size of the page.
+	MaxSize uint64 = 200
+)
+
+// Size returns the size of the page, always between 1 and MaxSize, so it's
+// safe to write into a TOP clause.
+// If the size is 0, it returns DefaultSize.
+// If the size is greater than MaxSize, it returns MaxSize.
 func Size(size uint64) uint64 {
-	if size <= 0 {
-		return 20
+	if size == 0 {
+		return DefaultSize
 	}
-	if size > 200 {
-		return 200
+	if size > MaxSize {
+		return MaxSize
 	}
 	return size
 }
@@ -24,6 +37,14 @@ func Size(size uint64) uint64 {
 type Cursor struct {
 	ID   string    `json:"id"`
 	Time time.Time `json:"time"`
+
+	// Null is set when the value the items are sorted by is NULL for the
+	// item of the cursor, Time is then zero.
+	Null bool `json:"null,omitempty"`
+
+	// Offset is the number of items returned by the pages before the cursor.
+	// It's only trusted when the cursor is signed, see Signer.
+	Offset uint64 `json:"offset,omitempty"`
 }
 
 // EncodeCursor encodes the cursor.
@@ -41,3 +62,79 @@ func DecodeCursor(s string) (*Cursor, error) {
 	c := &Cursor{}
 	return c, json.Unmarshal(cj, c)
 }
+
+// NextPageToken returns the token of the page following a page of n items
+// requested with the given size, or an empty string if it was the last page.
+// last is only called when there is a next page.
+func NextPageToken(n int, size uint64, last func() *Cursor) string {
+	if n == 0 || n < int(Size(size)) {
+		return ""
+	}
+	return EncodeCursor(last())
+}
+
+// ErrInvalidCursor is returned when a signed cursor was not signed with the
+// key of the Signer, or was changed since.
+var ErrInvalidCursor = errors.New("cursor signature is not valid")
+
+// Signer encodes the cursors with an HMAC-SHA256 of their content, so the
+// clients can't change them, e.g. to reset the Offset. A nil Signer encodes
+// and decodes the cursors unsigned.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a signer of the cursors with the given key.
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// EncodeCursor encodes and signs the cursor.
+func (s *Signer) EncodeCursor(c *Cursor) string {
+	if s == nil {
+		return EncodeCursor(c)
+	}
+	cj, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(cj) + "." +
+		base64.RawURLEncoding.EncodeToString(s.sign(cj))
+}
+
+// DecodeCursor verifies the signature of the cursor and decodes it.
+func (s *Signer) DecodeCursor(token string) (*Cursor, error) {
+	if s == nil {
+		return DecodeCursor(token)
+	}
+
+	p, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	cj, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, s.sign(cj)) {
+		return nil, ErrInvalidCursor
+	}
+
+	c := &Cursor{}
+	return c, json.Unmarshal(cj, c)
+}
+
+// NextPageToken is the package NextPageToken with the cursor signed.
+func (s *Signer) NextPageToken(n int, size uint64, last func() *Cursor) string {
+	if n == 0 || n < int(Size(size)) {
+		return ""
+	}
+	return s.EncodeCursor(last())
+}
+
+func (s *Signer) sign(b []byte) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write(b)
+	return h.Sum(nil)
+}
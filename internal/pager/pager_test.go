@@ -0,0 +1,81 @@
+package pager
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		size, want uint64
+	}{
+		{0, DefaultSize},
+		{1, 1},
+		{MaxSize, MaxSize},
+		{MaxSize + 1, MaxSize},
+		{^uint64(0), MaxSize},
+	}
+	for _, tt := range tests {
+		if got := Size(tt.size); got != tt.want {
+			t.Errorf("Size(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestNextPageToken(t *testing.T) {
+	last := func() *Cursor { return &Cursor{ID: "C2"} }
+
+	if got := NextPageToken(0, 2, last); got != "" {
+		t.Errorf("NextPageToken(empty page) = %q, want none", got)
+	}
+	if got := NextPageToken(1, 2, last); got != "" {
+		t.Errorf("NextPageToken(short page) = %q, want none", got)
+	}
+	got := NextPageToken(2, 2, last)
+	c, err := DecodeCursor(got)
+	if err != nil || c.ID != "C2" {
+		t.Errorf("NextPageToken(full page) = %q, want the cursor of the last item", got)
+	}
+}
+
+func TestSignerRoundTrip(t *testing.T) {
+	s := NewSigner([]byte("key"))
+	c := &Cursor{ID: "C1", Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Offset: 40}
+
+	got, err := s.DecodeCursor(s.EncodeCursor(c))
+	if err != nil {
+		t.Fatalf("DecodeCursor() error = %v", err)
+	}
+	if got.ID != c.ID || !got.Time.Equal(c.Time) || got.Offset != c.Offset {
+		t.Errorf("DecodeCursor() = %+v, want %+v", got, c)
+	}
+}
+
+func TestSignerRejectsResetOffset(t *testing.T) {
+	s := NewSigner([]byte("key"))
+	token := s.EncodeCursor(&Cursor{ID: "C1", Offset: 40})
+
+	payload, sig, _ := strings.Cut(token, ".")
+	b, _ := base64.RawURLEncoding.DecodeString(payload)
+	forged := strings.Replace(string(b), `"offset":40`, `"offset":0`, 1)
+	token = base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + sig
+
+	if _, err := s.DecodeCursor(token); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("DecodeCursor() error = %v, want %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestSignerRejectsOtherKeyAndUnsigned(t *testing.T) {
+	s := NewSigner([]byte("key"))
+	c := &Cursor{ID: "C1", Offset: 40}
+
+	if _, err := s.DecodeCursor(NewSigner([]byte("other")).EncodeCursor(c)); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("DecodeCursor(other key) error = %v, want %v", err, ErrInvalidCursor)
+	}
+	if _, err := s.DecodeCursor(EncodeCursor(c)); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("DecodeCursor(unsigned) error = %v, want %v", err, ErrInvalidCursor)
+	}
+}
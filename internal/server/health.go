@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HealthCheck is a dependency reported by the health endpoint.
+type HealthCheck struct {
+	// Name identifies the dependency in the verbose output.
+	Name string
+
+	// Critical dependencies make the server unhealthy when they fail, the
+	// others only report a degraded status.
+	Critical bool
+
+	// Check returns an error when the dependency is not healthy.
+	Check func(ctx context.Context) error
+}
+
+type healthResult struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+// healthCheckTimeout bounds every health check so a hanging dependency
+// does not hang the probe.
+const healthCheckTimeout = 3 * time.Second
+
+// health responds 200 when every critical dependency is healthy and 503
+// otherwise. The checks are detailed with ?verbose=true.
+func (s *Server) health(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), healthCheckTimeout)
+	defer cancel()
+
+	results := make(map[string]*healthResult, len(s.cfg.HealthChecks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range s.cfg.HealthChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := hc.Check(ctx)
+			r := &healthResult{
+				Status:    healthOK,
+				Critical:  hc.Critical,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				r.Status = healthDown
+				r.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[hc.Name] = r
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	overall := healthOK
+	for _, r := range results {
+		if r.Status == healthOK {
+			continue
+		}
+		if r.Critical {
+			overall = healthDown
+			break
+		}
+		overall = healthDegraded
+	}
+
+	code := http.StatusOK
+	if overall == healthDown {
+		code = http.StatusServiceUnavailable
+	}
+
+	if c.QueryParam("verbose") != "true" {
+		return c.JSON(code, echo.Map{
+			"status": overall,
+		})
+	}
+	return c.JSON(code, echo.Map{
+		"status": overall,
+		"checks": results,
+	})
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/10664kls/estatement/internal/statement"
+)
+
+// healthCheck returns a check of the dependency failing with err, if any.
+func healthCheck(name string, critical bool, err error) HealthCheck {
+	return HealthCheck{
+		Name:     name,
+		Critical: critical,
+		Check:    func(context.Context) error { return err },
+	}
+}
+
+type healthResponse struct {
+	Status string                  `json:"status"`
+	Checks map[string]healthResult `json:"checks"`
+}
+
+func getHealth(t *testing.T, checks []HealthCheck, query string) (int, healthResponse) {
+	t.Helper()
+
+	e, _, _ := newTestServer(t, Config{HealthChecks: checks}, statement.Config{})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz"+query, nil))
+
+	var res healthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	return rec.Code, res
+}
+
+func TestHealthVerbose(t *testing.T) {
+	code, res := getHealth(t, []HealthCheck{
+		healthCheck("db", true, nil),
+		healthCheck("smtp", false, errors.New("connection refused")),
+	}, "?verbose=true")
+
+	if code != http.StatusOK || res.Status != healthDegraded {
+		t.Errorf("GET = %d %s, want %d %s", code, res.Status, http.StatusOK, healthDegraded)
+	}
+	if db := res.Checks["db"]; db.Status != healthOK || !db.Critical || db.Error != "" {
+		t.Errorf("db check = %+v, want ok and critical", db)
+	}
+	if smtp := res.Checks["smtp"]; smtp.Status != healthDown || smtp.Critical || smtp.Error != "connection refused" {
+		t.Errorf("smtp check = %+v, want down with its error", smtp)
+	}
+}
+
+func TestHealthCriticalDown(t *testing.T) {
+	code, res := getHealth(t, []HealthCheck{
+		healthCheck("db", true, errors.New("timeout")),
+		healthCheck("smtp", false, nil),
+	}, "")
+
+	if code != http.StatusServiceUnavailable || res.Status != healthDown {
+		t.Errorf("GET = %d %s, want %d %s", code, res.Status, http.StatusServiceUnavailable, healthDown)
+	}
+	if res.Checks != nil {
+		t.Errorf("GET checks = %v, want them only when verbose", res.Checks)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The profiles of the Accept header selecting the naming of the keys,
+// e.g. "Accept: application/json; profile=snake_case".
+const (
+	snakeCaseProfile = "snake_case"
+	camelCaseProfile = "camel_case"
+)
+
+// jsonSerializer is the echo.JSONSerializer of the responses. The keys of
+// the objects are camelCase unless snake_case is selected by the server
+// config or the Accept header of the request.
+type jsonSerializer struct {
+	echo.DefaultJSONSerializer
+
+	snakeCase bool
+}
+
+func (j *jsonSerializer) Serialize(c echo.Context, i any, indent string) error {
+	if !j.wantSnakeCase(c) {
+		return j.DefaultJSONSerializer.Serialize(c, i, indent)
+	}
+
+	b, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(c.Response())
+	if indent != "" {
+		enc.SetIndent("", indent)
+	}
+	return enc.Encode(snakeCaseKeys(v))
+}
+
+// wantSnakeCase reports whether the response of the request has snake_case keys.
+// The profile of the Accept header takes precedence over the server config.
+func (j *jsonSerializer) wantSnakeCase(c echo.Context) bool {
+	for _, accept := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch params["profile"] {
+		case snakeCaseProfile:
+			return true
+		case camelCaseProfile:
+			return false
+		}
+	}
+	return j.snakeCase
+}
+
+// snakeCaseKeys converts the keys of every object nested in v to snake_case.
+func snakeCaseKeys(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[snakeCase(k)] = snakeCaseKeys(e)
+		}
+		return m
+
+	case []any:
+		for i, e := range v {
+			v[i] = snakeCaseKeys(e)
+		}
+		return v
+	}
+	return v
+}
+
+// snakeCase converts the camelCase s to snake_case, the runs of capitals
+// are kept together, e.g. "bankInfoURL" to "bank_info_url".
+func snakeCase(s string) string {
+	r := []rune(s)
+	var b strings.Builder
+	b.Grow(len(s) + 4)
+	for i, c := range r {
+		if unicode.IsUpper(c) && i > 0 {
+			prev := r[i-1]
+			nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/10664kls/estatement/internal/statement"
+	"github.com/labstack/echo/v4"
+)
+
+func TestJSONSerializerNaming(t *testing.T) {
+	createdAt := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	st := &statement.Statement{
+		ID:          "1",
+		QueueNumber: "Q1",
+		CreatedAt:   createdAt,
+		BankAccount: statement.BankAccount{Number: "0100200301"},
+	}
+
+	tests := []struct {
+		name      string
+		snakeCase bool
+		accept    string
+		want      []string
+		notWant   string
+	}{
+		{
+			name:    "camelCase by default",
+			want:    []string{`"queueNumber":"Q1"`, `"createdAt":"2025-03-01T10:00:00Z"`, `"bankAccount":{`},
+			notWant: "queue_number",
+		},
+		{
+			name:    "snake_case profile",
+			accept:  "application/json; profile=snake_case",
+			want:    []string{`"queue_number":"Q1"`, `"created_at":"2025-03-01T10:00:00Z"`, `"bank_account":{`},
+			notWant: "queueNumber",
+		},
+		{
+			name:      "snake_case config",
+			snakeCase: true,
+			want:      []string{`"queue_number":"Q1"`, `"bank_account":{`},
+			notWant:   "queueNumber",
+		},
+		{
+			name:      "camel_case profile overrides config",
+			snakeCase: true,
+			accept:    "application/json; profile=camel_case",
+			want:      []string{`"queueNumber":"Q1"`},
+			notWant:   "queue_number",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/statements/1", nil)
+			if tt.accept != "" {
+				req.Header.Set(echo.HeaderAccept, tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			j := &jsonSerializer{snakeCase: tt.snakeCase}
+			if err := j.Serialize(c, st, ""); err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+
+			got := rec.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Serialize() = %s, want it to contain %s", got, want)
+				}
+			}
+			if strings.Contains(got, tt.notWant) {
+				t.Errorf("Serialize() = %s, want no %s", got, tt.notWant)
+			}
+		})
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"queueNumber": "queue_number",
+		"id":          "id",
+		"bankInfoURL": "bank_info_url",
+		"URLPath":     "url_path",
+		"page2Token":  "page2_token",
+	}
+	for in, want := range tests {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -1,8 +1,16 @@
 package server
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/10664kls/estatement/internal/auth"
 	"github.com/10664kls/estatement/internal/statement"
@@ -16,9 +24,29 @@ import (
 type Server struct {
 	statement *statement.Service
 	auth      *auth.Auth
+	cfg       Config
 }
 
-func NewServer(statement *statement.Service, auth *auth.Auth) (*Server, error) {
+// Config defines the config for server.
+type Config struct {
+	// RefreshTokenCookie returns the refresh token in an HttpOnly cookie
+	// instead of the response body on login and token refresh, the refresh
+	// token is then read from the cookie when the body has none.
+	// Optional. Default value false.
+	RefreshTokenCookie bool
+
+	// HealthChecks are the dependencies reported by GET /healthz.
+	// Optional. Default value is empty, the server is always healthy.
+	HealthChecks []HealthCheck
+
+	// SnakeCaseJSON returns the keys of the JSON responses in snake_case
+	// rather than camelCase. The clients can select either with the profile
+	// of the Accept header, e.g. "application/json; profile=snake_case".
+	// Optional. Default value false.
+	SnakeCaseJSON bool
+}
+
+func NewServer(statement *statement.Service, auth *auth.Auth, cfg Config) (*Server, error) {
 	if statement == nil {
 		return nil, errors.New("statement service is nil")
 	}
@@ -29,6 +57,7 @@ func NewServer(statement *statement.Service, auth *auth.Auth) (*Server, error) {
 	s := &Server{
 		statement: statement,
 		auth:      auth,
+		cfg:       cfg,
 	}
 	return s, nil
 }
@@ -38,16 +67,53 @@ func (s *Server) Install(e *echo.Echo, mdw ...echo.MiddlewareFunc) error {
 		return errors.New("echo is nil")
 	}
 
+	e.JSONSerializer = &jsonSerializer{snakeCase: s.cfg.SnakeCaseJSON}
+
+	e.GET("/healthz", s.health)
+
 	v1 := e.Group("/v1")
 
 	v1.POST("/auth/login", s.login)
 	v1.POST("/auth/token", s.genToken)
+	v1.POST("/auth/introspect", s.introspect)
 	v1.GET("/auth/me", s.getProfile, mdw...)
+	v1.PATCH("/auth/me", s.updateProfile, mdw...)
+	v1.GET("/auth/me/logins", s.listLogins, mdw...)
+	v1.POST("/auth/me/password", s.changePassword, mdw...)
+
+	v1.GET("/users/:id", s.getUser, mdw...)
+	v1.PATCH("/users/:id", s.updateUser, mdw...)
+	v1.POST("/users/:id/api-tokens", s.createAPIToken, mdw...)
+	v1.DELETE("/users/:id/api-tokens/:tokenId", s.revokeAPIToken, mdw...)
+	v1.POST("/users/:id/reset-password", s.resetPassword, mdw...)
 
 	v1.GET("/statements", s.listStatements, mdw...)
 	v1.GET("/statements/export-to-excel", s.exportToExcel, mdw...)
-
+	v1.GET("/statements/export-to-csv", s.exportToCSV, mdw...)
+	v1.GET("/statements/export-url", s.signExportURL, mdw...)
+	v1.GET("/statements/export-estimate", s.estimateExport, mdw...)
+	v1.GET("/statements/export-signed", s.exportSigned)
+	v1.POST("/statements/export-jobs", s.createExportJob, mdw...)
+	v1.GET("/statements/export-jobs", s.listExportJobs, mdw...)
+	v1.POST("/statements/export-jobs/purge", s.purgeExportJobs, mdw...)
+	v1.GET("/statements/export-jobs/:id", s.getExportJob, mdw...)
+	v1.GET("/statements/export-jobs/:id/content", s.downloadExportJob, mdw...)
+	v1.GET("/statements/export-jobs/:id/events", s.exportJobEvents, mdw...)
+	v1.POST("/statements/validate-query", s.validateQuery, mdw...)
+	v1.POST("/statements/batch-get", s.getStatementsByQueueNumbers, mdw...)
+	v1.POST("/statements/import-email-status", s.importEmailStatus, mdw...)
+	v1.POST("/statements/reconcile-email-status", s.reconcileEmailStatus, mdw...)
+	v1.GET("/statements/summary/bank", s.summaryByBank, mdw...)
+	v1.GET("/statements/summary/export", s.exportSummary, mdw...)
+	v1.GET("/statements/kpis", s.getKPIs, mdw...)
+	v1.GET("/statements/count", s.countStatements, mdw...)
+	v1.GET("/reports/operator-workload", s.operatorWorkload, mdw...)
+
+	v1.GET("/statements/filters", s.listFilters, mdw...)
+	v1.GET("/statements/by-account/:accNo", s.getStatementsByAccount, mdw...)
 	v1.GET("/statements/:id", s.getStatementByID, mdw...)
+	v1.GET("/statements/:id/pdf", s.getStatementPDF, mdw...)
+	v1.POST("/statements/:id/refresh-bank-status", s.refreshBankStatus, mdw...)
 
 	v1.GET("/product-names", s.listProductNames, mdw...)
 	v1.GET("/occupations", s.listOccupations, mdw...)
@@ -73,25 +139,357 @@ func (s *Server) listStatements(c echo.Context) error {
 		return badJSON()
 	}
 
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), mimeNDJSON) {
+		return s.streamStatements(c, req)
+	}
+
+	fields, err := statement.ParseFields(c.QueryParam("fields"))
+	if err != nil {
+		return err
+	}
+
 	ctx := c.Request().Context()
 	statements, err := s.statement.ListStatements(ctx, req)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, statements)
+	if fields == nil {
+		return c.JSON(http.StatusOK, statements)
+	}
+
+	projected := make([]map[string]json.RawMessage, 0, len(statements.Statements))
+	for _, st := range statements.Statements {
+		p, err := st.Project(fields)
+		if err != nil {
+			return err
+		}
+		projected = append(projected, p)
+	}
+
+	result := echo.Map{
+		"statements":    projected,
+		"nextPageToken": statements.NextPageToken,
+	}
+	if p := statements.OffsetPage; p != nil {
+		result["page"] = p.Page
+		result["pageSize"] = p.PageSize
+		result["totalItems"] = p.TotalItems
+		result["totalPages"] = p.TotalPages
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) validateQuery(c echo.Context) error {
+	req := new(statement.StatementQuery)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	result, err := s.statement.ValidateQuery(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+const mimeNDJSON = "application/x-ndjson"
+
+// contentDisposition returns the Content-Disposition of a downloaded file.
+// The file is previewed in the browser with ?disposition=inline, any other
+// value downloads it as an attachment.
+func contentDisposition(c echo.Context, filename string) string {
+	disposition := "attachment"
+	if strings.EqualFold(strings.TrimSpace(c.QueryParam("disposition")), "inline") {
+		disposition = "inline"
+	}
+	return disposition + "; filename=\"" + filename + "\""
+}
+
+// streamStatements writes all the statements matching the query as newline
+// delimited JSON, one statement per line.
+func (s *Server) streamStatements(c echo.Context, req *statement.StatementQuery) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, mimeNDJSON)
+
+	const flushEvery = 200
+
+	enc := json.NewEncoder(res)
+	n := 0
+	err := s.statement.StreamStatements(c.Request().Context(), req, func(st *statement.Statement) error {
+		if err := enc.Encode(st); err != nil {
+			return err
+		}
+		n++
+		if n%flushEvery == 0 {
+			res.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if !res.Committed {
+			// Nothing was written yet, the client can still get a proper error.
+			return err
+		}
+
+		// The header is already written, the best we can do is to stop the stream.
+		zap.L().Error("failed to stream statements", zap.Error(err))
+	}
+
+	if !res.Committed {
+		res.WriteHeader(http.StatusOK)
+	}
+	res.Flush()
+	return nil
+}
+
+func (s *Server) summaryByBank(c echo.Context) error {
+	req := new(statement.StatementQuery)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	summary, err := s.statement.SummaryByBank(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"banks": summary,
+	})
+}
+
+func (s *Server) countStatements(c echo.Context) error {
+	req := new(statement.StatementQuery)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	count, err := s.statement.CountStatements(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"count": count,
+	})
+}
+
+func (s *Server) getKPIs(c echo.Context) error {
+	req := new(statement.StatementQuery)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	kpis, err := s.statement.KPIs(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"kpis": kpis,
+	})
+}
+
+func (s *Server) operatorWorkload(c echo.Context) error {
+	req := new(statement.StatementQuery)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	workload, err := s.statement.OperatorWorkload(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	// The breakdown by status is only sent when it's asked for.
+	if byStatus, _ := strconv.ParseBool(c.QueryParam("byStatus")); !byStatus {
+		for i := range workload {
+			workload[i].ByStatus = nil
+		}
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"operators": workload,
+	})
+}
+
+func (s *Server) exportSummary(c echo.Context) error {
+	req := new(statement.StatementQuery)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	buf, err := s.statement.GenSummaryExcel(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Content-Disposition", contentDisposition(c, "statement-summary.xlsx"))
+
+	return c.Blob(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+}
+
+func (s *Server) getStatementsByQueueNumbers(c echo.Context) error {
+	req := new(statement.GetByQueueNumbersReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	result, err := s.statement.GetStatementsByQueueNumbers(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) importEmailStatus(c echo.Context) error {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "Request must be a multipart form with a CSV file in the \"file\" field.")
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := c.Request().Context()
+	result, err := s.statement.ImportEmailStatus(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) reconcileEmailStatus(c echo.Context) error {
+	req := new(statement.ReconcileEmailStatusReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	result, err := s.statement.ReconcileEmailStatus(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
 }
 
 func (s *Server) getStatementByID(c echo.Context) error {
 	id := c.Param("id")
 
-	statement, err := s.statement.GetStatementByID(c.Request().Context(), id)
+	fields, err := statement.ParseFields(c.QueryParam("fields"))
+	if err != nil {
+		return err
+	}
+
+	st, err := s.statement.GetStatementByID(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+
+	if notModified(c, st.LastModified()) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	resp := echo.Map{
+		"statement": st,
+	}
+	if fields != nil {
+		projected, err := st.Project(fields)
+		if err != nil {
+			return err
+		}
+		resp["statement"] = projected
+	}
+
+	if c.QueryParam("debug") == "columns" {
+		columns, err := s.statement.ColumnMapping(c.Request().Context())
+		if err != nil {
+			return err
+		}
+		resp["columns"] = columns
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) getStatementsByAccount(c echo.Context) error {
+	ctx := c.Request().Context()
+	statements, err := s.statement.GetStatementsByAccount(ctx, c.Param("accNo"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"statements": statements,
+	})
+}
+
+func (s *Server) getStatementPDF(c echo.Context) error {
+	id := c.Param("id")
+
+	ctx := c.Request().Context()
+	buf, err := s.statement.GenStatementPDF(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Content-Disposition", contentDisposition(c, statement.PDFFilename(id)))
+
+	return c.Blob(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+func (s *Server) refreshBankStatus(c echo.Context) error {
+	ctx := c.Request().Context()
+	st, err := s.statement.RefreshBankStatus(ctx, c.Param("id"))
 	if err != nil {
 		return err
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{
-		"statement": statement,
+		"statement": st,
+	})
+}
+
+// notModified sets the Last-Modified header and reports whether the client
+// copy is still fresh according to the If-Modified-Since header.
+func notModified(c echo.Context, lastModified time.Time) bool {
+	if lastModified.IsZero() {
+		return false
+	}
+	// HTTP dates have a second precision.
+	lastModified = lastModified.UTC().Truncate(time.Second)
+	c.Response().Header().Set(echo.HeaderLastModified, lastModified.Format(http.TimeFormat))
+
+	since, err := http.ParseTime(c.Request().Header.Get(echo.HeaderIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(since)
+}
+
+func (s *Server) listFilters(c echo.Context) error {
+	ctx := c.Request().Context()
+	filters, err := s.statement.ListFilters(ctx)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"filters": filters,
 	})
 }
 
@@ -107,7 +505,7 @@ func (s *Server) listProductNames(c echo.Context) error {
 }
 
 func (s *Server) listOccupations(c echo.Context) error {
-	occupations, err := s.statement.ListOccupations(c.Request().Context())
+	occupations, err := s.statement.ListOccupations(c.Request().Context(), c.QueryParam("gender"))
 	if err != nil {
 		return err
 	}
@@ -133,12 +531,53 @@ func (s *Server) login(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return badJSON()
 	}
+	req.IP = c.RealIP()
+	req.UserAgent = c.Request().UserAgent()
 
 	ctx := c.Request().Context()
 	result, err := s.auth.Login(ctx, req)
 	if err != nil {
 		return err
 	}
+	return s.writeToken(c, result)
+}
+
+// refreshTokenCookie is the name of the cookie holding the refresh token.
+const refreshTokenCookie = "refresh_token"
+
+// writeToken writes the token to the response, the refresh token is moved to
+// an HttpOnly cookie when the server is configured so.
+func (s *Server) writeToken(c echo.Context, token *auth.Token) error {
+	if !s.cfg.RefreshTokenCookie {
+		return c.JSON(http.StatusOK, token)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    token.RefreshToken,
+		Path:     "/v1/auth",
+		MaxAge:   int(auth.RefreshTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"accessToken": token.AccessToken,
+	})
+}
+
+func (s *Server) introspect(c echo.Context) error {
+	req := new(auth.IntrospectReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	result, err := s.auth.Introspect(ctx, req)
+	if err != nil {
+		return err
+	}
 	return c.JSON(http.StatusOK, result)
 }
 
@@ -146,9 +585,110 @@ func (s *Server) getProfile(c echo.Context) error {
 	ctx := c.Request().Context()
 	profile, err := s.auth.Profile(ctx)
 	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"profile":     profile,
+		"permissions": s.auth.Permissions(ctx),
+	})
+}
+
+func (s *Server) updateProfile(c echo.Context) error {
+	req := new(auth.UpdateProfileReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	profile, err := s.auth.UpdateProfile(ctx, req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"profile":     profile,
+		"permissions": s.auth.Permissions(ctx),
+	})
+}
+
+// withExportTimezone defaults the timezone of the export to the preference
+// of the user, the timezone of the request takes precedence.
+func (s *Server) withExportTimezone(c echo.Context, req *statement.BatchGetStatementReq) error {
+	if strings.TrimSpace(req.Timezone) != "" {
+		return nil
+	}
+
+	profile, err := s.auth.Profile(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	req.Timezone = profile.Timezone
+	return nil
+}
+
+func (s *Server) changePassword(c echo.Context) error {
+	req := new(auth.ChangePasswordReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	if err := s.auth.ChangePassword(ctx, req); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) getUser(c echo.Context) error {
+	ctx := c.Request().Context()
+	user, err := s.auth.GetUser(ctx, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"user": user})
+}
+
+func (s *Server) updateUser(c echo.Context) error {
+	req := new(auth.UpdateUserReq)
+	if err := c.Bind(req); err != nil {
 		return badJSON()
 	}
-	return c.JSON(http.StatusOK, echo.Map{"profile": profile})
+
+	ctx := c.Request().Context()
+	user, err := s.auth.UpdateUser(ctx, c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"user": user})
+}
+
+func (s *Server) resetPassword(c echo.Context) error {
+	req := new(auth.ResetPasswordReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	result, err := s.auth.ResetPassword(ctx, c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) listLogins(c echo.Context) error {
+	req := new(auth.ListLoginsReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	result, err := s.auth.ListLogins(ctx, req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
 }
 
 func (s *Server) genToken(c echo.Context) error {
@@ -156,13 +696,39 @@ func (s *Server) genToken(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return badJSON()
 	}
+	if req.Token == "" && s.cfg.RefreshTokenCookie {
+		if cookie, err := c.Cookie(refreshTokenCookie); err == nil {
+			req.Token = cookie.Value
+		}
+	}
 
 	ctx := c.Request().Context()
 	result, err := s.auth.RefreshToken(ctx, req)
 	if err != nil {
 		return err
 	}
-	return c.JSON(http.StatusOK, result)
+	return s.writeToken(c, result)
+}
+
+func (s *Server) createAPIToken(c echo.Context) error {
+	ctx := c.Request().Context()
+	token, err := s.auth.CreateAPIToken(ctx, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"apiToken": token,
+	})
+}
+
+func (s *Server) revokeAPIToken(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := s.auth.RevokeAPIToken(ctx, c.Param("id"), c.Param("tokenId")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
 }
 
 func (s *Server) exportToExcel(c echo.Context) error {
@@ -170,6 +736,13 @@ func (s *Server) exportToExcel(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return badJSON()
 	}
+	if err := s.withExportTimezone(c, req); err != nil {
+		return err
+	}
+
+	if err := s.statement.ValidateExport(req); err != nil {
+		return err
+	}
 
 	ctx := c.Request().Context()
 	buf, err := s.statement.GenExcel(ctx, req)
@@ -177,8 +750,225 @@ func (s *Server) exportToExcel(c echo.Context) error {
 		return err
 	}
 
-	c.Response().Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	c.Response().Header().Set("Content-Disposition", "attachment; filename=\"statement-requests.xlsx\"")
+	c.Response().Header().Set(echo.HeaderContentDisposition, contentDisposition(c, req.ExportFilename(time.Now())))
 
 	return c.Blob(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
 }
+
+func (s *Server) exportToCSV(c echo.Context) error {
+	req := new(statement.BatchGetStatementReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+	if err := s.withExportTimezone(c, req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	buf, err := s.statement.GenCSV(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Content-Disposition", contentDisposition(c, req.CSVFilename(time.Now())))
+
+	return c.Blob(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+func (s *Server) estimateExport(c echo.Context) error {
+	req := new(statement.BatchGetStatementReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	rows, approxBytes, err := s.statement.EstimateExportSize(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"rows":        rows,
+		"approxBytes": approxBytes,
+	})
+}
+
+func (s *Server) signExportURL(c echo.Context) error {
+	req := new(statement.BatchGetStatementReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+	if err := s.withExportTimezone(c, req); err != nil {
+		return err
+	}
+
+	ttl := 15 * time.Minute
+	ctx := c.Request().Context()
+	url, err := s.statement.SignedExportURL(ctx, req, ttl)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"url":       url,
+		"expiresIn": int(ttl.Seconds()),
+	})
+}
+
+func (s *Server) exportSigned(c echo.Context) error {
+	ctx := c.Request().Context()
+	req, claims, err := s.statement.VerifyExportToken(ctx, c.QueryParam("token"))
+	if err != nil {
+		return err
+	}
+
+	// The export is generated on behalf of the user who signed the link.
+	ctx = auth.ContextWithClaims(ctx, claims)
+	buf, err := s.statement.GenExcel(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	h.Set(echo.HeaderContentDisposition, contentDisposition(c, req.ExportFilename(time.Now())))
+	// The workbook is generated again on each request, its hash is the strong
+	// validator for If-Range, so a resumed download doesn't mix two of them.
+	sum := sha256.Sum256(buf.Bytes())
+	h.Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+
+	http.ServeContent(c.Response(), c.Request(), "", time.Time{}, bytes.NewReader(buf.Bytes()))
+	return nil
+}
+
+func (s *Server) createExportJob(c echo.Context) error {
+	req := new(statement.BatchGetStatementReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+	if err := s.withExportTimezone(c, req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	job, err := s.statement.CreateExportJob(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusAccepted, echo.Map{
+		"exportJob": job,
+	})
+}
+
+func (s *Server) listExportJobs(c echo.Context) error {
+	req := new(statement.ListExportJobsReq)
+	if err := c.Bind(req); err != nil {
+		return badJSON()
+	}
+
+	ctx := c.Request().Context()
+	result, err := s.statement.ListExportJobs(ctx, req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) purgeExportJobs(c echo.Context) error {
+	olderThan := 24 * time.Hour
+	if v := c.QueryParam("olderThan"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			st, _ := status.New(codes.InvalidArgument, "Your request is not valid.").
+				WithDetails(&edpb.BadRequest{
+					FieldViolations: []*edpb.BadRequest_FieldViolation{
+						{
+							Field:       "olderThan",
+							Description: "olderThan must be a duration, e.g. 24h",
+						},
+					},
+				})
+			return st.Err()
+		}
+		olderThan = d
+	}
+
+	ctx := c.Request().Context()
+	deleted, err := s.statement.PurgeExpiredExports(ctx, olderThan)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"deleted": deleted,
+	})
+}
+
+func (s *Server) getExportJob(c echo.Context) error {
+	ctx := c.Request().Context()
+	job, err := s.statement.GetExportJob(ctx, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"exportJob": job,
+	})
+}
+
+// exportJobEvents streams the status transitions of the export job as
+// Server-Sent Events, until the job is completed or the client disconnects.
+func (s *Server) exportJobEvents(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+
+	// The job is checked before the stream starts, so the client still gets
+	// a proper error.
+	if _, err := s.statement.GetExportJob(ctx, id); err != nil {
+		return err
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+
+	err := s.statement.WatchExportJob(ctx, id, func(job *statement.ExportJob) error {
+		b, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(res, "event: status\ndata: %s\n\n", b); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		// The header is already written, the best we can do is to stop the stream.
+		zap.L().Error("failed to stream export job events", zap.Error(err))
+	}
+	return nil
+}
+
+// downloadExportJob serves the content of the export job. Range requests are
+// honored, so an interrupted download can be resumed.
+func (s *Server) downloadExportJob(c echo.Context) error {
+	ctx := c.Request().Context()
+	job, content, err := s.statement.ExportJobContent(ctx, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	h.Set(echo.HeaderContentDisposition, contentDisposition(c, job.Filename))
+	// The content of a job never changes, its id is a strong validator
+	// for If-Range.
+	h.Set("ETag", `"`+job.ID+`"`)
+
+	http.ServeContent(c.Response(), c.Request(), "", *job.CompletedAt, bytes.NewReader(content))
+	return nil
+}
@@ -0,0 +1,548 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+	"github.com/10664kls/estatement/internal/auth"
+	"github.com/10664kls/estatement/internal/statement"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/zap"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// testAccessKey and testRefreshKey are the keys of the tokens of the test
+// servers.
+var (
+	testAccessKey  = paseto.NewV4SymmetricKey()
+	testRefreshKey = paseto.NewV4SymmetricKey()
+)
+
+// newTestServer returns a server installed on e, backed by a mock database.
+func newTestServer(t *testing.T, cfg Config, stmtCfg statement.Config) (*echo.Echo, *statement.Service, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	stmt, err := statement.NewService(ctx, db, zap.NewNop(), stmtCfg)
+	if err != nil {
+		t.Fatalf("statement.NewService() error = %v", err)
+	}
+	authSvc, err := auth.NewAuthService(ctx, db, testAccessKey, testRefreshKey, zap.NewNop(), auth.Config{})
+	if err != nil {
+		t.Fatalf("auth.NewAuthService() error = %v", err)
+	}
+	s, err := NewServer(stmt, authSvc, cfg)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+
+	e := echo.New()
+	if err := s.Install(e); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	return e, stmt, mock
+}
+
+// statementRows returns the rows of the listings for the statements of the IDs.
+func statementRows(ids ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{
+		"CUID", "cusnum", "cus_name", "AccNo", "term", "bankname", "bankcreatedate", "bankstatus", "bankmoreinfo",
+		"gender", "productnames", "emailstatus", "emailmsg", "occupation", "createby", "statusBanking", "createdate",
+	})
+	for _, id := range ids {
+		rows.AddRow(id, "Q"+id, "Customer "+id, "01002003"+id, "12", "BCEL", nil, nil, nil,
+			"M", "LOAN", nil, nil, "Teacher", "operator", "PENDING", time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC))
+	}
+	return rows
+}
+
+// withClaims returns a middleware authenticating every request with the claims.
+func withClaims(claims *auth.Claims) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			c.SetRequest(req.WithContext(auth.ContextWithClaims(req.Context(), claims)))
+			return next(c)
+		}
+	}
+}
+
+func TestListStatementsNDJSON(t *testing.T) {
+	e, _, mock := newTestServer(t, Config{}, statement.Config{})
+	e.Pre(withClaims(&auth.Claims{ID: "A1", Username: "admin", Role: auth.RoleAdmin}))
+	mock.ExpectQuery("vm_customer").WillReturnRows(statementRows("1", "2", "3"))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/statements", nil)
+	req.Header.Set(echo.HeaderAccept, mimeNDJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != mimeNDJSON {
+		t.Errorf("Content-Type = %q, want %q", ct, mimeNDJSON)
+	}
+
+	ids := make([]string, 0)
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var st statement.Statement
+		if err := json.Unmarshal(scanner.Bytes(), &st); err != nil {
+			t.Fatalf("line %d: json.Unmarshal() error = %v", len(ids)+1, err)
+		}
+		ids = append(ids, st.ID)
+	}
+	if want := []string{"1", "2", "3"}; !slices.Equal(ids, want) {
+		t.Errorf("GET streamed %v, want %v", ids, want)
+	}
+}
+
+// refreshToken returns a refresh token of the user signed with testRefreshKey.
+func refreshToken(t *testing.T, username string) string {
+	t.Helper()
+
+	now := time.Now()
+	token := paseto.NewToken()
+	token.SetIssuedAt(now.Add(-time.Minute))
+	token.SetNotBefore(now.Add(-time.Minute))
+	token.SetExpiration(now.Add(auth.RefreshTokenTTL))
+	if err := token.Set("profile", &auth.Claims{ID: "U1", Username: username, Role: "user"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	return token.V4Encrypt(testRefreshKey, nil)
+}
+
+// userRow returns the row of the user read on token refresh.
+func userRow(username string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"USID", "Username", "pwd", "productnames", "role", "createdate", "must_change_pwd", "timezone"}).
+		AddRow("U1", username, "", "LOAN", "user", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), false, "")
+}
+
+func TestRefreshTokenCookie(t *testing.T) {
+	e, _, mock := newTestServer(t, Config{RefreshTokenCookie: true}, statement.Config{})
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRow("alice"))
+
+	old := refreshToken(t, "alice")
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/token", strings.NewReader("{}"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.AddCookie(&http.Cookie{Name: refreshTokenCookie, Value: old})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == refreshTokenCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || cookie.Value == old || !cookie.HttpOnly {
+		t.Errorf("POST cookie = %+v, want a rotated HttpOnly refresh token", cookie)
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if len(body) != 1 || body["accessToken"] == "" || body["accessToken"] == nil {
+		t.Errorf("POST body = %v, want only the access token", body)
+	}
+}
+
+func TestRefreshTokenBody(t *testing.T) {
+	e, _, mock := newTestServer(t, Config{}, statement.Config{})
+	mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(userRow("alice"))
+
+	body := `{"token":"` + refreshToken(t, "alice") + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/token", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("POST cookies = %v, want none", cookies)
+	}
+
+	var token auth.Token
+	if err := json.Unmarshal(rec.Body.Bytes(), &token); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if token.AccessToken == "" || token.RefreshToken == "" {
+		t.Errorf("POST body = %+v, want both tokens", token)
+	}
+}
+
+func TestGetStatementLastModified(t *testing.T) {
+	e, _, mock := newTestServer(t, Config{}, statement.Config{})
+	e.Pre(withClaims(&auth.Claims{ID: "A1", Username: "admin", Role: auth.RoleAdmin}))
+
+	get := func(ifModifiedSince string) *httptest.ResponseRecorder {
+		mock.ExpectQuery("vm_customer").WillReturnRows(statementRows("1"))
+		req := httptest.NewRequest(http.MethodGet, "/v1/statements/Q1", nil)
+		if ifModifiedSince != "" {
+			req.Header.Set("If-Modified-Since", ifModifiedSince)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("")
+	if first.Code != http.StatusOK {
+		t.Fatalf("GET = %d, want %d: %s", first.Code, http.StatusOK, first.Body)
+	}
+	lastModified := first.Header().Get("Last-Modified")
+	if lastModified != "Sat, 01 Mar 2025 10:00:00 GMT" {
+		t.Errorf("Last-Modified = %q, want the creation of the statement", lastModified)
+	}
+
+	if rec := get(lastModified); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("GET If-Modified-Since = %d with %d bytes, want %d", rec.Code, rec.Body.Len(), http.StatusNotModified)
+	}
+	if rec := get("Fri, 28 Feb 2025 10:00:00 GMT"); rec.Code != http.StatusOK {
+		t.Errorf("GET If-Modified-Since older = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestExportContentDisposition(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "", want: `attachment; filename="statement-summary.xlsx"`},
+		{query: "?disposition=inline", want: `inline; filename="statement-summary.xlsx"`},
+		{query: "?disposition=%20INLINE", want: `inline; filename="statement-summary.xlsx"`},
+		{query: "?disposition=inline%3B%20filename%3Devil", want: `attachment; filename="statement-summary.xlsx"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			e, _, mock := newTestServer(t, Config{}, statement.Config{})
+			e.Pre(withClaims(&auth.Claims{ID: "A1", Username: "admin", Role: auth.RoleAdmin}))
+			for range 3 {
+				mock.ExpectQuery("GROUP BY").WillReturnRows(sqlmock.NewRows([]string{"key", "count"}))
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/statements/summary/export"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if got := rec.Header().Get(echo.HeaderContentDisposition); got != tt.want {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportJobEvents(t *testing.T) {
+	e, stmt, mock := newTestServer(t, Config{}, statement.Config{})
+	claims := &auth.Claims{ID: "U1", Username: "alice", Role: "user"}
+	e.Pre(withClaims(claims))
+	mock.ExpectQuery("vm_customer").WillDelayFor(20 * time.Millisecond).WillReturnRows(statementRows("1"))
+	mock.ExpectQuery("vm_customer").WillReturnRows(statementRows())
+
+	job, err := stmt.CreateExportJob(auth.ContextWithClaims(context.Background(), claims), &statement.BatchGetStatementReq{})
+	if err != nil {
+		t.Fatalf("CreateExportJob() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/statements/export-jobs/"+job.ID+"/events", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	// The stream starts from the status of the job when it's opened, which
+	// may be past pending already.
+	var statuses []statement.ExportJobStatus
+	for _, event := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		data, ok := strings.CutPrefix(event, "event: status\ndata: ")
+		if !ok {
+			t.Fatalf("event = %q, want a status event", event)
+		}
+		var got statement.ExportJob
+		if err := json.Unmarshal([]byte(data), &got); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		statuses = append(statuses, got.Status)
+	}
+	order := []statement.ExportJobStatus{statement.ExportJobPending, statement.ExportJobRunning, statement.ExportJobSucceeded}
+	if len(statuses) == 0 || !slices.Equal(statuses, order[len(order)-len(statuses):]) {
+		t.Errorf("GET streamed %v, want the transitions up to %s", statuses, statement.ExportJobSucceeded)
+	}
+}
+
+func TestExportJobEventsScopedToOwner(t *testing.T) {
+	e, stmt, mock := newTestServer(t, Config{}, statement.Config{})
+	e.Pre(withClaims(&auth.Claims{ID: "U2", Username: "bob", Role: "user"}))
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("vm_customer").WillReturnRows(statementRows())
+
+	ctx := auth.ContextWithClaims(context.Background(), &auth.Claims{ID: "U1", Username: "alice", Role: "user"})
+	job, err := stmt.CreateExportJob(ctx, &statement.BatchGetStatementReq{})
+	if err != nil {
+		t.Fatalf("CreateExportJob() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/statements/export-jobs/"+job.ID+"/events", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get(echo.HeaderContentType); ct == "text/event-stream" {
+		t.Errorf("GET streamed the job of another user")
+	}
+}
+
+func TestExportTimezonePreference(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "preference", query: "", want: "01/03/2025 17:00:00"},
+		{name: "request overrides", query: "?timezone=UTC", want: "01/03/2025 10:00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _, mock := newTestServer(t, Config{}, statement.Config{})
+			e.Pre(withClaims(&auth.Claims{ID: "A1", Username: "admin", Role: auth.RoleAdmin}))
+			if tt.query == "" {
+				mock.ExpectQuery("FROM dbo.tb_user").WillReturnRows(
+					sqlmock.NewRows([]string{"USID", "Username", "pwd", "productnames", "role", "createdate", "must_change_pwd", "timezone"}).
+						AddRow("A1", "admin", "", "", auth.RoleAdmin, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), false, "Asia/Vientiane"))
+			}
+			mock.ExpectQuery("vm_customer").WillReturnRows(statementRows("1"))
+			mock.ExpectQuery("vm_customer").WillReturnRows(statementRows())
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/statements/export-to-excel"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			fx, err := excelize.OpenReader(rec.Body)
+			if err != nil {
+				t.Fatalf("excelize.OpenReader() error = %v", err)
+			}
+			defer fx.Close()
+
+			rows, err := fx.GetRows("Statement Requests")
+			if err != nil {
+				t.Fatalf("GetRows() error = %v", err)
+			}
+			if len(rows) < 2 || !slices.Contains(rows[1], tt.want) {
+				t.Errorf("GET rows = %v, want the creation time %s", rows, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestGetStatementDebugColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *auth.Claims
+		want   bool
+	}{
+		{name: "admin", claims: &auth.Claims{ID: "A1", Username: "admin", Role: auth.RoleAdmin}, want: true},
+		{name: "user", claims: &auth.Claims{ID: "U1", Username: "alice", Role: "user"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _, mock := newTestServer(t, Config{}, statement.Config{})
+			e.Pre(withClaims(tt.claims))
+			mock.ExpectQuery("vm_customer").WillReturnRows(statementRows("1"))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/statements/Q1?debug=columns", nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			var body struct {
+				Columns map[string]string `json:"columns"`
+			}
+			_ = json.Unmarshal(rec.Body.Bytes(), &body)
+			if got := body.Columns["cusnum"] == "queueNumber"; got != tt.want {
+				t.Errorf("GET = %d %s, want the column mapping %v", rec.Code, rec.Body, tt.want)
+			}
+			if !tt.want && rec.Code == http.StatusOK {
+				t.Errorf("GET = %d, want the debug mode refused", rec.Code)
+			}
+		})
+	}
+}
+
+func TestCountStatements(t *testing.T) {
+	e, _, mock := newTestServer(t, Config{}, statement.Config{})
+	e.Pre(withClaims(&auth.Claims{ID: "U1", Username: "alice", Role: "user"}))
+	mock.ExpectQuery("vm_customer").WillReturnRows(statementRows("3", "2", "1"))
+	mock.ExpectQuery(`SELECT COUNT\(\*\)`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	get := func(target string, v any) int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatalf("GET %s: json.Unmarshal() error = %v", target, err)
+			}
+		}
+		return rec.Code
+	}
+
+	var list struct {
+		Statements []statement.Statement `json:"statements"`
+	}
+	if code := get("/v1/statements?productName=LOAN&pageSize=100", &list); code != http.StatusOK {
+		t.Fatalf("GET list = %d, want %d", code, http.StatusOK)
+	}
+	var count struct {
+		Count int64 `json:"count"`
+	}
+	if code := get("/v1/statements/count?productName=LOAN&pageSize=100", &count); code != http.StatusOK {
+		t.Fatalf("GET count = %d, want %d", code, http.StatusOK)
+	}
+	if count.Count != int64(len(list.Statements)) {
+		t.Errorf("count = %d, want the %d statements listed", count.Count, len(list.Statements))
+	}
+
+	// The count is refused the same way as the list.
+	for _, target := range []string{"/v1/statements?includeInactive=true", "/v1/statements/count?includeInactive=true"} {
+		if code := get(target, nil); code == http.StatusOK {
+			t.Errorf("GET %s = %d, want the inactive statements refused", target, code)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportSignedRange(t *testing.T) {
+	e, stmt, mock := newTestServer(t, Config{}, statement.Config{ExportSigningKey: []byte("test-signing-key")})
+	for range 3 {
+		mock.ExpectQuery("vm_customer").WillReturnRows(sqlmock.NewRows([]string{"CUID"}))
+	}
+
+	ctx := auth.ContextWithClaims(context.Background(), &auth.Claims{ID: "U1", Username: "alice", Role: "user"})
+	url, err := stmt.SignedExportURL(ctx, &statement.BatchGetStatementReq{}, time.Minute)
+	if err != nil {
+		t.Fatalf("SignedExportURL() error = %v", err)
+	}
+
+	get := func(header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	full := get(nil)
+	if full.Code != http.StatusOK {
+		t.Fatalf("GET = %d, want %d: %s", full.Code, http.StatusOK, full.Body)
+	}
+	etag := full.Header().Get("ETag")
+	if etag == "" || full.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("GET headers = %v, want an ETag and byte ranges", full.Header())
+	}
+
+	partial := get(map[string]string{"Range": "bytes=0-9"})
+	if partial.Code != http.StatusPartialContent || partial.Body.Len() != 10 {
+		t.Errorf("GET Range = %d with %d bytes, want %d with 10", partial.Code, partial.Body.Len(), http.StatusPartialContent)
+	}
+	if !strings.HasPrefix(partial.Header().Get("Content-Range"), "bytes 0-9/") {
+		t.Errorf("Content-Range = %q, want bytes 0-9/*", partial.Header().Get("Content-Range"))
+	}
+
+	stale := get(map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`})
+	if stale.Code != http.StatusOK {
+		t.Errorf("GET stale If-Range = %d, want %d", stale.Code, http.StatusOK)
+	}
+}
+
+func TestExportToExcelWithoutRanges(t *testing.T) {
+	e, _, mock := newTestServer(t, Config{}, statement.Config{})
+	e.Pre(withClaims(&auth.Claims{ID: "A1", Username: "admin", Role: auth.RoleAdmin}))
+	mock.ExpectQuery("vm_customer").WillReturnRows(statementRows("1"))
+	mock.ExpectQuery("vm_customer").WillReturnRows(statementRows())
+
+	// The workbook is generated again on each request, there is no stable
+	// content to serve a range of.
+	req := httptest.NewRequest(http.MethodGet, "/v1/statements/export-to-excel?timezone=UTC", nil)
+	req.Header.Set("Range", "bytes=0-9")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET Range = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if h := rec.Header(); h.Get("ETag") != "" || h.Get("Accept-Ranges") != "" || h.Get("Content-Range") != "" {
+		t.Errorf("GET headers = %v, want no ETag nor byte ranges", h)
+	}
+}
+
+func TestExportToExcelInvalidRequest(t *testing.T) {
+	e, _, mock := newTestServer(t, Config{}, statement.Config{})
+	e.Pre(withClaims(&auth.Claims{ID: "A1", Username: "admin", Role: auth.RoleAdmin}))
+	var got error
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		got = err
+		c.NoContent(http.StatusBadRequest)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/statements/export-to-excel?timezone=UTC&month=March&profile=finance", nil)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	if code := rpcstatus.Code(got); code != codes.InvalidArgument {
+		t.Fatalf("GET error = %v, want %v", got, codes.InvalidArgument)
+	}
+	var fields []string
+	for _, d := range rpcstatus.Convert(got).Details() {
+		if br, ok := d.(*edpb.BadRequest); ok {
+			for _, v := range br.GetFieldViolations() {
+				fields = append(fields, v.GetField())
+			}
+		}
+	}
+	if !slices.Equal(fields, []string{"month", "profile"}) {
+		t.Errorf("GET violations = %v, want [month profile] in one error", fields)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("invalid export queried the database: %v", err)
+	}
+}
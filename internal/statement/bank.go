@@ -0,0 +1,97 @@
+package statement
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/10664kls/estatement/internal/auth"
+	sq "github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// BankStatus is the status of a bank account request in the banking system.
+type BankStatus struct {
+	Status string
+	Info   string
+}
+
+// BankStatusProvider fetches the bank status of the statements from the
+// upstream banking system.
+type BankStatusProvider interface {
+	BankStatus(ctx context.Context, st *Statement) (*BankStatus, error)
+}
+
+// RefreshBankStatus fetches the bank status of the statement from the
+// BankStatusProvider and stores it. Only admins are allowed to refresh the
+// bank status.
+func (s *Service) RefreshBankStatus(ctx context.Context, id string) (*Statement, error) {
+	claims := auth.ClaimsFromContext(ctx)
+	zlog := s.zlog.With(
+		zap.String("method", "RefreshBankStatus"),
+		s.piiField("id", id),
+		zap.String("username", claims.Username),
+	)
+	ctx = withOperation(ctx, "RefreshBankStatus")
+
+	zlog.Info("starting to refresh bank status")
+
+	if !claims.IsAdmin() {
+		zlog.Info("not an admin")
+		return nil, rpcstatus.Error(codes.PermissionDenied, "You are not allowed to refresh the bank status.")
+	}
+
+	if s.bankStatus == nil {
+		zlog.Info("no bank status provider")
+		return nil, rpcstatus.Error(codes.Unimplemented, "Bank status refresh is not available.")
+	}
+
+	// The statement is read from the primary it's updated on, a lagging
+	// replica may not have it yet.
+	st, err := getStatements(ctx, s.primary, s.dialect, &StatementQuery{QueueNumber: id, opts: s.listOptions()})
+	if errors.Is(err, ErrStatementNotFound) {
+		zlog.Warn("statement not found")
+		return nil, err
+	}
+	if err != nil {
+		zlog.Error("failed to get statement by id", zap.Error(err))
+		return nil, err
+	}
+
+	bs, err := s.bankStatus.BankStatus(ctx, st)
+	if err != nil {
+		zlog.Error("failed to fetch bank status", zap.Error(err))
+		return nil, rpcstatus.Error(codes.Unavailable, "The banking system is not available, please try again later.")
+	}
+
+	err = s.retryDeadlock(ctx, func() error {
+		return updateBankStatus(ctx, s.primary, s.dialect, s.table, st.ID, bs)
+	})
+	if err != nil {
+		zlog.Error("failed to update bank status", zap.Error(err))
+		return nil, err
+	}
+	s.exports.purge()
+
+	st.BankAccount.Status = &bs.Status
+	st.BankAccount.Info = &bs.Info
+
+	s.maskPolicy(ctx).apply(st)
+	return st, nil
+}
+
+// updateBankStatus updates the bank status of the statement of the ID in the
+// base table of the statements.
+func updateBankStatus(ctx context.Context, db *sql.DB, d Dialect, table, id string, bs *BankStatus) error {
+	q, args := d.builder().
+		Update(table).
+		Set("bankstatus", bs.Status).
+		Set("bankmoreinfo", bs.Info).
+		Where(sq.Eq{"CUID": id}).
+		MustSql()
+
+	_, err := db.ExecContext(ctx, q, args...)
+	return err
+}
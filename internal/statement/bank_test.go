@@ -0,0 +1,78 @@
+package statement
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// stubBankStatus is a BankStatusProvider returning its status, or err.
+type stubBankStatus struct {
+	status *BankStatus
+	err    error
+}
+
+func (p *stubBankStatus) BankStatus(context.Context, *Statement) (*BankStatus, error) {
+	return p.status, p.err
+}
+
+func TestRefreshBankStatus(t *testing.T) {
+	provider := &stubBankStatus{status: &BankStatus{Status: "OPENED", Info: "account 123"}}
+	s, mock, _ := newTestService(t, Config{BankStatusProvider: provider})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+	mock.ExpectExec("UPDATE dbo.tb_customer").WithArgs("OPENED", "account 123", "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	st, err := s.RefreshBankStatus(adminContext(), "Q1")
+	if err != nil {
+		t.Fatalf("RefreshBankStatus() error = %v", err)
+	}
+	if st.BankAccount.Status == nil || *st.BankAccount.Status != "OPENED" {
+		t.Errorf("RefreshBankStatus() bank status = %v, want OPENED", st.BankAccount.Status)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshBankStatusNotFound(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{BankStatusProvider: &stubBankStatus{}})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	_, err := s.RefreshBankStatus(adminContext(), "Q1")
+	if !errors.Is(err, ErrStatementNotFound) {
+		t.Errorf("RefreshBankStatus() error = %v, want %v", err, ErrStatementNotFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshBankStatusProviderFailure(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{BankStatusProvider: &stubBankStatus{err: errors.New("bank is down")}})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+
+	_, err := s.RefreshBankStatus(adminContext(), "Q1")
+	if code := rpcstatus.Code(err); code != codes.Unavailable {
+		t.Errorf("RefreshBankStatus() code = %v, want %v", code, codes.Unavailable)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshBankStatusRequiresAdmin(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{BankStatusProvider: &stubBankStatus{}})
+
+	_, err := s.RefreshBankStatus(userContext(), "Q1")
+	if code := rpcstatus.Code(err); code != codes.PermissionDenied {
+		t.Errorf("RefreshBankStatus() code = %v, want %v", code, codes.PermissionDenied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,77 @@
+package statement
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/10664kls/estatement/internal/pager"
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestGetStatementsByQueueNumbers(t *testing.T) {
+	s, mock, log := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1", "3"))
+
+	result, err := s.GetStatementsByQueueNumbers(userContext(), &GetByQueueNumbersReq{
+		QueueNumbers: []string{"Q1", "Q2", "Q3", "Q1"},
+	})
+	if err != nil {
+		t.Fatalf("GetStatementsByQueueNumbers() error = %v", err)
+	}
+	if len(result.Statements) != 2 {
+		t.Errorf("GetStatementsByQueueNumbers() statements = %d, want 2", len(result.Statements))
+	}
+	if !slices.Equal(result.NotFound, []string{"Q2"}) {
+		t.Errorf("GetStatementsByQueueNumbers() notFound = %v, want [Q2]", result.NotFound)
+	}
+	if q := log.all()[0]; !strings.Contains(q, "rectype") {
+		t.Errorf("GetStatementsByQueueNumbers() query = %q, want the inactive statements excluded", q)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetStatementsByQueueNumbersReadsEveryPage(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+
+	full := sqlmock.NewRows(statementColumns)
+	for i := range pager.MaxSize {
+		full.AddRow(statementRow(fmt.Sprint(i), "Q1")...)
+	}
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(full)
+	mock.ExpectQuery("OFFSET").WillReturnRows(sqlmock.NewRows(statementColumns).AddRow(statementRow("last", "Q1")...))
+
+	result, err := s.GetStatementsByQueueNumbers(userContext(), &GetByQueueNumbersReq{QueueNumbers: []string{"Q1"}})
+	if err != nil {
+		t.Fatalf("GetStatementsByQueueNumbers() error = %v", err)
+	}
+	if got, want := uint64(len(result.Statements)), pager.MaxSize+1; got != want {
+		t.Errorf("GetStatementsByQueueNumbers() statements = %d, want %d", got, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetStatementsByQueueNumbersOutOfRange(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+
+	tooMany := make([]string, maxQueueNumbers+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprint("Q", i)
+	}
+	for _, queueNumbers := range [][]string{nil, tooMany} {
+		_, err := s.GetStatementsByQueueNumbers(userContext(), &GetByQueueNumbersReq{QueueNumbers: queueNumbers})
+		if code := rpcstatus.Code(err); code != codes.InvalidArgument {
+			t.Errorf("GetStatementsByQueueNumbers(%d) code = %v, want %v", len(queueNumbers), code, codes.InvalidArgument)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,74 @@
+package statement
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// userDisplayNameColumn is the column of dbo.tb_user holding the display
+// name of the operators.
+const userDisplayNameColumn = "fullname"
+
+// resolveCreators sets the CreatedByName of the statements to the display
+// name of their creator. The names are looked up with a single query rather
+// than joined, the filters of the statements queries being unqualified.
+func resolveCreators(ctx context.Context, db queryer, d Dialect, statements []*Statement) error {
+	usernames := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, s := range statements {
+		if s.CreatedBy != "" && !seen[s.CreatedBy] {
+			seen[s.CreatedBy] = true
+			usernames = append(usernames, s.CreatedBy)
+		}
+	}
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	names, err := userDisplayNames(ctx, db, d, usernames)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statements {
+		s.CreatedByName = names[s.CreatedBy]
+	}
+	return nil
+}
+
+// userDisplayNames returns the display names of the active users, keyed by
+// username.
+func userDisplayNames(ctx context.Context, db queryer, d Dialect, usernames []string) (map[string]string, error) {
+	q, args, err := d.builder().
+		Select("Username", userDisplayNameColumn).
+		From("dbo.tb_user").
+		Where(sq.Eq{
+			"Username": usernames,
+			"rectype":  "ADD",
+		}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]string, len(usernames))
+	for rows.Next() {
+		var username string
+		var name sql.NullString
+		if err := rows.Scan(&username, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		names[username] = name.String
+	}
+
+	return names, rows.Err()
+}
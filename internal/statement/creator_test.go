@@ -0,0 +1,45 @@
+package statement
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListStatementsResolveCreator(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1", "2"))
+	// statementRows are all created by "operator", it's looked up once.
+	mock.ExpectQuery("FROM dbo.tb_user").
+		WithArgs("operator", "ADD").
+		WillReturnRows(sqlmock.NewRows([]string{"Username", "fullname"}).AddRow("operator", "Operator One"))
+
+	result, err := s.ListStatements(adminContext(), &StatementQuery{ResolveCreator: true})
+	if err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+	for _, st := range result.Statements {
+		if st.CreatedByName != "Operator One" {
+			t.Errorf("ListStatements() %s createdByName = %q, want %q", st.ID, st.CreatedByName, "Operator One")
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListStatementsWithoutCreator(t *testing.T) {
+	s, mock, log := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+
+	result, err := s.ListStatements(adminContext(), &StatementQuery{})
+	if err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+	if got := result.Statements[0].CreatedByName; got != "" {
+		t.Errorf("ListStatements() createdByName = %q, want it unresolved", got)
+	}
+	if n := len(log.all()); n != 1 {
+		t.Errorf("ListStatements() ran %d queries, want 1", n)
+	}
+}
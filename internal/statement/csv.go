@@ -0,0 +1,153 @@
+package statement
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+
+	"github.com/10664kls/estatement/internal/auth"
+	"github.com/10664kls/estatement/internal/pager"
+	"go.uber.org/zap"
+)
+
+// csvDelimiters maps the names of the delimiters allowed in the CSV exports
+// to their rune, e.g. the semicolon for the locales using the comma as the
+// decimal separator.
+var csvDelimiters = map[string]rune{
+	"comma":     ',',
+	"semicolon": ';',
+	"tab":       '\t',
+}
+
+// utf8BOM is the byte order mark Excel needs to read a CSV file as UTF-8.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// delimiter returns the rune of the delimiter of the CSV export.
+func (q *BatchGetStatementReq) delimiter() (rune, error) {
+	if q.Delimiter == "" {
+		return ',', nil
+	}
+	r, ok := csvDelimiters[q.Delimiter]
+	if !ok {
+		return 0, fmt.Errorf("unknown delimiter %q", q.Delimiter)
+	}
+	return r, nil
+}
+
+// GenCSV exports the statements matching the request as a flat CSV file with
+// the columns of the export profile, like GenExcel. The file is prefixed
+// with a UTF-8 BOM when the request asks for it, so Excel reads the Lao
+// names correctly.
+func (s *Service) GenCSV(ctx context.Context, in *BatchGetStatementReq) (*bytes.Buffer, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "GenCSV"),
+		s.queryField(in),
+	)
+	ctx = withOperation(ctx, "GenCSV")
+
+	zlog.Info("starting to gen csv")
+
+	if err := s.ValidateExport(in); err != nil {
+		zlog.Info("export request is not valid", zap.Error(err))
+		return nil, err
+	}
+
+	profile, err := s.exportProfile(in.Profile)
+	if err != nil {
+		zlog.Warn("export profile not found")
+		return nil, err
+	}
+
+	if s.requireBounded && !in.bounded() && !auth.ClaimsFromContext(ctx).IsAdmin() {
+		zlog.Info("export is not bounded")
+		return nil, errUnboundedExport()
+	}
+
+	loc, err := in.location()
+	if err != nil {
+		zlog.Info("timezone is not valid", zap.Error(err))
+		return nil, err
+	}
+
+	// The delimiter was validated along with the request.
+	delimiter, _ := in.delimiter()
+
+	columns := s.allowedColumns(ctx, profile)
+	mask := s.maskPolicy(ctx).union(profile.Mask)
+
+	release, err := s.acquireExport(ctx)
+	if err != nil {
+		zlog.Warn("no export slot available", zap.Error(err))
+		return nil, err
+	}
+	defer release()
+
+	buf := new(bytes.Buffer)
+	if in.BOM {
+		buf.Write(utf8BOM)
+	}
+
+	w := csv.NewWriter(buf)
+	w.Comma = delimiter
+
+	record := make([]string, len(columns))
+	for i, c := range columns {
+		record[i] = c.Label
+		if record[i] == "" {
+			record[i] = c.Key
+		}
+	}
+	if err := w.Write(record); err != nil {
+		zlog.Error("failed to write header", zap.Error(err))
+		return nil, err
+	}
+
+	var nextID string
+	for {
+		var batch *statementBatch
+		err := s.retryDeadlock(ctx, func() (err error) {
+			batch, err = batchGetStatements(ctx, s.db, s.dialect, pager.MaxSize, nextID, in, false)
+			return err
+		})
+		var re *rowError
+		if errors.As(err, &re) {
+			zlog.Error("failed to read statement", zap.String("id", re.id), zap.String("after", nextID), zap.Error(err))
+			return nil, errExportRow(re, nextID)
+		}
+		if err != nil {
+			zlog.Error("failed to batch get statements", zap.Error(err))
+			return nil, err
+		}
+
+		if batch.lastID == "" {
+			break
+		}
+		nextID = batch.lastID
+
+		for _, st := range batch.statements {
+			st.inLocation(loc)
+			mask.apply(st)
+			for i, c := range columns {
+				v := exportColumns[c.Key](st)
+				if v == nil {
+					v = in.NullPlaceholder
+				}
+				record[i] = fmt.Sprint(v)
+			}
+			if err := w.Write(record); err != nil {
+				zlog.Error("failed to write row", zap.Error(err))
+				return nil, err
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		zlog.Error("failed to flush csv", zap.Error(err))
+		return nil, err
+	}
+
+	return buf, nil
+}
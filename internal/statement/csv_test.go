@@ -0,0 +1,69 @@
+package statement
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// csvProfile exports the CUID and the queue number of the statements.
+var csvProfile = ExportProfile{
+	Name:    "ids",
+	Columns: []ExportColumn{{Key: "CUID"}, {Key: "CusNum", Label: "Queue number"}},
+}
+
+func TestGenCSVDelimiter(t *testing.T) {
+	tests := []struct {
+		delimiter string
+		want      string
+	}{
+		{delimiter: "", want: "CUID,Queue number\n1,Q1\n"},
+		{delimiter: "comma", want: "CUID,Queue number\n1,Q1\n"},
+		{delimiter: "semicolon", want: "CUID;Queue number\n1;Q1\n"},
+		{delimiter: "tab", want: "CUID\tQueue number\n1\tQ1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.delimiter, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{ExportProfiles: []ExportProfile{csvProfile}})
+			mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+			mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+			buf, err := s.GenCSV(adminContext(), &BatchGetStatementReq{Profile: "ids", Delimiter: tt.delimiter})
+			if err != nil {
+				t.Fatalf("GenCSV() error = %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("GenCSV() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenCSVUnknownDelimiter(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{ExportProfiles: []ExportProfile{csvProfile}})
+
+	if _, err := s.GenCSV(adminContext(), &BatchGetStatementReq{Profile: "ids", Delimiter: "|"}); err == nil {
+		t.Error("GenCSV() error = nil, want the delimiter refused")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGenCSVBOM(t *testing.T) {
+	for _, bom := range []bool{false, true} {
+		s, mock, _ := newTestService(t, Config{ExportProfiles: []ExportProfile{csvProfile}})
+		mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+		buf, err := s.GenCSV(adminContext(), &BatchGetStatementReq{Profile: "ids", BOM: bom})
+		if err != nil {
+			t.Fatalf("GenCSV(bom: %v) error = %v", bom, err)
+		}
+		if got := bytes.HasPrefix(buf.Bytes(), utf8BOM); got != bom {
+			t.Errorf("GenCSV(bom: %v) starts with the BOM = %v", bom, got)
+		}
+		if header := strings.TrimPrefix(buf.String(), string(utf8BOM)); header != "CUID,Queue number\n" {
+			t.Errorf("GenCSV(bom: %v) = %q, want the header alone", bom, header)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package statement
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// deadlockErrorNumber is the number of the SQL Server error returned to the
+// transaction chosen as the victim of a deadlock.
+const deadlockErrorNumber = 1205
+
+const (
+	// maxDeadlockRetries is how many times an operation chosen as a deadlock
+	// victim is run again.
+	maxDeadlockRetries = 3
+
+	// deadlockBackoff is the base delay before running the operation again,
+	// doubled on each retry and jittered so the victims don't collide again.
+	deadlockBackoff = 50 * time.Millisecond
+)
+
+// isDeadlock reports whether err is a SQL Server deadlock victim error, as
+// returned by the mssql driver.
+func isDeadlock(err error) bool {
+	var sqlErr interface{ SQLErrorNumber() int32 }
+	return errors.As(err, &sqlErr) && sqlErr.SQLErrorNumber() == deadlockErrorNumber
+}
+
+// retryDeadlock runs fn, and runs it again with a jittered backoff as long as
+// it fails as a deadlock victim, up to maxDeadlockRetries times. The other
+// errors are returned right away. fn must be safe to run again, e.g. a read
+// or a whole transaction.
+func (s *Service) retryDeadlock(ctx context.Context, fn func() error) error {
+	backoff := deadlockBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isDeadlock(err) || attempt > maxDeadlockRetries {
+			return err
+		}
+
+		delay := backoff/2 + rand.N(backoff)
+		s.zlog.Warn("deadlock victim, retrying",
+			zap.String("operation", operationFromContext(ctx)),
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+		)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
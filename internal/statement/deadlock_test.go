@@ -0,0 +1,75 @@
+package statement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// sqlError is shaped like the errors of the mssql driver.
+type sqlError struct {
+	number int32
+}
+
+func (e sqlError) Error() string         { return fmt.Sprintf("mssql: error %d", e.number) }
+func (e sqlError) SQLErrorNumber() int32 { return e.number }
+
+func TestListStatementsRetriesDeadlock(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	core, logs := observer.New(zapcore.WarnLevel)
+	s.zlog = zap.New(core)
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnError(sqlError{deadlockErrorNumber})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+
+	result, err := s.ListStatements(adminContext(), &StatementQuery{})
+	if err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+	if len(result.Statements) != 1 {
+		t.Errorf("ListStatements() = %d statements, want 1", len(result.Statements))
+	}
+	retries := logs.FilterMessage("deadlock victim, retrying").All()
+	if len(retries) != 1 {
+		t.Fatalf("logged %d deadlock retries, want 1", len(retries))
+	}
+	if op := retries[0].ContextMap()["operation"]; op != "ListStatements" {
+		t.Errorf("retry logged operation %v, want ListStatements", op)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRetryDeadlock(t *testing.T) {
+	s, _, _ := newTestService(t, Config{})
+	errOther := sqlError{2627}
+
+	tests := []struct {
+		name string
+		err  error
+		runs int
+	}{
+		{name: "other error", err: errOther, runs: 1},
+		{name: "wrapped deadlock", err: fmt.Errorf("failed to execute query: %w", sqlError{deadlockErrorNumber}), runs: 1 + maxDeadlockRetries},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs := 0
+			err := s.retryDeadlock(context.Background(), func() error {
+				runs++
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("retryDeadlock() error = %v, want %v", err, tt.err)
+			}
+			if runs != tt.runs {
+				t.Errorf("retryDeadlock() ran %d times, want %d", runs, tt.runs)
+			}
+		})
+	}
+}
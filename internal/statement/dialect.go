@@ -0,0 +1,70 @@
+package statement
+
+import (
+	"strconv"
+
+	"github.com/10664kls/estatement/internal/pager"
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Dialect is the SQL flavor used to build the queries.
+type Dialect struct {
+	// PlaceholderFormat is the format of the query placeholders.
+	PlaceholderFormat sq.PlaceholderFormat
+
+	// UseLimit limits the rows with a trailing LIMIT clause instead of
+	// SELECT TOP.
+	UseLimit bool
+}
+
+var (
+	// SQLServer is the dialect of Microsoft SQL Server.
+	SQLServer = Dialect{PlaceholderFormat: sq.AtP}
+
+	// Postgres is the dialect of PostgreSQL.
+	Postgres = Dialect{PlaceholderFormat: sq.Dollar, UseLimit: true}
+
+	// MySQL is the dialect of MySQL and SQLite.
+	MySQL = Dialect{PlaceholderFormat: sq.Question, UseLimit: true}
+)
+
+// builder returns a statement builder using the placeholder format of d.
+func (d Dialect) builder() sq.StatementBuilderType {
+	return sq.StatementBuilder.PlaceholderFormat(d.PlaceholderFormat)
+}
+
+// selectTop returns a select builder returning at most limit rows.
+// The limit is bounded by pager.Size, so it is never zero nor bigger than
+// pager.MaxSize whatever the caller passes.
+func (d Dialect) selectTop(limit uint64, columns ...string) sq.SelectBuilder {
+	limit = pager.Size(limit)
+
+	b := d.builder().Select(columns...)
+	if d.UseLimit {
+		return b.Limit(limit)
+	}
+	return b.Options(topClause(limit))
+}
+
+// selectPage returns a select builder returning at most limit rows after
+// skipping offset rows, the query must be ordered. It's selectTop when
+// offset is 0.
+func (d Dialect) selectPage(limit, offset uint64, columns ...string) sq.SelectBuilder {
+	if offset == 0 {
+		return d.selectTop(limit, columns...)
+	}
+	limit = pager.Size(limit)
+
+	b := d.builder().Select(columns...)
+	if d.UseLimit {
+		return b.Limit(limit).Offset(offset)
+	}
+	return b.Suffix("OFFSET ? ROWS FETCH NEXT ? ROWS ONLY", offset, limit)
+}
+
+// topClause returns the SQL Server TOP clause for the given limit.
+// It's the only place where a value is written into the query text instead of
+// being passed as an argument, which is fine because limit is an integer.
+func topClause(limit uint64) string {
+	return "TOP " + strconv.FormatUint(limit, 10)
+}
@@ -0,0 +1,75 @@
+package statement
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListStatementsDialects(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		want    []string
+		wantNot []string
+	}{
+		{
+			name:    "sql server",
+			dialect: SQLServer,
+			want:    []string{"SELECT TOP 20 ", "productnames = @p1"},
+			wantNot: []string{"LIMIT", "$1"},
+		},
+		{
+			name:    "postgres",
+			dialect: Postgres,
+			want:    []string{"productnames = $1", "LIMIT 20"},
+			wantNot: []string{"TOP", "@p"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, log := newTestService(t, Config{Dialect: tt.dialect})
+			mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+			_, err := s.ListStatements(adminContext(), &StatementQuery{ProductName: "LOAN", PageSize: 20})
+			if err != nil {
+				t.Fatalf("ListStatements() error = %v", err)
+			}
+
+			q := log.all()[0]
+			for _, want := range tt.want {
+				if !strings.Contains(q, want) {
+					t.Errorf("query %q does not contain %q", q, want)
+				}
+			}
+			for _, not := range tt.wantNot {
+				if strings.Contains(q, not) {
+					t.Errorf("query %q contains %q", q, not)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectTop(t *testing.T) {
+	tests := []struct {
+		limit uint64
+		want  string
+	}{
+		{limit: 0, want: "SELECT TOP 20 CUID FROM dbo.vm_customer"},
+		{limit: 1, want: "SELECT TOP 1 CUID FROM dbo.vm_customer"},
+		{limit: 50, want: "SELECT TOP 50 CUID FROM dbo.vm_customer"},
+		{limit: 200, want: "SELECT TOP 200 CUID FROM dbo.vm_customer"},
+		{limit: 1 << 40, want: "SELECT TOP 200 CUID FROM dbo.vm_customer"},
+		// A negative page size wrapped into an unsigned integer.
+		{limit: ^uint64(0), want: "SELECT TOP 200 CUID FROM dbo.vm_customer"},
+	}
+	for _, tt := range tests {
+		q, args, err := SQLServer.selectTop(tt.limit, "CUID").From("dbo.vm_customer").ToSql()
+		if err != nil {
+			t.Fatalf("selectTop(%d) error = %v", tt.limit, err)
+		}
+		if q != tt.want || len(args) != 0 {
+			t.Errorf("selectTop(%d) = %q %v, want %q", tt.limit, q, args, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package statement
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+
+	"golang.org/x/text/language"
+)
+
+// emailTemplate is the localized email sent to the customer with its
+// statement.
+type emailTemplate struct {
+	subject string
+	body    *template.Template
+}
+
+// emailTemplates are the statement email templates keyed by language.
+var emailTemplates = map[language.Tag]emailTemplate{
+	language.English: {
+		subject: "Your bank statement",
+		body: template.Must(template.New("en").Parse(
+			`<p>Dear {{.Customer.DisplayName}},</p>` +
+				`<p>Please find attached the statement of your request {{.QueueNumber}}.</p>`)),
+	},
+	language.Lao: {
+		subject: "ໃບແຈ້ງຍອດບັນຊີຂອງທ່ານ",
+		body: template.Must(template.New("lo").Parse(
+			`<p>ຮຽນ {{.Customer.DisplayName}},</p>` +
+				`<p>ກະລຸນາເບິ່ງໃບແຈ້ງຍອດບັນຊີຂອງຄຳຮ້ອງ {{.QueueNumber}} ທີ່ຄັດຕິດມານຳ.</p>`)),
+	},
+	language.Thai: {
+		subject: "รายการเดินบัญชีของคุณ",
+		body: template.Must(template.New("th").Parse(
+			`<p>เรียน {{.Customer.DisplayName}},</p>` +
+				`<p>กรุณาดูรายการเดินบัญชีของคำขอ {{.QueueNumber}} ตามไฟล์แนบ</p>`)),
+	},
+}
+
+// emailTags are the languages of the email templates, emailMatcher matches
+// a locale against them.
+var (
+	emailTags    = []language.Tag{language.English, language.Lao, language.Thai}
+	emailMatcher = language.NewMatcher(emailTags)
+)
+
+// StatementEmail is a rendered statement email.
+type StatementEmail struct {
+	Locale  string `json:"locale"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// RenderStatementEmail renders the statement email in the given locale
+// (e.g. "lo" or "th-TH"), falling back to the configured locale when no
+// template matches it.
+func (s *Service) RenderStatementEmail(locale string, st *Statement) (*StatementEmail, error) {
+	tag := s.emailFallback
+	if t, err := language.Parse(locale); err == nil {
+		if _, i, confidence := emailMatcher.Match(t); confidence != language.No {
+			tag = emailTags[i]
+		}
+	}
+
+	tmpl := emailTemplates[tag]
+	var body bytes.Buffer
+	if err := tmpl.body.Execute(&body, st); err != nil {
+		return nil, fmt.Errorf("failed to render email: %w", err)
+	}
+
+	return &StatementEmail{
+		Locale:  tag.String(),
+		Subject: tmpl.subject,
+		Body:    body.String(),
+	}, nil
+}
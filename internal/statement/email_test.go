@@ -0,0 +1,82 @@
+package statement
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRenderStatementEmail(t *testing.T) {
+	st := &Statement{
+		QueueNumber: "Q1",
+		Customer:    Customer{DisplayName: "Customer 1"},
+	}
+
+	tests := []struct {
+		name     string
+		fallback string
+		locale   string
+		want     string
+		subject  string
+		body     string
+	}{
+		{
+			name:    "english",
+			locale:  "en-US",
+			want:    "en",
+			subject: "Your bank statement",
+			body:    "<p>Dear Customer 1,</p>",
+		},
+		{
+			name:    "lao",
+			locale:  "lo",
+			want:    "lo",
+			subject: "ໃບແຈ້ງຍອດບັນຊີຂອງທ່ານ",
+			body:    "<p>ຮຽນ Customer 1,</p>",
+		},
+		{
+			name:     "unknown locale falls back",
+			fallback: "th",
+			locale:   "ja",
+			want:     "th",
+			subject:  "รายการเดินบัญชีของคุณ",
+			body:     "<p>เรียน Customer 1,</p>",
+		},
+		{
+			name:    "invalid locale falls back",
+			locale:  "not a locale",
+			want:    "en",
+			subject: "Your bank statement",
+			body:    "Q1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestService(t, Config{EmailFallbackLocale: tt.fallback})
+
+			got, err := s.RenderStatementEmail(tt.locale, st)
+			if err != nil {
+				t.Fatalf("RenderStatementEmail() error = %v", err)
+			}
+			if got.Locale != tt.want {
+				t.Errorf("RenderStatementEmail() locale = %q, want %q", got.Locale, tt.want)
+			}
+			if got.Subject != tt.subject {
+				t.Errorf("RenderStatementEmail() subject = %q, want %q", got.Subject, tt.subject)
+			}
+			if !strings.Contains(got.Body, tt.body) {
+				t.Errorf("RenderStatementEmail() body = %q, want it to contain %q", got.Body, tt.body)
+			}
+		})
+	}
+}
+
+func TestNewServiceEmailFallbackLocale(t *testing.T) {
+	for _, locale := range []string{"not a locale", "ja"} {
+		if _, err := NewService(context.Background(), nil, zap.NewNop(), Config{EmailFallbackLocale: locale}); err == nil {
+			t.Errorf("NewService(%q) error = nil, want an error", locale)
+		}
+	}
+}
@@ -3,20 +3,421 @@ package statement
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"time"
 
+	"github.com/10664kls/estatement/internal/auth"
+	"github.com/10664kls/estatement/internal/feature"
+	"github.com/10664kls/estatement/internal/pager"
 	"github.com/xuri/excelize/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
 )
 
+// ExportColumn is a single column of an export layout.
+type ExportColumn struct {
+	// Key is the column key, it must be one of the keys in exportColumns.
+	Key string
+
+	// Label is the header of the column.
+	// Optional. Default value is the Key.
+	Label string
+}
+
+// ExportProfile is a named export layout, it defines which columns are
+// exported and in which order.
+type ExportProfile struct {
+	Name    string
+	Columns []ExportColumn
+
+	// Mask is applied to the exported statements on top of the policy of
+	// the user's role.
+	// Optional. Default value is the zero MaskPolicy, nothing is masked.
+	Mask MaskPolicy
+}
+
+// exportColumns maps each exportable column key to its cell value, the
+// nullable columns return nil for the null values.
+var exportColumns = map[string]func(s *Statement) any{
+	"CUID":         func(s *Statement) any { return s.ID },
+	"CusNum":       func(s *Statement) any { return s.QueueNumber },
+	"CusName":      func(s *Statement) any { return s.Customer.DisplayName },
+	"AccNo":        func(s *Statement) any { return s.BankAccount.Number },
+	"Term":         func(s *Statement) any { return s.BankAccount.Term },
+	"BankName":     func(s *Statement) any { return s.BankAccount.Code },
+	"CreateDate":   func(s *Statement) any { return s.CreatedAt.Format("02/01/2006 15:04:05") },
+	"CreateBy":     func(s *Statement) any { return s.CreatedBy },
+	"BankStatus":   func(s *Statement) any { return nullable(s.BankAccount.Status) },
+	"BankMoreInfo": func(s *Statement) any { return nullable(s.BankAccount.Info) },
+	"BankCreateDate": func(s *Statement) any {
+		if s.BankAccount.CreatedAt == nil {
+			return nil
+		}
+		return s.BankAccount.CreatedAt.Format("02/01/2006 15:04:05")
+	},
+	"Gender":        func(s *Statement) any { return string(s.Customer.Gender.Canonical()) },
+	"ProductName":   func(s *Statement) any { return s.ProductName },
+	"EmailStatus":   func(s *Statement) any { return nullable(s.Email.IsSent) },
+	"EmailMsg":      func(s *Statement) any { return nullable(s.Email.Message) },
+	"Occupation":    func(s *Statement) any { return s.Customer.Occupation },
+	"StatusBanking": func(s *Statement) any { return s.Status },
+}
+
+// exportColumnDescriptions describes each exportable column in the data
+// dictionary sheet, it has an entry for every key of exportColumns.
+var exportColumnDescriptions = map[string]string{
+	"CUID":           "Unique ID of the statement request.",
+	"CusNum":         "Queue number of the customer.",
+	"CusName":        "Full name of the customer.",
+	"AccNo":          "Number of the bank account of the customer.",
+	"Term":           "Term of the statement request.",
+	"BankName":       "Code of the bank of the account.",
+	"CreateDate":     "Date and time the request was created, formatted as DD/MM/YYYY hh:mm:ss.",
+	"CreateBy":       "Username of the user who created the request.",
+	"BankStatus":     "Status of the request reported by the bank.",
+	"BankMoreInfo":   "Notes of the bank about the request.",
+	"BankCreateDate": "Date and time the bank received the request, formatted as DD/MM/YYYY hh:mm:ss. Empty if not received yet.",
+	"Gender":         "Gender of the customer.",
+	"ProductName":    "Name of the loan product.",
+	"EmailStatus":    "Whether the statement was sent to the customer by email.",
+	"EmailMsg":       "Message of the email sent to the customer, or the reason it failed.",
+	"Occupation":     "Occupation of the customer.",
+	"StatusBanking":  "Status of the request in the banking workflow.",
+}
+
+// dictionarySheetName is the name of the data dictionary sheet.
+const dictionarySheetName = "Data Dictionary"
+
+// writeDictionary adds the data dictionary sheet describing the columns.
+func writeDictionary(fx *excelize.File, columns []ExportColumn) error {
+	if _, err := fx.NewSheet(dictionarySheetName); err != nil {
+		return err
+	}
+
+	for i, header := range []string{"Column", "Key", "Description"} {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		fx.SetCellValue(dictionarySheetName, cell, header)
+	}
+	for i, c := range columns {
+		label := c.Label
+		if label == "" {
+			label = c.Key
+		}
+		for j, v := range []string{label, c.Key, exportColumnDescriptions[c.Key]} {
+			cell, _ := excelize.CoordinatesToCellName(j+1, i+2)
+			fx.SetCellValue(dictionarySheetName, cell, v)
+		}
+	}
+	return nil
+}
+
+// defaultExportProfile is used when the request does not select a profile.
+var defaultExportProfile = ExportProfile{
+	Name: "default",
+	Columns: []ExportColumn{
+		{Key: "CUID"},
+		{Key: "CusNum"},
+		{Key: "CusName"},
+		{Key: "AccNo"},
+		{Key: "Term"},
+		{Key: "BankName"},
+		{Key: "CreateDate"},
+		{Key: "CreateBy"},
+		{Key: "BankStatus"},
+		{Key: "BankMoreInfo"},
+		{Key: "BankCreateDate"},
+		{Key: "Gender"},
+		{Key: "ProductName"},
+		{Key: "EmailStatus"},
+		{Key: "EmailMsg"},
+		{Key: "Occupation"},
+		{Key: "StatusBanking"},
+	},
+}
+
+func (p *ExportProfile) validate() error {
+	if p.Name == "" {
+		return errors.New("export profile name is empty")
+	}
+	if len(p.Columns) == 0 {
+		return fmt.Errorf("export profile %q has no columns", p.Name)
+	}
+	for _, c := range p.Columns {
+		if _, ok := exportColumns[c.Key]; !ok {
+			return fmt.Errorf("export profile %q has unknown column %q", p.Name, c.Key)
+		}
+	}
+	return nil
+}
+
+// ValidateExport normalizes the export request and returns an error with
+// the field violations if it's not valid, its profile included.
+func (s *Service) ValidateExport(in *BatchGetStatementReq) error {
+	in.Normalize()
+	in.profiles = s.profiles
+	return in.Validate()
+}
+
+// exportProfile returns the profile registered under the given name.
+// An empty name selects the default profile.
+func (s *Service) exportProfile(name string) (*ExportProfile, error) {
+	if name == "" {
+		return &defaultExportProfile, nil
+	}
+
+	p, ok := s.profiles[name]
+	if !ok {
+		s, _ := rpcstatus.New(codes.InvalidArgument, "Export profile is not valid.").
+			WithDetails(&edpb.BadRequest{
+				FieldViolations: []*edpb.BadRequest_FieldViolation{
+					{
+						Field:       "profile",
+						Description: fmt.Sprintf("profile %q is not registered", name),
+					},
+				},
+			})
+		return nil, s.Err()
+	}
+	return p, nil
+}
+
+// errUnboundedExport is a helper function to create an error when an export
+// is not narrowed down enough.
+func errUnboundedExport() error {
+	s, _ := rpcstatus.New(codes.InvalidArgument, "Export must be limited to a date range, please set both createdAfter and createdBefore.").
+		WithDetails(&edpb.BadRequest{
+			FieldViolations: []*edpb.BadRequest_FieldViolation{
+				{
+					Field:       "createdAfter",
+					Description: "createdAfter is required",
+				},
+				{
+					Field:       "createdBefore",
+					Description: "createdBefore is required",
+				},
+			},
+		})
+	return s.Err()
+}
+
+// errInvalidTimezone is a helper function to create an error when the
+// timezone of an export is not known.
+func errInvalidTimezone() error {
+	s, _ := rpcstatus.New(codes.InvalidArgument, "Timezone of the export is not valid.").
+		WithDetails(&edpb.BadRequest{
+			FieldViolations: []*edpb.BadRequest_FieldViolation{
+				{
+					Field:       "timezone",
+					Description: "timezone must be a valid IANA timezone name",
+				},
+			},
+		})
+	return s.Err()
+}
+
+// location returns the location of the timezone of the export, or nil when
+// the dates are written as they are read.
+func (q *BatchGetStatementReq) location() (*time.Location, error) {
+	if q.Timezone == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return nil, errInvalidTimezone()
+	}
+	return loc, nil
+}
+
+// inLocation converts the dates of the statement to loc.
+func (s *Statement) inLocation(loc *time.Location) {
+	if loc == nil {
+		return
+	}
+	s.CreatedAt = s.CreatedAt.In(loc)
+	if s.BankAccount.CreatedAt != nil {
+		t := s.BankAccount.CreatedAt.In(loc)
+		s.BankAccount.CreatedAt = &t
+	}
+}
+
+// allowedColumns returns the columns of the profile the current user is
+// allowed to export, the restricted columns are dropped for non-admins.
+func (s *Service) allowedColumns(ctx context.Context, profile *ExportProfile) []ExportColumn {
+	if len(s.restricted) == 0 || auth.ClaimsFromContext(ctx).IsAdmin() {
+		return profile.Columns
+	}
+
+	columns := make([]ExportColumn, 0, len(profile.Columns))
+	dropped := make([]string, 0)
+	for _, c := range profile.Columns {
+		if s.restricted[c.Key] {
+			dropped = append(dropped, c.Key)
+			continue
+		}
+		columns = append(columns, c)
+	}
+
+	if len(dropped) > 0 {
+		s.zlog.Info("restricted export columns dropped",
+			zap.String("username", auth.ClaimsFromContext(ctx).Username),
+			zap.Strings("columns", dropped),
+		)
+	}
+	return columns
+}
+
+// exportSlotKey marks the contexts of the exports already holding a slot,
+// e.g. the queued export jobs.
+type exportSlotKey struct{}
+
+// acquireExport takes an export slot, waiting up to the export wait timeout.
+// The returned function releases the slot.
+func (s *Service) acquireExport(ctx context.Context) (func(), error) {
+	if ctx.Value(exportSlotKey{}) != nil {
+		return func() {}, nil
+	}
+	release := func() { <-s.exportSlots }
+
+	select {
+	case s.exportSlots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if s.exportWaitTimeout > 0 {
+		timer := time.NewTimer(s.exportWaitTimeout)
+		defer timer.Stop()
+
+		select {
+		case s.exportSlots <- struct{}{}:
+			return release, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return nil, rpcstatus.Error(codes.ResourceExhausted, "Too many exports are running, please try again later.")
+}
+
+// avgExportCellBytes is a rough average size of a cell of an exported
+// workbook once compressed.
+const avgExportCellBytes = 16
+
+// EstimateExportSize counts the statements matching the query and estimates
+// the size of their export, without fetching them.
+func (s *Service) EstimateExportSize(ctx context.Context, in *BatchGetStatementReq) (rows int64, approxBytes int64, err error) {
+	zlog := s.zlog.With(
+		zap.String("method", "EstimateExportSize"),
+		s.queryField(in),
+	)
+	ctx = withOperation(ctx, "EstimateExportSize")
+
+	zlog.Info("starting to estimate export size")
+
+	if err := s.ValidateExport(in); err != nil {
+		zlog.Info("export request is not valid", zap.Error(err))
+		return 0, 0, err
+	}
+
+	profile, err := s.exportProfile(in.Profile)
+	if err != nil {
+		zlog.Warn("export profile not found")
+		return 0, 0, err
+	}
+
+	q := *in
+	q.nextID = ""
+	rows, err = countStatements(ctx, s.db, s.dialect, &q)
+	if err != nil {
+		zlog.Error("failed to count statements", zap.Error(err))
+		return 0, 0, err
+	}
+
+	// The header row is counted as well.
+	approxBytes = (rows + 1) * int64(len(s.allowedColumns(ctx, profile))) * avgExportCellBytes
+	return rows, approxBytes, nil
+}
+
 func (s *Service) GenExcel(ctx context.Context, in *BatchGetStatementReq) (*bytes.Buffer, error) {
 	zlog := s.zlog.With(
 		zap.String("method", "GenExcel"),
-		zap.Any("query", in),
+		s.queryField(in),
 	)
+	ctx = withOperation(ctx, "GenExcel")
+
+	ctx, span := s.tracer.Start(ctx, "statement.GenExcel",
+		trace.WithAttributes(attribute.String("statement.export_profile", in.Profile)),
+	)
+	defer span.End()
 
 	zlog.Info("starting to gen excel")
 
+	if err := s.ValidateExport(in); err != nil {
+		zlog.Info("export request is not valid", zap.Error(err))
+		recordError(span, err)
+		return nil, err
+	}
+
+	profile, err := s.exportProfile(in.Profile)
+	if err != nil {
+		zlog.Warn("export profile not found")
+		recordError(span, err)
+		return nil, err
+	}
+
+	if s.requireBounded && !in.bounded() && !auth.ClaimsFromContext(ctx).IsAdmin() {
+		zlog.Info("export is not bounded")
+		err := errUnboundedExport()
+		recordError(span, err)
+		return nil, err
+	}
+
+	loc, err := in.location()
+	if err != nil {
+		zlog.Info("timezone is not valid", zap.Error(err))
+		recordError(span, err)
+		return nil, err
+	}
+
+	columns := s.allowedColumns(ctx, profile)
+	mask := s.maskPolicy(ctx).union(profile.Mask)
+	if g := in.grant; g != nil {
+		columns, mask = g.columns, g.mask
+	}
+	dictionary := in.IncludeDictionary || feature.FlagFromContext(ctx, feature.ExportDictionary)
+
+	var cacheKey, watermark string
+	if s.exports.enabled() {
+		cacheKey = exportCacheKey(in, columns, mask, dictionary)
+		watermark, err = exportWatermark(ctx, s.db, s.dialect, s.modifiedAt, in)
+		if err != nil {
+			zlog.Error("failed to get export watermark", zap.Error(err))
+			recordError(span, err)
+			return nil, err
+		}
+		if content, ok := s.exports.get(cacheKey, watermark); ok {
+			zlog.Info("export served from cache")
+			span.SetAttributes(attribute.Bool("statement.export_cached", true))
+			return bytes.NewBuffer(slices.Clone(content)), nil
+		}
+	}
+
+	release, err := s.acquireExport(ctx)
+	if err != nil {
+		zlog.Warn("no export slot available", zap.Error(err))
+		recordError(span, err)
+		return nil, err
+	}
+	defer release()
+
 	fx := excelize.NewFile()
 	defer fx.Close()
 
@@ -31,87 +432,168 @@ func (s *Service) GenExcel(ctx context.Context, in *BatchGetStatementReq) (*byte
 	fx.SetActiveSheet(sheet)
 
 	// add header
-	fx.SetCellValue(sheetName, "A1", "CUID")
-	fx.SetCellValue(sheetName, "B1", "CusNum")
-	fx.SetCellValue(sheetName, "C1", "CusName")
-	fx.SetCellValue(sheetName, "D1", "AccNo")
-	fx.SetCellValue(sheetName, "E1", "Term")
-	fx.SetCellValue(sheetName, "F1", "BankName")
-	fx.SetCellValue(sheetName, "G1", "CreateDate")
-	fx.SetCellValue(sheetName, "H1", "CreateBy")
-	fx.SetCellValue(sheetName, "I1", "BankStatus")
-	fx.SetCellValue(sheetName, "J1", "BankMoreInfo")
-	fx.SetCellValue(sheetName, "K1", "BankCreateDate")
-	fx.SetCellValue(sheetName, "L1", "Gender")
-	fx.SetCellValue(sheetName, "M1", "ProductName")
-	fx.SetCellValue(sheetName, "N1", "EmailStatus")
-	fx.SetCellValue(sheetName, "O1", "EmailMsg")
-	fx.SetCellValue(sheetName, "P1", "Occupation")
-	fx.SetCellValue(sheetName, "Q1", "StatusBanking")
+	for i, c := range columns {
+		label := c.Label
+		if label == "" {
+			label = c.Key
+		}
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		fx.SetCellValue(sheetName, cell, label)
+	}
 
 	row := 2
 	var nextID string
+	skipped := make([]*rowError, 0)
 	for {
-		statements, err := batchGetStatements(ctx, s.db, 200, nextID, in)
+		var batch *statementBatch
+		err := s.retryDeadlock(ctx, func() (err error) {
+			batch, err = batchGetStatements(ctx, s.db, s.dialect, pager.MaxSize, nextID, in, in.SkipBadRows)
+			return err
+		})
+		var re *rowError
+		if errors.As(err, &re) {
+			zlog.Error("failed to read statement", zap.String("id", re.id), zap.String("after", nextID), zap.Error(err))
+			err := errExportRow(re, nextID)
+			recordError(span, err)
+			return nil, err
+		}
 		if err != nil {
 			zlog.Error("failed to batch get statements", zap.Error(err))
+			recordError(span, err)
 			return nil, err
 		}
 
-		if len(statements) == 0 {
+		if batch.lastID == "" {
 			break
 		}
 
-		s.mu.Lock()
-		nextID = statements[len(statements)-1].ID
-		s.mu.Unlock()
+		nextID = batch.lastID
 
-		for _, s := range statements {
-			var bankCreatedAt, bankStatus, bankMoreInfo,
-				mailStatus, mailMsg string
-			if s.BankAccount.CreatedAt != nil {
-				bankCreatedAt = s.BankAccount.CreatedAt.Format("02/01/2006 15:04:05")
-			}
+		for _, re := range batch.skipped {
+			zlog.Warn("statement skipped", zap.String("id", re.id), zap.Error(re))
+		}
+		skipped = append(skipped, batch.skipped...)
 
-			if s.BankAccount.Status != nil {
-				bankStatus = *s.BankAccount.Status
-			}
-			if s.BankAccount.Info != nil {
-				bankMoreInfo = *s.BankAccount.Info
-			}
+		for _, st := range batch.statements {
+			st.inLocation(loc)
+			mask.apply(st)
+			if err := writeExportRow(fx, sheetName, row, columns, st, in.NullPlaceholder); err != nil {
+				re := &rowError{id: st.ID, written: true, err: err}
+				if !in.SkipBadRows {
+					zlog.Error("failed to write statement", zap.String("id", st.ID), zap.Error(err))
+					err := errExportRow(re, nextID)
+					recordError(span, err)
+					return nil, err
+				}
 
-			if s.Email.IsSent != nil {
-				mailStatus = *s.Email.IsSent
-			}
-			if s.Email.Message != nil {
-				mailMsg = *s.Email.Message
+				zlog.Warn("statement skipped", zap.String("id", st.ID), zap.Error(re))
+				skipped = append(skipped, re)
+				if err := fx.RemoveRow(sheetName, row); err != nil {
+					zlog.Error("failed to remove row", zap.Error(err))
+					recordError(span, err)
+					return nil, err
+				}
+				continue
 			}
-			fx.SetCellValue(sheetName, fmt.Sprintf("A%d", row), s.ID)
-			fx.SetCellValue(sheetName, fmt.Sprintf("B%d", row), s.QueueNumber)
-			fx.SetCellValue(sheetName, fmt.Sprintf("C%d", row), s.Customer.DisplayName)
-			fx.SetCellValue(sheetName, fmt.Sprintf("D%d", row), s.BankAccount.Number)
-			fx.SetCellValue(sheetName, fmt.Sprintf("E%d", row), s.BankAccount.Term)
-			fx.SetCellValue(sheetName, fmt.Sprintf("F%d", row), s.BankAccount.Code)
-			fx.SetCellValue(sheetName, fmt.Sprintf("G%d", row), s.CreatedAt.Format("02/01/2006 15:04:05"))
-			fx.SetCellValue(sheetName, fmt.Sprintf("H%d", row), s.CreatedBy)
-			fx.SetCellValue(sheetName, fmt.Sprintf("I%d", row), bankStatus)
-			fx.SetCellValue(sheetName, fmt.Sprintf("J%d", row), bankMoreInfo)
-			fx.SetCellValue(sheetName, fmt.Sprintf("K%d", row), bankCreatedAt)
-			fx.SetCellValue(sheetName, fmt.Sprintf("L%d", row), s.Customer.Gender)
-			fx.SetCellValue(sheetName, fmt.Sprintf("M%d", row), s.ProductName)
-			fx.SetCellValue(sheetName, fmt.Sprintf("N%d", row), mailStatus)
-			fx.SetCellValue(sheetName, fmt.Sprintf("O%d", row), mailMsg)
-			fx.SetCellValue(sheetName, fmt.Sprintf("P%d", row), s.Customer.Occupation)
-			fx.SetCellValue(sheetName, fmt.Sprintf("Q%d", row), s.Status)
 			row++
 		}
 	}
 
+	span.SetAttributes(attribute.Int("statement.row_count", row-2))
+
+	span.SetAttributes(attribute.Int("statement.skipped_count", len(skipped)))
+	if len(skipped) > 0 {
+		if err := writeSkippedRows(fx, skipped); err != nil {
+			zlog.Error("failed to write skipped rows", zap.Error(err))
+			recordError(span, err)
+			return nil, err
+		}
+	}
+
+	if dictionary {
+		if err := writeDictionary(fx, columns); err != nil {
+			zlog.Error("failed to write data dictionary", zap.Error(err))
+			recordError(span, err)
+			return nil, err
+		}
+	}
+
 	buf, err := fx.WriteToBuffer()
 	if err != nil {
 		zlog.Error("failed to write file to buffer", zap.Error(err))
+		recordError(span, err)
 		return nil, err
 	}
 
+	if s.exports.enabled() {
+		s.exports.put(cacheKey, watermark, slices.Clone(buf.Bytes()))
+	}
+
 	return buf, nil
 }
+
+// writeExportRow writes the cells of the statement into the row.
+func writeExportRow(fx *excelize.File, sheet string, row int, columns []ExportColumn, st *Statement, nullPlaceholder string) error {
+	for i, c := range columns {
+		cell, _ := excelize.CoordinatesToCellName(i+1, row)
+		v := exportColumns[c.Key](st)
+		if v == nil {
+			v = nullPlaceholder
+		}
+		if err := fx.SetCellValue(sheet, cell, v); err != nil {
+			return fmt.Errorf("column %s: %w", c.Key, err)
+		}
+	}
+	return nil
+}
+
+// skippedSheetName is the name of the sheet listing the skipped statements.
+const skippedSheetName = "Skipped Rows"
+
+// writeSkippedRows adds the sheet listing the statements skipped by the
+// export, see BatchGetStatementReq.SkipBadRows.
+func writeSkippedRows(fx *excelize.File, skipped []*rowError) error {
+	if _, err := fx.NewSheet(skippedSheetName); err != nil {
+		return err
+	}
+
+	fx.SetCellValue(skippedSheetName, "A1", "CUID")
+	fx.SetCellValue(skippedSheetName, "B1", "Reason")
+	for i, re := range skipped {
+		reason := "The statement could not be read."
+		if re.written {
+			reason = "The statement could not be written."
+		}
+		fx.SetCellValue(skippedSheetName, "A"+strconv.Itoa(i+2), re.id)
+		fx.SetCellValue(skippedSheetName, "B"+strconv.Itoa(i+2), reason)
+	}
+	return nil
+}
+
+// errExportRow is a helper function to create an error when a statement
+// could not be exported. The statement is identified by its ID, or by the
+// one of the statement it follows when its ID could not be read.
+func errExportRow(re *rowError, after string) error {
+	metadata := map[string]string{"statementId": re.id}
+	msg := fmt.Sprintf("Statement %s could not be exported, please contact an administrator.", re.id)
+	if re.id == "" {
+		metadata = map[string]string{"after": after}
+		msg = "A statement could not be exported, please contact an administrator."
+	}
+
+	s, _ := rpcstatus.New(codes.Internal, msg).
+		WithDetails(&edpb.ErrorInfo{
+			Reason:   "EXPORT_ROW_FAILED",
+			Domain:   "statement",
+			Metadata: metadata,
+		})
+	return s.Err()
+}
+
+// nullable returns the value of s, or nil if s is nil.
+func nullable(s *string) any {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
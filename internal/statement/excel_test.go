@@ -0,0 +1,454 @@
+package statement
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/10664kls/estatement/internal/feature"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/xuri/excelize/v2"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestAcquireExportRejectsOverLimit(t *testing.T) {
+	s, _, _ := newTestService(t, Config{MaxConcurrentExports: 2})
+
+	var acquired, exhausted atomic.Int32
+	var releases sync.WaitGroup
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := s.acquireExport(context.Background())
+			if rpcstatus.Code(err) == codes.ResourceExhausted {
+				exhausted.Add(1)
+				return
+			}
+			if err != nil {
+				t.Errorf("acquireExport() error = %v", err)
+				return
+			}
+			acquired.Add(1)
+			releases.Add(1)
+			go func() {
+				defer releases.Done()
+				<-done
+				release()
+			}()
+		}()
+	}
+	wg.Wait()
+	close(done)
+	releases.Wait()
+
+	if acquired.Load() != 2 || exhausted.Load() != 4 {
+		t.Errorf("acquireExport() = %d acquired, %d exhausted, want 2 and 4", acquired.Load(), exhausted.Load())
+	}
+	if n := len(s.exportSlots); n != 0 {
+		t.Errorf("%d export slots still taken, want all released", n)
+	}
+}
+
+func TestAcquireExportWaits(t *testing.T) {
+	s, _, _ := newTestService(t, Config{MaxConcurrentExports: 1, ExportWaitTimeout: time.Second})
+
+	release, err := s.acquireExport(context.Background())
+	if err != nil {
+		t.Fatalf("acquireExport() error = %v", err)
+	}
+	time.AfterFunc(20*time.Millisecond, release)
+
+	next, err := s.acquireExport(context.Background())
+	if err != nil {
+		t.Fatalf("acquireExport() error = %v, want the slot once released", err)
+	}
+	next()
+}
+
+func TestAcquireExportWaitTimeout(t *testing.T) {
+	s, _, _ := newTestService(t, Config{MaxConcurrentExports: 1, ExportWaitTimeout: 20 * time.Millisecond})
+
+	release, err := s.acquireExport(context.Background())
+	if err != nil {
+		t.Fatalf("acquireExport() error = %v", err)
+	}
+	defer release()
+
+	_, err = s.acquireExport(context.Background())
+	if rpcstatus.Code(err) != codes.ResourceExhausted {
+		t.Errorf("acquireExport() error = %v, want %s", err, codes.ResourceExhausted)
+	}
+}
+
+func TestEstimateExportSize(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("SELECT COUNT(*) FROM dbo.vm_customer").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(99))
+	mock.ExpectQuery("SELECT COUNT(*) FROM dbo.vm_customer").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(199))
+
+	rows, small, err := s.EstimateExportSize(adminContext(), &BatchGetStatementReq{})
+	if err != nil {
+		t.Fatalf("EstimateExportSize() error = %v", err)
+	}
+	if rows != 99 {
+		t.Errorf("EstimateExportSize() rows = %d, want 99", rows)
+	}
+
+	_, large, err := s.EstimateExportSize(adminContext(), &BatchGetStatementReq{})
+	if err != nil {
+		t.Fatalf("EstimateExportSize() error = %v", err)
+	}
+	// The estimates count the header row as well, 100 and 200 rows.
+	if small <= 0 || large != 2*small {
+		t.Errorf("EstimateExportSize() bytes = %d and %d, want proportional to the rows", small, large)
+	}
+}
+
+func TestGenExcelRestrictedColumns(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want bool
+	}{
+		{name: "user", ctx: userContext(), want: false},
+		{name: "admin", ctx: adminContext(), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{RestrictedExportColumns: []string{"EmailMsg", "BankMoreInfo"}})
+			mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+			mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+			buf, err := s.GenExcel(tt.ctx, &BatchGetStatementReq{})
+			if err != nil {
+				t.Fatalf("GenExcel() error = %v", err)
+			}
+			fx, err := excelize.OpenReader(buf)
+			if err != nil {
+				t.Fatalf("excelize.OpenReader() error = %v", err)
+			}
+			defer fx.Close()
+
+			rows, err := fx.GetRows("Statement Requests")
+			if err != nil {
+				t.Fatalf("GetRows() error = %v", err)
+			}
+			header := rows[0]
+			for _, key := range []string{"EmailMsg", "BankMoreInfo"} {
+				if got := slices.Contains(header, key); got != tt.want {
+					t.Errorf("GenExcel() header has %s = %t, want %t", key, got, tt.want)
+				}
+			}
+			if !slices.Contains(header, "CusName") {
+				t.Errorf("GenExcel() header = %v, want the unrestricted columns", header)
+			}
+		})
+	}
+}
+
+func TestGenExcelRequireBounded(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		req     *BatchGetStatementReq
+		wantErr codes.Code
+	}{
+		{name: "unbounded user", ctx: userContext(), req: &BatchGetStatementReq{ProductName: "LOAN"}, wantErr: codes.InvalidArgument},
+		{name: "created range", ctx: userContext(), req: &BatchGetStatementReq{CreatedAfter: testTime.AddDate(0, -1, 0), CreatedBefore: testTime}},
+		{name: "month", ctx: userContext(), req: &BatchGetStatementReq{Month: "2025-03"}},
+		{name: "queue number", ctx: userContext(), req: &BatchGetStatementReq{QueueNumber: "Q1"}},
+		{name: "unbounded admin", ctx: adminContext(), req: &BatchGetStatementReq{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{RequireBoundedExport: true})
+			mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+			_, err := s.GenExcel(tt.ctx, tt.req)
+			if code := rpcstatus.Code(err); code != tt.wantErr {
+				t.Errorf("GenExcel() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExportColumnDescriptions(t *testing.T) {
+	for key := range exportColumns {
+		if exportColumnDescriptions[key] == "" {
+			t.Errorf("exportColumnDescriptions[%q] is missing", key)
+		}
+	}
+}
+
+func TestGenExcelDictionary(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	buf, err := s.GenExcel(adminContext(), &BatchGetStatementReq{IncludeDictionary: true})
+	if err != nil {
+		t.Fatalf("GenExcel() error = %v", err)
+	}
+
+	fx, err := excelize.OpenReader(buf)
+	if err != nil {
+		t.Fatalf("excelize.OpenReader() error = %v", err)
+	}
+	defer fx.Close()
+
+	data, err := fx.GetRows("Statement Requests")
+	if err != nil {
+		t.Fatalf("GetRows() error = %v", err)
+	}
+	if len(data) != 2 {
+		t.Errorf("GenExcel() = %d data rows, want 2", len(data))
+	}
+
+	dictionary, err := fx.GetRows(dictionarySheetName)
+	if err != nil {
+		t.Fatalf("GetRows(%q) error = %v", dictionarySheetName, err)
+	}
+	if len(dictionary) != len(data[0])+1 {
+		t.Fatalf("GenExcel() dictionary = %d rows, want one per data column and the header", len(dictionary))
+	}
+	for i, header := range data[0] {
+		entry := dictionary[i+1]
+		if len(entry) != 3 || entry[0] != header || entry[2] == "" {
+			t.Errorf("GenExcel() dictionary row %d = %q, want the description of %q", i+2, entry, header)
+		}
+	}
+}
+
+func TestGenExcelWithoutDictionary(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	buf, err := s.GenExcel(adminContext(), &BatchGetStatementReq{})
+	if err != nil {
+		t.Fatalf("GenExcel() error = %v", err)
+	}
+
+	fx, err := excelize.OpenReader(buf)
+	if err != nil {
+		t.Fatalf("excelize.OpenReader() error = %v", err)
+	}
+	defer fx.Close()
+
+	if idx, _ := fx.GetSheetIndex(dictionarySheetName); idx != -1 {
+		t.Errorf("GenExcel() has the %q sheet, want none", dictionarySheetName)
+	}
+}
+
+func TestGenExcelDictionaryFlag(t *testing.T) {
+	for _, on := range []bool{true, false} {
+		s, mock, _ := newTestService(t, Config{})
+		mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+		ctx := feature.ContextWithFlags(adminContext(), feature.Flags{feature.ExportDictionary: on})
+		buf, err := s.GenExcel(ctx, &BatchGetStatementReq{})
+		if err != nil {
+			t.Fatalf("GenExcel() error = %v", err)
+		}
+
+		fx, err := excelize.OpenReader(buf)
+		if err != nil {
+			t.Fatalf("excelize.OpenReader() error = %v", err)
+		}
+		idx, _ := fx.GetSheetIndex(dictionarySheetName)
+		fx.Close()
+		if got := idx != -1; got != on {
+			t.Errorf("GenExcel() with %s=%v has the dictionary sheet %v", feature.ExportDictionary, on, got)
+		}
+	}
+}
+
+func TestGenExcelNullPlaceholder(t *testing.T) {
+	tests := []struct {
+		placeholder string
+		want        []string
+	}{
+		{placeholder: "", want: []string{"", "", "1"}},
+		{placeholder: "N/A", want: []string{"N/A", "N/A", "1"}},
+	}
+	for _, tt := range tests {
+		s, mock, _ := newTestService(t, Config{
+			ExportProfiles: []ExportProfile{{
+				Name:    "nullable",
+				Columns: []ExportColumn{{Key: "BankStatus"}, {Key: "EmailMsg"}, {Key: "CUID"}},
+			}},
+		})
+		mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+		mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+		buf, err := s.GenExcel(adminContext(), &BatchGetStatementReq{Profile: "nullable", NullPlaceholder: tt.placeholder})
+		if err != nil {
+			t.Fatalf("GenExcel() error = %v", err)
+		}
+
+		fx, err := excelize.OpenReader(buf)
+		if err != nil {
+			t.Fatalf("excelize.OpenReader() error = %v", err)
+		}
+		var got []string
+		for _, cell := range []string{"A2", "B2", "C2"} {
+			v, _ := fx.GetCellValue("Statement Requests", cell)
+			got = append(got, v)
+		}
+		fx.Close()
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("GenExcel(%q) row = %q, want %q", tt.placeholder, got, tt.want)
+		}
+	}
+}
+
+// badRowRows returns the rows of the statements 3, 2 and 1, the creation
+// time of 2 can't be read.
+func badRowRows() *sqlmock.Rows {
+	bad := statementRow("2", "Q2")
+	bad[len(bad)-1] = "yesterday"
+	return sqlmock.NewRows(statementColumns).
+		AddRow(statementRow("3", "Q3")...).
+		AddRow(bad...).
+		AddRow(statementRow("1", "Q1")...)
+}
+
+func TestGenExcelBadRowAborts(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(badRowRows())
+
+	_, err := s.GenExcel(adminContext(), &BatchGetStatementReq{})
+	st := rpcstatus.Convert(err)
+	if st.Code() != codes.Internal {
+		t.Fatalf("GenExcel() code = %v, want %v", st.Code(), codes.Internal)
+	}
+	var info *edpb.ErrorInfo
+	for _, d := range st.Details() {
+		if i, ok := d.(*edpb.ErrorInfo); ok {
+			info = i
+		}
+	}
+	if info.GetReason() != "EXPORT_ROW_FAILED" || info.GetMetadata()["statementId"] != "2" {
+		t.Errorf("GenExcel() error info = %v, want the failed row 2", info)
+	}
+}
+
+func TestGenExcelSkipBadRows(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(badRowRows())
+	mock.ExpectQuery("FROM dbo.vm_customer").WithArgs("DEL", "1").WillReturnRows(statementRows())
+
+	buf, err := s.GenExcel(adminContext(), &BatchGetStatementReq{SkipBadRows: true})
+	if err != nil {
+		t.Fatalf("GenExcel() error = %v", err)
+	}
+
+	fx, err := excelize.OpenReader(buf)
+	if err != nil {
+		t.Fatalf("excelize.OpenReader() error = %v", err)
+	}
+	var exported []string
+	for _, cell := range []string{"A2", "A3", "A4"} {
+		v, _ := fx.GetCellValue("Statement Requests", cell)
+		exported = append(exported, v)
+	}
+	if want := []string{"3", "1", ""}; !slices.Equal(exported, want) {
+		t.Errorf("GenExcel() exported %v, want %v", exported, want)
+	}
+	rows, err := fx.GetRows(skippedSheetName)
+	if err != nil {
+		t.Fatalf("GetRows(%s) error = %v", skippedSheetName, err)
+	}
+	if len(rows) != 2 || rows[1][0] != "2" {
+		t.Errorf("GenExcel() skipped %v, want the row 2", rows)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGenExcelBatches(t *testing.T) {
+	s, mock, log := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1", "2"))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("3"))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	buf, err := s.GenExcel(adminContext(), &BatchGetStatementReq{})
+	if err != nil {
+		t.Fatalf("GenExcel() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(log.all()); got != 3 {
+		t.Errorf("GenExcel() ran %d queries, want 3", got)
+	}
+
+	fx, err := excelize.OpenReader(buf)
+	if err != nil {
+		t.Fatalf("excelize.OpenReader() error = %v", err)
+	}
+	defer fx.Close()
+
+	rows, err := fx.GetRows("Statement Requests")
+	if err != nil {
+		t.Fatalf("GetRows() error = %v", err)
+	}
+	// The header row and a row per statement of both batches.
+	if len(rows) != 4 {
+		t.Errorf("GenExcel() = %d rows, want 4", len(rows))
+	}
+}
+
+func TestGenExcelProfile(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{
+		ExportProfiles: []ExportProfile{{
+			Name: "short",
+			Columns: []ExportColumn{
+				{Key: "CusName", Label: "Customer"},
+				{Key: "CUID"},
+			},
+		}},
+	})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	buf, err := s.GenExcel(adminContext(), &BatchGetStatementReq{Profile: "short"})
+	if err != nil {
+		t.Fatalf("GenExcel() error = %v", err)
+	}
+
+	fx, err := excelize.OpenReader(buf)
+	if err != nil {
+		t.Fatalf("excelize.OpenReader() error = %v", err)
+	}
+	defer fx.Close()
+
+	rows, err := fx.GetRows("Statement Requests")
+	if err != nil {
+		t.Fatalf("GetRows() error = %v", err)
+	}
+	want := [][]string{{"Customer", "CUID"}, {"Customer 1", "1"}}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("GenExcel() = %v, want %v", rows, want)
+	}
+}
+
+func TestGenExcelUnknownProfile(t *testing.T) {
+	s, _, _ := newTestService(t, Config{})
+
+	_, err := s.GenExcel(adminContext(), &BatchGetStatementReq{Profile: "missing"})
+	if rpcstatus.Code(err) != codes.InvalidArgument {
+		t.Errorf("GenExcel() error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
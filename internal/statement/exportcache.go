@@ -0,0 +1,145 @@
+package statement
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// exportCacheEntry is a generated workbook and the watermark of the data it
+// was generated from.
+type exportCacheEntry struct {
+	watermark string
+	content   []byte
+	storedAt  time.Time
+}
+
+// exportCache caches the last workbooks generated, keyed by the hash of
+// what the workbook is made of. An entry is only served while the watermark
+// of its data is unchanged. The oldest entry is evicted beyond size entries.
+type exportCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]exportCacheEntry
+}
+
+func newExportCache(size int) *exportCache {
+	return &exportCache{
+		size:    size,
+		entries: make(map[string]exportCacheEntry),
+	}
+}
+
+// enabled reports whether the workbooks are cached.
+func (c *exportCache) enabled() bool {
+	return c.size > 0
+}
+
+// get returns the workbook cached under the key if it was generated from
+// the data of the watermark.
+func (c *exportCache) get(key, watermark string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || e.watermark != watermark {
+		return nil, false
+	}
+	return e.content, true
+}
+
+// put caches the workbook under the key, evicting the oldest entry when the
+// cache is full.
+func (c *exportCache) put(key, watermark string, content []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		var oldest string
+		for k, e := range c.entries {
+			if oldest == "" || e.storedAt.Before(c.entries[oldest].storedAt) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = exportCacheEntry{
+		watermark: watermark,
+		content:   content,
+		storedAt:  time.Now(),
+	}
+}
+
+// purge drops every entry, e.g. after the statements were updated in place
+// which the watermark may not tell.
+func (c *exportCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
+
+// exportCacheKey returns the hash of everything the workbook of the request
+// is made of: its filters and options, and the columns and masks of the
+// current user. The name of the file is left out.
+func exportCacheKey(in *BatchGetStatementReq, columns []ExportColumn, mask MaskPolicy, dictionary bool) string {
+	req := *in
+	req.Filename = ""
+
+	b, _ := json.Marshal(struct {
+		Req        *BatchGetStatementReq
+		Columns    []ExportColumn
+		Mask       MaskPolicy
+		Dictionary bool
+	}{&req, columns, mask, dictionary})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// exportWatermark returns the watermark of the statements matching the
+// request: their count, latest creation and latest modification when the
+// view has a modification time column. It advances when a statement is
+// added, removed or, with the modification time, updated.
+func exportWatermark(ctx context.Context, db queryer, d Dialect, modifiedAt string, in *BatchGetStatementReq) (string, error) {
+	q := *in
+	q.nextID = ""
+	pred, args, err := q.ToSql()
+	if err != nil {
+		return "", toSqlError(err)
+	}
+
+	columns := []string{"COUNT(*)", "MAX(createdate)"}
+	if modifiedAt != "" {
+		columns = append(columns, "MAX("+modifiedAt+")")
+	}
+
+	query, args := d.builder().
+		Select(columns...).
+		From("dbo.vm_customer").
+		Where(sq.Expr(pred, args...)).
+		MustSql()
+
+	var count int64
+	var created, modified sql.NullTime
+	dest := []any{&count, &created}
+	if modifiedAt != "" {
+		dest = append(dest, &modified)
+	}
+	if err := db.QueryRowContext(ctx, query, args...).Scan(dest...); err != nil {
+		return "", fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return fmt.Sprintf("%d|%s|%s",
+		count,
+		created.Time.UTC().Format(time.RFC3339Nano),
+		modified.Time.UTC().Format(time.RFC3339Nano),
+	), nil
+}
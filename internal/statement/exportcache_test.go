@@ -0,0 +1,63 @@
+package statement
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestGenExcelCache(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{ExportCacheSize: 2})
+	watermark := func(count int64) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"count", "created"}).AddRow(count, testTime)
+	}
+
+	// The first export is generated.
+	mock.ExpectQuery("MAX(createdate)").WillReturnRows(watermark(1))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+	// The same export over unchanged data is served from the cache.
+	mock.ExpectQuery("MAX(createdate)").WillReturnRows(watermark(1))
+	// A new statement advances the watermark, the export is generated again.
+	mock.ExpectQuery("MAX(createdate)").WillReturnRows(watermark(2))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("2", "1"))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	export := func() []byte {
+		t.Helper()
+		buf, err := s.GenExcel(adminContext(), &BatchGetStatementReq{ProductName: "LOAN"})
+		if err != nil {
+			t.Fatalf("GenExcel() error = %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	first := export()
+	if cached := export(); !bytes.Equal(cached, first) {
+		t.Error("GenExcel() regenerated the workbook, want the cached one")
+	}
+	busted := export()
+	fx, err := excelize.OpenReader(bytes.NewReader(busted))
+	if err != nil {
+		t.Fatalf("excelize.OpenReader() error = %v", err)
+	}
+	if v, _ := fx.GetCellValue("Statement Requests", "A3"); v == "" {
+		t.Error("GenExcel() served the cached workbook, want the new statement")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportCacheKeyIgnoresFilename(t *testing.T) {
+	a := exportCacheKey(&BatchGetStatementReq{ProductName: "LOAN", Filename: "a.xlsx"}, nil, MaskPolicy{}, false)
+	b := exportCacheKey(&BatchGetStatementReq{ProductName: "LOAN", Filename: "b.xlsx"}, nil, MaskPolicy{}, false)
+	if a != b {
+		t.Error("exportCacheKey() differs by filename, want the same key")
+	}
+	if c := exportCacheKey(&BatchGetStatementReq{ProductName: "CARD"}, nil, MaskPolicy{}, false); c == a {
+		t.Error("exportCacheKey() is the same for other filters, want another key")
+	}
+}
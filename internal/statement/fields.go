@@ -0,0 +1,78 @@
+package statement
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// statementFields is the set of the top-level JSON fields of a statement.
+var statementFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(Statement{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// ParseFields parses a comma separated list of top-level statement fields,
+// e.g. "id,queueNumber,status".
+// It returns nil if s is empty, meaning all the fields.
+func ParseFields(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	fields := make([]string, 0)
+	violations := make([]*edpb.BadRequest_FieldViolation, 0)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if !statementFields[f] {
+			violations = append(violations, &edpb.BadRequest_FieldViolation{
+				Field:       "fields",
+				Description: fmt.Sprintf("field %q is not a statement field", f),
+			})
+			continue
+		}
+		fields = append(fields, f)
+	}
+
+	if len(violations) > 0 {
+		s, _ := rpcstatus.New(codes.InvalidArgument, "Fields are not valid.").
+			WithDetails(&edpb.BadRequest{
+				FieldViolations: violations,
+			})
+		return nil, s.Err()
+	}
+	return fields, nil
+}
+
+// Project returns the statement restricted to the given top-level fields.
+func (s *Statement) Project(fields []string) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+
+	projected := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			projected[f] = v
+		}
+	}
+	return projected, nil
+}
@@ -0,0 +1,42 @@
+package statement
+
+import (
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields("id, queueNumber,status")
+	if err != nil {
+		t.Fatalf("ParseFields() error = %v", err)
+	}
+	if want := []string{"id", "queueNumber", "status"}; !slices.Equal(fields, want) {
+		t.Errorf("ParseFields() = %v, want %v", fields, want)
+	}
+
+	if fields, err := ParseFields(""); fields != nil || err != nil {
+		t.Errorf("ParseFields(\"\") = %v, %v, want all the fields", fields, err)
+	}
+}
+
+func TestParseFieldsInvalid(t *testing.T) {
+	_, err := ParseFields("id,password")
+	if rpcstatus.Code(err) != codes.InvalidArgument {
+		t.Errorf("ParseFields() error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestProject(t *testing.T) {
+	st := &Statement{ID: "1", QueueNumber: "Q1", Status: "PENDING", ProductName: "LOAN"}
+
+	p, err := st.Project([]string{"id", "status"})
+	if err != nil {
+		t.Fatalf("Project() error = %v", err)
+	}
+	if len(p) != 2 || string(p["id"]) != `"1"` || string(p["status"]) != `"PENDING"` {
+		t.Errorf("Project() = %s, want only id and status", p)
+	}
+}
@@ -0,0 +1,70 @@
+package statement
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxFilenameLen bounds the length of the names given by the users.
+const maxFilenameLen = 100
+
+// ExportFilename returns the name of the workbook exported for the request.
+// It's the Filename of the request if set, otherwise a name made of the day
+// and a hash of the filters, e.g. "statements-20240115-a1b2c3.xlsx", so the
+// same export of the same day is always named the same.
+func (q *BatchGetStatementReq) ExportFilename(now time.Time) string {
+	return q.exportFilename(now, ".xlsx")
+}
+
+// CSVFilename returns the name of the CSV file exported for the request,
+// named like ExportFilename with the .csv extension.
+func (q *BatchGetStatementReq) CSVFilename(now time.Time) string {
+	return q.exportFilename(now, ".csv")
+}
+
+func (q *BatchGetStatementReq) exportFilename(now time.Time, ext string) string {
+	if name := sanitizeFilename(q.Filename); name != "" {
+		if !strings.EqualFold(path.Ext(name), ext) {
+			name += ext
+		}
+		return name
+	}
+
+	filters := *q
+	filters.Filename = ""
+	b, _ := json.Marshal(&filters)
+	sum := sha256.Sum256(b)
+	return "statements-" + now.Format("20060102") + "-" + hex.EncodeToString(sum[:3]) + ext
+}
+
+// sanitizeFilename keeps the letters, digits, spaces, dots, dashes and
+// underscores of the name, so it's safe in a Content-Disposition header
+// and on any file system.
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == ' ', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+
+	// The leading dots would hide the file on some systems.
+	name = strings.TrimSpace(strings.TrimLeft(name, ". "))
+	if len(name) > maxFilenameLen {
+		name = name[:maxFilenameLen]
+	}
+	return name
+}
+
+// PDFFilename returns the name of the PDF of the statement of the queue
+// number, e.g. "statement-Q0001.pdf".
+func PDFFilename(queueNumber string) string {
+	return "statement-" + sanitizeFilename(queueNumber) + ".pdf"
+}
@@ -0,0 +1,51 @@
+package statement
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestExportFilenameGenerated(t *testing.T) {
+	day := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	req := func() *BatchGetStatementReq {
+		return &BatchGetStatementReq{ProductName: "LOAN"}
+	}
+
+	name := req().ExportFilename(day)
+	if !regexp.MustCompile(`^statements-20240115-[0-9a-f]{6}\.xlsx$`).MatchString(name) {
+		t.Errorf("ExportFilename() = %q, want statements-20240115-<hash>.xlsx", name)
+	}
+	if got := req().ExportFilename(day.Add(8 * time.Hour)); got != name {
+		t.Errorf("ExportFilename() later that day = %q, want %q", got, name)
+	}
+	if got := req().ExportFilename(day.AddDate(0, 0, 1)); got == name {
+		t.Errorf("ExportFilename() the next day = %q, want another name", got)
+	}
+
+	other := req()
+	other.ProductName = "CARD"
+	if got := other.ExportFilename(day); got == name {
+		t.Errorf("ExportFilename() of other filters = %q, want another name", got)
+	}
+	if got := req().CSVFilename(day); got != name[:len(name)-len(".xlsx")]+".csv" {
+		t.Errorf("CSVFilename() = %q, want the name of the workbook with .csv", got)
+	}
+}
+
+func TestExportFilenameGiven(t *testing.T) {
+	day := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{filename: "march.xlsx", want: "march.xlsx"},
+		{filename: "march", want: "march.xlsx"},
+		{filename: "../..\\evil\".xlsx", want: "_.._evil_.xlsx"},
+	}
+	for _, tt := range tests {
+		if got := (&BatchGetStatementReq{Filename: tt.filename}).ExportFilename(day); got != tt.want {
+			t.Errorf("ExportFilename(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package statement
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// Gender is the gender of a customer. The common representations are
+// normalized to the canonical values, the other values are kept as is.
+type Gender string
+
+const (
+	GenderMale   Gender = "MALE"
+	GenderFemale Gender = "FEMALE"
+)
+
+// genderAliases are the representations of the canonical genders the
+// database may store, matched case-insensitively by ParseGender.
+var genderAliases = map[Gender][]string{
+	GenderMale:   {"M", "MALE", "Male", "male", "m"},
+	GenderFemale: {"F", "FEMALE", "Female", "female", "f"},
+}
+
+const (
+	// maxGenders is the maximum number of genders a filter matches at once.
+	maxGenders = 10
+
+	// maxGenderLen is the most characters of each gender of a filter.
+	maxGenderLen = 32
+)
+
+// ParseGender returns the canonical gender of s, or s trimmed when it's not
+// a known representation.
+func ParseGender(s string) Gender {
+	s = strings.TrimSpace(s)
+	for g, aliases := range genderAliases {
+		for _, alias := range aliases {
+			if strings.EqualFold(s, alias) {
+				return g
+			}
+		}
+	}
+	return Gender(s)
+}
+
+// ParseGenders returns the distinct genders of the comma separated list s,
+// each parsed by ParseGender.
+func ParseGenders(s string) []Gender {
+	genders := make([]Gender, 0)
+	for _, v := range strings.Split(s, ",") {
+		g := ParseGender(v)
+		if g == "" || slices.Contains(genders, g) {
+			continue
+		}
+		genders = append(genders, g)
+	}
+	return genders
+}
+
+// Canonical returns the canonical value of g. The filters may hold a comma
+// separated list of genders, each of them is made canonical.
+func (g Gender) Canonical() Gender {
+	genders := ParseGenders(string(g))
+	values := make([]string, len(genders))
+	for i, g := range genders {
+		values[i] = string(g)
+	}
+	return Gender(strings.Join(values, ","))
+}
+
+// values returns the values of the gender column matching g, all the
+// representations of a canonical gender are matched, and any of the genders
+// of a comma separated list.
+func (g Gender) values() []string {
+	values := make([]string, 0)
+	for _, g := range ParseGenders(string(g)) {
+		if aliases, ok := genderAliases[g]; ok {
+			values = append(values, aliases...)
+			continue
+		}
+		values = append(values, string(g))
+	}
+	return values
+}
+
+// violation returns the field violation of the gender filter g, nil when
+// it's valid. The genders which aren't known are matched as they are, so
+// only their number and length are checked.
+func (g Gender) violation() *edpb.BadRequest_FieldViolation {
+	genders := ParseGenders(string(g))
+	if len(genders) > maxGenders {
+		return &edpb.BadRequest_FieldViolation{
+			Field:       "gender",
+			Description: fmt.Sprintf("gender must have at most %d genders", maxGenders),
+		}
+	}
+	for _, g := range genders {
+		if len([]rune(g)) > maxGenderLen {
+			return &edpb.BadRequest_FieldViolation{
+				Field:       "gender",
+				Description: fmt.Sprintf("gender must have at most %d characters per gender", maxGenderLen),
+			}
+		}
+	}
+	return nil
+}
+
+func (g Gender) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(g.Canonical()))
+}
+
+func (g *Gender) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*g = Gender(s).Canonical()
+	return nil
+}
+
+// UnmarshalParam implements echo.BindUnmarshaler, so the gender of the
+// query string is normalized as well. The gender filter of the query string
+// may be a comma separated list, e.g. "gender=M,F", matching any of them.
+func (g *Gender) UnmarshalParam(param string) error {
+	*g = Gender(param).Canonical()
+	return nil
+}
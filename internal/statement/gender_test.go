@@ -0,0 +1,106 @@
+package statement
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestGenderMarshalJSON(t *testing.T) {
+	tests := []struct {
+		gender Gender
+		want   string
+	}{
+		{gender: "M", want: `"MALE"`},
+		{gender: "female", want: `"FEMALE"`},
+		{gender: " f ", want: `"FEMALE"`},
+		{gender: "X", want: `"X"`},
+		{gender: "", want: `""`},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(Customer{Gender: tt.gender})
+		if err != nil {
+			t.Fatalf("json.Marshal(%q) error = %v", tt.gender, err)
+		}
+		var got struct {
+			Gender json.RawMessage `json:"gender"`
+		}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		if string(got.Gender) != tt.want {
+			t.Errorf("json.Marshal(%q) gender = %s, want %s", tt.gender, got.Gender, tt.want)
+		}
+	}
+}
+
+func TestGenderUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Gender
+	}{
+		{in: `"m"`, want: GenderMale},
+		{in: `"Male"`, want: GenderMale},
+		{in: `"F"`, want: GenderFemale},
+		{in: `"M, f, male"`, want: "MALE,FEMALE"},
+		{in: `"other"`, want: "other"},
+	}
+	for _, tt := range tests {
+		var got Gender
+		if err := json.Unmarshal([]byte(tt.in), &got); err != nil {
+			t.Fatalf("json.Unmarshal(%s) error = %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("json.Unmarshal(%s) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	var g Gender
+	if err := json.Unmarshal([]byte(`1`), &g); err == nil {
+		t.Error("json.Unmarshal(1) error = nil, want an error")
+	}
+}
+
+func TestGenderUnmarshalParam(t *testing.T) {
+	var g Gender
+	if err := g.UnmarshalParam("f,M"); err != nil {
+		t.Fatalf("UnmarshalParam() error = %v", err)
+	}
+	if g != "FEMALE,MALE" {
+		t.Errorf("UnmarshalParam(\"f,M\") = %q, want %q", g, "FEMALE,MALE")
+	}
+}
+
+func TestStatementQueryGenderViolation(t *testing.T) {
+	tests := []struct {
+		name    string
+		gender  Gender
+		invalid bool
+	}{
+		{name: "known", gender: "M,F"},
+		{name: "other", gender: "X"},
+		{name: "too many", gender: "A,B,C,D,E,F,G,H,I,J,K", invalid: true},
+		{name: "too long", gender: Gender(strings.Repeat("x", maxGenderLen+1)), invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&StatementQuery{Gender: tt.gender}).Validate()
+			if !tt.invalid {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if code := rpcstatus.Code(err); code != codes.InvalidArgument {
+				t.Fatalf("Validate() code = %v, want %v", code, codes.InvalidArgument)
+			}
+			if fields := violationFields(err); !slices.Equal(fields, []string{"gender"}) {
+				t.Errorf("Validate() violations = %v, want [gender]", fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package statement
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/10664kls/estatement/internal/auth"
+	sq "github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// emailStatusHeader is the expected header of an email status CSV file.
+var emailStatusHeader = []string{"queueNumber", "status", "message"}
+
+type ImportRowError struct {
+	Row         int    `json:"row"`
+	QueueNumber string `json:"queueNumber"`
+	Message     string `json:"message"`
+}
+
+type ImportEmailStatusResult struct {
+	Updated int               `json:"updated"`
+	Failed  int               `json:"failed"`
+	Errors  []*ImportRowError `json:"errors"`
+}
+
+type emailStatusRow struct {
+	row         int
+	queueNumber string
+	status      string
+	message     string
+}
+
+// ImportEmailStatus updates the email status and message of the statements
+// from a CSV file with the columns queueNumber,status,message.
+// Malformed rows, unknown queue numbers and queue numbers of more than one
+// statement are reported and skipped, the other rows are updated in a single
+// transaction. Only admins are allowed to import the email status.
+func (s *Service) ImportEmailStatus(ctx context.Context, r io.Reader) (*ImportEmailStatusResult, error) {
+	claims := auth.ClaimsFromContext(ctx)
+	zlog := s.zlog.With(
+		zap.String("method", "ImportEmailStatus"),
+		zap.String("by", claims.Username),
+	)
+
+	zlog.Info("starting to import email status")
+
+	if !claims.IsAdmin() {
+		zlog.Info("not an admin")
+		return nil, rpcstatus.Error(codes.PermissionDenied, "You are not allowed to import the email status.")
+	}
+
+	result := &ImportEmailStatusResult{
+		Errors: make([]*ImportRowError, 0),
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		zlog.Info("file is empty")
+		return nil, rpcstatus.Error(codes.InvalidArgument, "The file is empty.")
+	}
+	if err != nil || !validHeader(header) {
+		zlog.Info("header is not valid", zap.Error(err))
+		return nil, rpcstatus.Error(codes.InvalidArgument,
+			"The file header must be: "+strings.Join(emailStatusHeader, ","))
+	}
+
+	rows := make([]*emailStatusRow, 0)
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, &ImportRowError{
+				Row:     line,
+				Message: "row is not valid CSV",
+			})
+			continue
+		}
+		if len(record) != len(emailStatusHeader) {
+			result.Errors = append(result.Errors, &ImportRowError{
+				Row:     line,
+				Message: fmt.Sprintf("row must have %d columns", len(emailStatusHeader)),
+			})
+			continue
+		}
+
+		row := &emailStatusRow{
+			row:         line,
+			queueNumber: strings.TrimSpace(record[0]),
+			status:      strings.TrimSpace(record[1]),
+			message:     strings.TrimSpace(record[2]),
+		}
+		if row.queueNumber == "" || row.status == "" {
+			result.Errors = append(result.Errors, &ImportRowError{
+				Row:         line,
+				QueueNumber: row.queueNumber,
+				Message:     "queueNumber and status are required",
+			})
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	// The whole transaction is run again when it's a deadlock victim.
+	parseErrors := result.Errors
+	err = s.retryDeadlock(ctx, func() error {
+		result.Updated = 0
+		result.Errors = slices.Clone(parseErrors)
+
+		tx, err := s.primary.BeginTx(ctx, nil)
+		if err != nil {
+			zlog.Error("failed to begin transaction", zap.Error(err))
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, row := range rows {
+			ids, err := statementIDs(ctx, tx, s.dialect, row.queueNumber)
+			if err != nil {
+				zlog.Error("failed to get statement ids", zap.Int("row", row.row), zap.Error(err))
+				return err
+			}
+			if len(ids) != 1 {
+				message := "statement not found"
+				if len(ids) > 1 {
+					message = "queueNumber matches more than one statement"
+				}
+				result.Errors = append(result.Errors, &ImportRowError{
+					Row:         row.row,
+					QueueNumber: row.queueNumber,
+					Message:     message,
+				})
+				continue
+			}
+
+			state := emailState{status: row.status, message: row.message}
+			if err := setEmailState(ctx, tx, s.dialect, s.table, ids[0], state); err != nil {
+				zlog.Error("failed to update email status", zap.Int("row", row.row), zap.Error(err))
+				return err
+			}
+			result.Updated++
+		}
+
+		if err := tx.Commit(); err != nil {
+			zlog.Error("failed to commit transaction", zap.Error(err))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.exports.purge()
+
+	result.Failed = len(result.Errors)
+	zlog.Info("email status imported",
+		zap.Int("updated", result.Updated),
+		zap.Int("failed", result.Failed),
+	)
+
+	return result, nil
+}
+
+func validHeader(header []string) bool {
+	if len(header) != len(emailStatusHeader) {
+		return false
+	}
+	for i, h := range header {
+		// Excel may prepend a BOM to the first column.
+		h = strings.TrimPrefix(strings.TrimSpace(h), "\ufeff")
+		if !strings.EqualFold(h, emailStatusHeader[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// txQueryer is implemented by *sql.DB and *sql.Tx.
+type txQueryer interface {
+	execer
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// statementIDs returns the IDs of the statements of the queue number.
+func statementIDs(ctx context.Context, db txQueryer, d Dialect, queueNumber string) ([]string, error) {
+	q, args := d.builder().
+		Select("CUID").
+		From("dbo.vm_customer").
+		Where(sq.Eq{"cusnum": queueNumber}).
+		MustSql()
+
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0, 1)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return ids, nil
+}
+
+// setEmailState updates the email status and message of the statement of the
+// ID in the base table of the statements.
+func setEmailState(ctx context.Context, db execer, d Dialect, table, id string, state emailState) error {
+	var message any
+	if state.message != "" {
+		message = state.message
+	}
+
+	q, args := d.builder().
+		Update(table).
+		Set("emailstatus", state.status).
+		Set("emailmsg", message).
+		Where(sq.Eq{"CUID": id}).
+		MustSql()
+
+	_, err := db.ExecContext(ctx, q, args...)
+	return err
+}
@@ -0,0 +1,98 @@
+package statement
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestImportEmailStatus(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM dbo.vm_customer").WithArgs("Q1").
+		WillReturnRows(sqlmock.NewRows([]string{"CUID"}).AddRow("1"))
+	mock.ExpectExec("UPDATE dbo.tb_customer").WithArgs("SENT", nil, "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM dbo.vm_customer").WithArgs("Q2").
+		WillReturnRows(sqlmock.NewRows([]string{"CUID"}).AddRow("2"))
+	mock.ExpectExec("UPDATE dbo.tb_customer").WithArgs("FAILED", "mailbox full", "2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	file := "queueNumber,status,message\nQ1,SENT,\nQ2,FAILED,mailbox full\n"
+	result, err := s.ImportEmailStatus(adminContext(), strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ImportEmailStatus() error = %v", err)
+	}
+	if result.Updated != 2 || result.Failed != 0 {
+		t.Errorf("ImportEmailStatus() = %+v, want 2 updated", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportEmailStatusBadRows(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM dbo.vm_customer").WithArgs("Q1").
+		WillReturnRows(sqlmock.NewRows([]string{"CUID"}).AddRow("1"))
+	mock.ExpectExec("UPDATE dbo.tb_customer").WithArgs("SENT", nil, "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM dbo.vm_customer").WithArgs("Q9").
+		WillReturnRows(sqlmock.NewRows([]string{"CUID"}))
+	mock.ExpectQuery("FROM dbo.vm_customer").WithArgs("Q5").
+		WillReturnRows(sqlmock.NewRows([]string{"CUID"}).AddRow("5").AddRow("6"))
+	mock.ExpectCommit()
+
+	file := "queueNumber,status,message\nQ1,SENT,\nQ2\n,SENT,\nQ9,SENT,\nQ5,SENT,\n"
+	result, err := s.ImportEmailStatus(adminContext(), strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ImportEmailStatus() error = %v", err)
+	}
+	if result.Updated != 1 || result.Failed != 4 {
+		t.Fatalf("ImportEmailStatus() = %+v, want 1 updated and 4 failed", result)
+	}
+	wantRows := []int{3, 4, 5, 6}
+	for i, e := range result.Errors {
+		if e.Row != wantRows[i] {
+			t.Errorf("Errors[%d].Row = %d, want %d", i, e.Row, wantRows[i])
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportEmailStatusRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		admin bool
+		file  string
+		want  codes.Code
+	}{
+		{"empty file", true, "", codes.InvalidArgument},
+		{"bad header", true, "queue,status\nQ1,SENT\n", codes.InvalidArgument},
+		{"not an admin", false, "queueNumber,status,message\nQ1,SENT,\n", codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{})
+
+			ctx := userContext()
+			if tt.admin {
+				ctx = adminContext()
+			}
+			_, err := s.ImportEmailStatus(ctx, strings.NewReader(tt.file))
+			if code := rpcstatus.Code(err); code != tt.want {
+				t.Errorf("ImportEmailStatus() code = %v, want %v", code, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,479 @@
+package statement
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/10664kls/estatement/internal/auth"
+	"github.com/10664kls/estatement/internal/pager"
+	"go.uber.org/zap"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// ExportJobStatus is the status of an export job.
+type ExportJobStatus string
+
+const (
+	ExportJobPending   ExportJobStatus = "PENDING"
+	ExportJobRunning   ExportJobStatus = "RUNNING"
+	ExportJobSucceeded ExportJobStatus = "SUCCEEDED"
+	ExportJobFailed    ExportJobStatus = "FAILED"
+)
+
+// ExportJob is an export generated in the background.
+type ExportJob struct {
+	ID          string          `json:"id"`
+	Owner       string          `json:"owner"`
+	Filename    string          `json:"filename"`
+	Status      ExportJobStatus `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Size        int64           `json:"size"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+	CompletedAt *time.Time      `json:"completedAt"`
+
+	content []byte
+}
+
+// errExportJobNotFound is a helper function to create an error when
+// the export job is not found or belongs to another user.
+func errExportJobNotFound() error {
+	s, _ := rpcstatus.New(codes.NotFound, "Export job not found.").
+		WithDetails(&edpb.ErrorInfo{
+			Reason: "EXPORT_JOB_NOT_FOUND",
+			Domain: "statement",
+		})
+	return s.Err()
+}
+
+// completed reports whether the job is done, successfully or not.
+func (job *ExportJob) completed() bool {
+	return job.Status == ExportJobSucceeded || job.Status == ExportJobFailed
+}
+
+// jobStore keeps the export jobs and their content in memory. The finished
+// jobs are evicted once older than ttl, and the oldest ones as soon as their
+// content takes more than maxBytes.
+type jobStore struct {
+	ttl      time.Duration
+	maxBytes int64
+	now      func() time.Time
+
+	mu   sync.Mutex
+	jobs map[string]*ExportJob
+
+	// changed holds, for the watched jobs, a channel closed on their next
+	// update.
+	changed map[string]chan struct{}
+}
+
+func newJobStore(ttl time.Duration, maxBytes int64) *jobStore {
+	return &jobStore{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		now:      time.Now,
+		jobs:     make(map[string]*ExportJob),
+		changed:  make(map[string]chan struct{}),
+	}
+}
+
+// evict deletes the finished jobs older than the ttl, then the oldest
+// finished ones until their content fits in maxBytes, st.mu must be held.
+// It runs on every access, so the expired jobs are not seen.
+func (st *jobStore) evict() {
+	cutoff := st.now().Add(-st.ttl)
+
+	finished := make([]*ExportJob, 0)
+	var size int64
+	for id, job := range st.jobs {
+		if job.CompletedAt == nil {
+			continue
+		}
+		if job.CompletedAt.Before(cutoff) {
+			delete(st.jobs, id)
+			st.notify(id)
+			continue
+		}
+		finished = append(finished, job)
+		size += job.Size
+	}
+	if size <= st.maxBytes {
+		return
+	}
+
+	// The newest job is kept whatever its size, so it can be downloaded.
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].CompletedAt.Before(*finished[j].CompletedAt)
+	})
+	for _, job := range finished[:len(finished)-1] {
+		if size <= st.maxBytes {
+			return
+		}
+		delete(st.jobs, job.ID)
+		st.notify(job.ID)
+		size -= job.Size
+	}
+}
+
+// get returns a copy of the job.
+func (st *jobStore) get(id string) (ExportJob, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.evict()
+	job, ok := st.jobs[id]
+	if !ok {
+		return ExportJob{}, false
+	}
+	return *job, true
+}
+
+// watch returns a copy of the job along with a channel closed on its next
+// update or deletion.
+func (st *jobStore) watch(id string) (ExportJob, <-chan struct{}, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.evict()
+	job, ok := st.jobs[id]
+	if !ok {
+		return ExportJob{}, nil, false
+	}
+
+	ch, ok := st.changed[id]
+	if !ok {
+		ch = make(chan struct{})
+		st.changed[id] = ch
+	}
+	return *job, ch, true
+}
+
+// notify wakes up the watchers of the job, st.mu must be held.
+func (st *jobStore) notify(id string) {
+	if ch, ok := st.changed[id]; ok {
+		close(ch)
+		delete(st.changed, id)
+	}
+}
+
+func (st *jobStore) put(job *ExportJob) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.jobs[job.ID] = job
+	st.evict()
+}
+
+// update applies fn to the job under the lock.
+func (st *jobStore) update(id string, fn func(*ExportJob)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if job, ok := st.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = st.now()
+		st.notify(id)
+	}
+	st.evict()
+}
+
+// purge deletes the completed jobs completed before the cutoff and returns
+// how many were deleted.
+func (st *jobStore) purge(cutoff time.Time) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	deleted := 0
+	for id, job := range st.jobs {
+		if job.CompletedAt != nil && job.CompletedAt.Before(cutoff) {
+			delete(st.jobs, id)
+			st.notify(id)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// list returns copies of the jobs matching fn, newest first.
+func (st *jobStore) list(fn func(*ExportJob) bool) []*ExportJob {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.evict()
+	jobs := make([]*ExportJob, 0)
+	for _, job := range st.jobs {
+		if fn(job) {
+			j := *job
+			jobs = append(jobs, &j)
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].ID > jobs[j].ID
+		}
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// CreateExportJob starts generating the export in the background and returns
+// the pending job, its status is polled with GetExportJob. The job is queued
+// while all the export slots are taken.
+func (s *Service) CreateExportJob(ctx context.Context, in *BatchGetStatementReq) (*ExportJob, error) {
+	claims, err := auth.RequireClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	zlog := s.zlog.With(
+		zap.String("method", "CreateExportJob"),
+		zap.String("username", claims.Username),
+		s.queryField(in),
+	)
+
+	zlog.Info("starting to create export job")
+
+	if err := s.ValidateExport(in); err != nil {
+		zlog.Info("export request is not valid", zap.Error(err))
+		return nil, err
+	}
+
+	if _, err := s.exportProfile(in.Profile); err != nil {
+		zlog.Warn("export profile not found")
+		return nil, err
+	}
+	if s.requireBounded && !in.bounded() && !claims.IsAdmin() {
+		zlog.Info("export is not bounded")
+		return nil, errUnboundedExport()
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		zlog.Error("failed to gen export job id", zap.Error(err))
+		return nil, err
+	}
+
+	// The queued jobs are released as the export slots free up, a full
+	// queue means the exports can't keep up.
+	select {
+	case s.exportQueue <- struct{}{}:
+	default:
+		zlog.Warn("export job queue is full")
+		return nil, rpcstatus.Error(codes.ResourceExhausted, "Too many export jobs are waiting, please try again later.")
+	}
+
+	now := time.Now()
+	job := &ExportJob{
+		ID:        hex.EncodeToString(b),
+		Owner:     claims.Username,
+		Filename:  in.ExportFilename(now),
+		Status:    ExportJobPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.jobs.put(job)
+	created := *job
+
+	// The export outlives the request, it keeps the claims of the caller.
+	q := *in
+	go s.runExportJob(context.WithoutCancel(ctx), job.ID, &q)
+
+	return &created, nil
+}
+
+func (s *Service) runExportJob(ctx context.Context, id string, in *BatchGetStatementReq) {
+	zlog := s.zlog.With(
+		zap.String("method", "runExportJob"),
+		zap.String("id", id),
+	)
+
+	// The job stays pending until an export slot is free, rather than fail
+	// while the service is busy. It leaves the queue once it holds a slot.
+	s.exportSlots <- struct{}{}
+	<-s.exportQueue
+	defer func() { <-s.exportSlots }()
+	ctx = context.WithValue(ctx, exportSlotKey{}, true)
+
+	s.jobs.update(id, func(job *ExportJob) {
+		job.Status = ExportJobRunning
+	})
+
+	buf, err := s.GenExcel(ctx, in)
+	now := time.Now()
+	if err != nil {
+		zlog.Error("export job failed", zap.Error(err))
+		s.jobs.update(id, func(job *ExportJob) {
+			job.Status = ExportJobFailed
+			job.Error = rpcstatus.Convert(err).Message()
+			job.CompletedAt = &now
+		})
+		return
+	}
+
+	s.jobs.update(id, func(job *ExportJob) {
+		job.Status = ExportJobSucceeded
+		job.content = buf.Bytes()
+		job.Size = int64(buf.Len())
+		job.CompletedAt = &now
+	})
+}
+
+// GetExportJob returns the export job, only its owner and the admins are
+// allowed to see it.
+func (s *Service) GetExportJob(ctx context.Context, id string) (*ExportJob, error) {
+	job, ok := s.jobs.get(id)
+	if !ok || !canSeeExportJob(ctx, &job) {
+		return nil, errExportJobNotFound()
+	}
+	return &job, nil
+}
+
+// ExportJobContent returns the export job along with the generated workbook.
+// It fails with codes.FailedPrecondition while the job is not succeeded.
+func (s *Service) ExportJobContent(ctx context.Context, id string) (*ExportJob, []byte, error) {
+	job, err := s.GetExportJob(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if job.Status != ExportJobSucceeded {
+		return nil, nil, rpcstatus.Error(codes.FailedPrecondition, "Export job is not completed yet.")
+	}
+	return job, job.content, nil
+}
+
+// WatchExportJob calls fn with the export job, then again on every change of
+// its status, until the job is completed, fn returns an error or ctx is done.
+// Only its owner and the admins are allowed to watch it.
+func (s *Service) WatchExportJob(ctx context.Context, id string, fn func(*ExportJob) error) error {
+	var last ExportJobStatus
+	for {
+		job, changed, ok := s.jobs.watch(id)
+		if !ok || !canSeeExportJob(ctx, &job) {
+			return errExportJobNotFound()
+		}
+
+		if job.Status != last {
+			if err := fn(&job); err != nil {
+				return err
+			}
+			last = job.Status
+		}
+		if job.completed() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func canSeeExportJob(ctx context.Context, job *ExportJob) bool {
+	claims := auth.ClaimsFromContext(ctx)
+	return claims.IsAdmin() || job.Owner == claims.Username
+}
+
+type ListExportJobsReq struct {
+	PageToken string `json:"pageToken" query:"pageToken"`
+	PageSize  uint64 `json:"pageSize" query:"pageSize"`
+}
+
+type ListExportJobsResult struct {
+	ExportJobs    []*ExportJob `json:"exportJobs"`
+	NextPageToken string       `json:"nextPageToken"`
+}
+
+// ListExportJobs returns the export jobs of the current user, newest first.
+// Admins see the jobs of every user.
+func (s *Service) ListExportJobs(ctx context.Context, in *ListExportJobsReq) (*ListExportJobsResult, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "ListExportJobs"),
+		zap.Any("req", in),
+	)
+
+	zlog.Info("starting to list export jobs")
+
+	var cursor *pager.Cursor
+	if in.PageToken != "" {
+		c, err := pager.DecodeCursor(in.PageToken)
+		if err != nil {
+			zlog.Info("invalid page token", zap.Error(err))
+			st, _ := rpcstatus.New(codes.InvalidArgument, "Your request is not valid.").
+				WithDetails(&edpb.BadRequest{
+					FieldViolations: []*edpb.BadRequest_FieldViolation{
+						{
+							Field:       "pageToken",
+							Description: "pageToken must be a valid token",
+						},
+					},
+				})
+			return nil, st.Err()
+		}
+		cursor = c
+	}
+
+	jobs := s.jobs.list(func(job *ExportJob) bool {
+		if !canSeeExportJob(ctx, job) {
+			return false
+		}
+		if cursor == nil {
+			return true
+		}
+		if job.CreatedAt.Equal(cursor.Time) {
+			return job.ID < cursor.ID
+		}
+		return job.CreatedAt.Before(cursor.Time)
+	})
+
+	if size := int(pager.Size(in.PageSize)); len(jobs) > size {
+		jobs = jobs[:size]
+	}
+
+	pageToken := pager.NextPageToken(len(jobs), in.PageSize, func() *pager.Cursor {
+		last := jobs[len(jobs)-1]
+		return &pager.Cursor{
+			ID:   last.ID,
+			Time: last.CreatedAt,
+		}
+	})
+
+	return &ListExportJobsResult{
+		ExportJobs:    jobs,
+		NextPageToken: pageToken,
+	}, nil
+}
+
+// PurgeExpiredExports deletes the export jobs, along with their content,
+// completed more than olderThan ago. The pending and running jobs are kept.
+// Only admins are allowed to purge the exports.
+func (s *Service) PurgeExpiredExports(ctx context.Context, olderThan time.Duration) (deleted int, err error) {
+	claims := auth.ClaimsFromContext(ctx)
+	zlog := s.zlog.With(
+		zap.String("method", "PurgeExpiredExports"),
+		zap.Duration("olderThan", olderThan),
+		zap.String("by", claims.Username),
+	)
+
+	zlog.Info("starting to purge expired exports")
+
+	if !claims.IsAdmin() {
+		zlog.Info("not an admin")
+		return 0, rpcstatus.Error(codes.PermissionDenied, "You are not allowed to purge the exports.")
+	}
+	if olderThan < 0 {
+		return 0, rpcstatus.Error(codes.InvalidArgument, "olderThan must not be negative.")
+	}
+
+	deleted = s.jobs.purge(time.Now().Add(-olderThan))
+	zlog.Info("expired exports purged", zap.Int("deleted", deleted))
+	return deleted, nil
+}
@@ -0,0 +1,249 @@
+package statement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// finishedJob returns a job of the owner completed at the time, with a
+// content of size bytes.
+func finishedJob(id, owner string, at time.Time, size int64) *ExportJob {
+	return &ExportJob{
+		ID:          id,
+		Owner:       owner,
+		Status:      ExportJobSucceeded,
+		Size:        size,
+		CreatedAt:   at,
+		UpdatedAt:   at,
+		CompletedAt: &at,
+		content:     make([]byte, size),
+	}
+}
+
+func TestWatchExportJobTransitions(t *testing.T) {
+	s, _, _ := newTestService(t, Config{})
+	now := time.Now()
+	s.jobs.put(&ExportJob{ID: "J1", Owner: "alice", Status: ExportJobPending, CreatedAt: now, UpdatedAt: now})
+
+	// Each transition is made once the previous one is seen.
+	next := map[ExportJobStatus]func(*ExportJob){
+		ExportJobPending: func(job *ExportJob) { job.Status = ExportJobRunning },
+		ExportJobRunning: func(job *ExportJob) {
+			job.Status = ExportJobSucceeded
+			job.CompletedAt = &now
+		},
+	}
+
+	var seen []ExportJobStatus
+	err := s.WatchExportJob(userContext(), "J1", func(job *ExportJob) error {
+		seen = append(seen, job.Status)
+		if fn, ok := next[job.Status]; ok {
+			go s.jobs.update("J1", fn)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WatchExportJob() error = %v", err)
+	}
+	want := []ExportJobStatus{ExportJobPending, ExportJobRunning, ExportJobSucceeded}
+	if !slices.Equal(seen, want) {
+		t.Errorf("WatchExportJob() saw %v, want %v", seen, want)
+	}
+}
+
+func TestWatchExportJobScopedToOwner(t *testing.T) {
+	s, _, _ := newTestService(t, Config{})
+	s.jobs.put(finishedJob("J1", "bob", time.Now(), 1))
+
+	err := s.WatchExportJob(userContext(), "J1", func(*ExportJob) error {
+		t.Error("WatchExportJob() called fn for the job of another user")
+		return nil
+	})
+	if code := rpcstatus.Code(err); code != codes.NotFound {
+		t.Errorf("WatchExportJob() code = %v, want %v", code, codes.NotFound)
+	}
+}
+
+func TestWatchExportJobCanceled(t *testing.T) {
+	s, _, _ := newTestService(t, Config{})
+	now := time.Now()
+	s.jobs.put(&ExportJob{ID: "J1", Owner: "alice", Status: ExportJobRunning, CreatedAt: now, UpdatedAt: now})
+
+	ctx, cancel := context.WithCancel(userContext())
+	err := s.WatchExportJob(ctx, "J1", func(*ExportJob) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("WatchExportJob() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestListExportJobsScopedToOwner(t *testing.T) {
+	s, _, _ := newTestService(t, Config{})
+	for i := range 5 {
+		owner := "alice"
+		if i%2 == 1 {
+			owner = "bob"
+		}
+		s.jobs.put(finishedJob(fmt.Sprint("J", i), owner, time.Now().Add(time.Duration(i)*time.Second), 1))
+	}
+
+	result, err := s.ListExportJobs(userContext(), &ListExportJobsReq{})
+	if err != nil {
+		t.Fatalf("ListExportJobs() error = %v", err)
+	}
+	for _, job := range result.ExportJobs {
+		if job.Owner != "alice" {
+			t.Errorf("ListExportJobs() returned the job %s of %s", job.ID, job.Owner)
+		}
+	}
+	if len(result.ExportJobs) != 3 {
+		t.Errorf("ListExportJobs() = %d jobs, want 3", len(result.ExportJobs))
+	}
+
+	all, err := s.ListExportJobs(adminContext(), &ListExportJobsReq{})
+	if err != nil {
+		t.Fatalf("ListExportJobs(admin) error = %v", err)
+	}
+	if len(all.ExportJobs) != 5 {
+		t.Errorf("ListExportJobs(admin) = %d jobs, want 5", len(all.ExportJobs))
+	}
+}
+
+func TestListExportJobsPages(t *testing.T) {
+	s, _, _ := newTestService(t, Config{})
+	now := time.Now()
+	for i := range 5 {
+		s.jobs.put(finishedJob(fmt.Sprint("J", i), "alice", now.Add(time.Duration(i)*time.Second), 1))
+	}
+
+	seen := make([]string, 0)
+	req := &ListExportJobsReq{PageSize: 2}
+	for range 3 {
+		result, err := s.ListExportJobs(userContext(), req)
+		if err != nil {
+			t.Fatalf("ListExportJobs() error = %v", err)
+		}
+		for _, job := range result.ExportJobs {
+			seen = append(seen, job.ID)
+		}
+		if result.NextPageToken == "" {
+			break
+		}
+		req.PageToken = result.NextPageToken
+	}
+
+	want := []string{"J4", "J3", "J2", "J1", "J0"}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("ListExportJobs() pages = %v, want %v", seen, want)
+	}
+}
+
+func TestJobStoreEvictsExpiredJobs(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := newJobStore(time.Hour, 1<<20)
+	st.now = func() time.Time { return now }
+
+	st.put(finishedJob("old", "alice", now.Add(-2*time.Hour), 1))
+	st.put(finishedJob("fresh", "alice", now.Add(-time.Minute), 1))
+	st.put(&ExportJob{ID: "running", Owner: "alice", Status: ExportJobRunning, CreatedAt: now.Add(-3 * time.Hour)})
+
+	if _, ok := st.get("old"); ok {
+		t.Error("get(old) found, want it evicted past the ttl")
+	}
+	for _, id := range []string{"fresh", "running"} {
+		if _, ok := st.get(id); !ok {
+			t.Errorf("get(%s) not found, want it kept", id)
+		}
+	}
+}
+
+func TestJobStoreEvictsOldestOverMaxBytes(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := newJobStore(time.Hour, 100)
+	st.now = func() time.Time { return now }
+
+	st.put(finishedJob("J1", "alice", now.Add(-3*time.Minute), 60))
+	st.put(finishedJob("J2", "alice", now.Add(-2*time.Minute), 30))
+	st.put(finishedJob("J3", "alice", now.Add(-time.Minute), 50))
+
+	if _, ok := st.get("J1"); ok {
+		t.Error("get(J1) found, want the oldest evicted over maxBytes")
+	}
+	for _, id := range []string{"J2", "J3"} {
+		if _, ok := st.get(id); !ok {
+			t.Errorf("get(%s) not found, want it kept", id)
+		}
+	}
+
+	// The newest job is kept even when it's bigger than maxBytes alone.
+	st.put(finishedJob("big", "alice", now, 500))
+	if _, ok := st.get("big"); !ok {
+		t.Error("get(big) not found, want the newest job kept")
+	}
+}
+
+func TestExportJobQueuedWhileSlotsBusy(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{MaxConcurrentExports: 1})
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	// Another export holds the only slot.
+	s.exportSlots <- struct{}{}
+
+	ctx := userContext()
+	job, err := s.CreateExportJob(ctx, &BatchGetStatementReq{})
+	if err != nil {
+		t.Fatalf("CreateExportJob() error = %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if got, _ := s.GetExportJob(ctx, job.ID); got.Status != ExportJobPending {
+		t.Fatalf("GetExportJob() status = %s, want %s while the slots are busy", got.Status, ExportJobPending)
+	}
+
+	<-s.exportSlots
+	err = s.WatchExportJob(ctx, job.ID, func(*ExportJob) error { return nil })
+	if err != nil {
+		t.Fatalf("WatchExportJob() error = %v", err)
+	}
+	if got, _ := s.GetExportJob(ctx, job.ID); got.Status != ExportJobSucceeded {
+		t.Errorf("GetExportJob() = %s (%s), want %s once a slot is free", got.Status, got.Error, ExportJobSucceeded)
+	}
+}
+
+func TestExportJobQueueFull(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{MaxConcurrentExports: 1, MaxQueuedExportJobs: 1})
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	// Another export holds the only slot.
+	s.exportSlots <- struct{}{}
+
+	ctx := userContext()
+	queued, err := s.CreateExportJob(ctx, &BatchGetStatementReq{})
+	if err != nil {
+		t.Fatalf("CreateExportJob() error = %v", err)
+	}
+	_, err = s.CreateExportJob(ctx, &BatchGetStatementReq{})
+	if code := rpcstatus.Code(err); code != codes.ResourceExhausted {
+		t.Errorf("CreateExportJob() with a full queue code = %v, want %v", code, codes.ResourceExhausted)
+	}
+
+	<-s.exportSlots
+	if err := s.WatchExportJob(ctx, queued.ID, func(*ExportJob) error { return nil }); err != nil {
+		t.Fatalf("WatchExportJob() error = %v", err)
+	}
+	if _, err := s.CreateExportJob(ctx, &BatchGetStatementReq{}); err != nil {
+		t.Errorf("CreateExportJob() once the queue drained error = %v", err)
+	}
+}
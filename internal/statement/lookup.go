@@ -0,0 +1,181 @@
+package statement
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	lookupProductNames = "productNames"
+	lookupOccupations  = "occupations"
+	lookupTerms        = "terms"
+	lookupBanks        = "banks"
+	lookupStatuses     = "statuses"
+)
+
+// lookupLoaders load the values of each lookup from the database.
+var lookupLoaders = map[string]func(context.Context, queryer, Dialect) ([]string, error){
+	lookupProductNames: listProductNames,
+	lookupOccupations:  listOccupations,
+	lookupTerms:        listTerms,
+	lookupBanks:        listBanks,
+	lookupStatuses:     listStatuses,
+}
+
+type lookupEntry struct {
+	values   []string
+	loadedAt time.Time
+}
+
+// lookupCache caches the lookups for ttl. The concurrent loads of the same
+// lookup are collapsed into a single query.
+type lookupCache struct {
+	ttl   time.Duration
+	now   func() time.Time
+	group singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]lookupEntry
+}
+
+func newLookupCache(ttl time.Duration) *lookupCache {
+	return &lookupCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]lookupEntry),
+	}
+}
+
+// get returns the cached values of the lookup, loading them when missing
+// or expired. Nothing is cached when the ttl is not positive.
+func (c *lookupCache) get(ctx context.Context, key string, load func(context.Context) ([]string, error)) ([]string, error) {
+	if c.ttl > 0 {
+		c.mu.Lock()
+		e, ok := c.entries[key]
+		c.mu.Unlock()
+		if ok && c.now().Sub(e.loadedAt) < c.ttl {
+			return slices.Clone(e.values), nil
+		}
+	}
+
+	values, err := c.refresh(ctx, key, load)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(values), nil
+}
+
+// lookupLoadTimeout bounds the shared load of a lookup.
+const lookupLoadTimeout = 30 * time.Second
+
+// refresh loads the values of the lookup and caches them.
+// The load is shared by the concurrent callers, so it's not cancelled with
+// the caller starting it, only bounded by lookupLoadTimeout. Each caller
+// still returns as soon as its own context is done.
+func (c *lookupCache) refresh(ctx context.Context, key string, load func(context.Context) ([]string, error)) ([]string, error) {
+	ch := c.group.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lookupLoadTimeout)
+		defer cancel()
+
+		values, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.entries[key] = lookupEntry{values: values, loadedAt: c.now()}
+		c.mu.Unlock()
+		return values, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.([]string), nil
+	}
+}
+
+// lookup returns the values of the lookup through the cache.
+func (s *Service) lookup(ctx context.Context, key string) ([]string, error) {
+	return s.lookups.get(ctx, key, func(ctx context.Context) ([]string, error) {
+		return lookupLoaders[key](ctx, s.db, s.dialect)
+	})
+}
+
+// Filters are the values the filters of the statements can take.
+type Filters struct {
+	ProductNames []string `json:"productNames"`
+	Occupations  []string `json:"occupations"`
+	Terms        []string `json:"terms"`
+	Banks        []string `json:"banks"`
+	Statuses     []string `json:"statuses"`
+	Genders      []Gender `json:"genders"`
+}
+
+// ListFilters returns the values of every filter of the statements at once,
+// through the lookup cache.
+func (s *Service) ListFilters(ctx context.Context) (*Filters, error) {
+	zlog := s.zlog.With(zap.Any("method", "ListFilters"))
+	ctx = withOperation(ctx, "ListFilters")
+
+	zlog.Info("starting to list filters")
+
+	f := &Filters{
+		Genders: []Gender{GenderMale, GenderFemale},
+	}
+
+	g, gctx := s.queryGroup(ctx)
+	for key, dst := range map[string]*[]string{
+		lookupProductNames: &f.ProductNames,
+		lookupOccupations:  &f.Occupations,
+		lookupTerms:        &f.Terms,
+		lookupBanks:        &f.Banks,
+		lookupStatuses:     &f.Statuses,
+	} {
+		g.Go(func() error {
+			values, err := s.lookup(gctx, key)
+			if err != nil {
+				zlog.Error("failed to list lookup", zap.String("lookup", key), zap.Error(err))
+				return err
+			}
+			*dst = values
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// refreshLookups reloads every lookup on each tick, so the requests
+// following the expiry of the cache don't pay for the queries.
+// It returns when ctx is done.
+func (s *Service) refreshLookups(ctx context.Context, ticks <-chan time.Time) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+		}
+
+		for key, load := range lookupLoaders {
+			_, err := s.lookups.refresh(ctx, key, func(ctx context.Context) ([]string, error) {
+				return load(ctx, s.db, s.dialect)
+			})
+			if err != nil {
+				s.zlog.Warn("failed to refresh lookup", zap.String("lookup", key), zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package statement
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestListsMarshalEmpty(t *testing.T) {
+	tests := []struct {
+		name string
+		list func(s *Service) (any, error)
+	}{
+		{"ListProductNames", func(s *Service) (any, error) { return s.ListProductNames(context.Background()) }},
+		{"ListOccupations", func(s *Service) (any, error) { return s.ListOccupations(context.Background(), "") }},
+		{"ListOccupationsByGender", func(s *Service) (any, error) { return s.ListOccupations(context.Background(), "female") }},
+		{"ListTerms", func(s *Service) (any, error) { return s.ListTerms(context.Background()) }},
+		{"ListStatements", func(s *Service) (any, error) {
+			result, err := s.ListStatements(adminContext(), &StatementQuery{})
+			if err != nil {
+				return nil, err
+			}
+			return result.Statements, nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{})
+			mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(sqlmock.NewRows([]string{"value"}))
+
+			list, err := tt.list(s)
+			if err != nil {
+				t.Fatalf("%s() error = %v", tt.name, err)
+			}
+			b, err := json.Marshal(list)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(b) != "[]" {
+				t.Errorf("%s() = %s, want []", tt.name, b)
+			}
+		})
+	}
+}
+
+func TestListOccupations(t *testing.T) {
+	tests := []struct {
+		name   string
+		gender string
+		rows   *sqlmock.Rows
+		where  bool
+		want   []string
+	}{
+		{
+			name:  "unfiltered",
+			rows:  sqlmock.NewRows([]string{"occupation"}).AddRow("Teacher").AddRow(nil).AddRow("Nurse"),
+			where: false,
+			want:  []string{"Teacher", "Nurse"},
+		},
+		{
+			name:   "by gender",
+			gender: "F",
+			rows:   sqlmock.NewRows([]string{"occupation"}).AddRow("Nurse"),
+			where:  true,
+			want:   []string{"Nurse"},
+		},
+		{
+			name:   "empty",
+			gender: "M",
+			rows:   sqlmock.NewRows([]string{"occupation"}),
+			where:  true,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, log := newTestService(t, Config{})
+			mock.ExpectQuery("SELECT occupation FROM dbo.vm_customer").WillReturnRows(tt.rows)
+
+			got, err := s.ListOccupations(context.Background(), tt.gender)
+			if err != nil {
+				t.Fatalf("ListOccupations() error = %v", err)
+			}
+			if got == nil || !slices.Equal(got, tt.want) {
+				t.Errorf("ListOccupations() = %#v, want %#v", got, tt.want)
+			}
+
+			q := log.all()[0]
+			if where := whereClause(q); (where != "") != tt.where {
+				t.Errorf("query = %q, want a WHERE clause %v", q, tt.where)
+			}
+			if tt.where && !strings.Contains(q, "gender IN (") {
+				t.Errorf("query = %q, want the gender predicate", q)
+			}
+		})
+	}
+}
+
+func TestListOccupationsInvalidGender(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+
+	_, err := s.ListOccupations(context.Background(), strings.Repeat("x", maxGenderLen+1))
+	if code := rpcstatus.Code(err); code != codes.InvalidArgument {
+		t.Fatalf("ListOccupations() code = %v, want %v", code, codes.InvalidArgument)
+	}
+	if fields := violationFields(err); !slices.Equal(fields, []string{"gender"}) {
+		t.Errorf("ListOccupations() violations = %v, want [gender]", fields)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{LookupCacheTTL: time.Minute})
+	mock.MatchExpectationsInOrder(false)
+	// Each lookup is expected once, the second call is served by the cache.
+	for column, value := range map[string]string{
+		"productnames":  "LOAN",
+		"occupation":    "Teacher",
+		"term":          "12",
+		"bankname":      "BCEL",
+		"statusBanking": "PENDING",
+	} {
+		mock.ExpectQuery("SELECT " + column + " FROM dbo.vm_customer").
+			WillReturnRows(sqlmock.NewRows([]string{column}).AddRow(value))
+	}
+
+	want := &Filters{
+		ProductNames: []string{"LOAN"},
+		Occupations:  []string{"Teacher"},
+		Terms:        []string{"12"},
+		Banks:        []string{"BCEL"},
+		Statuses:     []string{"PENDING"},
+		Genders:      []Gender{GenderMale, GenderFemale},
+	}
+	for range 2 {
+		f, err := s.ListFilters(context.Background())
+		if err != nil {
+			t.Fatalf("ListFilters() error = %v", err)
+		}
+		if fmt.Sprint(f) != fmt.Sprint(want) {
+			t.Errorf("ListFilters() = %+v, want %+v", f, want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListFiltersMarshalEmpty(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.MatchExpectationsInOrder(false)
+	for range lookupLoaders {
+		mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(sqlmock.NewRows([]string{"value"}))
+	}
+
+	f, err := s.ListFilters(context.Background())
+	if err != nil {
+		t.Fatalf("ListFilters() error = %v", err)
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if bytes.Contains(b, []byte("null")) {
+		t.Errorf("ListFilters() = %s, want [] for the empty lookups", b)
+	}
+}
+
+func TestLookupCacheCachesForTTL(t *testing.T) {
+	c := newLookupCache(time.Minute)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	var loads atomic.Int32
+	load := func(context.Context) ([]string, error) {
+		loads.Add(1)
+		return []string{"LOAN"}, nil
+	}
+
+	for range 2 {
+		if _, err := c.get(context.Background(), lookupProductNames, load); err != nil {
+			t.Fatalf("get() error = %v", err)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("loads = %d, want 1 within the ttl", n)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := c.get(context.Background(), lookupProductNames, load); err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	if n := loads.Load(); n != 2 {
+		t.Errorf("loads = %d, want 2 once expired", n)
+	}
+}
+
+func TestLookupCacheSharedLoadOutlivesCaller(t *testing.T) {
+	c := newLookupCache(time.Minute)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	load := func(ctx context.Context) ([]string, error) {
+		once.Do(func() { close(started) })
+		select {
+		case <-release:
+			return []string{"LOAN"}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := c.get(first, lookupProductNames, load)
+		firstErr <- err
+	}()
+	<-started
+
+	second := make(chan []string, 1)
+	go func() {
+		values, _ := c.get(context.Background(), lookupProductNames, load)
+		second <- values
+	}()
+
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("get(cancelled) error = %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	if values := <-second; !slices.Equal(values, []string{"LOAN"}) {
+		t.Errorf("get() = %v, want the load shared with the cancelled caller", values)
+	}
+}
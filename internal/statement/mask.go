@@ -0,0 +1,58 @@
+package statement
+
+import (
+	"context"
+	"strings"
+
+	"github.com/10664kls/estatement/internal/auth"
+)
+
+// MaskPolicy defines which personal details of the statements are masked.
+type MaskPolicy struct {
+	// AccountNumber masks the bank account number but its last 4 characters.
+	AccountNumber bool
+
+	// CustomerName masks the customer name but its first character.
+	CustomerName bool
+}
+
+// union returns the policy masking what either p or o masks.
+func (p MaskPolicy) union(o MaskPolicy) MaskPolicy {
+	return MaskPolicy{
+		AccountNumber: p.AccountNumber || o.AccountNumber,
+		CustomerName:  p.CustomerName || o.CustomerName,
+	}
+}
+
+// apply masks the details of the statement according to the policy.
+func (p MaskPolicy) apply(s *Statement) {
+	if p.AccountNumber {
+		s.BankAccount.Number = maskKeepLast(s.BankAccount.Number, 4)
+	}
+	if p.CustomerName {
+		s.Customer.DisplayName = maskKeepFirst(s.Customer.DisplayName, 1)
+	}
+}
+
+// maskPolicy returns the masking policy of the current user's role.
+func (s *Service) maskPolicy(ctx context.Context) MaskPolicy {
+	return s.masks[auth.ClaimsFromContext(ctx).Role]
+}
+
+// maskKeepLast replaces every character of v but the last n ones with '*'.
+func maskKeepLast(v string, n int) string {
+	r := []rune(v)
+	if len(r) <= n {
+		return strings.Repeat("*", len(r))
+	}
+	return strings.Repeat("*", len(r)-n) + string(r[len(r)-n:])
+}
+
+// maskKeepFirst replaces every character of v but the first n ones with '*'.
+func maskKeepFirst(v string, n int) string {
+	r := []rune(v)
+	if len(r) <= n {
+		return strings.Repeat("*", len(r))
+	}
+	return string(r[:n]) + strings.Repeat("*", len(r)-n)
+}
@@ -0,0 +1,86 @@
+package statement
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestMaskKeep(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{maskKeepLast("0100200300", 4), "******0300"},
+		{maskKeepLast("123", 4), "***"},
+		{maskKeepFirst("Customer", 1), "C*******"},
+		{maskKeepFirst("ສົມໃຈ", 1), "ສ****"},
+		{maskKeepFirst("", 1), ""},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("mask = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestListStatementsMaskPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		wantAccount string
+		wantName    string
+	}{
+		{name: "restricted", ctx: userContext(), wantAccount: "*******3001", wantName: "C*********"},
+		{name: "privileged", ctx: adminContext(), wantAccount: "01002003001", wantName: "Customer 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{
+				MaskPolicies: map[string]MaskPolicy{
+					"user": {AccountNumber: true, CustomerName: true},
+				},
+			})
+			mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+
+			result, err := s.ListStatements(tt.ctx, &StatementQuery{})
+			if err != nil {
+				t.Fatalf("ListStatements() error = %v", err)
+			}
+			st := result.Statements[0]
+			if st.BankAccount.Number != tt.wantAccount || st.Customer.DisplayName != tt.wantName {
+				t.Errorf("ListStatements() = %q %q, want %q %q", st.BankAccount.Number, st.Customer.DisplayName, tt.wantAccount, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestGenExcelMaskPolicy(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{
+		MaskPolicies: map[string]MaskPolicy{"user": {AccountNumber: true}},
+		ExportProfiles: []ExportProfile{{
+			Name:    "accounts",
+			Columns: []ExportColumn{{Key: "CUID"}, {Key: "AccNo"}},
+		}},
+	})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	buf, err := s.GenExcel(userContext(), &BatchGetStatementReq{Profile: "accounts"})
+	if err != nil {
+		t.Fatalf("GenExcel() error = %v", err)
+	}
+	fx, err := excelize.OpenReader(buf)
+	if err != nil {
+		t.Fatalf("excelize.OpenReader() error = %v", err)
+	}
+	defer fx.Close()
+
+	got, err := fx.GetCellValue("Statement Requests", "B2")
+	if err != nil {
+		t.Fatalf("GetCellValue() error = %v", err)
+	}
+	if got != "*******3001" {
+		t.Errorf("GenExcel() AccNo = %q, want it masked", got)
+	}
+}
@@ -0,0 +1,40 @@
+package statement
+
+import "strings"
+
+// Normalize trims the string filters of the query, so the values pasted
+// with surrounding spaces still match. The inner spaces are kept, the values
+// of the database may have runs of them.
+func (q *StatementQuery) Normalize() {
+	q.Gender = q.Gender.Canonical()
+	q.Status = strings.TrimSpace(q.Status)
+	q.Occupation = strings.TrimSpace(q.Occupation)
+	q.QueueNumber = strings.Join(q.queueNumbers(), ",")
+	q.ProductName = strings.TrimSpace(q.ProductName)
+	q.BankCode = strings.TrimSpace(q.BankCode)
+	q.CreatedBy = strings.TrimSpace(q.CreatedBy)
+	q.Term = strings.TrimSpace(q.Term)
+	q.BankInfo = strings.TrimSpace(q.BankInfo)
+	q.PageToken = strings.TrimSpace(q.PageToken)
+	q.Month = strings.TrimSpace(q.Month)
+	q.Timezone = strings.TrimSpace(q.Timezone)
+	q.Sort = strings.TrimSpace(q.Sort)
+	q.Nulls = strings.TrimSpace(q.Nulls)
+}
+
+// Normalize trims the string filters of the request like
+// StatementQuery.Normalize.
+func (q *BatchGetStatementReq) Normalize() {
+	q.Gender = q.Gender.Canonical()
+	q.Status = strings.TrimSpace(q.Status)
+	q.Occupation = strings.TrimSpace(q.Occupation)
+	q.QueueNumber = strings.TrimSpace(q.QueueNumber)
+	q.ProductName = strings.TrimSpace(q.ProductName)
+	q.BankCode = strings.TrimSpace(q.BankCode)
+	q.CreatedBy = strings.TrimSpace(q.CreatedBy)
+	q.Term = strings.TrimSpace(q.Term)
+	q.Profile = strings.TrimSpace(q.Profile)
+	q.Month = strings.TrimSpace(q.Month)
+	q.Timezone = strings.TrimSpace(q.Timezone)
+	q.Delimiter = strings.TrimSpace(q.Delimiter)
+}
@@ -0,0 +1,52 @@
+package statement
+
+import "testing"
+
+func TestStatementQueryNormalize(t *testing.T) {
+	q := &StatementQuery{
+		QueueNumber: " 123 ",
+		ProductName: "  Car  Loan ",
+		Occupation:  "\tTeacher  Assistant\n",
+		BankCode:    " BCEL ",
+	}
+	q.Normalize()
+
+	if q.QueueNumber != "123" {
+		t.Errorf("QueueNumber = %q, want %q", q.QueueNumber, "123")
+	}
+	// The inner spaces are kept so they still match the database values.
+	if q.ProductName != "Car  Loan" {
+		t.Errorf("ProductName = %q, want %q", q.ProductName, "Car  Loan")
+	}
+	if q.Occupation != "Teacher  Assistant" {
+		t.Errorf("Occupation = %q, want %q", q.Occupation, "Teacher  Assistant")
+	}
+	if q.BankCode != "BCEL" {
+		t.Errorf("BankCode = %q, want %q", q.BankCode, "BCEL")
+	}
+}
+
+func TestPaddedQueueNumberMatches(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WithArgs("123", "DEL").WillReturnRows(statementRows("1"))
+
+	result, err := s.ListStatements(userContext(), &StatementQuery{QueueNumber: " 123 "})
+	if err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+	if len(result.Statements) != 1 {
+		t.Errorf("ListStatements() = %d statements, want 1", len(result.Statements))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatchGetStatementReqNormalize(t *testing.T) {
+	q := &BatchGetStatementReq{ProductName: " Car  Loan ", Profile: " full "}
+	q.Normalize()
+
+	if q.ProductName != "Car  Loan" || q.Profile != "full" {
+		t.Errorf("Normalize() = %q, %q, want only trimmed", q.ProductName, q.Profile)
+	}
+}
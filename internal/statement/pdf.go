@@ -0,0 +1,167 @@
+package statement
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The A4 page of the statement PDF, in points.
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 50
+)
+
+// pdfMaxValueLen is the length the values are cut to, so they fit on a line.
+const pdfMaxValueLen = 60
+
+// GenStatementPDF renders the details of the statement into a one page PDF.
+// The PDF uses the standard Helvetica font, so the characters outside of
+// Latin-1, e.g. the Lao names, are written as "?".
+func (s *Service) GenStatementPDF(ctx context.Context, id string) (*bytes.Buffer, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "GenStatementPDF"),
+		s.piiField("id", id),
+	)
+
+	zlog.Info("starting to gen statement pdf")
+
+	st, err := s.GetStatementByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return renderStatementPDF(st, time.Now()), nil
+}
+
+// statementPDFRows returns the label and value of each row of the statement
+// PDF.
+func statementPDFRows(st *Statement) [][2]string {
+	str := func(v *string) string {
+		if v == nil || *v == "" {
+			return "-"
+		}
+		return *v
+	}
+	date := func(t *time.Time) string {
+		if t == nil || t.IsZero() {
+			return "-"
+		}
+		return t.Format("02/01/2006 15:04:05")
+	}
+	or := func(v string) string {
+		if v == "" {
+			return "-"
+		}
+		return v
+	}
+
+	return [][2]string{
+		{"Queue number", or(st.QueueNumber)},
+		{"Statement ID", or(st.ID)},
+		{"Status", or(st.Status)},
+		{"Product", or(st.ProductName)},
+		{"Customer name", or(st.Customer.DisplayName)},
+		{"Gender", or(string(st.Customer.Gender.Canonical()))},
+		{"Occupation", or(st.Customer.Occupation)},
+		{"Bank", or(st.BankAccount.Code)},
+		{"Account number", or(st.BankAccount.Number)},
+		{"Term", or(st.BankAccount.Term)},
+		{"Bank status", str(st.BankAccount.Status)},
+		{"Bank information", str(st.BankAccount.Info)},
+		{"Bank created at", date(st.BankAccount.CreatedAt)},
+		{"Email status", str(st.Email.IsSent)},
+		{"Email message", str(st.Email.Message)},
+		{"Created by", or(st.CreatedBy)},
+		{"Created at", date(&st.CreatedAt)},
+	}
+}
+
+// renderStatementPDF writes the one page PDF of the statement, a title
+// followed by a table of its details.
+func renderStatementPDF(st *Statement, now time.Time) *bytes.Buffer {
+	var c strings.Builder
+
+	y := pdfPageHeight - pdfMargin - 18
+	fmt.Fprintf(&c, "BT /F2 20 Tf %d %d Td (%s) Tj ET\n", pdfMargin, y, pdfString("Statement Request"))
+	y -= 22
+	fmt.Fprintf(&c, "BT /F1 11 Tf %d %d Td (%s) Tj ET\n", pdfMargin, y, pdfString("Queue number "+st.QueueNumber))
+	y -= 14
+	fmt.Fprintf(&c, "0.6 G 1 w %d %d m %d %d l S\n", pdfMargin, y, pdfPageWidth-pdfMargin, y)
+
+	y -= 28
+	for i, row := range statementPDFRows(st) {
+		if i%2 == 0 {
+			fmt.Fprintf(&c, "0.95 g %d %d %d 20 re f 0 g\n", pdfMargin, y-6, pdfPageWidth-2*pdfMargin)
+		}
+		fmt.Fprintf(&c, "BT /F2 10 Tf %d %d Td (%s) Tj ET\n", pdfMargin+8, y, pdfString(row[0]))
+		fmt.Fprintf(&c, "BT /F1 10 Tf %d %d Td (%s) Tj ET\n", pdfMargin+150, y, pdfString(row[1]))
+		y -= 20
+	}
+
+	fmt.Fprintf(&c, "0.6 G 1 w %d %d m %d %d l S\n", pdfMargin, pdfMargin+16, pdfPageWidth-pdfMargin, pdfMargin+16)
+	fmt.Fprintf(&c, "BT /F1 8 Tf 0.4 g %d %d Td (%s) Tj ET\n", pdfMargin, pdfMargin,
+		pdfString("Generated on "+now.Format("02/01/2006 15:04:05")))
+
+	content := c.String()
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", pdfPageWidth, pdfPageHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf
+}
+
+// pdfString escapes s for a PDF literal string in WinAnsiEncoding. It's cut
+// to pdfMaxValueLen characters, and the characters outside of Latin-1 are
+// replaced by "?".
+func pdfString(s string) string {
+	r := []rune(s)
+	if len(r) > pdfMaxValueLen {
+		r = append(r[:pdfMaxValueLen-3], []rune("...")...)
+	}
+
+	var b strings.Builder
+	for _, c := range r {
+		switch {
+		case c == '\\' || c == '(' || c == ')':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c < 0x20:
+			b.WriteByte(' ')
+		case c < 0x80:
+			b.WriteRune(c)
+		case c >= 0xa0 && c <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
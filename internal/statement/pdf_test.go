@@ -0,0 +1,43 @@
+package statement
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestGenStatementPDF(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+
+	buf, err := s.GenStatementPDF(adminContext(), "Q1")
+	if err != nil {
+		t.Fatalf("GenStatementPDF() error = %v", err)
+	}
+	b := buf.Bytes()
+	if !bytes.HasPrefix(b, []byte("%PDF-")) {
+		t.Errorf("GenStatementPDF() starts with %q, want %%PDF-", b[:min(len(b), 8)])
+	}
+	if !bytes.Contains(b, []byte("%%EOF")) {
+		t.Error("GenStatementPDF() has no end of file marker")
+	}
+	if !bytes.Contains(b, []byte("Customer 1")) {
+		t.Error("GenStatementPDF() does not render the customer")
+	}
+}
+
+func TestGenStatementPDFNotFound(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	_, err := s.GenStatementPDF(adminContext(), "Q1")
+	if !errors.Is(err, ErrStatementNotFound) {
+		t.Errorf("GenStatementPDF() error = %v, want %v", err, ErrStatementNotFound)
+	}
+	if code := rpcstatus.Code(err); code != codes.NotFound {
+		t.Errorf("GenStatementPDF() code = %v, want %v", code, codes.NotFound)
+	}
+}
@@ -0,0 +1,262 @@
+package statement
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/10664kls/estatement/internal/auth"
+	sq "github.com/Masterminds/squirrel"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// EmailDelivery is the delivery status of the email of a statement at the
+// email provider.
+type EmailDelivery struct {
+	QueueNumber string
+
+	// Status is the emailstatus value of the delivery, e.g. "SENT" or
+	// "FAILED", and Message its details, e.g. the bounce reason.
+	Status  string
+	Message string
+}
+
+// EmailDeliveryProvider fetches the delivery statuses of the statement emails
+// from the email provider.
+type EmailDeliveryProvider interface {
+	// EmailDeliveries returns a page of the delivery statuses of the emails of
+	// the queue numbers, and the token of the next page, empty on the last
+	// one. The queue numbers the provider doesn't know are left out.
+	EmailDeliveries(ctx context.Context, queueNumbers []string, pageToken string) ([]*EmailDelivery, string, error)
+}
+
+// reconcileBatchSize is how many queue numbers are sent to the provider at
+// once.
+const reconcileBatchSize = 100
+
+type ReconcileEmailStatusReq struct {
+	// Since is the oldest email reconciled, by the time it was sent when the
+	// view has an email sent time column, by the creation of the statement
+	// otherwise.
+	// Optional. Default value is 24 hours ago.
+	Since time.Time `json:"since" query:"since"`
+}
+
+type ReconcileEmailStatusResult struct {
+	// Checked is the number of the sent emails looked up at the provider.
+	Checked int `json:"checked"`
+
+	// Updated is the number of the statements whose status changed, and
+	// Unchanged the number of those already up to date.
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+
+	// Missing is the number of the emails the provider doesn't know, they
+	// are left as they are.
+	Missing int `json:"missing"`
+
+	// Failed is the number of the emails which could not be reconciled,
+	// Errors tells why.
+	Failed int                  `json:"failed"`
+	Errors []*ReconcileRowError `json:"errors"`
+}
+
+type ReconcileRowError struct {
+	QueueNumber string `json:"queueNumber"`
+	Message     string `json:"message"`
+}
+
+// emailState is the email status and message of a statement.
+type emailState struct {
+	status  string
+	message string
+}
+
+// sentEmail is the email state of a statement whose email was sent.
+type sentEmail struct {
+	id    string
+	state emailState
+}
+
+// ReconcileEmailStatus updates the email status and message of the recently
+// sent statements from the delivery statuses of the EmailDeliveryProvider.
+// The emails are looked up in batches, a failing batch is reported and
+// skipped, the others are still reconciled. Only admins are allowed to
+// reconcile the email status.
+func (s *Service) ReconcileEmailStatus(ctx context.Context, req *ReconcileEmailStatusReq) (*ReconcileEmailStatusResult, error) {
+	claims := auth.ClaimsFromContext(ctx)
+	zlog := s.zlog.With(
+		zap.String("method", "ReconcileEmailStatus"),
+		zap.Time("since", req.Since),
+		zap.String("by", claims.Username),
+	)
+	ctx = withOperation(ctx, "ReconcileEmailStatus")
+
+	zlog.Info("starting to reconcile email status")
+
+	if !claims.IsAdmin() {
+		zlog.Info("not an admin")
+		return nil, rpcstatus.Error(codes.PermissionDenied, "You are not allowed to reconcile the email status.")
+	}
+
+	if s.emailDelivery == nil {
+		zlog.Info("no email delivery provider")
+		return nil, rpcstatus.Error(codes.Unimplemented, "Email status reconciliation is not available.")
+	}
+
+	since := req.Since
+	if since.IsZero() {
+		since = time.Now().Add(-24 * time.Hour)
+	}
+
+	// The emails are read from the primary they're updated on.
+	sent, err := listSentEmails(ctx, s.primary, s.dialect, s.emailSent, s.emailSentAt, since)
+	if err != nil {
+		zlog.Error("failed to list sent emails", zap.Error(err))
+		return nil, err
+	}
+
+	result := &ReconcileEmailStatusResult{
+		Errors: make([]*ReconcileRowError, 0),
+	}
+	for _, emails := range sent {
+		result.Checked += len(emails)
+	}
+
+	queueNumbers := make([]string, 0, len(sent))
+	for qn := range sent {
+		queueNumbers = append(queueNumbers, qn)
+	}
+	sort.Strings(queueNumbers)
+
+	for start := 0; start < len(queueNumbers); start += reconcileBatchSize {
+		batch := queueNumbers[start:min(start+reconcileBatchSize, len(queueNumbers))]
+
+		deliveries, err := s.emailDeliveries(ctx, batch)
+		if err != nil {
+			zlog.Warn("failed to fetch email deliveries", zap.Int("batch", len(batch)), zap.Error(err))
+			for _, qn := range batch {
+				for range sent[qn] {
+					result.Errors = append(result.Errors, &ReconcileRowError{
+						QueueNumber: qn,
+						Message:     "email provider is not available",
+					})
+				}
+			}
+			continue
+		}
+
+		for _, qn := range batch {
+			d, ok := deliveries[qn]
+			if !ok {
+				result.Missing += len(sent[qn])
+				continue
+			}
+
+			state := emailState{status: d.Status, message: d.Message}
+			for _, email := range sent[qn] {
+				if state == email.state {
+					result.Unchanged++
+					continue
+				}
+
+				err := s.retryDeadlock(ctx, func() error {
+					return setEmailState(ctx, s.primary, s.dialect, s.table, email.id, state)
+				})
+				if err != nil {
+					zlog.Error("failed to update email status", s.piiField("queueNumber", qn), zap.Error(err))
+					result.Errors = append(result.Errors, &ReconcileRowError{
+						QueueNumber: qn,
+						Message:     "failed to update email status",
+					})
+					continue
+				}
+				result.Updated++
+			}
+		}
+	}
+
+	if result.Updated > 0 {
+		s.exports.purge()
+	}
+
+	result.Failed = len(result.Errors)
+	zlog.Info("email status reconciled",
+		zap.Int("checked", result.Checked),
+		zap.Int("updated", result.Updated),
+		zap.Int("missing", result.Missing),
+		zap.Int("failed", result.Failed),
+	)
+
+	return result, nil
+}
+
+// emailDeliveries fetches every page of the delivery statuses of the queue
+// numbers, keyed by queue number.
+func (s *Service) emailDeliveries(ctx context.Context, queueNumbers []string) (map[string]*EmailDelivery, error) {
+	deliveries := make(map[string]*EmailDelivery, len(queueNumbers))
+	var pageToken string
+	for {
+		page, next, err := s.emailDelivery.EmailDeliveries(ctx, queueNumbers, pageToken)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range page {
+			if d.Status == "" {
+				continue
+			}
+			deliveries[d.QueueNumber] = d
+		}
+
+		if next == "" {
+			return deliveries, nil
+		}
+		if next == pageToken {
+			return nil, fmt.Errorf("email provider returned the same page token %q twice", next)
+		}
+		pageToken = next
+	}
+}
+
+// listSentEmails returns the email state of the statements whose email was
+// sent since the given time, keyed by queue number.
+func listSentEmails(ctx context.Context, db *sql.DB, d Dialect, sentStatus, sentAtColumn string, since time.Time) (map[string][]sentEmail, error) {
+	column := "createdate"
+	if sentAtColumn != "" {
+		column = sentAtColumn
+	}
+
+	q, args := d.builder().
+		Select("CUID", "cusnum", "COALESCE(emailmsg, '')").
+		From("dbo.vm_customer").
+		Where(sq.Eq{"emailstatus": sentStatus}).
+		Where(sq.GtOrEq{column: since}).
+		MustSql()
+
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	sent := make(map[string][]sentEmail)
+	for rows.Next() {
+		var id, qn, message string
+		if err := rows.Scan(&id, &qn, &message); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		sent[qn] = append(sent[qn], sentEmail{
+			id:    id,
+			state: emailState{status: sentStatus, message: message},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
+	return sent, nil
+}
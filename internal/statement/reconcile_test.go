@@ -0,0 +1,133 @@
+package statement
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// fakeEmailDelivery is an EmailDeliveryProvider returning its deliveries in
+// pages of pageSize.
+type fakeEmailDelivery struct {
+	deliveries []*EmailDelivery
+	pageSize   int
+	err        error
+}
+
+func (p *fakeEmailDelivery) EmailDeliveries(_ context.Context, queueNumbers []string, pageToken string) ([]*EmailDelivery, string, error) {
+	if p.err != nil {
+		return nil, "", p.err
+	}
+
+	known := make(map[string]bool, len(queueNumbers))
+	for _, qn := range queueNumbers {
+		known[qn] = true
+	}
+	matched := make([]*EmailDelivery, 0)
+	for _, d := range p.deliveries {
+		if known[d.QueueNumber] {
+			matched = append(matched, d)
+		}
+	}
+
+	start := 0
+	if pageToken != "" {
+		start = int(pageToken[0] - '0')
+	}
+	end := min(start+p.pageSize, len(matched))
+	var next string
+	if end < len(matched) {
+		next = string(rune('0' + end))
+	}
+	return matched[start:end], next, nil
+}
+
+func sentEmailRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"CUID", "cusnum", "emailmsg"}).
+		AddRow("1", "Q1", "").
+		AddRow("2", "Q2", "").
+		AddRow("3", "Q3", "")
+}
+
+func TestReconcileEmailStatus(t *testing.T) {
+	provider := &fakeEmailDelivery{
+		pageSize: 2,
+		deliveries: []*EmailDelivery{
+			{QueueNumber: "Q1", Status: "SENT"},
+			{QueueNumber: "Q2", Status: "FAILED", Message: "bounced"},
+			{QueueNumber: "Q3", Status: "FAILED", Message: "mailbox full"},
+		},
+	}
+	s, mock, _ := newTestService(t, Config{EmailDeliveryProvider: provider})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(sentEmailRows())
+	mock.ExpectExec("UPDATE dbo.tb_customer").WithArgs("FAILED", "bounced", "2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE dbo.tb_customer").WithArgs("FAILED", "mailbox full", "3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	result, err := s.ReconcileEmailStatus(adminContext(), &ReconcileEmailStatusReq{})
+	if err != nil {
+		t.Fatalf("ReconcileEmailStatus() error = %v", err)
+	}
+	if result.Checked != 3 || result.Updated != 2 || result.Unchanged != 1 || result.Failed != 0 {
+		t.Errorf("ReconcileEmailStatus() = %+v, want 3 checked, 2 updated and 1 unchanged", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReconcileEmailStatusSubset(t *testing.T) {
+	provider := &fakeEmailDelivery{
+		pageSize:   10,
+		deliveries: []*EmailDelivery{{QueueNumber: "Q2", Status: "FAILED", Message: "bounced"}},
+	}
+	s, mock, _ := newTestService(t, Config{EmailDeliveryProvider: provider})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(sentEmailRows())
+	mock.ExpectExec("UPDATE dbo.tb_customer").WithArgs("FAILED", "bounced", "2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	result, err := s.ReconcileEmailStatus(adminContext(), &ReconcileEmailStatusReq{})
+	if err != nil {
+		t.Fatalf("ReconcileEmailStatus() error = %v", err)
+	}
+	if result.Updated != 1 || result.Missing != 2 {
+		t.Errorf("ReconcileEmailStatus() = %+v, want 1 updated and 2 missing", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReconcileEmailStatusProviderFailure(t *testing.T) {
+	provider := &fakeEmailDelivery{err: errors.New("provider is down")}
+	s, mock, _ := newTestService(t, Config{EmailDeliveryProvider: provider})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(sentEmailRows())
+
+	result, err := s.ReconcileEmailStatus(adminContext(), &ReconcileEmailStatusReq{})
+	if err != nil {
+		t.Fatalf("ReconcileEmailStatus() error = %v", err)
+	}
+	if result.Failed != 3 || result.Updated != 0 {
+		t.Errorf("ReconcileEmailStatus() = %+v, want 3 failed", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReconcileEmailStatusRequiresAdmin(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{EmailDeliveryProvider: &fakeEmailDelivery{}})
+
+	_, err := s.ReconcileEmailStatus(userContext(), &ReconcileEmailStatusReq{})
+	if code := rpcstatus.Code(err); code != codes.PermissionDenied {
+		t.Errorf("ReconcileEmailStatus() code = %v, want %v", code, codes.PermissionDenied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
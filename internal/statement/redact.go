@@ -0,0 +1,72 @@
+package statement
+
+import (
+	"reflect"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// piiFields are the JSON fields of the queries identifying the customers,
+// they're redacted from the logs.
+var piiFields = map[string]bool{
+	"gender":      true,
+	"occupation":  true,
+	"queueNumber": true,
+	"bankInfo":    true,
+}
+
+// redacted replaces the values of the redacted fields in the logs.
+const redacted = "[REDACTED]"
+
+// loggedQuery logs the set fields of a query struct by their JSON name, the
+// PII fields are redacted unless pii is set.
+type loggedQuery struct {
+	query any
+	pii   bool
+}
+
+func (l loggedQuery) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	v := reflect.ValueOf(l.query)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return enc.AddReflected("value", l.query)
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "" || name == "-" || v.Field(i).IsZero() {
+			continue
+		}
+		if piiFields[name] && !l.pii {
+			enc.AddString(name, redacted)
+			continue
+		}
+		if err := enc.AddReflected(name, v.Field(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryField returns the log field of the query, see loggedQuery.
+func (s *Service) queryField(query any) zap.Field {
+	return zap.Object("query", loggedQuery{query: query, pii: s.logPII})
+}
+
+// piiField returns the log field of a value identifying a customer, e.g. a
+// queue number, redacted unless the PII is logged.
+func (s *Service) piiField(key, value string) zap.Field {
+	if !s.logPII {
+		return zap.String(key, redacted)
+	}
+	return zap.String(key, value)
+}
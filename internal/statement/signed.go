@@ -0,0 +1,132 @@
+package statement
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/10664kls/estatement/internal/auth"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// signedExportPath is the route serving the signed exports.
+const signedExportPath = "/v1/statements/export-signed"
+
+// signedExport is the payload of a signed export token. The export is
+// downloaded on behalf of the signer, so the token carries their claims and
+// the columns and masking they were allowed when it was signed.
+type signedExport struct {
+	Query     *BatchGetStatementReq `json:"query"`
+	Claims    *auth.Claims          `json:"claims"`
+	Columns   []ExportColumn        `json:"columns"`
+	Mask      MaskPolicy            `json:"mask"`
+	ExpiresAt time.Time             `json:"expiresAt"`
+}
+
+// exportGrant pins the columns and the masking of an export, rather than
+// derive them from the claims of the context.
+type exportGrant struct {
+	columns []ExportColumn
+	mask    MaskPolicy
+}
+
+// SignedExportURL returns a URL to download the export of the statements
+// matching in without any other credentials, valid for ttl.
+func (s *Service) SignedExportURL(ctx context.Context, in *BatchGetStatementReq, ttl time.Duration) (string, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "SignedExportURL"),
+		s.queryField(in),
+		zap.Duration("ttl", ttl),
+	)
+
+	zlog.Info("starting to sign export url")
+
+	if len(s.signingKey) == 0 {
+		zlog.Warn("signing key is not configured")
+		return "", rpcstatus.Error(codes.Unimplemented, "Signed export links are not enabled.")
+	}
+
+	claims, err := auth.RequireClaims(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	profile, err := s.exportProfile(in.Profile)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(&signedExport{
+		Query:     in,
+		Claims:    claims,
+		Columns:   s.allowedColumns(ctx, profile),
+		Mask:      s.maskPolicy(ctx).union(profile.Mask),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		zlog.Error("failed to marshal signed export", zap.Error(err))
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.sign(payload))
+
+	return signedExportPath + "?token=" + url.QueryEscape(token), nil
+}
+
+// VerifyExportToken verifies the signature and the expiry of a signed export
+// token and returns the query it was signed for and the claims of its signer.
+// The export must be generated with the claims in the context, the query
+// pins the columns and the masking the signer was allowed.
+func (s *Service) VerifyExportToken(_ context.Context, token string) (*BatchGetStatementReq, *auth.Claims, error) {
+	invalid := rpcstatus.Error(codes.PermissionDenied, "The download link is not valid or has expired.")
+
+	if len(s.signingKey) == 0 {
+		return nil, nil, invalid
+	}
+
+	p, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, nil, invalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return nil, nil, invalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, nil, invalid
+	}
+	if !hmac.Equal(mac, s.sign(payload)) {
+		s.zlog.Warn("signed export token has been tampered")
+		return nil, nil, invalid
+	}
+
+	var e signedExport
+	if err := json.Unmarshal(payload, &e); err != nil || e.Query == nil || e.Claims == nil || len(e.Columns) == 0 {
+		return nil, nil, invalid
+	}
+	if time.Now().After(e.ExpiresAt) {
+		return nil, nil, invalid
+	}
+
+	e.Query.grant = &exportGrant{
+		columns: e.Columns,
+		mask:    e.Mask,
+	}
+	return e.Query, e.Claims, nil
+}
+
+func (s *Service) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, s.signingKey)
+	h.Write(payload)
+	return h.Sum(nil)
+}
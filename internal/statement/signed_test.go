@@ -0,0 +1,137 @@
+package statement
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/10664kls/estatement/internal/auth"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func newSigningService(t *testing.T, cfg Config) *Service {
+	t.Helper()
+
+	cfg.ExportSigningKey = []byte("test-signing-key")
+	s, err := NewService(context.Background(), nil, zap.NewNop(), cfg)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	return s
+}
+
+// signedToken returns the token of the signed URL.
+func signedToken(t *testing.T, u string) string {
+	t.Helper()
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("url.Parse(%q) error = %v", u, err)
+	}
+	if parsed.Path != signedExportPath {
+		t.Fatalf("path = %q, want %q", parsed.Path, signedExportPath)
+	}
+	return parsed.Query().Get("token")
+}
+
+func TestSignedExportURL(t *testing.T) {
+	s := newSigningService(t, Config{})
+	ctx := auth.ContextWithClaims(context.Background(), &auth.Claims{ID: "U1", Username: "alice", Role: "user"})
+
+	u, err := s.SignedExportURL(ctx, &BatchGetStatementReq{ProductName: "LOAN"}, time.Minute)
+	if err != nil {
+		t.Fatalf("SignedExportURL() error = %v", err)
+	}
+
+	req, claims, err := s.VerifyExportToken(context.Background(), signedToken(t, u))
+	if err != nil {
+		t.Fatalf("VerifyExportToken() error = %v", err)
+	}
+	if req.ProductName != "LOAN" {
+		t.Errorf("ProductName = %q, want %q", req.ProductName, "LOAN")
+	}
+	if claims.ID != "U1" || claims.Role != "user" {
+		t.Errorf("claims = %+v, want the claims of the signer", claims)
+	}
+}
+
+func TestSignedExportURLExpired(t *testing.T) {
+	s := newSigningService(t, Config{})
+	ctx := auth.ContextWithClaims(context.Background(), &auth.Claims{ID: "U1"})
+
+	u, err := s.SignedExportURL(ctx, &BatchGetStatementReq{}, -time.Second)
+	if err != nil {
+		t.Fatalf("SignedExportURL() error = %v", err)
+	}
+
+	_, _, err = s.VerifyExportToken(context.Background(), signedToken(t, u))
+	if code := rpcstatus.Code(err); code != codes.PermissionDenied {
+		t.Errorf("VerifyExportToken() code = %v, want %v", code, codes.PermissionDenied)
+	}
+}
+
+func TestSignedExportURLTampered(t *testing.T) {
+	s := newSigningService(t, Config{})
+	ctx := auth.ContextWithClaims(context.Background(), &auth.Claims{ID: "U1", Role: "user"})
+
+	u, err := s.SignedExportURL(ctx, &BatchGetStatementReq{ProductName: "LOAN"}, time.Minute)
+	if err != nil {
+		t.Fatalf("SignedExportURL() error = %v", err)
+	}
+
+	payload, sig, _ := strings.Cut(signedToken(t, u), ".")
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	tampered := strings.Replace(string(b), `"role":"user"`, `"role":"admin"`, 1)
+	token := base64.RawURLEncoding.EncodeToString([]byte(tampered)) + "." + sig
+
+	_, _, err = s.VerifyExportToken(context.Background(), token)
+	if code := rpcstatus.Code(err); code != codes.PermissionDenied {
+		t.Errorf("VerifyExportToken() code = %v, want %v", code, codes.PermissionDenied)
+	}
+}
+
+func TestSignedExportURLRequiresClaims(t *testing.T) {
+	s := newSigningService(t, Config{})
+
+	_, err := s.SignedExportURL(context.Background(), &BatchGetStatementReq{}, time.Minute)
+	if code := rpcstatus.Code(err); code != codes.Unauthenticated {
+		t.Errorf("SignedExportURL() code = %v, want %v", code, codes.Unauthenticated)
+	}
+}
+
+func TestSignedExportURLKeepsMaskAndColumns(t *testing.T) {
+	s := newSigningService(t, Config{
+		MaskPolicies:            map[string]MaskPolicy{"agent": {AccountNumber: true}},
+		RestrictedExportColumns: []string{"EmailMsg"},
+	})
+	ctx := auth.ContextWithClaims(context.Background(), &auth.Claims{ID: "U1", Role: "agent"})
+
+	u, err := s.SignedExportURL(ctx, &BatchGetStatementReq{}, time.Minute)
+	if err != nil {
+		t.Fatalf("SignedExportURL() error = %v", err)
+	}
+
+	req, _, err := s.VerifyExportToken(context.Background(), signedToken(t, u))
+	if err != nil {
+		t.Fatalf("VerifyExportToken() error = %v", err)
+	}
+	if req.grant == nil {
+		t.Fatal("grant is nil, want the columns and mask of the signer")
+	}
+	if !req.grant.mask.AccountNumber {
+		t.Error("mask.AccountNumber = false, want the mask of the signer's role")
+	}
+	for _, c := range req.grant.columns {
+		if c.Key == "EmailMsg" {
+			t.Error("columns include EmailMsg, want the restricted column dropped")
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package statement
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/10664kls/estatement/internal/pager"
+	sq "github.com/Masterminds/squirrel"
+)
+
+// SortOrder is the order the statements are listed in. Every order ends with
+// CUID so the rows sharing the same createdate are ordered deterministically.
+type SortOrder int
+
+const (
+	// SortByID lists the statements by CUID, the most recent first.
+	SortByID SortOrder = iota
+
+	// SortByCreatedAt lists the statements by createdate then CUID, the most
+	// recent first.
+	SortByCreatedAt
+
+	// SortByBankCreatedAt lists the statements by bankcreatedate then CUID,
+	// the most recent first. The statements without a bank account creation
+	// are placed by the NullOrder.
+	SortByBankCreatedAt
+)
+
+// ParseSortOrder parses the name of a sort order, either "id", "createdAt"
+// or "bankCreatedAt".
+func ParseSortOrder(name string) (SortOrder, error) {
+	switch name {
+	case "", "id":
+		return SortByID, nil
+	case "createdAt":
+		return SortByCreatedAt, nil
+	case "bankCreatedAt":
+		return SortByBankCreatedAt, nil
+	}
+	return 0, fmt.Errorf("unknown sort order %q", name)
+}
+
+// NullOrder places the statements whose sort column is NULL. The databases
+// disagree on where NULL sorts and SQL Server has no NULLS LAST, so it's
+// written as a CASE in the ORDER BY.
+type NullOrder int
+
+const (
+	// NullsLast lists the NULL values after the others.
+	NullsLast NullOrder = iota
+
+	// NullsFirst lists the NULL values before the others.
+	NullsFirst
+)
+
+// ParseNullOrder parses the name of a null order, either "last" or "first".
+func ParseNullOrder(name string) (NullOrder, error) {
+	switch name {
+	case "", "last":
+		return NullsLast, nil
+	case "first":
+		return NullsFirst, nil
+	}
+	return 0, fmt.Errorf("unknown null order %q", name)
+}
+
+// listOptions are the settings of the service the statements are queried
+// with.
+type listOptions struct {
+	order SortOrder
+
+	// nulls places the statements whose sort column is NULL, only
+	// bankcreatedate is nullable.
+	nulls NullOrder
+
+	// modifiedAt is the column of the modification time of the statements,
+	// empty when the view has none.
+	modifiedAt string
+
+	// emailSentAt is the column of the time the email of the statements was
+	// sent, empty when the view has none.
+	emailSentAt string
+
+	// cursors signs the page tokens, so their offset can be trusted.
+	cursors *pager.Signer
+}
+
+// sortByModifiedAt lists the statements by modification time then CUID, the
+// oldest first. It's used by the incremental pulls and requires the
+// modification time column.
+const sortByModifiedAt SortOrder = -1
+
+// orderBy returns the ORDER BY clauses of the sort order.
+func (o listOptions) orderBy() []string {
+	switch o.order {
+	case SortByCreatedAt:
+		return []string{"createdate DESC", "CUID DESC"}
+	case SortByBankCreatedAt:
+		return []string{o.nullsOrderBy("bankcreatedate"), "bankcreatedate DESC", "CUID DESC"}
+	case sortByModifiedAt:
+		return []string{o.modifiedAt + " ASC", "CUID ASC"}
+	}
+	return []string{"CUID DESC"}
+}
+
+// nullsOrderBy returns the ORDER BY clause placing the NULL values of the
+// column by the null order, it precedes the clause of the column itself.
+func (o listOptions) nullsOrderBy(column string) string {
+	if o.nulls == NullsFirst {
+		return "CASE WHEN " + column + " IS NULL THEN 0 ELSE 1 END"
+	}
+	return "CASE WHEN " + column + " IS NULL THEN 1 ELSE 0 END"
+}
+
+// after returns the predicate of the rows following the cursor in the sort
+// order, it mirrors orderBy. Like the filters, its placeholders are numbered
+// along with the rest of the query by the builder of the dialect.
+func (o listOptions) after(c *pager.Cursor) sq.Sqlizer {
+	switch o.order {
+	case SortByCreatedAt:
+		return sq.Or{
+			sq.Lt{"createdate": c.Time},
+			sq.And{
+				sq.Eq{"createdate": c.Time},
+				sq.Lt{"CUID": c.ID},
+			},
+		}
+	case SortByBankCreatedAt:
+		return o.afterNullable("bankcreatedate", c)
+	case sortByModifiedAt:
+		return sq.Or{
+			sq.Gt{o.modifiedAt: c.Time},
+			sq.And{
+				sq.Eq{o.modifiedAt: c.Time},
+				sq.Gt{"CUID": c.ID},
+			},
+		}
+	}
+	return sq.Lt{"CUID": c.ID}
+}
+
+// afterNullable returns the predicate of the rows following the cursor when
+// sorted by the nullable column descending then CUID, the NULL values
+// placed by the null order.
+func (o listOptions) afterNullable(column string, c *pager.Cursor) sq.Sqlizer {
+	if c.Null {
+		after := sq.And{
+			sq.Eq{column: nil},
+			sq.Lt{"CUID": c.ID},
+		}
+		if o.nulls == NullsFirst {
+			return sq.Or{after, sq.NotEq{column: nil}}
+		}
+		return after
+	}
+
+	after := sq.Or{
+		sq.Lt{column: c.Time},
+		sq.And{
+			sq.Eq{column: c.Time},
+			sq.Lt{"CUID": c.ID},
+		},
+	}
+	if o.nulls == NullsLast {
+		after = append(after, sq.Eq{column: nil})
+	}
+	return after
+}
+
+// cursor returns the cursor pointing right after the statement in the sort
+// order.
+func (o listOptions) cursor(s *Statement) *pager.Cursor {
+	c := &pager.Cursor{
+		ID:   s.ID,
+		Time: s.CreatedAt,
+	}
+	switch {
+	case o.order == sortByModifiedAt && s.ModifiedAt != nil:
+		c.Time = *s.ModifiedAt
+	case o.order == SortByBankCreatedAt && s.BankAccount.CreatedAt != nil:
+		c.Time = *s.BankAccount.CreatedAt
+	case o.order == SortByBankCreatedAt:
+		c.Time = time.Time{}
+		c.Null = true
+	}
+	return c
+}
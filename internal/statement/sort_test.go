@@ -0,0 +1,120 @@
+package statement
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListStatementsCreatedAtTies(t *testing.T) {
+	s, mock, log := newTestService(t, Config{DefaultSort: SortByCreatedAt})
+	// statementRows creates every statement at testTime, they only differ
+	// by their CUID.
+	mock.ExpectQuery("ORDER BY createdate DESC, CUID DESC").
+		WillReturnRows(statementRows("5", "4"))
+	mock.ExpectQuery("ORDER BY createdate DESC, CUID DESC").
+		WithArgs("DEL", testTime, testTime, "4").
+		WillReturnRows(statementRows("3", "2"))
+	mock.ExpectQuery("ORDER BY createdate DESC, CUID DESC").
+		WithArgs("DEL", testTime, testTime, "2").
+		WillReturnRows(statementRows("1"))
+
+	seen := make([]string, 0)
+	req := &StatementQuery{PageSize: 2}
+	for range 3 {
+		result, err := s.ListStatements(adminContext(), req)
+		if err != nil {
+			t.Fatalf("ListStatements() error = %v", err)
+		}
+		for _, st := range result.Statements {
+			seen = append(seen, st.ID)
+		}
+		if result.NextPageToken == "" {
+			break
+		}
+		req.PageToken = result.NextPageToken
+	}
+
+	if got, want := fmt.Sprint(seen), "[5 4 3 2 1]"; got != want {
+		t.Errorf("ListStatements() walked %s, want %s", got, want)
+	}
+	if q := log.all()[1]; !strings.Contains(q, "(createdate < @p2 OR (createdate = @p3 AND CUID < @p4))") {
+		t.Errorf("ListStatements() query = %q, want the rows after the cursor on createdate then CUID", q)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// bankCreatedRows returns the rows of the statements of the IDs, with the
+// bank account creation time of the dated ones, NULL for the others.
+func bankCreatedRows(dated map[string]time.Time, ids ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows(statementColumns)
+	for _, id := range ids {
+		row := statementRow(id, "Q"+id)
+		if at, ok := dated[id]; ok {
+			row[6] = at
+		}
+		rows.AddRow(row...)
+	}
+	return rows
+}
+
+func TestListStatementsNullOrderAcrossPages(t *testing.T) {
+	newer, older := testTime.Add(2*time.Hour), testTime.Add(time.Hour)
+	dated := map[string]time.Time{"5": newer, "4": older}
+
+	tests := []struct {
+		nulls   string
+		orderBy string
+		pages   [][]string
+		args    [][]driver.Value
+	}{
+		{
+			nulls:   "last",
+			orderBy: "ORDER BY CASE WHEN bankcreatedate IS NULL THEN 1 ELSE 0 END, bankcreatedate DESC, CUID DESC",
+			pages:   [][]string{{"5", "4"}, {"3", "2"}, {"1"}},
+			args:    [][]driver.Value{{"DEL"}, {"DEL", older, older, "4"}, {"DEL", "2"}},
+		},
+		{
+			nulls:   "first",
+			orderBy: "ORDER BY CASE WHEN bankcreatedate IS NULL THEN 0 ELSE 1 END, bankcreatedate DESC, CUID DESC",
+			pages:   [][]string{{"3", "2"}, {"1", "5"}, {"4"}},
+			args:    [][]driver.Value{{"DEL"}, {"DEL", "2"}, {"DEL", newer, newer, "5"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nulls, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{})
+			for i, page := range tt.pages {
+				mock.ExpectQuery(tt.orderBy).WithArgs(tt.args[i]...).WillReturnRows(bankCreatedRows(dated, page...))
+			}
+
+			seen := make([]string, 0)
+			req := &StatementQuery{Sort: "bankCreatedAt", Nulls: tt.nulls, PageSize: 2}
+			for range tt.pages {
+				result, err := s.ListStatements(adminContext(), req)
+				if err != nil {
+					t.Fatalf("ListStatements() error = %v", err)
+				}
+				for _, st := range result.Statements {
+					seen = append(seen, st.ID)
+				}
+				req.PageToken = result.NextPageToken
+			}
+
+			want := slices.Concat(tt.pages...)
+			if !slices.Equal(seen, want) {
+				t.Errorf("ListStatements() walked %v, want %v", seen, want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
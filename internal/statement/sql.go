@@ -5,10 +5,17 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/10664kls/estatement/internal/errs"
 	"github.com/10664kls/estatement/internal/pager"
 	sq "github.com/Masterminds/squirrel"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
 )
 
 type Statement struct {
@@ -21,6 +28,35 @@ type Statement struct {
 	Status      string      `json:"status"`
 	CreatedBy   string      `json:"createdBy"`
 	CreatedAt   time.Time   `json:"createdAt"`
+
+	// ModifiedAt is the last modification time of the statement, it's only
+	// set when the view has a modification time column.
+	ModifiedAt *time.Time `json:"modifiedAt,omitempty"`
+
+	// CreatedByName is the display name of the creator, it's only resolved
+	// when StatementQuery.ResolveCreator is set.
+	CreatedByName string `json:"createdByName,omitempty"`
+}
+
+// redactDetails removes the free-text details of the statement which may hold
+// private information.
+func (s *Statement) redactDetails() {
+	s.Email.Message = nil
+	s.BankAccount.Info = nil
+}
+
+// LastModified returns the latest known change of the statement, its
+// modification time when known, otherwise the creation of the statement or
+// of its bank account.
+func (s *Statement) LastModified() time.Time {
+	t := s.CreatedAt
+	if s.BankAccount.CreatedAt != nil && s.BankAccount.CreatedAt.After(t) {
+		t = *s.BankAccount.CreatedAt
+	}
+	if s.ModifiedAt != nil && s.ModifiedAt.After(t) {
+		t = *s.ModifiedAt
+	}
+	return t
 }
 
 type Email struct {
@@ -29,7 +65,7 @@ type Email struct {
 }
 
 type Customer struct {
-	Gender      string `json:"gender"`
+	Gender      Gender `json:"gender"`
 	DisplayName string `json:"displayName"`
 	Occupation  string `json:"occupation"`
 }
@@ -43,15 +79,28 @@ type BankAccount struct {
 	CreatedAt *time.Time `json:"createdAt"`
 }
 
+// ListStatementsResult is the result of ListStatements, Statements is never
+// nil so it's marshaled as [] rather than null.
 type ListStatementsResult struct {
 	Statements    []*Statement `json:"statements"`
 	NextPageToken string       `json:"nextPageToken"`
+
+	// OffsetPage is only set in the offset mode, when the query has a Page.
+	*OffsetPage
+}
+
+// OffsetPage is the position of a page of the offset pagination.
+type OffsetPage struct {
+	Page       uint64 `json:"page"`
+	PageSize   uint64 `json:"pageSize"`
+	TotalItems int64  `json:"totalItems"`
+	TotalPages int64  `json:"totalPages"`
 }
 
 type StatementQuery struct {
 	CreatedBefore time.Time `json:"createdBefore" query:"createdBefore"`
 	CreatedAfter  time.Time `json:"createdAfter" query:"createdAfter"`
-	Gender        string    `json:"gender" query:"gender"`
+	Gender        Gender    `json:"gender" query:"gender"`
 	Status        string    `json:"status" query:"status"`
 	Occupation    string    `json:"occupation" query:"occupation"`
 	QueueNumber   string    `json:"queueNumber" query:"queueNumber"`
@@ -61,12 +110,407 @@ type StatementQuery struct {
 	Term          string    `json:"term" query:"term"`
 	PageToken     string    `json:"pageToken" query:"pageToken"`
 	PageSize      uint64    `json:"pageSize" query:"pageSize"`
+
+	// Page switches to the offset pagination for the clients unable to
+	// follow the page tokens, it's the 1-based index of the page. Every
+	// page counts the matching statements, and the database walks all the
+	// rows of the previous pages, so the deep pages are much slower than
+	// with PageToken.
+	// Optional. Default value 0, the pages are walked with PageToken.
+	Page uint64 `json:"page" query:"page"`
+
+	// HasBankAccount restricts the statements to the ones with a bank account
+	// number when true, or to the ones without when false.
+	// Optional. Default value nil, both are returned.
+	HasBankAccount *bool `json:"hasBankAccount" query:"hasBankAccount"`
+
+	// HasEmailMessage restricts the statements to the ones with an email
+	// message, usually the error of a failed email, when true, or to the
+	// ones without when false.
+	// Optional. Default value nil, both are returned.
+	HasEmailMessage *bool `json:"hasEmailMessage" query:"hasEmailMessage"`
+
+	// IncludeInactive includes the removed statements, only admins are allowed
+	// to set it.
+	// Optional. Default value false.
+	IncludeInactive bool `json:"includeInactive" query:"includeInactive"`
+
+	// Month restricts the statements to the ones created in the given calendar
+	// month, formatted as YYYY-MM. It's ANDed with the other filters.
+	Month string `json:"month" query:"month"`
+
+	// Timezone is the IANA name of the timezone of Month.
+	// Optional. Default value is the server local timezone.
+	Timezone string `json:"timezone" query:"timezone"`
+
+	// BankInfo restricts the statements to the ones whose bank notes
+	// contain it, the LIKE wildcards are matched literally.
+	BankInfo string `json:"bankInfo" query:"bankInfo"`
+
+	// ResolveCreator resolves the display name of the creator of the
+	// statements into CreatedByName, at the cost of an extra query.
+	// Optional. Default value false.
+	ResolveCreator bool `json:"resolveCreator" query:"resolveCreator"`
+
+	// ModifiedAfter restricts the statements to the ones modified after it.
+	// It's only supported when the view has a modification time column.
+	ModifiedAfter time.Time `json:"modifiedAfter" query:"modifiedAfter"`
+
+	// UpdatedSince pulls the statements modified after it, the oldest
+	// modification first, for the incremental syncs. The next page token is
+	// then returned even on the last page, so the next pull resumes right
+	// after the last statement seen. The statements without a modification
+	// time are never pulled.
+	// It's only supported when the view has a modification time column.
+	UpdatedSince time.Time `json:"updatedSince" query:"updatedSince"`
+
+	// EmailSentAfter and EmailSentBefore restrict the statements to the ones
+	// whose email was sent in the range, both ends included. The statements
+	// never sent are excluded when either is set.
+	// They're only supported when the view has an email sent time column.
+	EmailSentAfter  time.Time `json:"emailSentAfter" query:"emailSentAfter"`
+	EmailSentBefore time.Time `json:"emailSentBefore" query:"emailSentBefore"`
+
+	// Sort is the order the statements are listed in, either "id",
+	// "createdAt" or "bankCreatedAt". The page tokens are only valid with
+	// the sort they were returned for.
+	// Optional. Default value is the default sort of the service.
+	Sort string `json:"sort" query:"sort"`
+
+	// Nulls places the statements without a value to sort by, either
+	// "first" or "last". Only bankCreatedAt may have no value.
+	// Optional. Default value "last".
+	Nulls string `json:"nulls" query:"nulls"`
+
+	// opts are the settings the query is run with, the page token is a
+	// cursor in their sort order.
+	opts listOptions
+}
+
+// inactiveRecType is the value of the rectype column of the removed records,
+// following dbo.tb_user where the active records have rectype 'ADD'.
+const inactiveRecType = "DEL"
+
+// likeEscaper escapes the LIKE wildcards with '!', which unlike '\' is
+// not an escape character of the string literals of any dialect.
+var likeEscaper = strings.NewReplacer(
+	"!", "!!",
+	"%", "!%",
+	"_", "!_",
+	"[", "![",
+)
+
+// escapeLike returns v with its LIKE wildcards escaped.
+func escapeLike(v string) string {
+	return likeEscaper.Replace(v)
+}
+
+// monthRange returns the start of the month and the start of the next month
+// in the given timezone.
+func monthRange(month, timezone string) (start, next time.Time, err error) {
+	loc := time.Local
+	if timezone != "" {
+		loc, err = time.LoadLocation(timezone)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+
+	start, err = time.ParseInLocation("2006-01", month, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid month: %w", err)
+	}
+
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// withOptions sets the settings the query is run with, the sort of the query
+// takes precedence over the default one, and the incremental pulls are
+// sorted by modification time whatever the sort is.
+func (q *StatementQuery) withOptions(opts listOptions) {
+	if order, err := ParseSortOrder(q.Sort); err == nil && q.Sort != "" {
+		opts.order = order
+	}
+	if nulls, err := ParseNullOrder(q.Nulls); err == nil {
+		opts.nulls = nulls
+	}
+	if !q.UpdatedSince.IsZero() {
+		opts.order = sortByModifiedAt
+	}
+	q.opts = opts
+}
+
+// Validate returns an error with the field violations if the query is not valid.
+func (q *StatementQuery) Validate() error {
+	violations := q.violations()
+	if len(violations) == 0 {
+		return nil
+	}
+
+	s, _ := rpcstatus.New(codes.InvalidArgument, "Statement query is not valid.").
+		WithDetails(&edpb.BadRequest{
+			FieldViolations: violations,
+		})
+	return s.Err()
+}
+
+// queueNumbers returns the distinct queue numbers of the query, QueueNumber
+// is either a single queue number or a comma separated list of them.
+func (q *StatementQuery) queueNumbers() []string {
+	numbers := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, n := range strings.Split(q.QueueNumber, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+func (q *StatementQuery) violations() []*edpb.BadRequest_FieldViolation {
+	violations := make([]*edpb.BadRequest_FieldViolation, 0)
+	if !q.CreatedBefore.IsZero() && !q.CreatedAfter.IsZero() && q.CreatedAfter.After(q.CreatedBefore) {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "createdAfter",
+			Description: "createdAfter must be before or equal to createdBefore",
+		})
+	}
+	if q.Timezone != "" {
+		if _, err := time.LoadLocation(q.Timezone); err != nil {
+			violations = append(violations, &edpb.BadRequest_FieldViolation{
+				Field:       "timezone",
+				Description: "timezone must be a valid IANA timezone name",
+			})
+		}
+	}
+	if q.Month != "" {
+		if _, err := time.Parse("2006-01", q.Month); err != nil {
+			violations = append(violations, &edpb.BadRequest_FieldViolation{
+				Field:       "month",
+				Description: "month must be formatted as YYYY-MM",
+			})
+		}
+	}
+	if !q.UpdatedSince.IsZero() && q.opts.modifiedAt == "" {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "updatedSince",
+			Description: "updatedSince is not supported, statements have no modification time",
+		})
+	}
+	if !q.ModifiedAfter.IsZero() && q.opts.modifiedAt == "" {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "modifiedAfter",
+			Description: "modifiedAfter is not supported, statements have no modification time",
+		})
+	}
+	if !q.EmailSentAfter.IsZero() && q.opts.emailSentAt == "" {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "emailSentAfter",
+			Description: "emailSentAfter is not supported, statements have no email sent time",
+		})
+	}
+	if !q.EmailSentBefore.IsZero() && q.opts.emailSentAt == "" {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "emailSentBefore",
+			Description: "emailSentBefore is not supported, statements have no email sent time",
+		})
+	}
+	if !q.EmailSentAfter.IsZero() && !q.EmailSentBefore.IsZero() && q.EmailSentAfter.After(q.EmailSentBefore) {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "emailSentAfter",
+			Description: "emailSentAfter must not be after emailSentBefore",
+		})
+	}
+	if v := q.Gender.violation(); v != nil {
+		violations = append(violations, v)
+	}
+	if n := len(q.queueNumbers()); n > maxQueueNumbers {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "queueNumber",
+			Description: fmt.Sprintf("queueNumber must have at most %d queue numbers", maxQueueNumbers),
+		})
+	}
+	if _, err := ParseSortOrder(q.Sort); err != nil {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "sort",
+			Description: "sort must be one of id, createdAt or bankCreatedAt",
+		})
+	}
+	if q.Sort != "" && !q.UpdatedSince.IsZero() {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "sort",
+			Description: "sort must not be set along with updatedSince",
+		})
+	}
+	if _, err := ParseNullOrder(q.Nulls); err != nil {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "nulls",
+			Description: "nulls must be either first or last",
+		})
+	}
+	if q.Page > 0 && q.PageToken != "" {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "page",
+			Description: "page must not be set along with pageToken",
+		})
+	}
+	if q.Page > 0 && !q.UpdatedSince.IsZero() {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "page",
+			Description: "page must not be set along with updatedSince",
+		})
+	}
+	if q.PageToken != "" {
+		if _, err := q.opts.cursors.DecodeCursor(q.PageToken); err != nil {
+			violations = append(violations, &edpb.BadRequest_FieldViolation{
+				Field:       "pageToken",
+				Description: "pageToken is not valid",
+			})
+		}
+	}
+	return violations
+}
+
+// offset returns how many statements the pages before PageToken, or
+// before Page, returned. It saturates at math.MaxUint64 rather than wrap
+// around for the absurd pages, so they're still over the result window.
+func (q *StatementQuery) offset() uint64 {
+	if q.Page > 0 {
+		size := pager.Size(q.PageSize)
+		if q.Page-1 > math.MaxUint64/size {
+			return math.MaxUint64
+		}
+		return (q.Page - 1) * size
+	}
+	if q.PageToken == "" {
+		return 0
+	}
+	cursor, err := q.opts.cursors.DecodeCursor(q.PageToken)
+	if err != nil {
+		return 0
+	}
+	return cursor.Offset
+}
+
+// errResultWindowExceeded is a helper function to create an error when
+// a chain of pages goes past the max result window.
+func errResultWindowExceeded(max uint64) error {
+	st, _ := rpcstatus.New(codes.InvalidArgument, fmt.Sprintf("Only the first %d statements can be paged through, please narrow your filters.", max)).
+		WithDetails(&edpb.BadRequest{
+			FieldViolations: []*edpb.BadRequest_FieldViolation{
+				{
+					Field:       "pageToken",
+					Description: "pageToken is past the max result window",
+				},
+			},
+		})
+	return st.Err()
 }
 
+// filterCount returns the number of conditions the query filters on.
+func (q *StatementQuery) filterCount() int {
+	and, err := q.conditions()
+	if err != nil {
+		return 0
+	}
+	return len(and)
+}
+
+// queryError is an error of a filter of the caller found while converting
+// the query to SQL, it converts to a codes.InvalidArgument status.
+type queryError struct {
+	field string
+	err   error
+}
+
+func (e *queryError) Error() string {
+	return e.field + ": " + e.err.Error()
+}
+
+func (e *queryError) Unwrap() error {
+	return e.err
+}
+
+func (e *queryError) GRPCStatus() *rpcstatus.Status {
+	st, _ := rpcstatus.New(codes.InvalidArgument, "Statement query is not valid.").
+		WithDetails(&edpb.BadRequest{
+			FieldViolations: []*edpb.BadRequest_FieldViolation{
+				{
+					Field:       e.field,
+					Description: e.err.Error(),
+				},
+			},
+		})
+	return st
+}
+
+// buildError is an error of the query builder, it converts to a
+// codes.Internal status which does not expose the cause.
+type buildError struct {
+	err error
+}
+
+func (e *buildError) Error() string {
+	return "failed to convert to sql: " + e.err.Error()
+}
+
+func (e *buildError) Unwrap() error {
+	return e.err
+}
+
+func (e *buildError) GRPCStatus() *rpcstatus.Status {
+	return rpcstatus.New(codes.Internal, "Failed to build the statement query.")
+}
+
+// toSqlError classifies the error returned by ToSql: the errors of the
+// filters of the caller are returned as is, the others as a *buildError.
+func toSqlError(err error) error {
+	var qe *queryError
+	if errors.As(err, &qe) {
+		return qe
+	}
+	return &buildError{err: err}
+}
+
+// ToSql converts the filters of the query to SQL, see predicate.
+// The SQL has "?" placeholders, they're renumbered to the placeholder format
+// of the dialect, e.g. "@p1", by the builder of the query it's embedded in,
+// so it must not be run on its own.
 func (q *StatementQuery) ToSql() (string, []any, error) {
+	return q.predicate().ToSql()
+}
+
+// errPredicate is a predicate whose conversion to SQL fails with err.
+type errPredicate struct {
+	err error
+}
+
+func (p errPredicate) ToSql() (string, []any, error) {
+	return "", nil, p.err
+}
+
+// predicate returns the WHERE predicate of the filters of the query. It's
+// shared by the listings, the counts and the summaries so they always match
+// the same statements. The page token is not part of it, the listings
+// add the cursor themselves.
+// Its conversion fails with a *queryError when a filter is not valid.
+func (q *StatementQuery) predicate() sq.Sqlizer {
+	and, err := q.conditions()
+	if err != nil {
+		return errPredicate{err: err}
+	}
+	return and
+}
+
+// conditions returns the conditions of the filters of the query, ANDed by
+// predicate.
+func (q *StatementQuery) conditions() (sq.And, error) {
 	and := sq.And{}
 	if q.Gender != "" {
-		and = append(and, sq.Eq{"gender": q.Gender})
+		and = append(and, sq.Eq{"gender": q.Gender.values()})
 	}
 	if q.Status != "" {
 		and = append(and, sq.Eq{"statusBanking": q.Status})
@@ -77,8 +521,12 @@ func (q *StatementQuery) ToSql() (string, []any, error) {
 	if q.BankCode != "" {
 		and = append(and, sq.Eq{"bankname": q.BankCode})
 	}
-	if q.QueueNumber != "" {
-		and = append(and, sq.Eq{"cusnum": q.QueueNumber})
+	switch numbers := q.queueNumbers(); len(numbers) {
+	case 0:
+	case 1:
+		and = append(and, sq.Eq{"cusnum": numbers[0]})
+	default:
+		and = append(and, sq.Eq{"cusnum": numbers})
 	}
 	if q.Term != "" {
 		and = append(and, sq.Eq{"term": q.Term})
@@ -90,68 +538,225 @@ func (q *StatementQuery) ToSql() (string, []any, error) {
 		and = append(and, sq.Eq{"occupation": q.Occupation})
 	}
 
+	if q.BankInfo != "" {
+		// A null info is never LIKE anything, so it doesn't match.
+		and = append(and, sq.Expr("bankmoreinfo LIKE ? ESCAPE '!'", "%"+escapeLike(q.BankInfo)+"%"))
+	}
+
 	if !q.CreatedBefore.IsZero() {
 		and = append(and, sq.LtOrEq{"createdate": q.CreatedBefore})
 	}
 	if !q.CreatedAfter.IsZero() {
 		and = append(and, sq.GtOrEq{"createdate": q.CreatedAfter})
 	}
-
-	if q.PageToken != "" {
-		cursor, err := pager.DecodeCursor(q.PageToken)
+	if !q.ModifiedAfter.IsZero() {
+		if q.opts.modifiedAt == "" {
+			return nil, &queryError{field: "modifiedAfter", err: errors.New("modifiedAfter is not supported")}
+		}
+		and = append(and, sq.Gt{q.opts.modifiedAt: q.ModifiedAfter})
+	}
+	if !q.UpdatedSince.IsZero() {
+		if q.opts.modifiedAt == "" {
+			return nil, &queryError{field: "updatedSince", err: errors.New("updatedSince is not supported")}
+		}
+		and = append(and, sq.Gt{q.opts.modifiedAt: q.UpdatedSince})
+	}
+	if !q.EmailSentAfter.IsZero() || !q.EmailSentBefore.IsZero() {
+		column := q.opts.emailSentAt
+		if column == "" {
+			return nil, &queryError{field: "emailSentAfter", err: errors.New("email sent range is not supported")}
+		}
+		// The range already excludes the nulls, it's spelled out for the
+		// readers of the query.
+		and = append(and, sq.NotEq{column: nil})
+		if !q.EmailSentAfter.IsZero() {
+			and = append(and, sq.GtOrEq{column: q.EmailSentAfter})
+		}
+		if !q.EmailSentBefore.IsZero() {
+			and = append(and, sq.LtOrEq{column: q.EmailSentBefore})
+		}
+	}
+	if !q.IncludeInactive {
+		and = append(and, sq.Or{
+			sq.Eq{"rectype": nil},
+			sq.NotEq{"rectype": inactiveRecType},
+		})
+	}
+	if q.HasBankAccount != nil {
+		if *q.HasBankAccount {
+			and = append(and, sq.And{
+				sq.NotEq{"AccNo": nil},
+				sq.NotEq{"AccNo": ""},
+			})
+		} else {
+			and = append(and, sq.Or{
+				sq.Eq{"AccNo": nil},
+				sq.Eq{"AccNo": ""},
+			})
+		}
+	}
+	if q.HasEmailMessage != nil {
+		if *q.HasEmailMessage {
+			and = append(and, sq.And{
+				sq.NotEq{"emailmsg": nil},
+				sq.NotEq{"emailmsg": ""},
+			})
+		} else {
+			and = append(and, sq.Or{
+				sq.Eq{"emailmsg": nil},
+				sq.Eq{"emailmsg": ""},
+			})
+		}
+	}
+	if q.Month != "" {
+		start, next, err := monthRange(q.Month, q.Timezone)
 		if err != nil {
-			return "", nil, err
+			return nil, &queryError{field: "month", err: err}
 		}
-		and = append(and, sq.Expr("CUID < ?", cursor.ID))
+		and = append(and,
+			sq.GtOrEq{"createdate": start},
+			sq.Lt{"createdate": next},
+		)
 	}
 
-	return and.ToSql()
+	return and, nil
 }
 
-func getStatements(ctx context.Context, db *sql.DB, in *StatementQuery) (*Statement, error) {
-	statements, err := listStatements(ctx, db, in)
+func getStatements(ctx context.Context, db queryer, d Dialect, in *StatementQuery) (*Statement, error) {
+	// A single statement is looked up, a list of queue numbers matches none.
+	if strings.Contains(in.QueueNumber, ",") {
+		return nil, errs.NotFound("statement", in.QueueNumber)
+	}
+
+	// Two rows are enough to tell a duplicate queue number apart.
+	statements, err := queryStatements(ctx, db, d, 2, 0, in.opts, in.predicate())
 	if err != nil {
 		return nil, err
 	}
 	if len(statements) == 0 {
-		return nil, ErrStatementNotFound
+		return nil, errs.NotFound("statement", in.QueueNumber)
+	}
+	if len(statements) > 1 {
+		return nil, errAmbiguousQueueNumber(in.QueueNumber)
 	}
 
 	return statements[0], nil
 }
 
-func listStatements(ctx context.Context, db *sql.DB, in *StatementQuery) ([]*Statement, error) {
-	id := fmt.Sprintf("TOP %d CUID", pager.Size(in.PageSize))
-	pred, args, err := in.ToSql()
+// errAmbiguousQueueNumber is a helper function to create an error when
+// several statements have the queue number looked up.
+func errAmbiguousQueueNumber(queueNumber string) error {
+	s, _ := rpcstatus.New(codes.FailedPrecondition, "Several statements have this queue number, please list them with the queueNumber filter instead.").
+		WithDetails(&edpb.ErrorInfo{
+			Reason:   "AMBIGUOUS_QUEUE_NUMBER",
+			Domain:   "statement",
+			Metadata: map[string]string{"queueNumber": queueNumber},
+		})
+	return s.Err()
+}
+
+func listStatements(ctx context.Context, db queryer, d Dialect, in *StatementQuery) ([]*Statement, error) {
+	limit := pager.Size(in.PageSize)
+	pred := in.predicate()
+	if in.PageToken != "" {
+		cursor, err := in.opts.cursors.DecodeCursor(in.PageToken)
+		if err != nil {
+			return nil, &queryError{field: "pageToken", err: errors.New("pageToken is not valid")}
+		}
+		pred = sq.And{pred, in.opts.after(cursor)}
+	}
+
+	var offset uint64
+	if in.Page > 0 {
+		offset = in.offset()
+	}
+	return queryStatements(ctx, db, d, limit, offset, in.opts, pred)
+}
+
+// statementColumns are the columns of dbo.vm_customer scanned by scanStatement.
+var statementColumns = []string{
+	"CUID",
+	"cusnum",
+	"cus_name",
+	"AccNo",
+	"term",
+	"bankname",
+	"bankcreatedate",
+	"bankstatus",
+	"bankmoreinfo",
+	"gender",
+	"productnames",
+	"emailstatus",
+	"emailmsg",
+	"occupation",
+	"createby",
+	"statusBanking",
+	"createdate",
+}
+
+// columnFields maps each of the statementColumns to the JSON field of the
+// Statement it's scanned into.
+var columnFields = map[string]string{
+	"CUID":           "id",
+	"cusnum":         "queueNumber",
+	"cus_name":       "customer.displayName",
+	"AccNo":          "bankAccount.number",
+	"term":           "bankAccount.term",
+	"bankname":       "bankAccount.code",
+	"bankcreatedate": "bankAccount.createdAt",
+	"bankstatus":     "bankAccount.status",
+	"bankmoreinfo":   "bankAccount.info",
+	"gender":         "customer.gender",
+	"productnames":   "productName",
+	"emailstatus":    "email.isSent",
+	"emailmsg":       "email.message",
+	"occupation":     "customer.occupation",
+	"createby":       "createdBy",
+	"statusBanking":  "status",
+	"createdate":     "createdAt",
+}
+
+// queryStatements returns at most limit statements matching pred, in the
+// order of opts.
+func queryStatements(ctx context.Context, db queryer, d Dialect, limit, offset uint64, opts listOptions, pred sq.Sqlizer) ([]*Statement, error) {
+	batch, err := queryStatementBatch(ctx, db, d, limit, offset, opts, pred, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert to sql: %w", err)
-	}
-
-	q, args := sq.
-		Select(
-			id,
-			"cusnum",
-			"cus_name",
-			"AccNo",
-			"term",
-			"bankname",
-			"bankcreatedate",
-			"bankstatus",
-			"bankmoreinfo",
-			"gender",
-			"productnames",
-			"emailstatus",
-			"emailmsg",
-			"occupation",
-			"createby",
-			"statusBanking",
-			"createdate",
-		).
+		return nil, err
+	}
+	return batch.statements, nil
+}
+
+// statementBatch is a batch of the statements read for an export.
+type statementBatch struct {
+	statements []*Statement
+
+	// skipped are the rows which could not be read, they're only skipped
+	// when asked to.
+	skipped []*rowError
+
+	// lastID is the ID of the last row read, skipped or not, the next batch
+	// follows it.
+	lastID string
+}
+
+// queryStatementBatch is queryStatements, which skips the rows which could
+// not be read rather than fail when skip is set. The rows whose ID could
+// not be read are never skipped, the next batch could not follow them.
+func queryStatementBatch(ctx context.Context, db queryer, d Dialect, limit, offset uint64, opts listOptions, pred sq.Sqlizer, skip bool) (*statementBatch, error) {
+	columns := statementColumns
+	if opts.modifiedAt != "" {
+		columns = append(slices.Clip(columns), opts.modifiedAt)
+	}
+
+	q, args, err := d.
+		selectPage(limit, offset, columns...).
 		From("dbo.vm_customer").
-		PlaceholderFormat(sq.AtP).
-		Where(pred, args...).
-		OrderBy("CUID DESC").
-		MustSql()
+		Where(pred).
+		OrderBy(opts.orderBy()...).
+		ToSql()
+	if err != nil {
+		return nil, toSqlError(err)
+	}
 
 	rows, err := db.QueryContext(ctx, q, args...)
 	if err != nil {
@@ -159,114 +764,163 @@ func listStatements(ctx context.Context, db *sql.DB, in *StatementQuery) ([]*Sta
 	}
 	defer rows.Close()
 
-	statements := make([]*Statement, 0)
+	batch := &statementBatch{
+		statements: make([]*Statement, 0),
+		skipped:    make([]*rowError, 0),
+	}
 	for rows.Next() {
-		var s Statement
-		var isSent sql.NullString
-		err := rows.Scan(
-			&s.ID,
-			&s.QueueNumber,
-			&s.Customer.DisplayName,
-			&s.BankAccount.Number,
-			&s.BankAccount.Term,
-			&s.BankAccount.Code,
-			&s.BankAccount.CreatedAt,
-			&s.BankAccount.Status,
-			&s.BankAccount.Info,
-			&s.Customer.Gender,
-			&s.ProductName,
-			&isSent,
-			&s.Email.Message,
-			&s.Customer.Occupation,
-			&s.CreatedBy,
-			&s.Status,
-			&s.CreatedAt,
-		)
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrStatementNotFound
+		s, err := scanStatement(rows, opts.modifiedAt != "")
+		var re *rowError
+		if skip && errors.As(err, &re) && re.id != "" {
+			batch.skipped = append(batch.skipped, re)
+			batch.lastID = re.id
+			continue
 		}
-
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
-		if isSent.Valid {
-			s.Email.IsSent = &isSent.String
-		}
 
-		statements = append(statements, &s)
+		batch.statements = append(batch.statements, s)
+		batch.lastID = s.ID
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to iterate rows: %w", err)
 	}
 
-	return statements, nil
+	return batch, nil
 }
 
-func listProductNames(ctx context.Context, db *sql.DB) ([]string, error) {
-	q, args := sq.
-		Select("productnames").
-		From("dbo.vm_customer").
-		PlaceholderFormat(sq.AtP).
-		GroupBy("productnames").
-		MustSql()
+// rowError is the error of a statement which could not be read or written,
+// with its ID when it was read.
+type rowError struct {
+	id string
 
-	rows, err := db.QueryContext(ctx, q, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %w", err)
+	// written is set when the statement was read but could not be written
+	// into the export.
+	written bool
+
+	err error
+}
+
+func (e *rowError) Error() string {
+	op := "read"
+	if e.written {
+		op = "write"
 	}
-	defer rows.Close()
+	if e.id == "" {
+		return "failed to " + op + " statement: " + e.err.Error()
+	}
+	return "failed to " + op + " statement " + e.id + ": " + e.err.Error()
+}
 
-	productNames := make([]string, 0)
-	for rows.Next() {
-		var productName string
-		err := rows.Scan(&productName)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
-		productNames = append(productNames, productName)
+func (e *rowError) Unwrap() error {
+	return e.err
+}
+
+// scanStatement scans the statementColumns of the current row.
+// The modification time is scanned after them when withModifiedAt is set.
+func scanStatement(rows *sql.Rows, withModifiedAt bool) (*Statement, error) {
+	var s Statement
+	var isSent, accNo sql.NullString
+	dest := []any{
+		&s.ID,
+		&s.QueueNumber,
+		&s.Customer.DisplayName,
+		&accNo,
+		&s.BankAccount.Term,
+		&s.BankAccount.Code,
+		&s.BankAccount.CreatedAt,
+		&s.BankAccount.Status,
+		&s.BankAccount.Info,
+		&s.Customer.Gender,
+		&s.ProductName,
+		&isSent,
+		&s.Email.Message,
+		&s.Customer.Occupation,
+		&s.CreatedBy,
+		&s.Status,
+		&s.CreatedAt,
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	if withModifiedAt {
+		dest = append(dest, &s.ModifiedAt)
 	}
-	return productNames, nil
+	if err := rows.Scan(dest...); err != nil {
+		// The columns are scanned in order, so the ID is set unless it's
+		// the one which failed.
+		return nil, &rowError{id: s.ID, err: err}
+	}
+
+	if isSent.Valid {
+		s.Email.IsSent = &isSent.String
+	}
+	s.BankAccount.Number = accNo.String
+
+	return &s, nil
 }
 
-func listOccupations(ctx context.Context, db *sql.DB) ([]string, error) {
-	q, args := sq.
-		Select("occupation").
+// countStatements counts the statements matching in, either a *StatementQuery
+// or a *BatchGetStatementReq.
+func countStatements(ctx context.Context, db queryer, d Dialect, in sq.Sqlizer) (int64, error) {
+	pred, args, err := in.ToSql()
+	if err != nil {
+		return 0, toSqlError(err)
+	}
+
+	q, args := d.builder().
+		Select("COUNT(*)").
 		From("dbo.vm_customer").
-		PlaceholderFormat(sq.AtP).
-		GroupBy("occupation").
+		Where(pred, args...).
 		MustSql()
 
-	rows, err := db.QueryContext(ctx, q, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %w", err)
+	var count int64
+	if err := db.QueryRowContext(ctx, q, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to execute query: %w", err)
 	}
-	defer rows.Close()
 
-	occupations := make([]string, 0)
-	for rows.Next() {
-		var occupation string
-		err := rows.Scan(&occupation)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
-		occupations = append(occupations, occupation)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return occupations, nil
+	return count, nil
+}
+
+func listProductNames(ctx context.Context, db queryer, d Dialect) ([]string, error) {
+	return listDistinct(ctx, db, d, "productnames", nil)
+}
+
+func listOccupations(ctx context.Context, db queryer, d Dialect) ([]string, error) {
+	return listDistinct(ctx, db, d, "occupation", nil)
+}
+
+// listOccupationsByGender returns the occupations of the customers of the gender.
+func listOccupationsByGender(ctx context.Context, db queryer, d Dialect, gender Gender) ([]string, error) {
+	return listDistinct(ctx, db, d, "occupation", sq.Eq{"gender": gender.values()})
+}
+
+func listTerms(ctx context.Context, db queryer, d Dialect) ([]string, error) {
+	return listDistinct(ctx, db, d, "term", nil)
+}
+
+func listBanks(ctx context.Context, db queryer, d Dialect) ([]string, error) {
+	return listDistinct(ctx, db, d, "bankname", nil)
 }
 
-func listTerms(ctx context.Context, db *sql.DB) ([]string, error) {
-	q, args := sq.
-		Select("term").
+func listStatuses(ctx context.Context, db queryer, d Dialect) ([]string, error) {
+	return listDistinct(ctx, db, d, "statusBanking", nil)
+}
+
+// listDistinct returns the distinct non-null values of the column of the
+// statements matching pred, pred may be nil.
+// The returned slice is never nil so it's marshaled as [] rather than null.
+func listDistinct(ctx context.Context, db queryer, d Dialect, column string, pred sq.Sqlizer) ([]string, error) {
+	b := d.builder().
+		Select(column).
 		From("dbo.vm_customer").
-		PlaceholderFormat(sq.AtP).
-		GroupBy("term").
-		MustSql()
+		GroupBy(column)
+	if pred != nil {
+		b = b.Where(pred)
+	}
+
+	q, args, err := b.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
 
 	rows, err := db.QueryContext(ctx, q, args...)
 	if err != nil {
@@ -274,26 +928,28 @@ func listTerms(ctx context.Context, db *sql.DB) ([]string, error) {
 	}
 	defer rows.Close()
 
-	terms := make([]string, 0)
+	values := make([]string, 0)
 	for rows.Next() {
-		var term string
-		err := rows.Scan(&term)
+		var value sql.NullString
+		err := rows.Scan(&value)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
-		terms = append(terms, term)
+		if value.Valid {
+			values = append(values, value.String)
+		}
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return terms, nil
+	return values, nil
 }
 
 type BatchGetStatementReq struct {
 	CreatedBefore time.Time `json:"createdBefore" query:"createdBefore"`
 	CreatedAfter  time.Time `json:"createdAfter" query:"createdAfter"`
-	Gender        string    `json:"gender" query:"gender"`
+	Gender        Gender    `json:"gender" query:"gender"`
 	Status        string    `json:"status" query:"status"`
 	Occupation    string    `json:"occupation" query:"occupation"`
 	QueueNumber   string    `json:"queueNumber" query:"queueNumber"`
@@ -301,130 +957,180 @@ type BatchGetStatementReq struct {
 	BankCode      string    `json:"bankCode" query:"bankCode"`
 	CreatedBy     string    `json:"createdBy" query:"createdBy"`
 	Term          string    `json:"term" query:"term"`
+	Profile       string    `json:"profile" query:"profile"`
+
+	// HasBankAccount restricts the statements to the ones with a bank account
+	// number when true, or to the ones without when false.
+	// Optional. Default value nil, both are returned.
+	HasBankAccount *bool `json:"hasBankAccount" query:"hasBankAccount"`
+
+	// Month restricts the statements to the ones created in the given calendar
+	// month of Timezone, formatted as YYYY-MM.
+	Month string `json:"month" query:"month"`
+
+	// IncludeDictionary adds a sheet describing the exported columns to
+	// the workbook.
+	// Optional. Default value false.
+	IncludeDictionary bool `json:"includeDictionary" query:"includeDictionary"`
+
+	// NullPlaceholder is written in the cells of the null values, e.g. "N/A".
+	// Optional. Default value "", the cells are left empty.
+	NullPlaceholder string `json:"nullPlaceholder" query:"nullPlaceholder"`
+
+	// Filename is the name of the downloaded workbook.
+	// Optional. Default value is a name made of the day and the filters,
+	// see ExportFilename.
+	Filename string `json:"filename" query:"filename"`
+
+	// Timezone is the IANA timezone the dates of the workbook are written
+	// in, e.g. "Asia/Vientiane".
+	// Optional. Default value is the export timezone of the user, see
+	// auth.User, or the timezone of the server when it's not set.
+	Timezone string `json:"timezone" query:"timezone"`
+
+	// SkipBadRows skips the statements which could not be exported rather
+	// than fail the export, they're listed in a sheet of the workbook. It
+	// only applies to the Excel exports.
+	// Optional. Default value false.
+	SkipBadRows bool `json:"skipBadRows" query:"skipBadRows"`
+
+	// Delimiter is the delimiter of the fields of the CSV export, either
+	// "comma", "semicolon" or "tab".
+	// Optional. Default value "comma".
+	Delimiter string `json:"delimiter" query:"delimiter"`
+
+	// BOM prefixes the CSV export with a UTF-8 byte order mark, so Excel
+	// reads the non-ASCII names correctly.
+	// Optional. Default value false.
+	BOM bool `json:"bom" query:"bom"`
 
 	nextID string
+
+	// grant pins the columns and the masking of a signed export, see
+	// VerifyExportToken.
+	grant *exportGrant
+
+	// profiles are the registered export profiles the profile is checked
+	// against, see Service.ValidateExport.
+	profiles map[string]*ExportProfile
 }
 
-func (q *BatchGetStatementReq) ToSql() (string, []any, error) {
-	and := sq.And{}
-	if q.Gender != "" {
-		and = append(and, sq.Eq{"gender": q.Gender})
+// maxCellLen is the most characters an Excel cell holds.
+const maxCellLen = 32767
+
+// Validate returns an error with the field violations if the request is not
+// valid. The profile is checked against the registered export profiles, see
+// Service.ValidateExport, a request holding none of them accepts only the
+// default profile.
+func (q *BatchGetStatementReq) Validate() error {
+	violations := q.violations()
+	if len(violations) == 0 {
+		return nil
 	}
-	if q.Status != "" {
-		and = append(and, sq.Eq{"statusBanking": q.Status})
+
+	s, _ := rpcstatus.New(codes.InvalidArgument, "Export request is not valid.").
+		WithDetails(&edpb.BadRequest{
+			FieldViolations: violations,
+		})
+	return s.Err()
+}
+
+func (q *BatchGetStatementReq) violations() []*edpb.BadRequest_FieldViolation {
+	violations := make([]*edpb.BadRequest_FieldViolation, 0)
+	if !q.CreatedBefore.IsZero() && !q.CreatedAfter.IsZero() && q.CreatedAfter.After(q.CreatedBefore) {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "createdAfter",
+			Description: "createdAfter must be before or equal to createdBefore",
+		})
 	}
-	if q.ProductName != "" {
-		and = append(and, sq.Eq{"productnames": q.ProductName})
+	if q.Timezone != "" {
+		if _, err := time.LoadLocation(q.Timezone); err != nil {
+			violations = append(violations, &edpb.BadRequest_FieldViolation{
+				Field:       "timezone",
+				Description: "timezone must be a valid IANA timezone name",
+			})
+		}
 	}
-	if q.BankCode != "" {
-		and = append(and, sq.Eq{"bankname": q.BankCode})
+	if len([]rune(q.NullPlaceholder)) > maxCellLen {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "nullPlaceholder",
+			Description: fmt.Sprintf("nullPlaceholder must have at most %d characters", maxCellLen),
+		})
 	}
-	if q.QueueNumber != "" {
-		and = append(and, sq.Eq{"cusnum": q.QueueNumber})
+	if _, err := q.delimiter(); err != nil {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "delimiter",
+			Description: "delimiter must be one of comma, semicolon or tab",
+		})
 	}
-	if q.Term != "" {
-		and = append(and, sq.Eq{"term": q.Term})
+	if strings.ContainsAny(q.QueueNumber, ",") {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "queueNumber",
+			Description: "queueNumber must be a single queue number",
+		})
 	}
-	if q.CreatedBy != "" {
-		and = append(and, sq.Eq{"createby": q.CreatedBy})
+	if q.Month != "" {
+		if _, err := time.Parse("2006-01", q.Month); err != nil {
+			violations = append(violations, &edpb.BadRequest_FieldViolation{
+				Field:       "month",
+				Description: "month must be formatted as YYYY-MM",
+			})
+		}
 	}
-	if q.Occupation != "" {
-		and = append(and, sq.Eq{"occupation": q.Occupation})
+	if _, ok := q.profiles[q.Profile]; q.Profile != "" && !ok {
+		violations = append(violations, &edpb.BadRequest_FieldViolation{
+			Field:       "profile",
+			Description: fmt.Sprintf("profile %q is not registered", q.Profile),
+		})
 	}
+	return violations
+}
 
-	if !q.CreatedBefore.IsZero() {
-		and = append(and, sq.LtOrEq{"createdate": q.CreatedBefore})
-	}
-	if !q.CreatedAfter.IsZero() {
-		and = append(and, sq.GtOrEq{"createdate": q.CreatedAfter})
+// bounded reports whether the request is narrowed down by a date range, a
+// month or a queue number, so it can't export the whole table.
+func (q *BatchGetStatementReq) bounded() bool {
+	return q.QueueNumber != "" || q.Month != "" || (!q.CreatedAfter.IsZero() && !q.CreatedBefore.IsZero())
+}
+
+// statementQuery returns the query of the statements matching the filters
+// of the request, so the exports match the same statements as the listings.
+func (q *BatchGetStatementReq) statementQuery() *StatementQuery {
+	return &StatementQuery{
+		CreatedBefore:  q.CreatedBefore,
+		CreatedAfter:   q.CreatedAfter,
+		Gender:         q.Gender,
+		Status:         q.Status,
+		Occupation:     q.Occupation,
+		QueueNumber:    q.QueueNumber,
+		ProductName:    q.ProductName,
+		BankCode:       q.BankCode,
+		CreatedBy:      q.CreatedBy,
+		Term:           q.Term,
+		HasBankAccount: q.HasBankAccount,
+		Month:          q.Month,
+		Timezone:       q.Timezone,
 	}
+}
 
+// ToSql converts the filters of the request to SQL with the predicate of
+// StatementQuery, followed by the statements after nextID.
+func (q *BatchGetStatementReq) ToSql() (string, []any, error) {
+	pred := q.statementQuery().predicate()
 	if q.nextID != "" {
-		and = append(and, sq.Lt{"CUID": q.nextID})
+		pred = sq.And{pred, sq.Lt{"CUID": q.nextID}}
 	}
-
-	return and.ToSql()
+	return pred.ToSql()
 }
 
-func batchGetStatements(ctx context.Context, db *sql.DB, batchSize int, nextID string, in *BatchGetStatementReq) ([]*Statement, error) {
-	id := fmt.Sprintf("TOP %d CUID", batchSize)
+// batchGetStatements returns the batch of the statements following nextID,
+// the rows which could not be read are skipped when skip is set.
+func batchGetStatements(ctx context.Context, db queryer, d Dialect, batchSize uint64, nextID string, in *BatchGetStatementReq, skip bool) (*statementBatch, error) {
 	in.nextID = nextID
 	pred, args, err := in.ToSql()
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert to sql: %w", err)
-	}
-
-	q, args := sq.
-		Select(
-			id,
-			"cusnum",
-			"cus_name",
-			"AccNo",
-			"term",
-			"bankname",
-			"bankcreatedate",
-			"bankstatus",
-			"bankmoreinfo",
-			"gender",
-			"productnames",
-			"emailstatus",
-			"emailmsg",
-			"occupation",
-			"createby",
-			"statusBanking",
-			"createdate",
-		).
-		From("dbo.vm_customer").
-		PlaceholderFormat(sq.AtP).
-		Where(pred, args...).
-		OrderBy("CUID DESC").
-		MustSql()
-
-	rows, err := db.QueryContext(ctx, q, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %w", err)
-	}
-	defer rows.Close()
-
-	statements := make([]*Statement, 0)
-	for rows.Next() {
-		var s Statement
-		var isSent sql.NullString
-		err := rows.Scan(
-			&s.ID,
-			&s.QueueNumber,
-			&s.Customer.DisplayName,
-			&s.BankAccount.Number,
-			&s.BankAccount.Term,
-			&s.BankAccount.Code,
-			&s.BankAccount.CreatedAt,
-			&s.BankAccount.Status,
-			&s.BankAccount.Info,
-			&s.Customer.Gender,
-			&s.ProductName,
-			&isSent,
-			&s.Email.Message,
-			&s.Customer.Occupation,
-			&s.CreatedBy,
-			&s.Status,
-			&s.CreatedAt,
-		)
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrStatementNotFound
-		}
-
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
-		if isSent.Valid {
-			s.Email.IsSent = &isSent.String
-		}
-
-		statements = append(statements, &s)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+		return nil, toSqlError(err)
 	}
 
-	return statements, nil
+	// The batches are paginated by CUID only.
+	return queryStatementBatch(ctx, db, d, batchSize, 0, listOptions{order: SortByID}, sq.Expr(pred, args...), skip)
 }
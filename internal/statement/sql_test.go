@@ -0,0 +1,716 @@
+package statement
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/10664kls/estatement/internal/pager"
+	"github.com/DATA-DOG/go-sqlmock"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestStatementQueryToSqlMonth(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Vientiane")
+	if err != nil {
+		t.Fatalf("time.LoadLocation() error = %v", err)
+	}
+
+	tests := []struct {
+		month      string
+		start, end time.Time
+	}{
+		{month: "2024-02", start: time.Date(2024, 2, 1, 0, 0, 0, 0, loc), end: time.Date(2024, 3, 1, 0, 0, 0, 0, loc)},
+		{month: "2024-12", start: time.Date(2024, 12, 1, 0, 0, 0, 0, loc), end: time.Date(2025, 1, 1, 0, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		q := &StatementQuery{Month: tt.month, Timezone: "Asia/Vientiane"}
+		got, args, err := q.ToSql()
+		if err != nil {
+			t.Fatalf("ToSql(%s) error = %v", tt.month, err)
+		}
+		if !strings.Contains(got, "createdate >= ?") || !strings.Contains(got, "createdate < ?") {
+			t.Errorf("ToSql(%s) = %q, want the createdate range", tt.month, got)
+		}
+
+		var times []time.Time
+		for _, a := range args {
+			if v, ok := a.(time.Time); ok {
+				times = append(times, v)
+			}
+		}
+		if len(times) != 2 || !times[0].Equal(tt.start) || !times[1].Equal(tt.end) {
+			t.Errorf("ToSql(%s) range = %v, want [%v %v)", tt.month, times, tt.start, tt.end)
+		}
+	}
+}
+
+func TestListStatementsMalformedMonth(t *testing.T) {
+	s, _, _ := newTestService(t, Config{})
+
+	for _, month := range []string{"2025-13", "2025/03", "March"} {
+		_, err := s.ListStatements(adminContext(), &StatementQuery{Month: month})
+		if rpcstatus.Code(err) != codes.InvalidArgument {
+			t.Errorf("ListStatements(%q) error = %v, want %s", month, err, codes.InvalidArgument)
+		}
+	}
+}
+
+func TestStatementQueryToSqlHasBankAccount(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name    string
+		has     *bool
+		want    string
+		wantLen int
+	}{
+		{name: "true", has: &yes, want: "(AccNo IS NOT NULL AND AccNo <> ?)", wantLen: 2},
+		{name: "false", has: &no, want: "(AccNo IS NULL OR AccNo = ?)", wantLen: 2},
+		{name: "unset", has: nil, want: "", wantLen: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, err := (&StatementQuery{HasBankAccount: tt.has}).ToSql()
+			if err != nil {
+				t.Fatalf("ToSql() error = %v", err)
+			}
+			if tt.want != "" && !strings.Contains(got, tt.want) {
+				t.Errorf("ToSql() = %q, want %q", got, tt.want)
+			}
+			if tt.want == "" && strings.Contains(got, "AccNo") {
+				t.Errorf("ToSql() = %q, want no bank account predicate", got)
+			}
+			if len(args) != tt.wantLen {
+				t.Errorf("ToSql() args = %v, want %d args", args, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestStatementQueryToSqlBankInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		info string
+		want string
+	}{
+		{name: "plain", info: "overdraft", want: "%overdraft%"},
+		{name: "wildcards", info: "50%_off [x]!", want: "%50!%!_off ![x]!!%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, err := (&StatementQuery{BankInfo: tt.info}).ToSql()
+			if err != nil {
+				t.Fatalf("ToSql() error = %v", err)
+			}
+			if want := "bankmoreinfo LIKE ? ESCAPE '!'"; !strings.Contains(got, want) {
+				t.Errorf("ToSql() = %q, want %q", got, want)
+			}
+			if len(args) == 0 || args[0] != tt.want {
+				t.Errorf("ToSql() args = %v, want %q first", args, tt.want)
+			}
+		})
+	}
+}
+
+// modifiedRows returns the rows of the statements of the IDs along with
+// their modification time in the modifydate column.
+func modifiedRows(at map[string]time.Time, ids ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows(append(slices.Clip(statementColumns), "modifydate"))
+	for _, id := range ids {
+		rows.AddRow(append(statementRow(id, "Q"+id), at[id])...)
+	}
+	return rows
+}
+
+func TestListStatementsModifiedAt(t *testing.T) {
+	s, mock, log := newTestService(t, Config{ModifiedAtColumn: "modifydate"})
+	modified := testTime.Add(time.Hour)
+	mock.ExpectQuery("FROM dbo.vm_customer").
+		WillReturnRows(modifiedRows(map[string]time.Time{"1": modified}, "1"))
+
+	result, err := s.ListStatements(adminContext(), &StatementQuery{ModifiedAfter: testTime})
+	if err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+	if got := result.Statements[0].ModifiedAt; got == nil || !got.Equal(modified) {
+		t.Errorf("ListStatements() modifiedAt = %v, want %v", got, modified)
+	}
+
+	q := log.all()[0]
+	if !strings.Contains(q, ", modifydate FROM") {
+		t.Errorf("query = %q, want modifydate selected", q)
+	}
+	if where := whereClause(q); !strings.Contains(where, "modifydate > @p1") {
+		t.Errorf("WHERE = %q, want the modifiedAfter predicate", where)
+	}
+}
+
+func TestListStatementsModifiedAfterUnsupported(t *testing.T) {
+	s, _, log := newTestService(t, Config{})
+
+	_, err := s.ListStatements(adminContext(), &StatementQuery{ModifiedAfter: testTime})
+	if got := rpcstatus.Code(err); got != codes.InvalidArgument {
+		t.Errorf("ListStatements() code = %v, want %v", got, codes.InvalidArgument)
+	}
+	if n := len(log.all()); n != 0 {
+		t.Errorf("ListStatements() ran %d queries, want none", n)
+	}
+}
+
+func TestListStatementsUpdatedSince(t *testing.T) {
+	s, mock, log := newTestService(t, Config{ModifiedAtColumn: "modifydate"})
+	since := testTime
+	t1, t2 := testTime.Add(time.Minute), testTime.Add(2*time.Minute)
+	at := map[string]time.Time{"5": t1, "3": t1, "9": t2}
+
+	// Statements 3 and 5 were modified at the same time, the tie is broken
+	// by CUID so the second pull starts right after 5.
+	mock.ExpectQuery("ORDER BY modifydate ASC, CUID ASC").
+		WithArgs(since, inactiveRecType).
+		WillReturnRows(modifiedRows(at, "3", "5"))
+	mock.ExpectQuery("(modifydate > @p3 OR (modifydate = @p4 AND CUID > @p5))").
+		WithArgs(since, inactiveRecType, t1, t1, "5").
+		WillReturnRows(modifiedRows(at, "9"))
+	mock.ExpectQuery("(modifydate > @p3 OR (modifydate = @p4 AND CUID > @p5))").
+		WithArgs(since, inactiveRecType, t2, t2, "9").
+		WillReturnRows(modifiedRows(at))
+
+	var seen []string
+	var token string
+	for range 3 {
+		result, err := s.ListStatements(adminContext(), &StatementQuery{
+			UpdatedSince: since,
+			PageSize:     2,
+			PageToken:    token,
+		})
+		if err != nil {
+			t.Fatalf("ListStatements() error = %v", err)
+		}
+		for _, st := range result.Statements {
+			seen = append(seen, st.ID)
+		}
+		if result.NextPageToken == "" {
+			t.Fatal("ListStatements() nextPageToken is empty, want a cursor to resume from")
+		}
+		token = result.NextPageToken
+	}
+
+	if want := []string{"3", "5", "9"}; !slices.Equal(seen, want) {
+		t.Errorf("ListStatements() pulled %v, want %v", seen, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("queries = %q: %v", log.all(), err)
+	}
+}
+
+func TestListStatementsOffsetMode(t *testing.T) {
+	s, mock, log := newTestService(t, Config{})
+	query := func() *StatementQuery {
+		return &StatementQuery{ProductName: "LOAN", PageSize: 2}
+	}
+
+	mock.ExpectQuery("SELECT TOP 2").WillReturnRows(statementRows("5", "4"))
+	cursor, err := s.ListStatements(adminContext(), query())
+	if err != nil {
+		t.Fatalf("ListStatements() cursor mode error = %v", err)
+	}
+	if cursor.NextPageToken == "" || cursor.OffsetPage != nil {
+		t.Errorf("ListStatements() cursor mode = %+v, want a page token and no offset page", cursor)
+	}
+
+	mock.ExpectQuery("OFFSET @p3 ROWS FETCH NEXT @p4 ROWS ONLY").
+		WithArgs("LOAN", inactiveRecType, 2, 2).
+		WillReturnRows(statementRows("3", "2"))
+	mock.ExpectQuery("COUNT(*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	in := query()
+	in.Page = 2
+	offset, err := s.ListStatements(adminContext(), in)
+	if err != nil {
+		t.Fatalf("ListStatements() offset mode error = %v", err)
+	}
+	want := OffsetPage{Page: 2, PageSize: 2, TotalItems: 5, TotalPages: 3}
+	if offset.OffsetPage == nil || *offset.OffsetPage != want {
+		t.Errorf("ListStatements() offset page = %+v, want %+v", offset.OffsetPage, want)
+	}
+	if offset.NextPageToken != "" {
+		t.Errorf("ListStatements() offset mode nextPageToken = %q, want none", offset.NextPageToken)
+	}
+
+	queries := log.all()
+	if c, o := whereClause(queries[0]), whereClause(queries[1]); c != o {
+		t.Errorf("cursor WHERE = %q, offset WHERE = %q, want the same predicate", c, o)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestToSqlError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{
+			name: "bad filter",
+			err:  &queryError{field: "modifiedAfter", err: errors.New("modifiedAfter is not supported")},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "wrapped bad filter",
+			err:  fmt.Errorf("and: %w", &queryError{field: "pageToken", err: errors.New("pageToken is not valid")}),
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "builder",
+			err:  errors.New("select statements must have at least one result column"),
+			want: codes.Internal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := toSqlError(tt.err)
+			st := rpcstatus.Convert(err)
+			if st.Code() != tt.want {
+				t.Errorf("toSqlError() code = %v, want %v", st.Code(), tt.want)
+			}
+			if tt.want == codes.Internal && strings.Contains(st.Message(), "result column") {
+				t.Errorf("toSqlError() message = %q, want the cause hidden", st.Message())
+			}
+		})
+	}
+}
+
+func TestStatementQueryToSql(t *testing.T) {
+	got, args, err := (&StatementQuery{ProductName: "LOAN"}).ToSql()
+	if err != nil {
+		t.Fatalf("ToSql() error = %v", err)
+	}
+	if want := "(productnames = ? AND (rectype IS NULL OR rectype <> ?))"; got != want {
+		t.Errorf("ToSql() = %q, want %q", got, want)
+	}
+	if want := []any{"LOAN", inactiveRecType}; !slices.Equal(args, want) {
+		t.Errorf("ToSql() args = %v, want %v", args, want)
+	}
+}
+
+func TestStatementQueryToSqlEmailSentRange(t *testing.T) {
+	after := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	opts := listOptions{emailSentAt: "emailsentdate"}
+
+	tests := []struct {
+		name     string
+		q        *StatementQuery
+		want     string
+		wantArgs []any
+	}{
+		{
+			name:     "range",
+			q:        &StatementQuery{EmailSentAfter: after, EmailSentBefore: before, IncludeInactive: true, opts: opts},
+			want:     "(emailsentdate IS NOT NULL AND emailsentdate >= ? AND emailsentdate <= ?)",
+			wantArgs: []any{after, before},
+		},
+		{
+			name:     "after only",
+			q:        &StatementQuery{EmailSentAfter: after, IncludeInactive: true, opts: opts},
+			want:     "(emailsentdate IS NOT NULL AND emailsentdate >= ?)",
+			wantArgs: []any{after},
+		},
+		{
+			name:     "before only",
+			q:        &StatementQuery{EmailSentBefore: before, IncludeInactive: true, opts: opts},
+			want:     "(emailsentdate IS NOT NULL AND emailsentdate <= ?)",
+			wantArgs: []any{before},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, err := tt.q.ToSql()
+			if err != nil {
+				t.Fatalf("ToSql() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ToSql() = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("ToSql() args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestStatementQueryToSqlEmailSentUnsupported(t *testing.T) {
+	_, _, err := (&StatementQuery{EmailSentAfter: time.Now()}).ToSql()
+	if code := rpcstatus.Code(toSqlError(err)); code != codes.InvalidArgument {
+		t.Errorf("ToSql() code = %v, want %v", code, codes.InvalidArgument)
+	}
+}
+
+func TestStatementQueryToSqlQueueNumbers(t *testing.T) {
+	tests := []struct {
+		name     string
+		number   string
+		want     string
+		wantArgs []any
+	}{
+		{name: "single", number: "Q1", want: "(cusnum = ?)", wantArgs: []any{"Q1"}},
+		{name: "multiple", number: "Q1, Q2,Q3", want: "(cusnum IN (?,?,?))", wantArgs: []any{"Q1", "Q2", "Q3"}},
+		{name: "duplicates", number: "Q1,Q1, ,Q2", want: "(cusnum IN (?,?))", wantArgs: []any{"Q1", "Q2"}},
+		{name: "one after trimming", number: " Q1 ,", want: "(cusnum = ?)", wantArgs: []any{"Q1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, err := (&StatementQuery{QueueNumber: tt.number, IncludeInactive: true}).ToSql()
+			if err != nil {
+				t.Fatalf("ToSql() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ToSql() = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("ToSql() args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestStatementQueryToSqlHasEmailMessage(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name string
+		has  *bool
+		want string
+	}{
+		{name: "true", has: &yes, want: "((emailmsg IS NOT NULL AND emailmsg <> ?))"},
+		{name: "false", has: &no, want: "((emailmsg IS NULL OR emailmsg = ?))"},
+		{name: "unset", has: nil, want: "(1=1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, err := (&StatementQuery{HasEmailMessage: tt.has, IncludeInactive: true}).ToSql()
+			if err != nil {
+				t.Fatalf("ToSql() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ToSql() = %q, want %q", got, tt.want)
+			}
+			if tt.has != nil && !slices.Equal(args, []any{""}) {
+				t.Errorf("ToSql() args = %v, want [\"\"]", args)
+			}
+		})
+	}
+}
+
+func TestListStatementsPlaceholders(t *testing.T) {
+	for _, order := range []SortOrder{SortByID, SortByCreatedAt, SortByBankCreatedAt} {
+		s, mock, log := newTestService(t, Config{DefaultSort: order})
+		mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("2", "1"))
+		mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+		query := func() *StatementQuery {
+			return &StatementQuery{ProductName: "LOAN", CreatedAfter: testTime.AddDate(0, -1, 0), PageSize: 2}
+		}
+		first, err := s.ListStatements(adminContext(), query())
+		if err != nil {
+			t.Fatalf("ListStatements() error = %v", err)
+		}
+		next := query()
+		next.PageToken = first.NextPageToken
+		if _, err := s.ListStatements(adminContext(), next); err != nil {
+			t.Fatalf("ListStatements(%s) error = %v", first.NextPageToken, err)
+		}
+
+		q := log.all()[1]
+		if strings.Contains(q, "?") {
+			t.Errorf("query = %q, want no ? placeholder", q)
+		}
+		// The placeholders of the filters and of the cursor are numbered
+		// in a single sequence.
+		matches := regexp.MustCompile(`@p(\d+)`).FindAllStringSubmatch(q, -1)
+		if len(matches) < 4 {
+			t.Fatalf("query = %q, want the cursor bound along with the filters", q)
+		}
+		for i, m := range matches {
+			if m[1] != strconv.Itoa(i+1) {
+				t.Errorf("query = %q, placeholder %d is @p%s", q, i+1, m[1])
+				break
+			}
+		}
+	}
+}
+
+func TestListStatementsTopClause(t *testing.T) {
+	tests := []struct {
+		size uint64
+		want string
+	}{
+		{0, "SELECT TOP 20 "},
+		{1, "SELECT TOP 1 "},
+		{pager.MaxSize, "SELECT TOP 200 "},
+		{pager.MaxSize + 1, "SELECT TOP 200 "},
+		{^uint64(0), "SELECT TOP 200 "},
+	}
+	for _, tt := range tests {
+		s, mock, log := newTestService(t, Config{})
+		mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+		if _, err := s.ListStatements(userContext(), &StatementQuery{PageSize: tt.size}); err != nil {
+			t.Fatalf("ListStatements(%d) error = %v", tt.size, err)
+		}
+		if q := log.all()[0]; !strings.HasPrefix(q, tt.want) {
+			t.Errorf("ListStatements(%d) query = %q, want prefix %q", tt.size, q, tt.want)
+		}
+	}
+}
+
+// violationFields returns the fields of the violations of the error.
+func violationFields(err error) []string {
+	var fields []string
+	for _, d := range rpcstatus.Convert(err).Details() {
+		if br, ok := d.(*edpb.BadRequest); ok {
+			for _, v := range br.GetFieldViolations() {
+				fields = append(fields, v.GetField())
+			}
+		}
+	}
+	return fields
+}
+
+func TestBatchGetStatementReqValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		req  BatchGetStatementReq
+		want string
+	}{
+		{name: "valid", req: BatchGetStatementReq{
+			CreatedAfter:  testTime.AddDate(0, -1, 0),
+			CreatedBefore: testTime,
+			Timezone:      "Asia/Vientiane",
+			Delimiter:     "semicolon",
+			QueueNumber:   "Q1",
+			Month:         "2025-03",
+		}},
+		{name: "date range", req: BatchGetStatementReq{CreatedAfter: testTime, CreatedBefore: testTime.AddDate(0, -1, 0)}, want: "createdAfter"},
+		{name: "timezone", req: BatchGetStatementReq{Timezone: "Mars/Olympus"}, want: "timezone"},
+		{name: "null placeholder", req: BatchGetStatementReq{NullPlaceholder: strings.Repeat("x", maxCellLen+1)}, want: "nullPlaceholder"},
+		{name: "delimiter", req: BatchGetStatementReq{Delimiter: "pipe"}, want: "delimiter"},
+		{name: "queue number", req: BatchGetStatementReq{QueueNumber: "Q1,Q2"}, want: "queueNumber"},
+		{name: "month", req: BatchGetStatementReq{Month: "03/2025"}, want: "month"},
+		{name: "profile", req: BatchGetStatementReq{Profile: "finance"}, want: "profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if code := rpcstatus.Code(err); code != codes.InvalidArgument {
+				t.Fatalf("Validate() code = %v, want %v", code, codes.InvalidArgument)
+			}
+			if fields := violationFields(err); !slices.Equal(fields, []string{tt.want}) {
+				t.Errorf("Validate() violations = %v, want [%s]", fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenExcelInvalidRequest(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+
+	_, err := s.GenExcel(adminContext(), &BatchGetStatementReq{Month: "March"})
+	if code := rpcstatus.Code(err); code != codes.InvalidArgument {
+		t.Errorf("GenExcel() code = %v, want %v", code, codes.InvalidArgument)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateExportProfile(t *testing.T) {
+	s, _, _ := newTestService(t, Config{ExportProfiles: []ExportProfile{
+		{Name: "finance", Columns: []ExportColumn{{Key: "Gender"}}},
+	}})
+
+	if err := s.ValidateExport(&BatchGetStatementReq{Profile: "finance"}); err != nil {
+		t.Errorf("ValidateExport(registered profile) error = %v, want nil", err)
+	}
+
+	err := s.ValidateExport(&BatchGetStatementReq{Profile: "audit", Month: "March"})
+	if fields := violationFields(err); !slices.Equal(fields, []string{"month", "profile"}) {
+		t.Errorf("ValidateExport() violations = %v, want [month profile] in one error", fields)
+	}
+}
+
+func TestStatementQueryToSqlGenders(t *testing.T) {
+	male := []any{"M", "MALE", "Male", "male", "m"}
+	female := []any{"F", "FEMALE", "Female", "female", "f"}
+	tests := []struct {
+		name       string
+		gender     Gender
+		wantGender Gender
+		wantArgs   []any
+	}{
+		{name: "single", gender: "m", wantGender: "MALE", wantArgs: male},
+		{name: "single other", gender: " X ", wantGender: "X", wantArgs: []any{"X"}},
+		{name: "multiple", gender: "male, F,X", wantGender: "MALE,FEMALE,X", wantArgs: slices.Concat(male, female, []any{"X"})},
+		{name: "duplicates", gender: "M,male, ,f", wantGender: "MALE,FEMALE", wantArgs: slices.Concat(male, female)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &StatementQuery{Gender: tt.gender, IncludeInactive: true}
+			q.Normalize()
+			if q.Gender != tt.wantGender {
+				t.Errorf("Normalize() gender = %q, want %q", q.Gender, tt.wantGender)
+			}
+
+			got, args, err := q.ToSql()
+			if err != nil {
+				t.Fatalf("ToSql() error = %v", err)
+			}
+			want := "(gender IN (" + strings.Repeat(",?", len(tt.wantArgs))[1:] + "))"
+			if got != want {
+				t.Errorf("ToSql() = %q, want %q", got, want)
+			}
+			if !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("ToSql() args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestBatchGetStatementReqToSqlSharesPredicate(t *testing.T) {
+	hasAccount := true
+	req := &BatchGetStatementReq{
+		ProductName:    "LOAN",
+		BankCode:       "BCEL",
+		HasBankAccount: &hasAccount,
+		Month:          "2025-03",
+		Timezone:       "UTC",
+	}
+	query := &StatementQuery{
+		ProductName:    "LOAN",
+		BankCode:       "BCEL",
+		HasBankAccount: &hasAccount,
+		Month:          "2025-03",
+		Timezone:       "UTC",
+	}
+
+	got, gotArgs, err := req.ToSql()
+	if err != nil {
+		t.Fatalf("BatchGetStatementReq.ToSql() error = %v", err)
+	}
+	want, wantArgs, err := query.ToSql()
+	if err != nil {
+		t.Fatalf("StatementQuery.ToSql() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("BatchGetStatementReq.ToSql() = %q, want %q", got, want)
+	}
+	if len(gotArgs) != len(wantArgs) {
+		t.Errorf("BatchGetStatementReq.ToSql() args = %v, want %v", gotArgs, wantArgs)
+	}
+	if !strings.Contains(got, "rectype") {
+		t.Errorf("BatchGetStatementReq.ToSql() = %q, want the inactive statements excluded", got)
+	}
+}
+
+func TestBatchGetStatementReqToSqlAfterNextID(t *testing.T) {
+	req := &BatchGetStatementReq{nextID: "C100"}
+
+	got, args, err := req.ToSql()
+	if err != nil {
+		t.Fatalf("ToSql() error = %v", err)
+	}
+	if !strings.Contains(got, "CUID < ?") {
+		t.Errorf("ToSql() = %q, want the statements after nextID", got)
+	}
+	if args[len(args)-1] != "C100" {
+		t.Errorf("ToSql() args = %v, want nextID last", args)
+	}
+}
+
+func TestListAndCountSharePredicate(t *testing.T) {
+	s, mock, log := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1", "2"))
+	mock.ExpectQuery("COUNT(*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	query := func() *StatementQuery {
+		return &StatementQuery{
+			ProductName:  "LOAN",
+			Gender:       "M",
+			CreatedAfter: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+
+	ctx := userContext()
+	if _, err := s.ListStatements(ctx, query()); err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+	if _, err := s.CountStatements(ctx, query()); err != nil {
+		t.Fatalf("CountStatements() error = %v", err)
+	}
+
+	queries := log.all()
+	if len(queries) != 2 {
+		t.Fatalf("ran %d queries, want 2", len(queries))
+	}
+	list, count := whereClause(queries[0]), whereClause(queries[1])
+	if list == "" || list != count {
+		t.Errorf("list WHERE = %q, count WHERE = %q, want the same predicate", list, count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListStatementsResultWindow(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{MaxResultWindow: 4})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("4", "3"))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("2", "1"))
+
+	ctx := userContext()
+	first, err := s.ListStatements(ctx, &StatementQuery{PageSize: 2})
+	if err != nil {
+		t.Fatalf("ListStatements(page 1) error = %v", err)
+	}
+	second, err := s.ListStatements(ctx, &StatementQuery{PageSize: 2, PageToken: first.NextPageToken})
+	if err != nil {
+		t.Fatalf("ListStatements(page 2) error = %v", err)
+	}
+	if second.NextPageToken == "" {
+		t.Fatal("ListStatements(page 2) has no next page token")
+	}
+
+	_, err = s.ListStatements(ctx, &StatementQuery{PageSize: 2, PageToken: second.NextPageToken})
+	if code := rpcstatus.Code(err); code != codes.InvalidArgument {
+		t.Errorf("ListStatements(past the window) code = %v, want %v", code, codes.InvalidArgument)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListStatementsRejectsForgedPageToken(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{MaxResultWindow: 4})
+
+	// An unsigned token with its offset reset would page past the window.
+	token := pager.EncodeCursor(&pager.Cursor{ID: "1", Offset: 0})
+	_, err := s.ListStatements(userContext(), &StatementQuery{PageSize: 2, PageToken: token})
+	if code := rpcstatus.Code(err); code != codes.InvalidArgument {
+		t.Errorf("ListStatements() code = %v, want %v", code, codes.InvalidArgument)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
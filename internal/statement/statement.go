@@ -2,92 +2,825 @@ package statement
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"errors"
-	"sync"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/10664kls/estatement/internal/auth"
+	"github.com/10664kls/estatement/internal/errs"
 	"github.com/10664kls/estatement/internal/pager"
+	sq "github.com/Masterminds/squirrel"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/text/language"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	rpcstatus "google.golang.org/grpc/status"
 )
 
-// ErrStatementNotFound is returned when the statement is not found.
-var ErrStatementNotFound = errors.New("statement not found")
+// ErrStatementNotFound matches the error returned when a statement is not
+// found, it converts to a codes.NotFound status.
+var ErrStatementNotFound error = &errs.NotFoundError{Resource: "statement"}
 
 type Service struct {
-	db   *sql.DB
-	zlog *zap.Logger
+	db         queryer
+	primary    *sql.DB
+	replica    *sql.DB
+	zlog       *zap.Logger
+	dialect    Dialect
+	profiles   map[string]*ExportProfile
+	redact     bool
+	signingKey []byte
+	tracer     trace.Tracer
 
-	mu *sync.RWMutex
+	exportSlots       chan struct{}
+	exportQueue       chan struct{}
+	exportWaitTimeout time.Duration
+	restricted        map[string]bool
+	requireBounded    bool
+	jobs              *jobStore
+	sort              SortOrder
+	emailSent         string
+	emailFailed       string
+	masks             map[string]MaskPolicy
+	lookups           *lookupCache
+	emailFallback     language.Tag
+	bankStatus        BankStatusProvider
+	emailDelivery     EmailDeliveryProvider
+	modifiedAt        string
+	emailSentAt       string
+	table             string
+	maxResultWindow   uint64
+	logPII            bool
+	queryConcurrency  int
+	exports           *exportCache
+	cursors           *pager.Signer
 }
 
-func NewService(_ context.Context, db *sql.DB, zlog *zap.Logger) (*Service, error) {
+// Config defines the config for statement service.
+type Config struct {
+	// Dialect is the SQL flavor of the database.
+	// Optional. Default value SQLServer.
+	Dialect Dialect
+
+	// ExportProfiles are the named export layouts selectable by
+	// BatchGetStatementReq.Profile.
+	// Optional. Default value is empty, only the default layout is available.
+	ExportProfiles []ExportProfile
+
+	// RedactListDetails removes the email message and the bank account info
+	// from the statements returned by ListStatements and StreamStatements.
+	// They are still returned by GetStatementByID.
+	// Optional. Default value false.
+	RedactListDetails bool
+
+	// ExportSigningKey is the HMAC key used to sign the export download links.
+	// Optional. Default value nil, signed links are disabled.
+	ExportSigningKey []byte
+
+	// SlowQueryThreshold is the duration above which a query is logged as slow.
+	// Optional. Default value 1 second.
+	SlowQueryThreshold time.Duration
+
+	// Tracer is used to trace the service operations.
+	// Optional. Default value is the tracer of the global provider.
+	Tracer trace.Tracer
+
+	// MaxConcurrentExports is the maximum number of exports running at once.
+	// Optional. Default value 2.
+	MaxConcurrentExports int
+
+	// ExportWaitTimeout is how long an export waits for a free slot before
+	// being rejected with codes.ResourceExhausted.
+	// Optional. Default value 0, exports are rejected right away when all the
+	// slots are taken.
+	ExportWaitTimeout time.Duration
+
+	// RestrictedExportColumns are the export column keys (e.g. "EmailMsg")
+	// only admins are allowed to export, they are dropped from the exports of
+	// the other users.
+	// Optional. Default value is empty, every column is exportable.
+	RestrictedExportColumns []string
+
+	// RequireBoundedExport rejects the exports of non-admins which are not
+	// bounded by both createdAfter and createdBefore, or by a queue number.
+	// Optional. Default value false.
+	RequireBoundedExport bool
+
+	// DefaultSort is the order the statements are listed in.
+	// Optional. Default value SortByID.
+	DefaultSort SortOrder
+
+	// StatementTable is the base table behind dbo.vm_customer the updates of
+	// the statements, e.g. their email status, are written to. They're keyed
+	// by the CUID of the statements.
+	// Optional. Default value "dbo.tb_customer".
+	StatementTable string
+
+	// EmailSentStatus is the emailstatus value of the sent emails.
+	// Optional. Default value "SENT".
+	EmailSentStatus string
+
+	// EmailFailedStatus is the emailstatus value of the failed emails.
+	// Optional. Default value "FAILED".
+	EmailFailedStatus string
+
+	// MaskPolicies are the masking policies applied to the statements
+	// returned to the users of a role, keyed by role. The export profiles can
+	// mask more details.
+	// Optional. Default value is empty, nothing is masked.
+	MaskPolicies map[string]MaskPolicy
+
+	// LookupCacheTTL is how long the product names, occupations and terms
+	// are cached.
+	// Optional. Default value 0, the lookups are not cached.
+	LookupCacheTTL time.Duration
+
+	// LookupRefreshInterval reloads the cached lookups in the background at
+	// this interval, until the context given to NewService is done. It
+	// should be shorter than LookupCacheTTL.
+	// Optional. Default value 0, the lookups are only reloaded on demand.
+	LookupRefreshInterval time.Duration
+
+	// EmailFallbackLocale is the locale of the statement emails when the
+	// requested one has no template, one of "en", "lo" or "th".
+	// Optional. Default value "en".
+	EmailFallbackLocale string
+
+	// BankStatusProvider fetches the bank status of the statements for
+	// RefreshBankStatus.
+	// Optional. Default value nil, the refresh is not available.
+	BankStatusProvider BankStatusProvider
+
+	// EmailDeliveryProvider fetches the delivery status of the statement
+	// emails for ReconcileEmailStatus.
+	// Optional. Default value nil, the reconciliation is not available.
+	EmailDeliveryProvider EmailDeliveryProvider
+
+	// ModifiedAtColumn is the column of dbo.vm_customer holding the last
+	// modification time of the statements, if the view exposes one. It's
+	// returned as Statement.ModifiedAt and filtered by modifiedAfter.
+	// Optional. Default value "", the statements have no modification time.
+	ModifiedAtColumn string
+
+	// EmailSentAtColumn is the column of dbo.vm_customer holding the time
+	// the email of the statements was sent, if the view exposes one. It's
+	// filtered by emailSentAfter and emailSentBefore.
+	// Optional. Default value "", the statements have no email sent time.
+	EmailSentAtColumn string
+
+	// MaxResultWindow is how many statements a chain of pages of
+	// ListStatements may return, the page following it is rejected.
+	// The incremental pulls with updatedSince are not limited.
+	// Optional. Default value 100000.
+	MaxResultWindow uint64
+
+	// ReadReplica is a read-only replica of the database the statements,
+	// exports, lookups and summaries are read from, to spare the primary.
+	// The writes, and the reads they depend on, always go to the primary.
+	// Optional. Default value nil, everything is read from the primary.
+	ReadReplica *sql.DB
+
+	// QueryConcurrency is how many of the queries of a single request run at
+	// once, e.g. the sections of the summary workbook or the lookups of the
+	// filters. The first failing query cancels the others.
+	// Optional. Default value 1, they run one after the other.
+	QueryConcurrency int
+
+	// ExportJobTTL is how long the finished export jobs are kept, along with
+	// their content, before they're evicted.
+	// Optional. Default value 24 hours.
+	ExportJobTTL time.Duration
+
+	// ExportJobMaxBytes caps the total size of the content of the finished
+	// export jobs kept in memory, the oldest ones are evicted first.
+	// Optional. Default value 512 MiB.
+	ExportJobMaxBytes int64
+
+	// MaxQueuedExportJobs is the maximum number of export jobs waiting for an
+	// export slot, the new jobs are rejected with codes.ResourceExhausted
+	// while the queue is full.
+	// Optional. Default value 10.
+	MaxQueuedExportJobs int
+
+	// ExportCacheSize is how many generated workbooks are kept to serve the
+	// same export again while the data it's made of is unchanged, i.e. the
+	// count, latest creation and latest modification of the matching
+	// statements. The cache is purged by the updates of the service.
+	// Optional. Default value 0, the exports are not cached.
+	ExportCacheSize int
+
+	// LogPII logs the filters of the queries identifying the customers, e.g.
+	// the queue number, they're redacted otherwise.
+	// Optional. Default value false.
+	LogPII bool
+
+	// PageTokenSigningKey is the HMAC key the page tokens of ListStatements
+	// are signed with, so the clients can't reset their offset to page past
+	// the MaxResultWindow. The instances serving the same clients must share
+	// it.
+	// Optional. Default value is a random key, the page tokens are then only
+	// valid on this instance until it restarts.
+	PageTokenSigningKey []byte
+}
+
+func NewService(ctx context.Context, db *sql.DB, zlog *zap.Logger, cfg Config) (*Service, error) {
+	if cfg.Dialect.PlaceholderFormat == nil {
+		cfg.Dialect = SQLServer
+	}
+	if cfg.SlowQueryThreshold <= 0 {
+		cfg.SlowQueryThreshold = time.Second
+	}
+	if cfg.Tracer == nil {
+		cfg.Tracer = otel.Tracer(tracerName)
+	}
+	if cfg.ExportJobTTL <= 0 {
+		cfg.ExportJobTTL = 24 * time.Hour
+	}
+	if cfg.ExportJobMaxBytes <= 0 {
+		cfg.ExportJobMaxBytes = 512 << 20
+	}
+	if cfg.MaxConcurrentExports <= 0 {
+		cfg.MaxConcurrentExports = 2
+	}
+	if cfg.MaxQueuedExportJobs <= 0 {
+		cfg.MaxQueuedExportJobs = 10
+	}
+	if cfg.StatementTable == "" {
+		cfg.StatementTable = "dbo.tb_customer"
+	}
+	if cfg.EmailSentStatus == "" {
+		cfg.EmailSentStatus = "SENT"
+	}
+	if cfg.EmailFailedStatus == "" {
+		cfg.EmailFailedStatus = "FAILED"
+	}
+	if cfg.MaxResultWindow == 0 {
+		cfg.MaxResultWindow = 100000
+	}
+	if cfg.QueryConcurrency <= 0 {
+		cfg.QueryConcurrency = 1
+	}
+	if len(cfg.PageTokenSigningKey) == 0 {
+		cfg.PageTokenSigningKey = make([]byte, 32)
+		if _, err := rand.Read(cfg.PageTokenSigningKey); err != nil {
+			return nil, fmt.Errorf("failed to gen page token signing key: %w", err)
+		}
+	}
+
+	emailFallback := language.English
+	if cfg.EmailFallbackLocale != "" {
+		tag, err := language.Parse(cfg.EmailFallbackLocale)
+		if err != nil {
+			return nil, fmt.Errorf("invalid email fallback locale: %w", err)
+		}
+		if _, ok := emailTemplates[tag]; !ok {
+			return nil, fmt.Errorf("email fallback locale %q has no template", cfg.EmailFallbackLocale)
+		}
+		emailFallback = tag
+	}
+
+	restricted := make(map[string]bool, len(cfg.RestrictedExportColumns))
+	for _, key := range cfg.RestrictedExportColumns {
+		if _, ok := exportColumns[key]; !ok {
+			return nil, fmt.Errorf("restricted export column %q is unknown", key)
+		}
+		restricted[key] = true
+	}
+
+	profiles := make(map[string]*ExportProfile, len(cfg.ExportProfiles))
+	for i := range cfg.ExportProfiles {
+		p := &cfg.ExportProfiles[i]
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := profiles[p.Name]; ok {
+			return nil, fmt.Errorf("export profile %q is registered more than once", p.Name)
+		}
+		profiles[p.Name] = p
+	}
+
+	reads := db
+	if cfg.ReadReplica != nil {
+		reads = cfg.ReadReplica
+	}
+
 	s := &Service{
-		db:   db,
-		zlog: zlog,
-		mu:   new(sync.RWMutex),
+		db: &tracedDB{
+			db:        reads,
+			zlog:      zlog,
+			threshold: cfg.SlowQueryThreshold,
+		},
+		primary:    db,
+		replica:    cfg.ReadReplica,
+		zlog:       zlog,
+		dialect:    cfg.Dialect,
+		profiles:   profiles,
+		redact:     cfg.RedactListDetails,
+		signingKey: cfg.ExportSigningKey,
+		tracer:     cfg.Tracer,
+
+		exportSlots:       make(chan struct{}, cfg.MaxConcurrentExports),
+		exportQueue:       make(chan struct{}, cfg.MaxQueuedExportJobs),
+		exportWaitTimeout: cfg.ExportWaitTimeout,
+		restricted:        restricted,
+		requireBounded:    cfg.RequireBoundedExport,
+		jobs:              newJobStore(cfg.ExportJobTTL, cfg.ExportJobMaxBytes),
+		sort:              cfg.DefaultSort,
+		emailSent:         cfg.EmailSentStatus,
+		emailFailed:       cfg.EmailFailedStatus,
+		masks:             cfg.MaskPolicies,
+		lookups:           newLookupCache(cfg.LookupCacheTTL),
+		emailFallback:     emailFallback,
+		bankStatus:        cfg.BankStatusProvider,
+		emailDelivery:     cfg.EmailDeliveryProvider,
+		modifiedAt:        cfg.ModifiedAtColumn,
+		emailSentAt:       cfg.EmailSentAtColumn,
+		table:             cfg.StatementTable,
+		maxResultWindow:   cfg.MaxResultWindow,
+		logPII:            cfg.LogPII,
+		queryConcurrency:  cfg.QueryConcurrency,
+		exports:           newExportCache(cfg.ExportCacheSize),
+		cursors:           pager.NewSigner(cfg.PageTokenSigningKey),
+	}
+
+	if cfg.LookupRefreshInterval > 0 {
+		ticker := time.NewTicker(cfg.LookupRefreshInterval)
+		go func() {
+			defer ticker.Stop()
+			s.refreshLookups(ctx, ticker.C)
+		}()
 	}
 
 	return s, nil
 }
 
+// listOptions returns the settings the statements are queried with.
+func (s *Service) listOptions() listOptions {
+	return listOptions{
+		order:       s.sort,
+		modifiedAt:  s.modifiedAt,
+		emailSentAt: s.emailSentAt,
+		cursors:     s.cursors,
+	}
+}
+
+// prepareQuery normalizes the query, sets the settings it's run with and
+// validates it. It's the single place the queries of the statements are
+// checked, including whether the current user is allowed to include the
+// inactive statements.
+func (s *Service) prepareQuery(ctx context.Context, in *StatementQuery) error {
+	in.Normalize()
+	in.withOptions(s.listOptions())
+	if err := in.Validate(); err != nil {
+		return err
+	}
+	return authorizeQuery(ctx, in)
+}
+
+// authorizeQuery returns an error if the current user is not allowed to run
+// the query, only admins are allowed to include the inactive statements.
+func authorizeQuery(ctx context.Context, in *StatementQuery) error {
+	if in.IncludeInactive && !auth.ClaimsFromContext(ctx).IsAdmin() {
+		return rpcstatus.Error(codes.PermissionDenied, "You are not allowed to list inactive statements.")
+	}
+	return nil
+}
+
+// queryGroup returns the group running the queries of a request, at most
+// QueryConcurrency at once. The returned context is cancelled by the first
+// failing query.
+func (s *Service) queryGroup(ctx context.Context) (*errgroup.Group, context.Context) {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(s.queryConcurrency)
+	return g, ctx
+}
+
+// Ping checks the connection to the database, and to its replica when
+// one is configured.
+func (s *Service) Ping(ctx context.Context) error {
+	if err := s.primary.PingContext(ctx); err != nil {
+		return err
+	}
+	if s.replica != nil {
+		if err := s.replica.PingContext(ctx); err != nil {
+			return fmt.Errorf("replica: %w", err)
+		}
+	}
+	return nil
+}
+
 func (s *Service) ListStatements(ctx context.Context, in *StatementQuery) (*ListStatementsResult, error) {
 	zlog := s.zlog.With(
 		zap.String("method", "ListStatements"),
-		zap.Any("query", in),
+		s.queryField(in),
 	)
+	ctx = withOperation(ctx, "ListStatements")
+
+	ctx, span := s.tracer.Start(ctx, "statement.ListStatements",
+		trace.WithAttributes(attribute.Int("statement.filter_count", in.filterCount())),
+	)
+	defer span.End()
 
 	zlog.Info("starting to list statements")
 
-	statements, err := listStatements(ctx, s.db, in)
+	if err := s.prepareQuery(ctx, in); err != nil {
+		zlog.Info("query is not valid", zap.Error(err))
+		recordError(span, err)
+		return nil, err
+	}
+	offset := in.offset()
+	if in.UpdatedSince.IsZero() && offset >= s.maxResultWindow {
+		zlog.Info("result window exceeded", zap.Uint64("offset", offset))
+		return nil, errResultWindowExceeded(s.maxResultWindow)
+	}
+
+	var statements []*Statement
+	err := s.retryDeadlock(ctx, func() (err error) {
+		statements, err = listStatements(ctx, s.db, s.dialect, in)
+		return err
+	})
 	if err != nil {
 		zlog.Error("failed to list statements", zap.Error(err))
+		recordError(span, err)
 		return nil, err
 	}
+	span.SetAttributes(attribute.Int("statement.row_count", len(statements)))
 
-	var pageToken string
-	if l := len(statements); l > 0 && l == int(pager.Size(in.PageSize)) {
-		last := statements[l-1]
-		pageToken = pager.EncodeCursor(&pager.Cursor{
-			ID:   last.ID,
-			Time: last.CreatedAt,
-		})
+	if in.ResolveCreator {
+		if err := resolveCreators(ctx, s.db, s.dialect, statements); err != nil {
+			zlog.Error("failed to resolve creators", zap.Error(err))
+			recordError(span, err)
+			return nil, err
+		}
+	}
+
+	mask := s.maskPolicy(ctx)
+	for _, statement := range statements {
+		if s.redact {
+			statement.redactDetails()
+		}
+		mask.apply(statement)
 	}
 
-	return &ListStatementsResult{
+	next := func() *pager.Cursor {
+		c := in.opts.cursor(statements[len(statements)-1])
+		c.Offset = offset + uint64(len(statements))
+		return c
+	}
+	pageToken := in.opts.cursors.NextPageToken(len(statements), in.PageSize, next)
+	if !in.UpdatedSince.IsZero() {
+		// The incremental pulls always resume from the last statement seen.
+		pageToken = in.PageToken
+		if len(statements) > 0 {
+			pageToken = in.opts.cursors.EncodeCursor(next())
+		}
+	}
+
+	result := &ListStatementsResult{
 		Statements:    statements,
 		NextPageToken: pageToken,
+	}
+	if in.Page > 0 {
+		total, err := countStatements(ctx, s.db, s.dialect, in)
+		if err != nil {
+			zlog.Error("failed to count statements", zap.Error(err))
+			recordError(span, err)
+			return nil, err
+		}
+
+		size := pager.Size(in.PageSize)
+		result.NextPageToken = ""
+		result.OffsetPage = &OffsetPage{
+			Page:       in.Page,
+			PageSize:   size,
+			TotalItems: total,
+			TotalPages: (total + int64(size) - 1) / int64(size),
+		}
+	}
+
+	return result, nil
+}
+
+// GetStatementsByAccount returns the statements of the bank account number,
+// an account may have several statements. At most pager.MaxSize statements
+// are returned.
+func (s *Service) GetStatementsByAccount(ctx context.Context, accNo string) ([]*Statement, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "GetStatementsByAccount"),
+		s.piiField("accNo", accNo),
+	)
+	ctx = withOperation(ctx, "GetStatementsByAccount")
+
+	zlog.Info("starting to get statements by account")
+
+	accNo = strings.TrimSpace(accNo)
+	if accNo == "" {
+		return nil, errs.NotFound("statement", accNo)
+	}
+
+	in := &StatementQuery{opts: s.listOptions()}
+	pred := sq.And{in.predicate(), sq.Eq{"AccNo": accNo}}
+	statements, err := queryStatements(ctx, s.db, s.dialect, pager.MaxSize, 0, in.opts, pred)
+	if err != nil {
+		zlog.Error("failed to get statements by account", zap.Error(err))
+		return nil, err
+	}
+	if len(statements) == 0 {
+		zlog.Warn("statement not found")
+		return nil, errs.NotFound("statement", accNo)
+	}
+
+	mask := s.maskPolicy(ctx)
+	for _, statement := range statements {
+		mask.apply(statement)
+	}
+	return statements, nil
+}
+
+// CountStatements counts the statements matching the query, with the same
+// filters and validation as ListStatements. The pagination of the query is
+// ignored.
+func (s *Service) CountStatements(ctx context.Context, in *StatementQuery) (int64, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "CountStatements"),
+		s.queryField(in),
+	)
+	ctx = withOperation(ctx, "CountStatements")
+
+	zlog.Info("starting to count statements")
+
+	if err := s.prepareQuery(ctx, in); err != nil {
+		zlog.Info("query is not valid", zap.Error(err))
+		return 0, err
+	}
+
+	count, err := countStatements(ctx, s.db, s.dialect, in)
+	if err != nil {
+		zlog.Error("failed to count statements", zap.Error(err))
+		return 0, err
+	}
+	return count, nil
+}
+
+// StreamStatements walks all the statements matching the query page by page
+// and calls fn for each of them, until fn returns an error or ctx is done.
+// The PageSize and Page of the query are ignored, pages of pager.MaxSize are used.
+func (s *Service) StreamStatements(ctx context.Context, in *StatementQuery, fn func(*Statement) error) error {
+	zlog := s.zlog.With(
+		zap.String("method", "StreamStatements"),
+		s.queryField(in),
+	)
+	ctx = withOperation(ctx, "StreamStatements")
+
+	zlog.Info("starting to stream statements")
+
+	if err := s.prepareQuery(ctx, in); err != nil {
+		zlog.Info("query is not valid", zap.Error(err))
+		return err
+	}
+
+	q := *in
+	q.PageSize = pager.MaxSize
+	q.Page = 0
+	mask := s.maskPolicy(ctx)
+	for {
+		if err := ctx.Err(); err != nil {
+			zlog.Info("stream stopped", zap.Error(err))
+			return err
+		}
+
+		statements, err := listStatements(ctx, s.db, s.dialect, &q)
+		if err != nil {
+			zlog.Error("failed to list statements", zap.Error(err))
+			return err
+		}
+
+		if q.ResolveCreator {
+			if err := resolveCreators(ctx, s.db, s.dialect, statements); err != nil {
+				zlog.Error("failed to resolve creators", zap.Error(err))
+				return err
+			}
+		}
+
+		for _, statement := range statements {
+			if s.redact {
+				statement.redactDetails()
+			}
+			mask.apply(statement)
+			if err := fn(statement); err != nil {
+				return err
+			}
+		}
+
+		if len(statements) < int(q.PageSize) {
+			return nil
+		}
+
+		last := statements[len(statements)-1]
+		q.PageToken = q.opts.cursors.EncodeCursor(q.opts.cursor(last))
+	}
+}
+
+type ValidateQueryResult struct {
+	Valid           bool                              `json:"valid"`
+	Violations      []*edpb.BadRequest_FieldViolation `json:"violations"`
+	TotalMatching   int64                             `json:"totalMatching"`
+	NormalizedQuery *StatementQuery                   `json:"normalizedQuery"`
+}
+
+// ValidateQuery validates the query and counts the statements matching it
+// without fetching them.
+func (s *Service) ValidateQuery(ctx context.Context, in *StatementQuery) (*ValidateQueryResult, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "ValidateQuery"),
+		s.queryField(in),
+	)
+	ctx = withOperation(ctx, "ValidateQuery")
+
+	zlog.Info("starting to validate query")
+
+	in.Normalize()
+	in.withOptions(s.listOptions())
+	if violations := in.violations(); len(violations) > 0 {
+		zlog.Info("query is not valid")
+		return &ValidateQueryResult{
+			Valid:      false,
+			Violations: violations,
+		}, nil
+	}
+	if err := authorizeQuery(ctx, in); err != nil {
+		zlog.Info("query is not allowed", zap.Error(err))
+		return nil, err
+	}
+
+	normalized := *in
+	normalized.PageSize = pager.Size(in.PageSize)
+
+	count, err := countStatements(ctx, s.db, s.dialect, &normalized)
+	if err != nil {
+		zlog.Error("failed to count statements", zap.Error(err))
+		return nil, err
+	}
+
+	return &ValidateQueryResult{
+		Valid:           true,
+		Violations:      make([]*edpb.BadRequest_FieldViolation, 0),
+		TotalMatching:   count,
+		NormalizedQuery: &normalized,
 	}, nil
 }
 
+// ColumnMapping returns the columns of dbo.vm_customer read by the listings
+// mapped to the JSON fields of the statements, to debug the filters.
+// Only admins are allowed to see it.
+func (s *Service) ColumnMapping(ctx context.Context) (map[string]string, error) {
+	if !auth.ClaimsFromContext(ctx).IsAdmin() {
+		return nil, rpcstatus.Error(codes.PermissionDenied, "You are not allowed to see the column mapping.")
+	}
+
+	mapping := make(map[string]string, len(columnFields)+1)
+	for column, field := range columnFields {
+		mapping[column] = field
+	}
+	if s.modifiedAt != "" {
+		mapping[s.modifiedAt] = "modifiedAt"
+	}
+	return mapping, nil
+}
+
 func (s *Service) GetStatementByID(ctx context.Context, id string) (*Statement, error) {
 	zlog := s.zlog.With(
 		zap.String("method", "GetStatementByID"),
-		zap.Any("id", id),
+		s.piiField("id", id),
 	)
+	ctx = withOperation(ctx, "GetStatementByID")
+
+	ctx, span := s.tracer.Start(ctx, "statement.GetStatementByID",
+		trace.WithAttributes(attribute.String("statement.id", id)),
+	)
+	defer span.End()
 
 	zlog.Info("starting to get statement by id")
 
-	statement, err := getStatements(ctx, s.db, &StatementQuery{QueueNumber: id})
+	statement, err := getStatements(ctx, s.db, s.dialect, &StatementQuery{QueueNumber: id, opts: s.listOptions()})
 	if errors.Is(err, ErrStatementNotFound) {
 		zlog.Warn("statement not found")
-		return nil, rpcstatus.Error(codes.NotFound, "Statement not found.")
+		span.SetAttributes(attribute.Bool("statement.found", false))
+		return nil, err
+	}
+	if rpcstatus.Code(err) == codes.FailedPrecondition {
+		zlog.Warn("queue number is ambiguous")
+		return nil, err
 	}
 	if err != nil {
 		zlog.Error("failed to get statement by id", zap.Error(err))
+		recordError(span, err)
 		return nil, err
 	}
+	span.SetAttributes(attribute.Bool("statement.found", true))
+
+	s.maskPolicy(ctx).apply(statement)
 	return statement, nil
 }
 
+// maxQueueNumbers is the maximum number of queue numbers looked up at once.
+const maxQueueNumbers = 100
+
+type GetByQueueNumbersReq struct {
+	QueueNumbers []string `json:"queueNumbers"`
+}
+
+type GetByQueueNumbersResult struct {
+	Statements []*Statement `json:"statements"`
+	NotFound   []string     `json:"notFound"`
+}
+
+// GetStatementsByQueueNumbers returns the statements of the given queue numbers
+// and the queue numbers without any statement.
+func (s *Service) GetStatementsByQueueNumbers(ctx context.Context, in *GetByQueueNumbersReq) (*GetByQueueNumbersResult, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "GetStatementsByQueueNumbers"),
+		zap.Int("count", len(in.QueueNumbers)),
+	)
+	ctx = withOperation(ctx, "GetStatementsByQueueNumbers")
+
+	zlog.Info("starting to get statements by queue numbers")
+
+	if n := len(in.QueueNumbers); n == 0 || n > maxQueueNumbers {
+		zlog.Info("queue numbers count out of range")
+		s, _ := rpcstatus.New(codes.InvalidArgument, "Queue numbers are not valid.").
+			WithDetails(&edpb.BadRequest{
+				FieldViolations: []*edpb.BadRequest_FieldViolation{
+					{
+						Field:       "queueNumbers",
+						Description: fmt.Sprintf("queueNumbers must have between 1 and %d items", maxQueueNumbers),
+					},
+				},
+			})
+		return nil, s.Err()
+	}
+
+	queueNumbers := make([]string, 0, len(in.QueueNumbers))
+	seen := make(map[string]bool, len(in.QueueNumbers))
+	for _, n := range in.QueueNumbers {
+		if !seen[n] {
+			seen[n] = true
+			queueNumbers = append(queueNumbers, n)
+		}
+	}
+
+	// The inactive statements are left out like in the listings, and a queue
+	// number may have several statements, so they're read page by page
+	// rather than capped to a single page.
+	q := &StatementQuery{opts: s.listOptions()}
+	pred := sq.And{q.predicate(), sq.Eq{"cusnum": queueNumbers}}
+	statements := make([]*Statement, 0, len(queueNumbers))
+	for offset := uint64(0); ; offset += pager.MaxSize {
+		page, err := queryStatements(ctx, s.db, s.dialect, pager.MaxSize, offset, q.opts, pred)
+		if err != nil {
+			zlog.Error("failed to get statements by queue numbers", zap.Error(err))
+			return nil, err
+		}
+		statements = append(statements, page...)
+		if uint64(len(page)) < pager.MaxSize {
+			break
+		}
+	}
+
+	mask := s.maskPolicy(ctx)
+	found := make(map[string]bool, len(statements))
+	for _, st := range statements {
+		found[st.QueueNumber] = true
+		mask.apply(st)
+	}
+	notFound := make([]string, 0)
+	for _, n := range queueNumbers {
+		if !found[n] {
+			notFound = append(notFound, n)
+		}
+	}
+
+	return &GetByQueueNumbersResult{
+		Statements: statements,
+		NotFound:   notFound,
+	}, nil
+}
+
 func (s *Service) ListProductNames(ctx context.Context) ([]string, error) {
 	zlog := s.zlog.With(zap.Any("method", "ListProductNames"))
+	ctx = withOperation(ctx, "ListProductNames")
 
 	zlog.Info("starting to list product names")
 
-	productNames, err := listProductNames(ctx, s.db)
+	productNames, err := s.lookup(ctx, lookupProductNames)
 	if err != nil {
 		zlog.Error("failed to list product names", zap.Error(err))
 		return nil, err
@@ -95,12 +828,37 @@ func (s *Service) ListProductNames(ctx context.Context) ([]string, error) {
 	return productNames, nil
 }
 
-func (s *Service) ListOccupations(ctx context.Context) ([]string, error) {
-	zlog := s.zlog.With(zap.Any("method", "ListOccupations"))
+// ListOccupations returns the occupations of the customers, only those of
+// the customers of the gender if it's not empty.
+func (s *Service) ListOccupations(ctx context.Context, gender string) ([]string, error) {
+	zlog := s.zlog.With(
+		zap.Any("method", "ListOccupations"),
+		zap.String("gender", gender),
+	)
+	ctx = withOperation(ctx, "ListOccupations")
 
 	zlog.Info("starting to list occupations")
 
-	occupations, err := listOccupations(ctx, s.db)
+	if v := Gender(gender).violation(); v != nil {
+		s, _ := rpcstatus.New(codes.InvalidArgument, "Gender of the occupations is not valid.").
+			WithDetails(&edpb.BadRequest{
+				FieldViolations: []*edpb.BadRequest_FieldViolation{v},
+			})
+		return nil, s.Err()
+	}
+
+	if gender := ParseGender(gender); gender != "" {
+		occupations, err := s.lookups.get(ctx, lookupOccupations+":"+string(gender), func(ctx context.Context) ([]string, error) {
+			return listOccupationsByGender(ctx, s.db, s.dialect, gender)
+		})
+		if err != nil {
+			zlog.Error("failed to list occupations", zap.Error(err))
+			return nil, err
+		}
+		return occupations, nil
+	}
+
+	occupations, err := s.lookup(ctx, lookupOccupations)
 	if err != nil {
 		zlog.Error("failed to list occupations", zap.Error(err))
 		return nil, err
@@ -110,10 +868,11 @@ func (s *Service) ListOccupations(ctx context.Context) ([]string, error) {
 
 func (s *Service) ListTerms(ctx context.Context) ([]string, error) {
 	zlog := s.zlog.With(zap.Any("method", "ListTerms"))
+	ctx = withOperation(ctx, "ListTerms")
 
 	zlog.Info("starting to list terms")
 
-	terms, err := listTerms(ctx, s.db)
+	terms, err := s.lookup(ctx, lookupTerms)
 	if err != nil {
 		zlog.Error("failed to list terms", zap.Error(err))
 		return nil, err
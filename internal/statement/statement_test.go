@@ -0,0 +1,404 @@
+package statement
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/10664kls/estatement/internal/auth"
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+// queryLog records the queries run against the mock database.
+type queryLog struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func (l *queryLog) all() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.queries...)
+}
+
+// newTestService returns a service backed by a mock database. The expected
+// queries are matched when they contain the expected SQL, and every query
+// run is recorded into the returned log.
+func newTestService(t *testing.T, cfg Config) (*Service, sqlmock.Sqlmock, *queryLog) {
+	t.Helper()
+
+	log := &queryLog{}
+	matcher := sqlmock.QueryMatcherFunc(func(expected, actual string) error {
+		log.mu.Lock()
+		log.queries = append(log.queries, actual)
+		log.mu.Unlock()
+		if !strings.Contains(actual, expected) {
+			return &mismatchError{expected: expected, actual: actual}
+		}
+		return nil
+	})
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s, err := NewService(context.Background(), db, zap.NewNop(), cfg)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	return s, mock, log
+}
+
+type mismatchError struct {
+	expected, actual string
+}
+
+func (e *mismatchError) Error() string {
+	return "query " + e.actual + " does not contain " + e.expected
+}
+
+// whereClause returns the WHERE clause of the query, up to its ORDER BY.
+func whereClause(q string) string {
+	_, where, ok := strings.Cut(q, " WHERE ")
+	if !ok {
+		return ""
+	}
+	where, _, _ = strings.Cut(where, " ORDER BY ")
+	return where
+}
+
+func adminContext() context.Context {
+	return auth.ContextWithClaims(context.Background(), &auth.Claims{ID: "A1", Username: "admin", Role: auth.RoleAdmin})
+}
+
+func userContext() context.Context {
+	return auth.ContextWithClaims(context.Background(), &auth.Claims{ID: "U1", Username: "alice", Role: "user"})
+}
+
+// testTime is the creation time of the statements of statementRows.
+var testTime = time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// statementRows returns the rows of the statements of the IDs, with the
+// queue number "Q" + ID.
+func statementRows(ids ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows(statementColumns)
+	for _, id := range ids {
+		rows.AddRow(statementRow(id, "Q"+id)...)
+	}
+	return rows
+}
+
+// statementRow returns the values of the statementColumns of a statement.
+func statementRow(id, queueNumber string) []driver.Value {
+	return []driver.Value{
+		id,
+		queueNumber,
+		"Customer " + id,
+		"0100200300" + id,
+		"12",
+		"BCEL",
+		nil,
+		nil,
+		nil,
+		"M",
+		"LOAN",
+		nil,
+		nil,
+		"Teacher",
+		"operator",
+		"PENDING",
+		testTime,
+	}
+}
+
+func TestValidateQueryInvalid(t *testing.T) {
+	s, _, log := newTestService(t, Config{})
+
+	result, err := s.ValidateQuery(adminContext(), &StatementQuery{
+		CreatedAfter:  testTime,
+		CreatedBefore: testTime.AddDate(0, 0, -1),
+	})
+	if err != nil {
+		t.Fatalf("ValidateQuery() error = %v", err)
+	}
+	if result.Valid || len(result.Violations) != 1 || result.Violations[0].Field != "createdAfter" {
+		t.Errorf("ValidateQuery() = %+v, want the createdAfter violation", result)
+	}
+	if n := len(log.all()); n != 0 {
+		t.Errorf("ValidateQuery() ran %d queries, want none", n)
+	}
+}
+
+func TestValidateQueryCount(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("SELECT COUNT(*) FROM dbo.vm_customer").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+
+	result, err := s.ValidateQuery(adminContext(), &StatementQuery{ProductName: " LOAN "})
+	if err != nil {
+		t.Fatalf("ValidateQuery() error = %v", err)
+	}
+	if !result.Valid || result.TotalMatching != 42 {
+		t.Errorf("ValidateQuery() = %+v, want valid with 42 matching", result)
+	}
+	if result.Violations == nil || len(result.Violations) != 0 {
+		t.Errorf("ValidateQuery() violations = %v, want empty", result.Violations)
+	}
+	if got := result.NormalizedQuery.ProductName; got != "LOAN" {
+		t.Errorf("ValidateQuery() normalized productName = %q, want %q", got, "LOAN")
+	}
+}
+
+// detailedStatementRows returns the rows of a statement with an email message
+// and a bank account info.
+func detailedStatementRows(id string) *sqlmock.Rows {
+	row := statementRow(id, "Q"+id)
+	row[8] = "account info"
+	row[12] = "email message"
+	return sqlmock.NewRows(statementColumns).AddRow(row...)
+}
+
+func TestRedactListDetails(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{RedactListDetails: true})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(detailedStatementRows("1"))
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(detailedStatementRows("1"))
+
+	list, err := s.ListStatements(adminContext(), &StatementQuery{})
+	if err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+	if st := list.Statements[0]; st.Email.Message != nil || st.BankAccount.Info != nil {
+		t.Errorf("ListStatements() = %+v %+v, want the details redacted", st.Email, st.BankAccount)
+	}
+
+	st, err := s.GetStatementByID(adminContext(), "Q1")
+	if err != nil {
+		t.Fatalf("GetStatementByID() error = %v", err)
+	}
+	if st.Email.Message == nil || st.BankAccount.Info == nil {
+		t.Errorf("GetStatementByID() = %+v %+v, want the details", st.Email, st.BankAccount)
+	}
+}
+
+func TestListDetailsNotRedactedByDefault(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(detailedStatementRows("1"))
+
+	list, err := s.ListStatements(adminContext(), &StatementQuery{})
+	if err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+	if st := list.Statements[0]; st.Email.Message == nil || st.BankAccount.Info == nil {
+		t.Errorf("ListStatements() = %+v %+v, want the details", st.Email, st.BankAccount)
+	}
+}
+
+// newReplica returns a mock database to serve as the read replica.
+func newReplica(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func TestReadReplicaServesReads(t *testing.T) {
+	replica, rmock := newReplica(t)
+	s, mock, log := newTestService(t, Config{ReadReplica: replica})
+	rmock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+	rmock.ExpectQuery("SELECT productnames FROM dbo.vm_customer").
+		WillReturnRows(sqlmock.NewRows([]string{"productnames"}).AddRow("LOAN"))
+	rmock.ExpectQuery("GROUP BY bankname").
+		WillReturnRows(sqlmock.NewRows([]string{"bankname", "count"}).AddRow("BCEL", 1))
+
+	ctx := adminContext()
+	if _, err := s.ListStatements(ctx, &StatementQuery{}); err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+	if _, err := s.ListProductNames(ctx); err != nil {
+		t.Fatalf("ListProductNames() error = %v", err)
+	}
+	if _, err := s.SummaryByBank(ctx, &StatementQuery{}); err != nil {
+		t.Fatalf("SummaryByBank() error = %v", err)
+	}
+
+	if err := rmock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if q := log.all(); len(q) != 0 {
+		t.Errorf("primary ran %q, want the reads on the replica", q)
+	}
+}
+
+func TestReadReplicaSparesWrites(t *testing.T) {
+	replica, rmock := newReplica(t)
+	provider := &stubBankStatus{status: &BankStatus{Status: "OPENED", Info: "account 123"}}
+	s, mock, _ := newTestService(t, Config{ReadReplica: replica, BankStatusProvider: provider})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+	mock.ExpectExec("UPDATE dbo.tb_customer").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if _, err := s.RefreshBankStatus(adminContext(), "Q1"); err != nil {
+		t.Fatalf("RefreshBankStatus() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if err := rmock.ExpectationsWereMet(); err != nil {
+		t.Errorf("replica: %v", err)
+	}
+}
+
+func TestGetStatementByIDAmbiguous(t *testing.T) {
+	s, mock, log := newTestService(t, Config{})
+	rows := sqlmock.NewRows(statementColumns).
+		AddRow(statementRow("1", "Q1")...).
+		AddRow(statementRow("2", "Q1")...)
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(rows)
+
+	_, err := s.GetStatementByID(adminContext(), "Q1")
+	if code := rpcstatus.Code(err); code != codes.FailedPrecondition {
+		t.Errorf("GetStatementByID() code = %v, want %v", code, codes.FailedPrecondition)
+	}
+	if q := log.all()[0]; !strings.HasPrefix(q, "SELECT TOP 2 ") {
+		t.Errorf("query = %q, want two rows at most", q)
+	}
+}
+
+func TestGetStatementByIDUnique(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1"))
+
+	st, err := s.GetStatementByID(adminContext(), "Q1")
+	if err != nil {
+		t.Fatalf("GetStatementByID() error = %v", err)
+	}
+	if st.ID != "1" {
+		t.Errorf("GetStatementByID() = %s, want 1", st.ID)
+	}
+}
+
+func TestGetStatementsByAccount(t *testing.T) {
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+		want []string
+	}{
+		{name: "unique", rows: statementRows("1"), want: []string{"1"}},
+		{name: "multiple", rows: statementRows("2", "1"), want: []string{"2", "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, log := newTestService(t, Config{})
+			mock.ExpectQuery("FROM dbo.vm_customer").WithArgs(inactiveRecType, "010020031").WillReturnRows(tt.rows)
+
+			statements, err := s.GetStatementsByAccount(adminContext(), " 010020031 ")
+			if err != nil {
+				t.Fatalf("GetStatementsByAccount() error = %v", err)
+			}
+			ids := make([]string, 0, len(statements))
+			for _, st := range statements {
+				ids = append(ids, st.ID)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("GetStatementsByAccount() = %v, want %v", ids, tt.want)
+			}
+			if q := log.all()[0]; !strings.Contains(q, "AccNo = ") {
+				t.Errorf("query = %q, want the statements of the account", q)
+			}
+		})
+	}
+}
+
+func TestGetStatementsByAccountNotFound(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	_, err := s.GetStatementsByAccount(adminContext(), "010020031")
+	if !errors.Is(err, ErrStatementNotFound) {
+		t.Errorf("GetStatementsByAccount() error = %v, want %v", err, ErrStatementNotFound)
+	}
+
+	// A blank account number doesn't query the database.
+	_, err = s.GetStatementsByAccount(adminContext(), " ")
+	if !errors.Is(err, ErrStatementNotFound) {
+		t.Errorf("GetStatementsByAccount(blank) error = %v, want %v", err, ErrStatementNotFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueryGroupBounded(t *testing.T) {
+	s, _, _ := newTestService(t, Config{QueryConcurrency: 2})
+
+	var running, peak atomic.Int32
+	g, _ := s.queryGroup(context.Background())
+	for range 6 {
+		g.Go(func() error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if got := peak.Load(); got != 2 {
+		t.Errorf("ran %d queries at once, want 2", got)
+	}
+}
+
+func TestQueryGroupFailureCancels(t *testing.T) {
+	s, _, _ := newTestService(t, Config{QueryConcurrency: 3})
+	errQuery := errors.New("query failed")
+
+	g, ctx := s.queryGroup(context.Background())
+	canceled := make(chan error, 2)
+	for range 2 {
+		g.Go(func() error {
+			select {
+			case <-ctx.Done():
+				canceled <- ctx.Err()
+				return ctx.Err()
+			case <-time.After(time.Second):
+				canceled <- nil
+				return nil
+			}
+		})
+	}
+	g.Go(func() error { return errQuery })
+
+	if err := g.Wait(); !errors.Is(err, errQuery) {
+		t.Errorf("Wait() error = %v, want %v", err, errQuery)
+	}
+	for range 2 {
+		if err := <-canceled; !errors.Is(err, context.Canceled) {
+			t.Errorf("query ended with %v, want %v", err, context.Canceled)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package statement
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/zap"
+)
+
+// SummaryByBank counts the statements matching the query per bank.
+// The statements without a bank are not counted.
+func (s *Service) SummaryByBank(ctx context.Context, in *StatementQuery) (map[string]int64, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "SummaryByBank"),
+		s.queryField(in),
+	)
+	ctx = withOperation(ctx, "SummaryByBank")
+
+	zlog.Info("starting to summarize statements by bank")
+
+	if err := s.prepareQuery(ctx, in); err != nil {
+		zlog.Info("query is not valid", zap.Error(err))
+		return nil, err
+	}
+
+	summary, err := countStatementsBy(ctx, s.db, s.dialect, "bankname", in)
+	if err != nil {
+		zlog.Error("failed to count statements by bank", zap.Error(err))
+		return nil, err
+	}
+	return summary, nil
+}
+
+// KPIs are the aggregate figures of the statements matching a query.
+type KPIs struct {
+	TotalStatements   int64 `json:"totalStatements"`
+	SentEmails        int64 `json:"sentEmails"`
+	FailedEmails      int64 `json:"failedEmails"`
+	DistinctCustomers int64 `json:"distinctCustomers"`
+	DistinctBanks     int64 `json:"distinctBanks"`
+}
+
+// KPIs computes the aggregate figures of the statements matching the query,
+// typically narrowed by createdAfter and createdBefore, in a single query.
+// The customers are told apart by their name.
+func (s *Service) KPIs(ctx context.Context, in *StatementQuery) (*KPIs, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "KPIs"),
+		s.queryField(in),
+	)
+	ctx = withOperation(ctx, "KPIs")
+
+	zlog.Info("starting to compute kpis")
+
+	if err := s.prepareQuery(ctx, in); err != nil {
+		zlog.Info("query is not valid", zap.Error(err))
+		return nil, err
+	}
+
+	kpis, err := computeKPIs(ctx, s.db, s.dialect, s.emailSent, s.emailFailed, in)
+	if err != nil {
+		zlog.Error("failed to compute kpis", zap.Error(err))
+		return nil, err
+	}
+	return kpis, nil
+}
+
+// computeKPIs computes the KPIs of the statements matching the query.
+// The page token of the query is ignored.
+func computeKPIs(ctx context.Context, db queryer, d Dialect, sent, failed string, in *StatementQuery) (*KPIs, error) {
+	pred, args, err := in.ToSql()
+	if err != nil {
+		return nil, toSqlError(err)
+	}
+
+	query, args := d.builder().
+		Select("COUNT(*)").
+		Column(sq.Expr("COALESCE(SUM(CASE WHEN emailstatus = ? THEN 1 ELSE 0 END), 0)", sent)).
+		Column(sq.Expr("COALESCE(SUM(CASE WHEN emailstatus = ? THEN 1 ELSE 0 END), 0)", failed)).
+		Column("COUNT(DISTINCT cus_name)").
+		Column("COUNT(DISTINCT bankname)").
+		From("dbo.vm_customer").
+		Where(pred, args...).
+		MustSql()
+
+	var k KPIs
+	err = db.QueryRowContext(ctx, query, args...).Scan(
+		&k.TotalStatements,
+		&k.SentEmails,
+		&k.FailedEmails,
+		&k.DistinctCustomers,
+		&k.DistinctBanks,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return &k, nil
+}
+
+// countStatementsBy counts the statements matching the query grouped by the
+// given column. Rows where the column is null or empty are not counted.
+// The page token of the query is ignored.
+func countStatementsBy(ctx context.Context, db queryer, d Dialect, column string, in *StatementQuery) (map[string]int64, error) {
+	pred, args, err := in.ToSql()
+	if err != nil {
+		return nil, toSqlError(err)
+	}
+
+	query, args := d.builder().
+		Select(column, "COUNT(*)").
+		From("dbo.vm_customer").
+		Where(pred, args...).
+		Where(sq.And{
+			sq.NotEq{column: nil},
+			sq.NotEq{column: ""},
+		}).
+		GroupBy(column).
+		MustSql()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var key string
+		var count int64
+		if err := rows.Scan(&key, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		counts[key] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
+	return counts, nil
+}
+
+// OperatorCount is the number of statements created by an operator.
+type OperatorCount struct {
+	Operator string `json:"operator"`
+	Count    int64  `json:"count"`
+
+	// ByStatus breaks the count down by the banking status of the statements.
+	ByStatus map[string]int64 `json:"byStatus,omitempty"`
+}
+
+// OperatorWorkload counts the statements matching the query per operator,
+// typically narrowed by createdAfter and createdBefore, with the breakdown by
+// status. The operators are sorted by their count, the busiest first.
+// The statements without an operator are not counted.
+func (s *Service) OperatorWorkload(ctx context.Context, in *StatementQuery) ([]OperatorCount, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "OperatorWorkload"),
+		s.queryField(in),
+	)
+	ctx = withOperation(ctx, "OperatorWorkload")
+
+	zlog.Info("starting to count statements by operator")
+
+	if err := s.prepareQuery(ctx, in); err != nil {
+		zlog.Info("query is not valid", zap.Error(err))
+		return nil, err
+	}
+
+	workload, err := countStatementsByOperator(ctx, s.db, s.dialect, in)
+	if err != nil {
+		zlog.Error("failed to count statements by operator", zap.Error(err))
+		return nil, err
+	}
+	return workload, nil
+}
+
+// countStatementsByOperator counts the statements matching the query grouped
+// by createby and statusBanking. The page token of the query is ignored.
+func countStatementsByOperator(ctx context.Context, db queryer, d Dialect, in *StatementQuery) ([]OperatorCount, error) {
+	pred, args, err := in.ToSql()
+	if err != nil {
+		return nil, toSqlError(err)
+	}
+
+	query, args := d.builder().
+		Select("createby", "COALESCE(statusBanking, '')", "COUNT(*)").
+		From("dbo.vm_customer").
+		Where(pred, args...).
+		Where(sq.And{
+			sq.NotEq{"createby": nil},
+			sq.NotEq{"createby": ""},
+		}).
+		GroupBy("createby", "statusBanking").
+		MustSql()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	byOperator := make(map[string]*OperatorCount)
+	for rows.Next() {
+		var operator, status string
+		var count int64
+		if err := rows.Scan(&operator, &status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		oc, ok := byOperator[operator]
+		if !ok {
+			oc = &OperatorCount{Operator: operator, ByStatus: make(map[string]int64)}
+			byOperator[operator] = oc
+		}
+		oc.Count += count
+		oc.ByStatus[status] += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
+	workload := make([]OperatorCount, 0, len(byOperator))
+	for _, oc := range byOperator {
+		workload = append(workload, *oc)
+	}
+	sort.Slice(workload, func(i, j int) bool {
+		if workload[i].Count != workload[j].Count {
+			return workload[i].Count > workload[j].Count
+		}
+		return workload[i].Operator < workload[j].Operator
+	})
+
+	return workload, nil
+}
+
+// GenSummaryExcel generates a one sheet workbook summarizing the statements
+// matching the query by status, by product and by bank.
+func (s *Service) GenSummaryExcel(ctx context.Context, in *StatementQuery) (*bytes.Buffer, error) {
+	zlog := s.zlog.With(
+		zap.String("method", "GenSummaryExcel"),
+		s.queryField(in),
+	)
+	ctx = withOperation(ctx, "GenSummaryExcel")
+
+	zlog.Info("starting to gen summary excel")
+
+	if err := s.prepareQuery(ctx, in); err != nil {
+		zlog.Info("query is not valid", zap.Error(err))
+		return nil, err
+	}
+
+	sections := []struct {
+		title  string
+		column string
+	}{
+		{title: "Status", column: "statusBanking"},
+		{title: "Product", column: "productnames"},
+		{title: "Bank", column: "bankname"},
+	}
+
+	fx := excelize.NewFile()
+	defer fx.Close()
+
+	const sheetName = "Summary"
+
+	sheet, err := fx.NewSheet(sheetName)
+	if err != nil {
+		zlog.Error("failed to create sheet", zap.Error(err))
+		return nil, err
+	}
+	fx.SetActiveSheet(sheet)
+	fx.DeleteSheet("Sheet1")
+
+	bold, err := fx.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		zlog.Error("failed to create style", zap.Error(err))
+		return nil, err
+	}
+
+	fx.SetColWidth(sheetName, "A", "A", 40)
+	fx.SetColWidth(sheetName, "B", "B", 12)
+
+	sectionCounts := make([]map[string]int64, len(sections))
+	g, gctx := s.queryGroup(ctx)
+	for i, sec := range sections {
+		g.Go(func() error {
+			counts, err := countStatementsBy(gctx, s.db, s.dialect, sec.column, in)
+			if err != nil {
+				zlog.Error("failed to count statements", zap.String("by", sec.column), zap.Error(err))
+				return err
+			}
+			sectionCounts[i] = counts
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	row := 1
+	for i, sec := range sections {
+		counts := sectionCounts[i]
+
+		keys := make([]string, 0, len(counts))
+		for k := range counts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fx.SetCellValue(sheetName, fmt.Sprintf("A%d", row), sec.title)
+		fx.SetCellValue(sheetName, fmt.Sprintf("B%d", row), "Count")
+		fx.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("B%d", row), bold)
+		row++
+
+		var total int64
+		for _, k := range keys {
+			fx.SetCellValue(sheetName, fmt.Sprintf("A%d", row), k)
+			fx.SetCellValue(sheetName, fmt.Sprintf("B%d", row), counts[k])
+			total += counts[k]
+			row++
+		}
+
+		fx.SetCellValue(sheetName, fmt.Sprintf("A%d", row), "Total")
+		fx.SetCellValue(sheetName, fmt.Sprintf("B%d", row), total)
+		fx.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("B%d", row), bold)
+		row += 2
+	}
+
+	buf, err := fx.WriteToBuffer()
+	if err != nil {
+		zlog.Error("failed to write file to buffer", zap.Error(err))
+		return nil, err
+	}
+
+	return buf, nil
+}
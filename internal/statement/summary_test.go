@@ -0,0 +1,210 @@
+package statement
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/xuri/excelize/v2"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+)
+
+func TestSummaryByBank(t *testing.T) {
+	s, mock, log := newTestService(t, Config{})
+	mock.ExpectQuery("GROUP BY bankname").
+		WillReturnRows(sqlmock.NewRows([]string{"bankname", "count"}).
+			AddRow("BCEL", 3).
+			AddRow("LDB", 1))
+
+	summary, err := s.SummaryByBank(adminContext(), &StatementQuery{Gender: "female", Status: "APPROVED"})
+	if err != nil {
+		t.Fatalf("SummaryByBank() error = %v", err)
+	}
+	if len(summary) != 2 || summary["BCEL"] != 3 || summary["LDB"] != 1 {
+		t.Errorf("SummaryByBank() = %v, want BCEL: 3, LDB: 1", summary)
+	}
+
+	q := log.all()[0]
+	for _, want := range []string{"gender IN", "statusBanking = @p", "bankname IS NOT NULL", "bankname <> @p"} {
+		if !strings.Contains(q, want) {
+			t.Errorf("SummaryByBank() query = %q, want %q", q, want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGenSummaryExcel(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("GROUP BY statusBanking").
+		WillReturnRows(sqlmock.NewRows([]string{"statusBanking", "count"}).AddRow("PENDING", 2).AddRow("APPROVED", 5))
+	mock.ExpectQuery("GROUP BY productnames").
+		WillReturnRows(sqlmock.NewRows([]string{"productnames", "count"}).AddRow("LOAN", 7))
+	mock.ExpectQuery("GROUP BY bankname").
+		WillReturnRows(sqlmock.NewRows([]string{"bankname", "count"}).AddRow("BCEL", 4).AddRow("LDB", 2))
+
+	buf, err := s.GenSummaryExcel(adminContext(), &StatementQuery{})
+	if err != nil {
+		t.Fatalf("GenSummaryExcel() error = %v", err)
+	}
+
+	fx, err := excelize.OpenReader(buf)
+	if err != nil {
+		t.Fatalf("excelize.OpenReader() error = %v", err)
+	}
+	defer fx.Close()
+
+	if sheets := fx.GetSheetList(); len(sheets) != 1 || sheets[0] != "Summary" {
+		t.Fatalf("GenSummaryExcel() sheets = %v, want [Summary]", sheets)
+	}
+	rows, err := fx.GetRows("Summary")
+	if err != nil {
+		t.Fatalf("GetRows() error = %v", err)
+	}
+
+	want := [][]string{
+		{"Status", "Count"}, {"APPROVED", "5"}, {"PENDING", "2"}, {"Total", "7"}, {},
+		{"Product", "Count"}, {"LOAN", "7"}, {"Total", "7"}, {},
+		{"Bank", "Count"}, {"BCEL", "4"}, {"LDB", "2"}, {"Total", "6"},
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("GenSummaryExcel() = %v, want %v", rows, want)
+	}
+}
+
+func TestKPIs(t *testing.T) {
+	s, mock, log := newTestService(t, Config{})
+	after, before := testTime.AddDate(0, -1, 0), testTime
+	mock.ExpectQuery("FROM dbo.vm_customer").
+		WithArgs("SENT", "FAILED", before, after, "DEL").
+		WillReturnRows(sqlmock.NewRows([]string{"total", "sent", "failed", "customers", "banks"}).
+			AddRow(12, 7, 2, 10, 3))
+
+	kpis, err := s.KPIs(adminContext(), &StatementQuery{CreatedAfter: after, CreatedBefore: before})
+	if err != nil {
+		t.Fatalf("KPIs() error = %v", err)
+	}
+	want := KPIs{TotalStatements: 12, SentEmails: 7, FailedEmails: 2, DistinctCustomers: 10, DistinctBanks: 3}
+	if *kpis != want {
+		t.Errorf("KPIs() = %+v, want %+v", *kpis, want)
+	}
+
+	q := log.all()[0]
+	for _, want := range []string{"createdate <= @p3", "createdate >= @p4", "COUNT(DISTINCT cus_name)", "COUNT(DISTINCT bankname)"} {
+		if !strings.Contains(q, want) {
+			t.Errorf("KPIs() query = %q, want %q", q, want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOperatorWorkload(t *testing.T) {
+	s, mock, log := newTestService(t, Config{})
+	after, before := testTime.AddDate(0, -1, 0), testTime
+	mock.ExpectQuery("GROUP BY createby, statusBanking").
+		WithArgs(before, after, "DEL", "").
+		WillReturnRows(sqlmock.NewRows([]string{"createby", "status", "count"}).
+			AddRow("bob", "PENDING", 2).
+			AddRow("alice", "PENDING", 3).
+			AddRow("alice", "DONE", 4).
+			AddRow("carol", "", 5))
+
+	workload, err := s.OperatorWorkload(adminContext(), &StatementQuery{CreatedAfter: after, CreatedBefore: before})
+	if err != nil {
+		t.Fatalf("OperatorWorkload() error = %v", err)
+	}
+	want := []OperatorCount{
+		{Operator: "alice", Count: 7, ByStatus: map[string]int64{"PENDING": 3, "DONE": 4}},
+		{Operator: "carol", Count: 5, ByStatus: map[string]int64{"": 5}},
+		{Operator: "bob", Count: 2, ByStatus: map[string]int64{"PENDING": 2}},
+	}
+	if fmt.Sprint(workload) != fmt.Sprint(want) {
+		t.Errorf("OperatorWorkload() = %v, want %v", workload, want)
+	}
+
+	where := whereClause(log.all()[0])
+	for _, want := range []string{"createdate <= @p1", "createdate >= @p2", "createby IS NOT NULL"} {
+		if !strings.Contains(where, want) {
+			t.Errorf("OperatorWorkload() WHERE = %q, want %q", where, want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueriesRejectInactiveForNonAdmins(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(s *Service, ctx context.Context, in *StatementQuery) error
+	}{
+		{"ListStatements", func(s *Service, ctx context.Context, in *StatementQuery) error {
+			_, err := s.ListStatements(ctx, in)
+			return err
+		}},
+		{"CountStatements", func(s *Service, ctx context.Context, in *StatementQuery) error {
+			_, err := s.CountStatements(ctx, in)
+			return err
+		}},
+		{"ValidateQuery", func(s *Service, ctx context.Context, in *StatementQuery) error {
+			_, err := s.ValidateQuery(ctx, in)
+			return err
+		}},
+		{"SummaryByBank", func(s *Service, ctx context.Context, in *StatementQuery) error {
+			_, err := s.SummaryByBank(ctx, in)
+			return err
+		}},
+		{"KPIs", func(s *Service, ctx context.Context, in *StatementQuery) error {
+			_, err := s.KPIs(ctx, in)
+			return err
+		}},
+		{"OperatorWorkload", func(s *Service, ctx context.Context, in *StatementQuery) error {
+			_, err := s.OperatorWorkload(ctx, in)
+			return err
+		}},
+		{"GenSummaryExcel", func(s *Service, ctx context.Context, in *StatementQuery) error {
+			_, err := s.GenSummaryExcel(ctx, in)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, _ := newTestService(t, Config{})
+
+			err := tt.run(s, userContext(), &StatementQuery{IncludeInactive: true})
+			if code := rpcstatus.Code(err); code != codes.PermissionDenied {
+				t.Errorf("%s() code = %v, want %v", tt.name, code, codes.PermissionDenied)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSummaryByBankIncludesInactiveForAdmins(t *testing.T) {
+	s, mock, log := newTestService(t, Config{})
+	mock.ExpectQuery("FROM dbo.vm_customer").
+		WillReturnRows(sqlmock.NewRows([]string{"bankname", "count"}).AddRow("BCEL", 3))
+
+	summary, err := s.SummaryByBank(adminContext(), &StatementQuery{IncludeInactive: true})
+	if err != nil {
+		t.Fatalf("SummaryByBank() error = %v", err)
+	}
+	if summary["BCEL"] != 3 {
+		t.Errorf("SummaryByBank() = %v, want BCEL: 3", summary)
+	}
+	if q := log.all()[0]; strings.Contains(q, "rectype") {
+		t.Errorf("SummaryByBank() query = %q, want the inactive statements included", q)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,84 @@
+package statement
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// queryer is the subset of *sql.DB used to run the queries.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type operationKey struct{}
+
+// withOperation returns a copy of ctx carrying the name of the operation
+// the queries are run for.
+func withOperation(ctx context.Context, op string) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+func operationFromContext(ctx context.Context) string {
+	op, _ := ctx.Value(operationKey{}).(string)
+	return op
+}
+
+// tracedDB logs every query with its duration, at warn level when it is
+// slower than threshold and at debug level otherwise.
+// Only the parameterized query is logged, never the argument values.
+type tracedDB struct {
+	db        queryer
+	zlog      *zap.Logger
+	threshold time.Duration
+}
+
+func (t *tracedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer t.log(ctx, query, len(args), time.Now())
+	return t.db.QueryContext(ctx, query, args...)
+}
+
+func (t *tracedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer t.log(ctx, query, len(args), time.Now())
+	return t.db.QueryRowContext(ctx, query, args...)
+}
+
+// log logs the query and its argument count, the argument values are never
+// logged as they may hold personal data.
+func (t *tracedDB) log(ctx context.Context, query string, argCount int, start time.Time) {
+	elapsed := time.Since(start)
+
+	if elapsed >= t.threshold {
+		t.zlog.Warn("slow query",
+			zap.String("operation", operationFromContext(ctx)),
+			zap.String("sql", query),
+			zap.Int("argCount", argCount),
+			zap.Duration("elapsed", elapsed),
+		)
+		return
+	}
+
+	// Check the level first so the fields are not built for nothing.
+	if ce := t.zlog.Check(zap.DebugLevel, "query"); ce != nil {
+		ce.Write(
+			zap.String("operation", operationFromContext(ctx)),
+			zap.String("sql", query),
+			zap.Int("argCount", argCount),
+			zap.Duration("elapsed", elapsed),
+		)
+	}
+}
+
+// tracerName is the name of the tracer of this package.
+const tracerName = "github.com/10664kls/estatement/internal/statement"
+
+// recordError records err on the span and marks the span as failed.
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(otelcodes.Error, err.Error())
+}
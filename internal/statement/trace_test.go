@@ -0,0 +1,195 @@
+package statement
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// sleepyDB is a queryer taking delay to run every query.
+type sleepyDB struct {
+	delay time.Duration
+}
+
+func (d sleepyDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	time.Sleep(d.delay)
+	return nil, nil
+}
+
+func (d sleepyDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	time.Sleep(d.delay)
+	return nil
+}
+
+func TestTracedDBSlowQuery(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	db := &tracedDB{db: sleepyDB{delay: 20 * time.Millisecond}, zlog: zap.New(core), threshold: 10 * time.Millisecond}
+
+	ctx := withOperation(context.Background(), "ListStatements")
+	db.QueryContext(ctx, "SELECT CUID FROM dbo.vm_customer WHERE cusnum = @p1", "Q1")
+
+	entries := logs.FilterMessage("slow query").All()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d slow queries, want 1", len(entries))
+	}
+	if got := entries[0].Level; got != zapcore.WarnLevel {
+		t.Errorf("slow query level = %s, want %s", got, zapcore.WarnLevel)
+	}
+	fields := entries[0].ContextMap()
+	if fields["operation"] != "ListStatements" || fields["sql"] != "SELECT CUID FROM dbo.vm_customer WHERE cusnum = @p1" {
+		t.Errorf("slow query fields = %v, want the operation and the sql", fields)
+	}
+}
+
+func TestTracedDBFastQuery(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	db := &tracedDB{db: sleepyDB{}, zlog: zap.New(core), threshold: time.Second}
+
+	db.QueryRowContext(context.Background(), "SELECT 1")
+
+	if n := logs.FilterMessage("slow query").Len(); n != 0 {
+		t.Errorf("logged %d slow queries, want none", n)
+	}
+	entries := logs.FilterMessage("query").All()
+	if len(entries) != 1 || entries[0].Level != zapcore.DebugLevel {
+		t.Errorf("logged %v, want the query at debug level", entries)
+	}
+}
+
+// recordingTracer records the spans started with it in memory.
+type recordingTracer struct {
+	embedded.Tracer
+
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	span := &recordingSpan{name: name, attrs: cfg.Attributes()}
+
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// span returns the span of the name, nil if it was not started.
+func (t *recordingTracer) span(name string) *recordingSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+type recordingSpan struct {
+	noop.Span
+
+	name   string
+	attrs  []attribute.KeyValue
+	status otelcodes.Code
+	ended  bool
+}
+
+func (s *recordingSpan) IsRecording() bool { return true }
+
+func (s *recordingSpan) SetAttributes(kv ...attribute.KeyValue) { s.attrs = append(s.attrs, kv...) }
+
+func (s *recordingSpan) SetStatus(code otelcodes.Code, _ string) { s.status = code }
+
+func (s *recordingSpan) End(...trace.SpanEndOption) { s.ended = true }
+
+// attr returns the value of the attribute of the key.
+func (s *recordingSpan) attr(key attribute.Key) attribute.Value {
+	for _, kv := range s.attrs {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestListStatementsSpan(t *testing.T) {
+	tracer := &recordingTracer{}
+	s, mock, _ := newTestService(t, Config{Tracer: tracer})
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows("1", "2"))
+
+	_, err := s.ListStatements(adminContext(), &StatementQuery{ProductName: "LOAN", BankCode: "BCEL"})
+	if err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+
+	span := tracer.span("statement.ListStatements")
+	if span == nil {
+		t.Fatal("ListStatements() recorded no span")
+	}
+	if !span.ended || span.status == otelcodes.Error {
+		t.Errorf("span ended = %t with status %v, want ended without error", span.ended, span.status)
+	}
+	// The exclusion of the removed statements is counted as well.
+	if got := span.attr("statement.filter_count").AsInt64(); got != 3 {
+		t.Errorf("statement.filter_count = %d, want 3", got)
+	}
+	if got := span.attr("statement.row_count").AsInt64(); got != 2 {
+		t.Errorf("statement.row_count = %d, want 2", got)
+	}
+}
+
+func TestListStatementsSpanError(t *testing.T) {
+	tracer := &recordingTracer{}
+	s, _, _ := newTestService(t, Config{Tracer: tracer})
+
+	_, err := s.ListStatements(adminContext(), &StatementQuery{Month: "March"})
+	if err == nil {
+		t.Fatal("ListStatements() error = nil, want the query rejected")
+	}
+	if span := tracer.span("statement.ListStatements"); span == nil || span.status != otelcodes.Error {
+		t.Errorf("span = %+v, want the error recorded", span)
+	}
+}
+
+func TestQueryDebugLogOmitsArgs(t *testing.T) {
+	s, mock, _ := newTestService(t, Config{})
+	core, logs := observer.New(zapcore.DebugLevel)
+	s.db.(*tracedDB).zlog = zap.New(core)
+	mock.ExpectQuery("FROM dbo.vm_customer").WillReturnRows(statementRows())
+
+	_, err := s.ListStatements(adminContext(), &StatementQuery{QueueNumber: "Q-SECRET", Occupation: "Spy"})
+	if err != nil {
+		t.Fatalf("ListStatements() error = %v", err)
+	}
+
+	entries := logs.FilterMessage("query").All()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d queries, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if q, _ := fields["sql"].(string); !strings.Contains(q, "cusnum = @p") || !strings.Contains(q, "occupation = @p") {
+		t.Errorf("logged sql = %q, want the parameterized query", q)
+	}
+	if fields["argCount"] != int64(3) {
+		t.Errorf("logged argCount = %v, want 3", fields["argCount"])
+	}
+	for _, e := range logs.All() {
+		if s := fmt.Sprint(e.Message, e.ContextMap()); strings.Contains(s, "Q-SECRET") || strings.Contains(s, "Spy") {
+			t.Errorf("logged %s, want no argument value", s)
+		}
+	}
+}